@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+var (
+	ErrInvalidCursor = fmt.Errorf("invalid cursor")
+	ErrInvalidLimit  = fmt.Errorf("invalid limit")
+)
+
+// Page is a keyset pagination request read from the `limit` and `cursor`
+// query parameters. A zero Limit means the listing is not paginated, so
+// clients that don't send either parameter still get every row.
+type Page struct {
+	Limit  int
+	Cursor string
+}
+
+func ParsePage(r *http.Request) (Page, error) {
+	var page Page
+
+	page.Cursor = r.URL.Query().Get("cursor")
+
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			return Page{}, ErrInvalidLimit
+		}
+		page.Limit = min(limit, MaxPageLimit)
+	} else if page.Cursor != "" {
+		page.Limit = DefaultPageLimit
+	}
+
+	return page, nil
+}
+
+// EncodeCursor packs the sort key of the last row on a page into an opaque
+// token that can be sent back as the `cursor` query parameter.
+func EncodeCursor(values ...any) (string, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeCursor unpacks a cursor created by EncodeCursor into the given
+// pointers, which must match the number and types of the encoded values.
+func DecodeCursor(cursor string, values ...any) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return ErrInvalidCursor
+	}
+
+	if len(raw) != len(values) {
+		return ErrInvalidCursor
+	}
+
+	for i, value := range values {
+		if err := json.Unmarshal(raw[i], value); err != nil {
+			return ErrInvalidCursor
+		}
+	}
+
+	return nil
+}
+
+// NextPage trims the extra row that listings fetch past the limit and builds
+// the cursor for the following page from the last row that was kept. The
+// cursor is nil once there are no more rows.
+func NextPage[T any](rows []T, page Page, key func(T) []any) ([]T, *string, error) {
+	if page.Limit == 0 || len(rows) <= page.Limit {
+		return rows, nil, nil
+	}
+
+	rows = rows[:page.Limit]
+
+	cursor, err := EncodeCursor(key(rows[len(rows)-1])...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rows, &cursor, nil
+}
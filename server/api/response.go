@@ -10,6 +10,8 @@ type Response struct {
 	Message string `json:"message"`
 	Data    any    `json:"data"`
 
+	NextCursor *string `json:"nextCursor,omitempty"`
+
 	Error error `json:"-"`
 	Raw   bool  `json:"-"`
 }
@@ -1,8 +1,23 @@
 package api
 
+import "strings"
+
 type Sort string
 
 const (
 	Asc  Sort = "asc"
-	Desc Sort = "asc"
+	Desc Sort = "desc"
 )
+
+// Direction returns the SQL sort direction, falling back to def for anything
+// other than "asc" or "desc" so user input never reaches the query as is.
+func (s Sort) Direction(def Sort) string {
+	switch strings.ToLower(string(s)) {
+	case string(Asc):
+		return "ASC"
+	case string(Desc):
+		return "DESC"
+	}
+
+	return def.Direction(Desc)
+}
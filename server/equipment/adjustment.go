@@ -0,0 +1,167 @@
+package equipment
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	errInvalidItemAdjustment = fmt.Errorf("adjusted quantity must not exceed the requested quantity")
+	errAllItemsDropped       = fmt.Errorf("at least one item must be approved")
+	errAdjustmentNotApproved = fmt.Errorf("item quantities can only be adjusted when approving")
+	errBorrowRequestReviewed = fmt.Errorf("borrow request has already been reviewed")
+)
+
+// reviewBorrowRequestItem lowers the approved quantity of an item. A
+// quantity of 0 drops the item from the request.
+type reviewBorrowRequestItem struct {
+	BorrowRequestItemID string `json:"borrowRequestItemId"`
+	Quantity            uint   `json:"quantity"`
+}
+
+type itemAdjustment struct {
+	BorrowRequestItemID string `json:"borrowRequestItemId"`
+	EquipmentTypeID     string `json:"equipmentTypeId"`
+	Name                string `json:"name"`
+	RequestedQuantity   uint   `json:"requestedQuantity"`
+	ApprovedQuantity    uint   `json:"approvedQuantity"`
+}
+
+func (a itemAdjustment) String() string {
+	if a.ApprovedQuantity == 0 {
+		return fmt.Sprintf("%s was removed from the request.", a.Name)
+	}
+
+	return fmt.Sprintf("Approved %d of the %d requested unit(s) of %s.", a.ApprovedQuantity, a.RequestedQuantity, a.Name)
+}
+
+// adjustmentRemarks appends a summary of the adjustments to the manager's
+// remarks so the borrower knows what changed.
+func adjustmentRemarks(remarks *string, adjustments []itemAdjustment) *string {
+	if len(adjustments) == 0 {
+		return remarks
+	}
+
+	var lines []string
+	if remarks != nil && strings.TrimSpace(*remarks) != "" {
+		lines = append(lines, strings.TrimSpace(*remarks))
+	}
+	for _, adjustment := range adjustments {
+		lines = append(lines, adjustment.String())
+	}
+
+	res := strings.Join(lines, "\n")
+	return &res
+}
+
+// lockPendingBorrowRequest keeps a request from being reviewed again, e.g. by
+// a second manager, once it's no longer pending. The lock is held until the
+// review is done so its items can't change in between.
+func lockPendingBorrowRequest(ctx context.Context, tx pgx.Tx, borrowRequestID string) error {
+	query := `
+	SELECT borrow_request_status_id
+	FROM borrow_request
+	WHERE borrow_request_id = $1
+	FOR UPDATE
+	`
+
+	var status borrowRequestStatus
+	if err := tx.QueryRow(ctx, query, borrowRequestID).Scan(&status); err != nil {
+		return err
+	}
+
+	if status != pending {
+		return errBorrowRequestReviewed
+	}
+
+	return nil
+}
+
+// adjustBorrowRequestItems lowers the quantities of a request's items before
+// it is approved, deleting the ones that are dropped. Items that are left
+// unchanged aren't included in the returned adjustments.
+func adjustBorrowRequestItems(
+	ctx context.Context,
+	tx pgx.Tx,
+	borrowRequestID string,
+	items []reviewBorrowRequestItem,
+) ([]itemAdjustment, error) {
+	query := `
+	SELECT
+		borrow_request_item.borrow_request_item_id,
+		borrow_request_item.equipment_type_id,
+		equipment_type.name,
+		borrow_request_item.quantity
+	FROM borrow_request_item
+	JOIN equipment_type ON equipment_type.equipment_type_id = borrow_request_item.equipment_type_id
+	WHERE borrow_request_item.borrow_request_id = $1
+	ORDER BY equipment_type.name
+	FOR UPDATE OF borrow_request_item
+	`
+
+	rows, err := tx.Query(ctx, query, borrowRequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (itemAdjustment, error) {
+		var item itemAdjustment
+		err := row.Scan(&item.BorrowRequestItemID, &item.EquipmentTypeID, &item.Name, &item.RequestedQuantity)
+		item.ApprovedQuantity = item.RequestedQuantity
+		return item, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*itemAdjustment, len(current))
+	for i := range current {
+		byID[current[i].BorrowRequestItemID] = &current[i]
+	}
+
+	for _, item := range items {
+		adjustment, ok := byID[item.BorrowRequestItemID]
+		if !ok {
+			return nil, errBorrowRequestItemNotFound
+		}
+		if item.Quantity > adjustment.RequestedQuantity {
+			return nil, errInvalidItemAdjustment
+		}
+		adjustment.ApprovedQuantity = item.Quantity
+	}
+
+	var adjustments []itemAdjustment
+	remaining := 0
+	for _, item := range current {
+		if item.ApprovedQuantity > 0 {
+			remaining++
+		}
+		if item.ApprovedQuantity != item.RequestedQuantity {
+			adjustments = append(adjustments, item)
+		}
+	}
+
+	if remaining == 0 {
+		return nil, errAllItemsDropped
+	}
+
+	for _, adjustment := range adjustments {
+		if adjustment.ApprovedQuantity == 0 {
+			query := "DELETE FROM borrow_request_item WHERE borrow_request_item_id = $1"
+			if _, err := tx.Exec(ctx, query, adjustment.BorrowRequestItemID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		query := "UPDATE borrow_request_item SET quantity = $1 WHERE borrow_request_item_id = $2"
+		if _, err := tx.Exec(ctx, query, adjustment.ApprovedQuantity, adjustment.BorrowRequestItemID); err != nil {
+			return nil, err
+		}
+	}
+
+	return adjustments, nil
+}
@@ -0,0 +1,346 @@
+package equipment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/valkey-io/valkey-go"
+	"github.com/xGihyun/hirami/api"
+)
+
+const (
+	defaultAnalyticsCacheTTL = 10 * time.Minute
+	defaultAnalyticsRange    = 30 * 24 * time.Hour
+
+	// maxAnalyticsRange keeps a single report from scanning years of loans.
+	maxAnalyticsRange = 366 * 24 * time.Hour
+)
+
+var errInvalidAnalyticsRange = fmt.Errorf("invalid analytics date range")
+
+// loadAnalyticsCacheTTL reads ANALYTICS_CACHE_TTL, e.g. "10m". Reports are
+// at most this stale.
+func loadAnalyticsCacheTTL() time.Duration {
+	ttl := defaultAnalyticsCacheTTL
+
+	if v := os.Getenv("ANALYTICS_CACHE_TTL"); v != "" {
+		t, err := time.ParseDuration(v)
+		if err != nil || t <= 0 {
+			slog.Warn("Invalid ANALYTICS_CACHE_TTL, using default: " + v)
+		} else {
+			ttl = t
+		}
+	}
+
+	return ttl
+}
+
+type utilizationReport struct {
+	From       time.Time     `json:"from"`
+	To         time.Time     `json:"to"`
+	Equipments []utilization `json:"equipments"`
+	Categories []utilization `json:"categories"`
+}
+
+type utilization struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Units     int    `json:"units"`
+	IdleUnits int    `json:"idleUnits"`
+
+	// LoanCount only counts loans that started within the range, while
+	// LoanHours is every hour a unit spent on loan within it.
+	LoanCount        int      `json:"loanCount"`
+	LoanHours        float64  `json:"loanHours"`
+	AverageLoanHours *float64 `json:"averageLoanHours"`
+
+	// Utilization is the share of unit-hours spent on loan, from 0 to 1.
+	Utilization float64 `json:"utilization"`
+
+	PeakConcurrentLoans int        `json:"peakConcurrentLoans"`
+	PeakAt              *time.Time `json:"peakAt"`
+}
+
+type getUtilizationParams struct {
+	from   time.Time
+	to     time.Time
+	siteID string
+}
+
+// getUtilization measures how much each equipment type and category was
+// lent out. A loan runs from its borrow transaction until the unit is
+// returned. Units only count from when they were added and disposed units
+// are left out entirely.
+func (r *repository) getUtilization(ctx context.Context, params getUtilizationParams) (utilizationReport, error) {
+	query := `
+	WITH unit AS (
+		SELECT
+			equipment.equipment_id,
+			equipment.equipment_type_id,
+			GREATEST(equipment.created_at, $1) AS available_from
+		FROM equipment
+		WHERE equipment.created_at < $2
+		AND equipment.equipment_status_id <> $4
+		AND ($3 = '' OR equipment.site_id::text = $3)
+	),
+	loan AS (
+		SELECT
+			unit.equipment_id,
+			unit.equipment_type_id,
+			borrow_transaction.created_at AS borrowed_at,
+			COALESCE(returned.returned_at, NOW()) AS returned_at
+		FROM borrow_transaction
+		JOIN unit ON unit.equipment_id = borrow_transaction.equipment_id
+		LEFT JOIN LATERAL (
+			SELECT MIN(return_transaction.created_at) AS returned_at
+			FROM return_transaction
+			WHERE return_transaction.borrow_transaction_id = borrow_transaction.borrow_transaction_id
+		) returned ON TRUE
+		WHERE borrow_transaction.created_at < $2
+		AND COALESCE(returned.returned_at, NOW()) > $1
+	),
+	dimension AS (
+		SELECT 'equipment' AS kind, equipment_type_id AS id, name, equipment_type_id
+		FROM equipment_type
+
+		UNION ALL
+
+		SELECT 'category', category.category_id, category.name, equipment_type_category.equipment_type_id
+		FROM category
+		JOIN equipment_type_category ON equipment_type_category.category_id = category.category_id
+	),
+	unit_stats AS (
+		SELECT
+			dimension.kind,
+			dimension.id,
+			COUNT(*) AS units,
+			SUM(EXTRACT(EPOCH FROM $2 - unit.available_from)) / 3600 AS available_hours,
+			COUNT(*) FILTER (
+				WHERE NOT EXISTS (SELECT 1 FROM loan WHERE loan.equipment_id = unit.equipment_id)
+			) AS idle_units
+		FROM dimension
+		JOIN unit ON unit.equipment_type_id = dimension.equipment_type_id
+		GROUP BY dimension.kind, dimension.id
+	),
+	loan_stats AS (
+		SELECT
+			dimension.kind,
+			dimension.id,
+			COUNT(*) FILTER (WHERE loan.borrowed_at >= $1) AS loan_count,
+			SUM(EXTRACT(EPOCH FROM LEAST(loan.returned_at, $2) - GREATEST(loan.borrowed_at, $1))) / 3600 AS loan_hours,
+			AVG(EXTRACT(EPOCH FROM loan.returned_at - loan.borrowed_at)) FILTER (WHERE loan.borrowed_at >= $1) / 3600 AS average_loan_hours
+		FROM dimension
+		JOIN loan ON loan.equipment_type_id = dimension.equipment_type_id
+		GROUP BY dimension.kind, dimension.id
+	),
+	loan_event AS (
+		SELECT dimension.kind, dimension.id, GREATEST(loan.borrowed_at, $1) AS event_at, 1 AS delta
+		FROM dimension
+		JOIN loan ON loan.equipment_type_id = dimension.equipment_type_id
+
+		UNION ALL
+
+		SELECT dimension.kind, dimension.id, LEAST(loan.returned_at, $2), -1
+		FROM dimension
+		JOIN loan ON loan.equipment_type_id = dimension.equipment_type_id
+	),
+	concurrency AS (
+		-- Returns sort before loans at the same instant so a unit handed
+		-- straight to the next borrower doesn't count twice
+		SELECT
+			kind,
+			id,
+			event_at,
+			SUM(delta) OVER (PARTITION BY kind, id ORDER BY event_at, delta ROWS UNBOUNDED PRECEDING) AS concurrent
+		FROM loan_event
+	),
+	peak AS (
+		SELECT DISTINCT ON (kind, id) kind, id, concurrent, event_at
+		FROM concurrency
+		ORDER BY kind, id, concurrent DESC, event_at
+	)
+	SELECT
+		dimension.kind,
+		dimension.id,
+		dimension.name,
+		COALESCE(unit_stats.units, 0)::int,
+		COALESCE(unit_stats.idle_units, 0)::int,
+		COALESCE(loan_stats.loan_count, 0)::int,
+		COALESCE(loan_stats.loan_hours, 0)::float8,
+		loan_stats.average_loan_hours::float8,
+		COALESCE(unit_stats.available_hours, 0)::float8,
+		COALESCE(peak.concurrent, 0)::int,
+		peak.event_at
+	FROM (SELECT DISTINCT kind, id, name FROM dimension) dimension
+	LEFT JOIN unit_stats ON unit_stats.kind = dimension.kind AND unit_stats.id = dimension.id
+	LEFT JOIN loan_stats ON loan_stats.kind = dimension.kind AND loan_stats.id = dimension.id
+	LEFT JOIN peak ON peak.kind = dimension.kind AND peak.id = dimension.id
+	WHERE unit_stats.id IS NOT NULL OR loan_stats.id IS NOT NULL
+	ORDER BY dimension.kind, dimension.name, dimension.id
+	`
+
+	rows, err := r.querier.Query(ctx, query, params.from, params.to, params.siteID, disposed)
+	if err != nil {
+		return utilizationReport{}, err
+	}
+	defer rows.Close()
+
+	report := utilizationReport{
+		From:       params.from,
+		To:         params.to,
+		Equipments: []utilization{},
+		Categories: []utilization{},
+	}
+
+	for rows.Next() {
+		var kind string
+		var u utilization
+		var availableHours float64
+		if err := rows.Scan(
+			&kind,
+			&u.ID,
+			&u.Name,
+			&u.Units,
+			&u.IdleUnits,
+			&u.LoanCount,
+			&u.LoanHours,
+			&u.AverageLoanHours,
+			&availableHours,
+			&u.PeakConcurrentLoans,
+			&u.PeakAt,
+		); err != nil {
+			return utilizationReport{}, err
+		}
+
+		if availableHours > 0 {
+			u.Utilization = min(u.LoanHours/availableHours, 1)
+		}
+
+		if kind == "category" {
+			report.Categories = append(report.Categories, u)
+		} else {
+			report.Equipments = append(report.Equipments, u)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return utilizationReport{}, err
+	}
+
+	return report, nil
+}
+
+// parseUtilizationParams defaults to the last 30 days. The range never goes
+// past now and is rounded to the cache TTL so requests made around the same
+// time share a cached report.
+func parseUtilizationParams(r *http.Request, cacheTTL time.Duration) (getUtilizationParams, error) {
+	query := r.URL.Query()
+	now := time.Now().Truncate(cacheTTL)
+
+	params := getUtilizationParams{
+		from:   now.Add(-defaultAnalyticsRange),
+		to:     now,
+		siteID: query.Get("site"),
+	}
+
+	if v := query.Get("from"); v != "" {
+		from, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return getUtilizationParams{}, fmt.Errorf("%w: %w", errInvalidAnalyticsRange, err)
+		}
+		params.from = from.Truncate(time.Minute)
+	}
+
+	if v := query.Get("to"); v != "" {
+		to, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return getUtilizationParams{}, fmt.Errorf("%w: %w", errInvalidAnalyticsRange, err)
+		}
+		params.to = to.Truncate(time.Minute)
+		if params.to.After(now) {
+			params.to = now
+		}
+	}
+
+	if !params.from.Before(params.to) || params.to.Sub(params.from) > maxAnalyticsRange {
+		return getUtilizationParams{}, errInvalidAnalyticsRange
+	}
+
+	return params, nil
+}
+
+func (params getUtilizationParams) cacheKey() string {
+	return fmt.Sprintf("analytics:utilization:%d:%d:%s", params.from.Unix(), params.to.Unix(), params.siteID)
+}
+
+// getCachedReport loads a report cached under key into dst. Cache errors
+// aren't worth failing the request over, so they only count as a miss.
+func (s *Server) getCachedReport(ctx context.Context, key string, dst any) bool {
+	cached, err := s.valkeyClient.Do(ctx, s.valkeyClient.B().Get().Key(key).Build()).ToString()
+	if err != nil {
+		if !valkey.IsValkeyNil(err) {
+			slog.Warn("Failed to get cached report", "key", key, "error", err)
+		}
+		return false
+	}
+
+	if err := json.Unmarshal([]byte(cached), dst); err != nil {
+		slog.Warn("Failed to decode cached report", "key", key, "error", err)
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) cacheReport(ctx context.Context, key string, report any) {
+	jsonData, err := json.Marshal(report)
+	if err != nil {
+		slog.Warn("Failed to encode report", "key", key, "error", err)
+		return
+	}
+
+	setCmd := s.valkeyClient.B().Set().Key(key).Value(string(jsonData)).Ex(s.analyticsCacheTTL).Build()
+	if err := s.valkeyClient.Do(ctx, setCmd).Error(); err != nil {
+		slog.Warn("Failed to cache report", "key", key, "error", err)
+	}
+}
+
+func (s *Server) getUtilization(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	params, err := parseUtilizationParams(r, s.analyticsCacheTTL)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get utilization: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid date range. Use RFC 3339 dates at most a year apart.",
+		}
+	}
+
+	key := params.cacheKey()
+
+	var report utilizationReport
+	if !s.getCachedReport(ctx, key, &report) {
+		report, err = s.repository.getUtilization(ctx, params)
+		if err != nil {
+			return api.Response{
+				Error:   fmt.Errorf("get utilization: %w", err),
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to get utilization.",
+			}
+		}
+
+		s.cacheReport(ctx, key, report)
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched utilization.",
+		Data:    report,
+	}
+}
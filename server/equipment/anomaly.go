@@ -1,66 +1,808 @@
 package equipment
 
-// import (
-// 	"bytes"
-// 	"context"
-// 	"encoding/json"
-// 	"net/http"
-// 	"time"
-//
-// 	"github.com/xGihyun/hirami/sse"
-// )
-//
-// func (s *Server) detectAnomaly(ctx context.Context, arg createBorrowResponse) error {
-// 	phTime, err := time.LoadLocation("Asia/Manila")
-// 	if err != nil {
-// 		return err
-// 	}
-//
-// 	// Convert timestamps to PH time since it also sensitive on the hour of the day
-// 	arg.CreatedAt = arg.CreatedAt.In(phTime)
-// 	arg.ExpectedReturnAt = arg.ExpectedReturnAt.In(phTime)
-//
-// 	payload := []createBorrowResponse{arg}
-// 	jsonData, err := json.Marshal(payload)
-// 	if err != nil {
-// 		return err
-// 	}
-//
-// 	anomalyServiceURL := "http://ml:8000/anomalies"
-// 	req, err := http.NewRequestWithContext(ctx, "POST", anomalyServiceURL, bytes.NewBuffer(jsonData))
-// 	if err != nil {
-// 		return err
-// 	}
-// 	req.Header.Set("Content-Type", "application/json")
-//
-// 	resp, err := http.DefaultClient.Do(req)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	defer resp.Body.Close()
-//
-// 	var anomalyRes []anomaly
-// 	if err := json.NewDecoder(resp.Body).Decode(&anomalyRes); err != nil {
-// 		return err
-// 	}
-//
-// 	if err := s.repository.createAnomalyResult(ctx, anomalyRes[0]); err != nil {
-// 		return err
-// 	}
-//
-// 	// Send to SSE
-// 	eventRes := sse.EventResponse{
-// 		Event: eventEquipmentAnomaly,
-// 		Data:  anomalyRes[0],
-// 	}
-// 	jsonData, err = json.Marshal(eventRes)
-// 	if err != nil {
-// 		return err
-// 	}
-//
-// 	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
-// 	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
-// 		return err
-// 	}
-// 	return nil
-// }
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/sse"
+	"github.com/xGihyun/hirami/user"
+)
+
+const (
+	// defaultAnomalyThreshold is the usual cut-off for modified z-scores.
+	defaultAnomalyThreshold = 3.5
+
+	// maxAnomalyScore caps the score of values that are off the charts, e.g.
+	// when every past request looked exactly the same.
+	maxAnomalyScore = 10
+
+	// minAnomalyHistory is how many past requests are needed before the
+	// scorer trusts them over its built-in baselines.
+	minAnomalyHistory = 30
+
+	// anomalyHistoryLimit is how many of the latest requests the baselines
+	// are computed from.
+	anomalyHistoryLimit = 500
+
+	// anomalyCalibrationWindow is how far back manager reviews are used to
+	// calibrate the threshold, so old labels stop mattering as habits change.
+	anomalyCalibrationWindow = 90 * 24 * time.Hour
+)
+
+// borrowFeatures describe a borrow request the way the anomaly scorer sees
+// it.
+type borrowFeatures struct {
+	quantity  float64
+	itemTypes float64
+	loanHours float64
+
+	// requestHour is when the request was filed, in hours since midnight in
+	// Asia/Manila.
+	requestHour float64
+
+	// recentRequests is how many other requests the borrower filed in the
+	// week before this one.
+	recentRequests float64
+}
+
+// featureBaseline is what normal looks like for one feature. Spread is the
+// median absolute deviation scaled to match a standard deviation.
+type featureBaseline struct {
+	median float64
+	spread float64
+}
+
+// defaultAnomalyBaselines are used until there's enough history, roughly
+// what the old anomaly service was trained on: up to 20 units of up to 3
+// kinds, lent for a few hours and requested between 7AM and 7PM.
+var defaultAnomalyBaselines = struct {
+	quantity       featureBaseline
+	itemTypes      featureBaseline
+	loanHours      featureBaseline
+	hourDistance   featureBaseline
+	recentRequests featureBaseline
+	hourCenter     float64
+}{
+	quantity:       featureBaseline{median: 10, spread: 7.5},
+	itemTypes:      featureBaseline{median: 2, spread: 1.5},
+	loanHours:      featureBaseline{median: 4, spread: 3},
+	hourDistance:   featureBaseline{median: 3, spread: 1.5},
+	recentRequests: featureBaseline{median: 1, spread: 1.5},
+	hourCenter:     13,
+}
+
+// anomalyScorer flags borrow requests that stand out from recent ones using
+// robust z-scores, which the odd outlier in the history doesn't skew the way
+// it would a mean and standard deviation. Only unusually high quantities,
+// loan lengths and request bursts count, while the request hour counts
+// either way from the usual time of day.
+type anomalyScorer struct {
+	location  *time.Location
+	threshold float64
+}
+
+// loadManilaLocation is the time zone borrowing habits follow.
+func loadManilaLocation() *time.Location {
+	location, err := time.LoadLocation("Asia/Manila")
+	if err != nil {
+		// Manila has no daylight saving time
+		location = time.FixedZone("PHT", 8*60*60)
+	}
+	return location
+}
+
+// loadAnomalyScorer reads ANOMALY_THRESHOLD, which defaults to 3.5.
+func loadAnomalyScorer(location *time.Location) anomalyScorer {
+	scorer := anomalyScorer{
+		location:  location,
+		threshold: defaultAnomalyThreshold,
+	}
+
+	if v := os.Getenv("ANOMALY_THRESHOLD"); v != "" {
+		threshold, err := strconv.ParseFloat(v, 64)
+		if err != nil || threshold <= 0 {
+			slog.Warn("Invalid ANOMALY_THRESHOLD, using default: " + v)
+		} else {
+			scorer.threshold = threshold
+		}
+	}
+
+	return scorer
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// newFeatureBaseline falls back to the mean absolute deviation when more
+// than half of the values are the same and the median absolute deviation is
+// zero.
+func newFeatureBaseline(values []float64) featureBaseline {
+	m := median(values)
+
+	deviations := make([]float64, len(values))
+	var total float64
+	for i, v := range values {
+		deviations[i] = math.Abs(v - m)
+		total += deviations[i]
+	}
+
+	spread := median(deviations) / 0.6745
+	if spread == 0 && len(values) > 0 {
+		spread = 1.2533 * total / float64(len(values))
+	}
+
+	return featureBaseline{median: m, spread: spread}
+}
+
+// zScore only reports deviations above the median.
+func (b featureBaseline) zScore(x float64) float64 {
+	if x <= b.median {
+		return 0
+	}
+	if b.spread == 0 {
+		return maxAnomalyScore
+	}
+	return min((x-b.median)/b.spread, maxAnomalyScore)
+}
+
+// hourDistance is how far apart two times of day are, going around midnight
+// if that's shorter.
+func hourDistance(a, b float64) float64 {
+	d := math.Abs(a - b)
+	return min(d, 24-d)
+}
+
+// circularMeanHour is the usual time of day, averaged on a clock face so 11PM
+// and 1AM average out to midnight rather than noon.
+func circularMeanHour(hours []float64) float64 {
+	var sin, cos float64
+	for _, h := range hours {
+		angle := h / 24 * 2 * math.Pi
+		sin += math.Sin(angle)
+		cos += math.Cos(angle)
+	}
+
+	hour := math.Atan2(sin, cos) / (2 * math.Pi) * 24
+	if hour < 0 {
+		hour += 24
+	}
+	return hour
+}
+
+// score rates a request against the ones before it. The score is the
+// highest robust z-score across the features.
+func (s anomalyScorer) score(target borrowFeatures, history []borrowFeatures) (float64, bool) {
+	baselines := defaultAnomalyBaselines
+
+	if len(history) >= minAnomalyHistory {
+		quantities := make([]float64, len(history))
+		itemTypes := make([]float64, len(history))
+		loanHours := make([]float64, len(history))
+		hours := make([]float64, len(history))
+		recentRequests := make([]float64, len(history))
+		for i, h := range history {
+			quantities[i] = h.quantity
+			itemTypes[i] = h.itemTypes
+			loanHours[i] = h.loanHours
+			hours[i] = h.requestHour
+			recentRequests[i] = h.recentRequests
+		}
+
+		baselines.hourCenter = circularMeanHour(hours)
+		distances := make([]float64, len(hours))
+		for i, h := range hours {
+			distances[i] = hourDistance(h, baselines.hourCenter)
+		}
+
+		baselines.quantity = newFeatureBaseline(quantities)
+		baselines.itemTypes = newFeatureBaseline(itemTypes)
+		baselines.loanHours = newFeatureBaseline(loanHours)
+		baselines.hourDistance = newFeatureBaseline(distances)
+		baselines.recentRequests = newFeatureBaseline(recentRequests)
+	}
+
+	score := max(
+		baselines.quantity.zScore(target.quantity),
+		baselines.itemTypes.zScore(target.itemTypes),
+		baselines.loanHours.zScore(target.loanHours),
+		baselines.hourDistance.zScore(hourDistance(target.requestHour, baselines.hourCenter)),
+		baselines.recentRequests.zScore(target.recentRequests),
+	)
+
+	return score, score > s.threshold
+}
+
+// anomalyCalibration sums up how managers reviewed recent results.
+type anomalyCalibration struct {
+	// MaxFalsePositive is the highest score marked as a false positive.
+	MaxFalsePositive *float64
+
+	// MinConfirmed is the lowest score confirmed as an anomaly.
+	MinConfirmed *float64
+}
+
+// calibrate raises the threshold so requests scoring no higher than a known
+// false positive aren't flagged again, without going past a confirmed
+// anomaly. It never goes past twice the configured threshold so one careless
+// review can't switch detection off.
+func (s anomalyScorer) calibrate(c anomalyCalibration) anomalyScorer {
+	// Scores are stored as REAL, so leave some room for rounding
+	const tolerance = 1e-3
+
+	threshold := s.threshold
+
+	if c.MaxFalsePositive != nil && *c.MaxFalsePositive+tolerance > threshold {
+		threshold = *c.MaxFalsePositive + tolerance
+	}
+
+	// Missing an anomaly is worse than flagging a harmless request
+	if c.MinConfirmed != nil && *c.MinConfirmed <= threshold {
+		threshold = max(s.threshold, *c.MinConfirmed-tolerance)
+	}
+
+	s.threshold = min(threshold, 2*s.threshold)
+	return s
+}
+
+const borrowFeaturesSelect = `
+	SELECT
+		borrow_request.created_at,
+		EXTRACT(EPOCH FROM borrow_request.expected_return_at - COALESCE(borrow_request.expected_claim_at, borrow_request.created_at)) / 3600,
+		COALESCE(items.quantity, 0)::float8,
+		COALESCE(items.item_types, 0)::float8,
+		(
+			SELECT COUNT(*)
+			FROM borrow_request recent
+			WHERE recent.requested_by = borrow_request.requested_by
+			AND recent.created_at < borrow_request.created_at
+			AND recent.created_at >= borrow_request.created_at - INTERVAL '7 days'
+		)::float8
+	FROM borrow_request
+	LEFT JOIN LATERAL (
+		SELECT SUM(borrow_request_item.quantity) AS quantity, COUNT(*) AS item_types
+		FROM borrow_request_item
+		WHERE borrow_request_item.borrow_request_id = borrow_request.borrow_request_id
+	) items ON TRUE
+`
+
+func collectBorrowFeatures(rows pgx.Rows, location *time.Location) ([]borrowFeatures, error) {
+	defer rows.Close()
+
+	var features []borrowFeatures
+	for rows.Next() {
+		var f borrowFeatures
+		var createdAt time.Time
+		if err := rows.Scan(&createdAt, &f.loanHours, &f.quantity, &f.itemTypes, &f.recentRequests); err != nil {
+			return nil, err
+		}
+
+		createdAt = createdAt.In(location)
+		f.requestHour = float64(createdAt.Hour()) + float64(createdAt.Minute())/60
+		features = append(features, f)
+	}
+
+	return features, rows.Err()
+}
+
+// getBorrowFeatures returns the features of a borrow request along with the
+// ones of the latest requests filed before it.
+func (r *repository) getBorrowFeatures(
+	ctx context.Context,
+	borrowRequestID string,
+	location *time.Location,
+) (borrowFeatures, []borrowFeatures, error) {
+	rows, err := r.querier.Query(ctx, borrowFeaturesSelect+" WHERE borrow_request.borrow_request_id = $1", borrowRequestID)
+	if err != nil {
+		return borrowFeatures{}, nil, err
+	}
+
+	target, err := collectBorrowFeatures(rows, location)
+	if err != nil {
+		return borrowFeatures{}, nil, err
+	}
+	if len(target) == 0 {
+		return borrowFeatures{}, nil, pgx.ErrNoRows
+	}
+
+	historyQuery := borrowFeaturesSelect + `
+	WHERE borrow_request.created_at < (
+		SELECT created_at FROM borrow_request WHERE borrow_request_id = $1
+	)
+	ORDER BY borrow_request.created_at DESC
+	LIMIT $2
+	`
+
+	rows, err = r.querier.Query(ctx, historyQuery, borrowRequestID, anomalyHistoryLimit)
+	if err != nil {
+		return borrowFeatures{}, nil, err
+	}
+
+	history, err := collectBorrowFeatures(rows, location)
+	if err != nil {
+		return borrowFeatures{}, nil, err
+	}
+
+	return target[0], history, nil
+}
+
+// detectAnomaly scores a new borrow request, records the result and lets the
+// managers know. It runs in the background so a slow query or anomaly service
+// never holds up the borrower.
+func (s *Server) detectAnomaly(ctx context.Context, arg createBorrowResponse) error {
+	result, err := s.detector.Detect(ctx, arg)
+	if err != nil {
+		return fmt.Errorf("detect anomaly: %w", err)
+	}
+
+	if err := s.repository.createAnomalyResult(ctx, result); err != nil {
+		return fmt.Errorf("create anomaly result: %w", err)
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventEquipmentAnomaly,
+		Data:  result,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return err
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return res.Error()
+	}
+
+	return nil
+}
+
+func (r *repository) getAnomalyCalibration(
+	ctx context.Context,
+	detector anomalyDetector,
+	since time.Time,
+) (anomalyCalibration, error) {
+	query := `
+	SELECT
+		(MAX(score) FILTER (WHERE is_false_positive))::float8,
+		(MIN(score) FILTER (WHERE NOT is_false_positive))::float8
+	FROM anomaly_result
+	WHERE detector = $1 AND reviewed_at >= $2
+	`
+
+	var c anomalyCalibration
+	if err := r.querier.QueryRow(ctx, query, string(detector), since).Scan(&c.MaxFalsePositive, &c.MinConfirmed); err != nil {
+		return anomalyCalibration{}, err
+	}
+
+	return c, nil
+}
+
+// Review filters for flagged borrow requests.
+const (
+	anomalyReviewPending       = "pending"
+	anomalyReviewFalsePositive = "false-positive"
+	anomalyReviewConfirmed     = "confirmed"
+)
+
+var (
+	errInvalidAnomalyReview = fmt.Errorf("isFalsePositive is required")
+	errAnomalyNotFlagged    = fmt.Errorf("only flagged borrow requests can be reviewed")
+)
+
+type flaggedBorrowRequest struct {
+	BorrowRequestID  string                    `json:"id"`
+	RequestedAt      time.Time                 `json:"requestedAt"`
+	Borrower         user.BasicInfo            `json:"borrower"`
+	RequestedItems   []borrowRequestItem       `json:"requestedItems"`
+	Location         string                    `json:"location"`
+	Purpose          string                    `json:"purpose"`
+	Status           borrowRequestStatusDetail `json:"status"`
+	ExpectedClaimAt  time.Time                 `json:"expectedClaimAt"`
+	ExpectedReturnAt time.Time                 `json:"expectedReturnAt"`
+	Site             siteDetail                `json:"site"`
+
+	Anomaly    anomaly         `json:"anomaly"`
+	FlaggedAt  time.Time       `json:"flaggedAt"`
+	ReviewedBy *user.BasicInfo `json:"reviewedBy"`
+	ReviewedAt *time.Time      `json:"reviewedAt"`
+}
+
+var flaggedBorrowRequestSelect = `
+	SELECT
+		borrow_request.borrow_request_id,
+		borrow_request.created_at AS requested_at,
+		jsonb_build_object(
+			'id', borrower.person_id,
+			'firstName', borrower.first_name,
+			'middleName', borrower.middle_name,
+			'lastName', borrower.last_name,
+			'avatarUrl', borrower.avatar_url
+		) AS borrower,
+		COALESCE(requested_items_agg.items_agg, '[]'::jsonb) AS requested_items,
+		borrow_request.location,
+		borrow_request.purpose,
+		jsonb_build_object(
+			'id', borrow_request_status.borrow_request_status_id,
+			'code', borrow_request_status.code,
+			'label', borrow_request_status.label
+		) AS status,
+		COALESCE(borrow_request.expected_claim_at, borrow_request.created_at) AS expected_claim_at,
+		borrow_request.expected_return_at,
+		` + siteJSON("borrow_request.site_id") + ` AS site,
+		jsonb_build_object(
+			'borrowRequestId', anomaly_result.borrow_request_id,
+			'score', anomaly_result.score,
+			'isAnomaly', anomaly_result.is_anomaly,
+			'isFalsePositive', anomaly_result.is_false_positive
+		) AS anomaly,
+		anomaly_result.created_at AS flagged_at,
+		CASE
+			WHEN reviewer.person_id IS NULL THEN NULL
+			ELSE jsonb_build_object(
+				'id', reviewer.person_id,
+				'firstName', reviewer.first_name,
+				'middleName', reviewer.middle_name,
+				'lastName', reviewer.last_name,
+				'avatarUrl', reviewer.avatar_url
+			)
+		END AS reviewed_by,
+		anomaly_result.reviewed_at
+	FROM anomaly_result
+	JOIN borrow_request ON borrow_request.borrow_request_id = anomaly_result.borrow_request_id
+	JOIN borrow_request_status ON borrow_request_status.borrow_request_status_id = borrow_request.borrow_request_status_id
+	JOIN person borrower ON borrower.person_id = borrow_request.requested_by
+	LEFT JOIN person reviewer ON reviewer.person_id = anomaly_result.reviewed_by
+	LEFT JOIN LATERAL (
+		SELECT jsonb_agg(
+			jsonb_build_object(
+				'id', borrow_request_item.borrow_request_item_id,
+				'equipment', jsonb_build_object(
+					'id', equipment_type.equipment_type_id,
+					'name', equipment_type.name,
+					'brand', equipment_type.brand,
+					'model', equipment_type.model,
+					'imageUrl', equipment_type.image_url,
+					'quantity', borrow_request_item.quantity
+				)
+			)
+		) AS items_agg
+		FROM borrow_request_item
+		JOIN equipment_type USING (equipment_type_id)
+		WHERE borrow_request_item.borrow_request_id = borrow_request.borrow_request_id
+	) requested_items_agg ON TRUE
+	WHERE anomaly_result.is_anomaly
+`
+
+type getFlaggedBorrowRequestsParams struct {
+	review    *string
+	userID    *string
+	siteID    *string
+	startDate *time.Time
+	endDate   *time.Time
+
+	page api.Page
+}
+
+// getFlaggedBorrowRequests lists the requests the detector flagged, most
+// recently flagged first.
+func (r *repository) getFlaggedBorrowRequests(
+	ctx context.Context,
+	params getFlaggedBorrowRequestsParams,
+) ([]flaggedBorrowRequest, *string, error) {
+	query := flaggedBorrowRequestSelect
+
+	var args []any
+	if params.review != nil {
+		switch *params.review {
+		case anomalyReviewPending:
+			query += " AND anomaly_result.is_false_positive IS NULL"
+		case anomalyReviewFalsePositive:
+			query += " AND anomaly_result.is_false_positive"
+		case anomalyReviewConfirmed:
+			query += " AND NOT anomaly_result.is_false_positive"
+		}
+	}
+
+	if params.userID != nil && *params.userID != "" {
+		args = append(args, *params.userID)
+		query += fmt.Sprintf(" AND borrow_request.requested_by::text = $%d", len(args))
+	}
+
+	if params.siteID != nil && *params.siteID != "" {
+		args = append(args, *params.siteID)
+		query += fmt.Sprintf(" AND borrow_request.site_id::text = $%d", len(args))
+	}
+
+	if params.startDate != nil {
+		args = append(args, *params.startDate)
+		query += fmt.Sprintf(" AND anomaly_result.created_at >= $%d", len(args))
+	}
+
+	if params.endDate != nil {
+		args = append(args, *params.endDate)
+		query += fmt.Sprintf(" AND anomaly_result.created_at <= $%d", len(args))
+	}
+
+	if params.page.Cursor != "" {
+		var cursorFlaggedAt time.Time
+		var cursorID string
+		if err := api.DecodeCursor(params.page.Cursor, &cursorFlaggedAt, &cursorID); err != nil {
+			return nil, nil, err
+		}
+
+		args = append(args, cursorFlaggedAt, cursorID)
+		query += fmt.Sprintf(
+			" AND (anomaly_result.created_at, anomaly_result.borrow_request_id) < ($%d, $%d)",
+			len(args)-1,
+			len(args),
+		)
+	}
+
+	query += " ORDER BY anomaly_result.created_at DESC, anomaly_result.borrow_request_id DESC"
+
+	if params.page.Limit > 0 {
+		args = append(args, params.page.Limit+1)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := r.querier.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	requests, err := pgx.CollectRows(rows, pgx.RowToStructByName[flaggedBorrowRequest])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if requests == nil {
+		requests = []flaggedBorrowRequest{}
+	}
+
+	return api.NextPage(requests, params.page, func(f flaggedBorrowRequest) []any {
+		return []any{f.FlaggedAt, f.BorrowRequestID}
+	})
+}
+
+type reviewAnomaly struct {
+	BorrowRequestID string `json:"-"`
+
+	// IsFalsePositive is true when the request turned out to be harmless and
+	// false when the anomaly is confirmed.
+	IsFalsePositive *bool `json:"isFalsePositive"`
+
+	ReviewedBy string `json:"-"`
+}
+
+func (arg reviewAnomaly) validate() error {
+	if arg.IsFalsePositive == nil {
+		return errInvalidAnomalyReview
+	}
+	return nil
+}
+
+// reviewAnomaly labels a flagged request. The labels are what the built-in
+// detector calibrates its threshold with.
+func (r *repository) reviewAnomaly(ctx context.Context, arg reviewAnomaly) (flaggedBorrowRequest, error) {
+	if err := arg.validate(); err != nil {
+		return flaggedBorrowRequest{}, err
+	}
+
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return flaggedBorrowRequest{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var isAnomaly bool
+	if err := tx.QueryRow(
+		ctx,
+		"SELECT is_anomaly FROM anomaly_result WHERE borrow_request_id = $1 FOR UPDATE",
+		arg.BorrowRequestID,
+	).Scan(&isAnomaly); err != nil {
+		return flaggedBorrowRequest{}, err
+	}
+
+	if !isAnomaly {
+		return flaggedBorrowRequest{}, errAnomalyNotFlagged
+	}
+
+	query := `
+	UPDATE anomaly_result
+	SET
+		is_false_positive = $1,
+		reviewed_by = $2,
+		reviewed_at = NOW(),
+		updated_at = NOW()
+	WHERE borrow_request_id = $3
+	`
+
+	if _, err := tx.Exec(ctx, query, *arg.IsFalsePositive, arg.ReviewedBy, arg.BorrowRequestID); err != nil {
+		return flaggedBorrowRequest{}, err
+	}
+
+	rows, err := tx.Query(ctx, flaggedBorrowRequestSelect+" AND anomaly_result.borrow_request_id = $1", arg.BorrowRequestID)
+	if err != nil {
+		return flaggedBorrowRequest{}, err
+	}
+
+	flagged, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[flaggedBorrowRequest])
+	if err != nil {
+		return flaggedBorrowRequest{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return flaggedBorrowRequest{}, err
+	}
+
+	return flagged, nil
+}
+
+func anomalyReviewErrorResponse(op string, err error) api.Response {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusNotFound,
+			Message: "Borrow request has not been checked for anomalies.",
+		}
+	case errors.Is(err, errInvalidAnomalyReview):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusBadRequest,
+			Message: "Mark the anomaly as a false positive or confirm it.",
+		}
+	case errors.Is(err, errAnomalyNotFlagged):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "Borrow request was not flagged as an anomaly.",
+		}
+	default:
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusInternalServerError,
+			Message: fmt.Sprintf("Failed to %s.", op),
+		}
+	}
+}
+
+func (s *Server) getFlaggedBorrowRequests(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	page, err := api.ParsePage(r)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get flagged borrow requests: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid limit.",
+		}
+	}
+
+	query := r.URL.Query()
+	review := query.Get("review")
+	userID := query.Get("userId")
+	siteID := query.Get("site")
+
+	var startDate, endDate *time.Time
+	if s := query.Get("startDate"); s != "" {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			startDate = &t
+		}
+	}
+	if e := query.Get("endDate"); e != "" {
+		if t, err := time.Parse(time.RFC3339, e); err == nil {
+			endDate = &t
+		}
+	}
+
+	params := getFlaggedBorrowRequestsParams{
+		review:    &review,
+		userID:    &userID,
+		siteID:    &siteID,
+		startDate: startDate,
+		endDate:   endDate,
+		page:      page,
+	}
+
+	requests, nextCursor, err := s.repository.getFlaggedBorrowRequests(ctx, params)
+	if err != nil {
+		if errors.Is(err, api.ErrInvalidCursor) {
+			return api.Response{
+				Error:   fmt.Errorf("get flagged borrow requests: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid cursor.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("get flagged borrow requests: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get flagged borrow requests.",
+		}
+	}
+
+	return api.Response{
+		Code:       http.StatusOK,
+		Message:    "Successfully fetched flagged borrow requests.",
+		Data:       requests,
+		NextCursor: nextCursor,
+	}
+}
+
+func (s *Server) reviewAnomaly(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data reviewAnomaly
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("review anomaly: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid anomaly review.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("review anomaly", err)
+	}
+	data.BorrowRequestID = r.PathValue("id")
+	data.ReviewedBy = claims.UserID
+
+	flagged, err := s.repository.reviewAnomaly(ctx, data)
+	if err != nil {
+		return anomalyReviewErrorResponse("review anomaly", err)
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventEquipmentAnomalyReview,
+		Data:  flagged,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("review anomaly: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to review anomaly.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("review anomaly: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to review anomaly.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully reviewed anomaly.",
+		Data:    flagged,
+	}
+}
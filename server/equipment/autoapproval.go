@@ -0,0 +1,129 @@
+package equipment
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// autoApprovalRules decide when a new borrow request skips the manager's
+// queue. The borrower must be in good standing, i.e. no active strikes
+// within the strike window and no overdue equipment, and then one of the
+// following has to hold:
+//   - none of the applicable borrow policies require approval
+//   - every requested equipment belongs to a self-service category
+//   - the total quantity is at most maxQuantity
+type autoApprovalRules struct {
+	// maxQuantity of 0 disables the quantity rule.
+	maxQuantity  int
+	strikeWindow time.Duration
+}
+
+// loadAutoApprovalRules reads AUTO_APPROVE_MAX_QUANTITY, which is disabled
+// by default. Good standing uses the same window as the strike policy.
+func loadAutoApprovalRules(policy strikePolicy) autoApprovalRules {
+	rules := autoApprovalRules{strikeWindow: policy.window}
+
+	if v := os.Getenv("AUTO_APPROVE_MAX_QUANTITY"); v != "" {
+		maxQuantity, err := strconv.Atoi(v)
+		if err != nil || maxQuantity < 0 {
+			slog.Warn("Invalid AUTO_APPROVE_MAX_QUANTITY, using default: " + v)
+		} else {
+			rules.maxQuantity = maxQuantity
+		}
+	}
+
+	return rules
+}
+
+// isInGoodStanding checks that the borrower has no active strikes within
+// the window and isn't holding on to overdue equipment.
+func isInGoodStanding(ctx context.Context, q dbQuerier, personID string, strikeWindow time.Duration) (bool, error) {
+	query := `
+	SELECT
+		NOT EXISTS (
+			SELECT 1
+			FROM borrower_strike
+			WHERE person_id = $1
+				AND waived_at IS NULL
+				AND created_at > $2
+		)
+		AND NOT EXISTS (
+			SELECT 1
+			FROM borrow_request
+			WHERE requested_by = $1
+				AND overdue_at IS NOT NULL
+				AND borrow_request_status_id = $3
+		)
+	`
+
+	var ok bool
+	err := q.QueryRow(ctx, query, personID, time.Now().Add(-strikeWindow), claimed).Scan(&ok)
+	return ok, err
+}
+
+// isSelfService checks that every equipment type belongs to at least one
+// self-service category.
+func isSelfService(ctx context.Context, q dbQuerier, equipmentTypeIDs []string) (bool, error) {
+	query := `
+	SELECT bool_and(EXISTS (
+		SELECT 1
+		FROM equipment_type_category
+		JOIN category ON category.category_id = equipment_type_category.category_id
+		WHERE equipment_type_category.equipment_type_id = ids.id
+			AND category.is_self_service
+	))
+	FROM unnest($1::uuid[]) AS ids(id)
+	`
+
+	var ok *bool
+	if err := q.QueryRow(ctx, query, equipmentTypeIDs).Scan(&ok); err != nil {
+		return false, err
+	}
+
+	return ok != nil && *ok, nil
+}
+
+// autoApprovalRemarks returns the remarks recorded on a request that is
+// approved automatically, or an empty string if a manager has to review it.
+func autoApprovalRemarks(
+	ctx context.Context,
+	q dbQuerier,
+	arg createBorrowRequest,
+	requiresApproval bool,
+	rules autoApprovalRules,
+) (string, error) {
+	ok, err := isInGoodStanding(ctx, q, arg.RequestedBy, rules.strikeWindow)
+	if err != nil || !ok {
+		return "", err
+	}
+
+	const prefix = "Automatically approved since the borrower is in good standing and "
+
+	if !requiresApproval {
+		return prefix + "no borrow policy requires a manager's approval.", nil
+	}
+
+	equipmentTypeIDs := make([]string, len(arg.Equipments))
+	total := 0
+	for i, item := range arg.Equipments {
+		equipmentTypeIDs[i] = item.EquipmentTypeID
+		total += int(item.Quantity)
+	}
+
+	ok, err = isSelfService(ctx, q, equipmentTypeIDs)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return prefix + "all requested equipment is self-service.", nil
+	}
+
+	if rules.maxQuantity > 0 && total <= rules.maxQuantity {
+		return prefix + "the request is for at most " + strconv.Itoa(rules.maxQuantity) + " unit(s).", nil
+	}
+
+	return "", nil
+}
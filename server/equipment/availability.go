@@ -0,0 +1,579 @@
+package equipment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xGihyun/hirami/api"
+)
+
+// Units are only set aside for an approved request once its claim time is
+// this close. Until then the request just counts against the availability of
+// its time window, so the units stay free for bookings that end before it.
+const reservationLeadTime = 1 * time.Hour
+
+const (
+	defaultAvailabilitySlot   = 1 * time.Hour
+	minAvailabilitySlot       = 15 * time.Minute
+	maxAvailabilitySlotsCount = 31 * 24
+)
+
+var (
+	errClaimTooEarly             = fmt.Errorf("borrow request cannot be claimed yet")
+	errInvalidAvailabilityWindow = fmt.Errorf("invalid availability window")
+)
+
+// dbQuerier is satisfied by both the pool and a transaction, so availability
+// can be checked inside the transaction that approves a request.
+type dbQuerier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type booking struct {
+	startAt  time.Time
+	endAt    time.Time
+	quantity int
+}
+
+// getBookings returns how many units of an equipment type are committed to
+// approved and claimed requests at the site that overlap the window. An empty
+// site ID covers every site. Claimed units that
+// are overdue are held until now, since they haven't come back yet. Pending
+// requests offered from the waitlist also hold their units so the next
+// borrower in line can't be offered the same ones.
+func getBookings(
+	ctx context.Context,
+	q dbQuerier,
+	siteID string,
+	equipmentTypeID string,
+	from, to time.Time,
+	excludeBorrowRequestID string,
+) ([]booking, error) {
+	query := `
+	SELECT start_at, end_at, quantity
+	FROM (
+		SELECT
+			borrow_request.borrow_request_id,
+			borrow_request.site_id,
+			borrow_request.expected_claim_at AS start_at,
+			borrow_request.expected_return_at AS end_at,
+			SUM(borrow_request_item.quantity)::int AS quantity
+		FROM borrow_request
+		JOIN borrow_request_item USING (borrow_request_id)
+		WHERE (
+			borrow_request.borrow_request_status_id = $1
+			OR (
+				borrow_request.borrow_request_status_id = $7
+				AND EXISTS (
+					SELECT 1
+					FROM waitlist_entry
+					WHERE waitlist_entry.borrow_request_id = borrow_request.borrow_request_id
+				)
+			)
+		)
+		AND borrow_request_item.equipment_type_id = $3
+		GROUP BY borrow_request.borrow_request_id
+
+		UNION ALL
+
+		SELECT
+			borrow_request.borrow_request_id,
+			borrow_request.site_id,
+			LEAST(COALESCE(borrow_request.claimed_at, borrow_request.expected_claim_at), NOW()) AS start_at,
+			GREATEST(borrow_request.expected_return_at, NOW()) AS end_at,
+			COUNT(borrow_transaction.borrow_transaction_id)::int AS quantity
+		FROM borrow_request
+		JOIN borrow_request_item USING (borrow_request_id)
+		JOIN borrow_transaction USING (borrow_request_item_id)
+		WHERE borrow_request.borrow_request_status_id = $2
+		AND borrow_request_item.equipment_type_id = $3
+		AND NOT EXISTS (
+			SELECT 1
+			FROM return_transaction
+			WHERE return_transaction.borrow_transaction_id = borrow_transaction.borrow_transaction_id
+		)
+		GROUP BY borrow_request.borrow_request_id
+	) bookings
+	WHERE start_at < $5 AND end_at > $4
+	AND borrow_request_id::text <> $6
+	AND ($8 = '' OR site_id::text = $8)
+	`
+
+	rows, err := q.Query(ctx, query, approved, claimed, equipmentTypeID, from, to, excludeBorrowRequestID, pending, siteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bookings []booking
+	for rows.Next() {
+		var b booking
+		if err := rows.Scan(&b.startAt, &b.endAt, &b.quantity); err != nil {
+			return nil, err
+		}
+		bookings = append(bookings, b)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return bookings, nil
+}
+
+// getCapacity counts the units of an equipment type kept at the site that can
+// be lent out at all, regardless of who has them right now. An empty site ID
+// covers every site.
+func getCapacity(ctx context.Context, q dbQuerier, siteID, equipmentTypeID string) (int, error) {
+	query := `
+	SELECT COUNT(equipment.equipment_id) FILTER (
+		WHERE equipment.equipment_status_id IN ($2, $3, $4)
+		AND ($5 = '' OR equipment.site_id::text = $5)
+	)
+	FROM equipment_type
+	LEFT JOIN equipment USING (equipment_type_id)
+	WHERE equipment_type.equipment_type_id = $1
+	GROUP BY equipment_type.equipment_type_id
+	`
+
+	var capacity int
+	if err := q.QueryRow(ctx, query, equipmentTypeID, available, reserved, borrowed, siteID).Scan(&capacity); err != nil {
+		return 0, err
+	}
+
+	return capacity, nil
+}
+
+// peakDemand is the most units the bookings need at the same time within the
+// window. Demand only goes up when a booking starts, so it is enough to look
+// at the start of the window and at every booking that starts inside it.
+func peakDemand(bookings []booking, from, to time.Time) int {
+	points := []time.Time{from}
+	for _, b := range bookings {
+		if b.startAt.After(from) && b.startAt.Before(to) {
+			points = append(points, b.startAt)
+		}
+	}
+
+	peak := 0
+	for _, point := range points {
+		demand := 0
+		for _, b := range bookings {
+			if !b.startAt.After(point) && b.endAt.After(point) {
+				demand += b.quantity
+			}
+		}
+		peak = max(peak, demand)
+	}
+
+	return peak
+}
+
+// checkAvailability makes sure every requested equipment type still has
+// enough units free at the site for the whole window once the other bookings
+// there are taken into account.
+func checkAvailability(
+	ctx context.Context,
+	q dbQuerier,
+	siteID string,
+	items []borrowEquipmentItem,
+	from, to time.Time,
+	excludeBorrowRequestID string,
+) error {
+	requested := make(map[string]int)
+	for _, item := range items {
+		requested[item.EquipmentTypeID] += int(item.Quantity)
+	}
+
+	for equipmentTypeID, quantity := range requested {
+		capacity, err := getCapacity(ctx, q, siteID, equipmentTypeID)
+		if err != nil {
+			return err
+		}
+
+		bookings, err := getBookings(ctx, q, siteID, equipmentTypeID, from, to, excludeBorrowRequestID)
+		if err != nil {
+			return err
+		}
+
+		if quantity > capacity-peakDemand(bookings, from, to) {
+			return errInsufficientEquipmentQuantity
+		}
+	}
+
+	return nil
+}
+
+// lockBorrowRequestItems locks the equipment types of a borrow request so
+// that two overlapping requests can't be approved at the same time, then
+// returns the request's items and time window.
+func lockBorrowRequestItems(ctx context.Context, tx pgx.Tx, borrowRequestID string) ([]borrowEquipmentItem, time.Time, time.Time, error) {
+	lockQuery := `
+	SELECT equipment_type_id
+	FROM equipment_type
+	WHERE equipment_type_id IN (
+		SELECT equipment_type_id
+		FROM borrow_request_item
+		WHERE borrow_request_id = $1
+	)
+	ORDER BY equipment_type_id
+	FOR UPDATE
+	`
+	if _, err := tx.Exec(ctx, lockQuery, borrowRequestID); err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+
+	var claimAt, returnAt time.Time
+	windowQuery := `
+	SELECT expected_claim_at, expected_return_at
+	FROM borrow_request
+	WHERE borrow_request_id = $1
+	`
+	if err := tx.QueryRow(ctx, windowQuery, borrowRequestID).Scan(&claimAt, &returnAt); err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+
+	itemsQuery := `
+	SELECT equipment_type_id, quantity
+	FROM borrow_request_item
+	WHERE borrow_request_id = $1
+	`
+	rows, err := tx.Query(ctx, itemsQuery, borrowRequestID)
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+	defer rows.Close()
+
+	var items []borrowEquipmentItem
+	for rows.Next() {
+		var item borrowEquipmentItem
+		if err := rows.Scan(&item.EquipmentTypeID, &item.Quantity); err != nil {
+			return nil, time.Time{}, time.Time{}, err
+		}
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+
+	return items, claimAt, returnAt, nil
+}
+
+// reserveBorrowRequestUnits moves enough available units at the request's
+// site into reserved for every item of an approved request. It does nothing
+// if the request already has its units set aside.
+func reserveBorrowRequestUnits(ctx context.Context, tx pgx.Tx, borrowRequestID string) error {
+	var (
+		siteID     string
+		reservedAt *time.Time
+	)
+	reservedAtQuery := `
+	SELECT site_id, reserved_at
+	FROM borrow_request
+	WHERE borrow_request_id = $1
+	FOR UPDATE
+	`
+	if err := tx.QueryRow(ctx, reservedAtQuery, borrowRequestID).Scan(&siteID, &reservedAt); err != nil {
+		return err
+	}
+
+	if reservedAt != nil {
+		return nil
+	}
+
+	itemsQuery := `
+	SELECT equipment_type_id, SUM(quantity)::int
+	FROM borrow_request_item
+	WHERE borrow_request_id = $1
+	GROUP BY equipment_type_id
+	ORDER BY equipment_type_id
+	`
+
+	rows, err := tx.Query(ctx, itemsQuery, borrowRequestID)
+	if err != nil {
+		return err
+	}
+
+	type requestItem struct {
+		equipmentTypeID string
+		quantity        int
+	}
+
+	var items []requestItem
+	for rows.Next() {
+		var item requestItem
+		if err := rows.Scan(&item.equipmentTypeID, &item.quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	reserveQuery := `
+	UPDATE equipment
+	SET equipment_status_id = $1, updated_at = NOW()
+	WHERE equipment_id IN (
+		SELECT equipment_id
+		FROM equipment
+		WHERE equipment_type_id = $2 AND equipment_status_id = $3 AND site_id = $5
+		ORDER BY asset_tag
+		LIMIT $4
+		FOR UPDATE
+	)
+	`
+
+	for _, item := range items {
+		tag, err := tx.Exec(ctx, reserveQuery, reserved, item.equipmentTypeID, available, item.quantity, siteID)
+		if err != nil {
+			return err
+		}
+
+		if int(tag.RowsAffected()) < item.quantity {
+			return errInsufficientEquipmentQuantity
+		}
+	}
+
+	updateQuery := `
+	UPDATE borrow_request
+	SET reserved_at = NOW()
+	WHERE borrow_request_id = $1
+	`
+	if _, err := tx.Exec(ctx, updateQuery, borrowRequestID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// lockBorrowRequestForClaim makes sure an approved request is close enough to
+// its claim time to be handed out, and reports whether its units have
+// already been reserved.
+func lockBorrowRequestForClaim(ctx context.Context, tx pgx.Tx, borrowRequestID string) (bool, error) {
+	query := `
+	SELECT borrow_request_status_id, expected_claim_at, reserved_at
+	FROM borrow_request
+	WHERE borrow_request_id = $1
+	FOR UPDATE
+	`
+
+	var (
+		status     borrowRequestStatus
+		claimAt    time.Time
+		reservedAt *time.Time
+	)
+	if err := tx.QueryRow(ctx, query, borrowRequestID).Scan(&status, &claimAt, &reservedAt); err != nil {
+		return false, err
+	}
+
+	if status != approved {
+		return false, errBorrowRequestNotClaimable
+	}
+
+	if claimAt.After(time.Now().Add(reservationLeadTime)) {
+		return false, errClaimTooEarly
+	}
+
+	return reservedAt != nil, nil
+}
+
+// reserveUpcomingBorrowRequests reserves units for approved requests whose
+// claim time is coming up. Requests that can't be covered yet, e.g. because
+// an earlier borrower is late, are retried on the next run.
+func (r *repository) reserveUpcomingBorrowRequests(ctx context.Context) error {
+	query := `
+	SELECT borrow_request_id
+	FROM borrow_request
+	WHERE borrow_request_status_id = $1
+	AND reserved_at IS NULL
+	AND expected_claim_at <= $2
+	ORDER BY expected_claim_at
+	`
+
+	rows, err := r.querier.Query(ctx, query, approved, time.Now().Add(reservationLeadTime))
+	if err != nil {
+		return err
+	}
+
+	borrowRequestIDs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	for _, borrowRequestID := range borrowRequestIDs {
+		if err := r.reserveBorrowRequest(ctx, borrowRequestID); err != nil {
+			if errors.Is(err, errInsufficientEquipmentQuantity) {
+				slog.Warn("Not enough units to reserve for borrow request " + borrowRequestID)
+				continue
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *repository) reserveBorrowRequest(ctx context.Context, borrowRequestID string) error {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := reserveBorrowRequestUnits(ctx, tx, borrowRequestID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+type availabilitySlot struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Available int       `json:"available"`
+}
+
+type equipmentAvailability struct {
+	EquipmentTypeID string             `json:"equipmentTypeId"`
+	Capacity        int                `json:"capacity"`
+	Slots           []availabilitySlot `json:"slots"`
+}
+
+type getAvailabilityParams struct {
+	equipmentTypeID string
+	siteID          string
+	from            time.Time
+	to              time.Time
+	slot            time.Duration
+}
+
+func (r *repository) getAvailability(ctx context.Context, params getAvailabilityParams) (equipmentAvailability, error) {
+	capacity, err := getCapacity(ctx, r.querier, params.siteID, params.equipmentTypeID)
+	if err != nil {
+		return equipmentAvailability{}, err
+	}
+
+	bookings, err := getBookings(ctx, r.querier, params.siteID, params.equipmentTypeID, params.from, params.to, "")
+	if err != nil {
+		return equipmentAvailability{}, err
+	}
+
+	res := equipmentAvailability{
+		EquipmentTypeID: params.equipmentTypeID,
+		Capacity:        capacity,
+		Slots:           []availabilitySlot{},
+	}
+
+	for from := params.from; from.Before(params.to); from = from.Add(params.slot) {
+		to := from.Add(params.slot)
+		if to.After(params.to) {
+			to = params.to
+		}
+
+		res.Slots = append(res.Slots, availabilitySlot{
+			From:      from,
+			To:        to,
+			Available: max(capacity-peakDemand(bookings, from, to), 0),
+		})
+	}
+
+	return res, nil
+}
+
+func (s *Server) getAvailability(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	params := getAvailabilityParams{
+		equipmentTypeID: r.PathValue("equipmentTypeId"),
+		siteID:          r.URL.Query().Get("site"),
+		from:            time.Now().Truncate(time.Hour),
+		slot:            defaultAvailabilitySlot,
+	}
+
+	if f := r.URL.Query().Get("from"); f != "" {
+		from, err := time.Parse(time.RFC3339, f)
+		if err != nil {
+			return api.Response{
+				Error:   fmt.Errorf("get availability: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid start of availability window.",
+			}
+		}
+		params.from = from
+	}
+
+	params.to = params.from.Add(24 * time.Hour)
+	if t := r.URL.Query().Get("to"); t != "" {
+		to, err := time.Parse(time.RFC3339, t)
+		if err != nil {
+			return api.Response{
+				Error:   fmt.Errorf("get availability: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid end of availability window.",
+			}
+		}
+		params.to = to
+	}
+
+	if sl := r.URL.Query().Get("slot"); sl != "" {
+		slot, err := time.ParseDuration(sl)
+		if err != nil || slot < minAvailabilitySlot {
+			return api.Response{
+				Error:   fmt.Errorf("get availability: %w: slot %q", errInvalidAvailabilityWindow, sl),
+				Code:    http.StatusBadRequest,
+				Message: "Slot must be a duration of at least 15 minutes, e.g. 1h.",
+			}
+		}
+		params.slot = slot
+	}
+
+	if !params.to.After(params.from) {
+		return api.Response{
+			Error:   fmt.Errorf("get availability: %w: end is not after start", errInvalidAvailabilityWindow),
+			Code:    http.StatusBadRequest,
+			Message: "End of availability window must be after its start.",
+		}
+	}
+
+	if params.to.Sub(params.from)/params.slot > maxAvailabilitySlotsCount {
+		return api.Response{
+			Error:   fmt.Errorf("get availability: %w: too many slots", errInvalidAvailabilityWindow),
+			Code:    http.StatusBadRequest,
+			Message: "Availability window is too long for the slot size.",
+		}
+	}
+
+	availability, err := s.repository.getAvailability(ctx, params)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("get availability: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Equipment not found.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("get availability: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get equipment availability.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched equipment availability.",
+		Data:    availability,
+	}
+}
@@ -0,0 +1,436 @@
+package equipment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/sse"
+)
+
+type claimBorrowRequest struct {
+	BorrowRequestID string `json:"id"`
+
+	// Units holds the scanned unit IDs or asset tags being handed over.
+	Units []string `json:"units"`
+
+	ClaimedBy string `json:"-"`
+}
+
+type claimedUnit struct {
+	UnitID              string `json:"id"`
+	AssetTag            string `json:"assetTag"`
+	EquipmentTypeID     string `json:"equipmentTypeId"`
+	BorrowRequestItemID string `json:"borrowRequestItemId"`
+}
+
+type claimBorrowResponse struct {
+	BorrowRequestID string                    `json:"id"`
+	Status          borrowRequestStatusDetail `json:"status"`
+	Units           []claimedUnit             `json:"units"`
+}
+
+var (
+	errUnitNotFound              = fmt.Errorf("equipment unit not found")
+	errUnitTypeMismatch          = fmt.Errorf("equipment unit does not belong to the requested equipment")
+	errUnitUnavailable           = fmt.Errorf("equipment unit is not available")
+	errUnitQuantityMismatch      = fmt.Errorf("number of scanned units does not match the requested quantity")
+	errDuplicateScannedUnit      = fmt.Errorf("equipment unit was scanned more than once")
+	errUnitNotBorrowed           = fmt.Errorf("equipment unit is not borrowed under this request")
+	errEmptyScannedUnitList      = fmt.Errorf("scanned units list cannot be empty")
+	errBorrowRequestNotClaimable = fmt.Errorf("borrow request is not approved")
+	errUnitAtOtherSite           = fmt.Errorf("equipment unit is kept at another site")
+)
+
+type scannedUnit struct {
+	unitID          string
+	assetTag        string
+	equipmentTypeID string
+	status          equipmentStatus
+	siteID          string
+}
+
+// lockScannedUnits resolves scanned IDs/asset tags into units and locks them
+// for the rest of the transaction.
+func lockScannedUnits(ctx context.Context, tx pgx.Tx, scanned []string) ([]scannedUnit, error) {
+	if len(scanned) == 0 {
+		return nil, errEmptyScannedUnitList
+	}
+
+	query := `
+	SELECT equipment_id, asset_tag, equipment_type_id, equipment_status_id, site_id
+	FROM equipment
+	WHERE equipment_id::text = ANY($1) OR asset_tag = ANY($1)
+	ORDER BY asset_tag
+	FOR UPDATE
+	`
+
+	rows, err := tx.Query(ctx, query, scanned)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var units []scannedUnit
+	for rows.Next() {
+		var unit scannedUnit
+		if err := rows.Scan(&unit.unitID, &unit.assetTag, &unit.equipmentTypeID, &unit.status, &unit.siteID); err != nil {
+			return nil, err
+		}
+		units = append(units, unit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(units) < len(scanned) {
+		// Either a code did not match anything or the same unit was scanned
+		// twice (once by ID and once by tag, or the same tag repeated).
+		seen := make(map[string]bool, len(scanned))
+		for _, code := range scanned {
+			if seen[code] {
+				return nil, errDuplicateScannedUnit
+			}
+			seen[code] = true
+		}
+		for _, unit := range units {
+			if seen[unit.unitID] && seen[unit.assetTag] {
+				return nil, errDuplicateScannedUnit
+			}
+		}
+		return nil, errUnitNotFound
+	}
+
+	return units, nil
+}
+
+func (r *repository) claimBorrowRequest(ctx context.Context, arg claimBorrowRequest) (claimBorrowResponse, error) {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return claimBorrowResponse{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	isReserved, err := lockBorrowRequestForClaim(ctx, tx, arg.BorrowRequestID)
+	if err != nil {
+		return claimBorrowResponse{}, err
+	}
+
+	siteID, err := getBorrowRequestSiteID(ctx, tx, arg.BorrowRequestID)
+	if err != nil {
+		return claimBorrowResponse{}, err
+	}
+
+	if err := checkSiteManager(ctx, tx, arg.ClaimedBy, siteID); err != nil {
+		return claimBorrowResponse{}, err
+	}
+
+	itemsQuery := `
+	SELECT borrow_request_item_id, equipment_type_id, quantity
+	FROM borrow_request_item
+	WHERE borrow_request_id = $1
+	ORDER BY borrow_request_item_id
+	`
+
+	itemRows, err := tx.Query(ctx, itemsQuery, arg.BorrowRequestID)
+	if err != nil {
+		return claimBorrowResponse{}, err
+	}
+
+	type requestItem struct {
+		itemID          string
+		equipmentTypeID string
+		quantity        int
+	}
+
+	var items []requestItem
+	for itemRows.Next() {
+		var item requestItem
+		if err := itemRows.Scan(&item.itemID, &item.equipmentTypeID, &item.quantity); err != nil {
+			return claimBorrowResponse{}, err
+		}
+		items = append(items, item)
+	}
+	if err := itemRows.Err(); err != nil {
+		return claimBorrowResponse{}, err
+	}
+
+	units, err := lockScannedUnits(ctx, tx, arg.Units)
+	if err != nil {
+		return claimBorrowResponse{}, err
+	}
+
+	unitsByType := make(map[string][]scannedUnit)
+	for _, unit := range units {
+		if unit.siteID != siteID {
+			return claimBorrowResponse{}, fmt.Errorf("%w: %s", errUnitAtOtherSite, unit.assetTag)
+		}
+
+		// Without a reservation of its own, any reserved unit is being held
+		// for someone else.
+		if unit.status != available && (!isReserved || unit.status != reserved) {
+			return claimBorrowResponse{}, fmt.Errorf("%w: %s", errUnitUnavailable, unit.assetTag)
+		}
+		unitsByType[unit.equipmentTypeID] = append(unitsByType[unit.equipmentTypeID], unit)
+	}
+
+	requestedByType := make(map[string]int)
+	for _, item := range items {
+		requestedByType[item.equipmentTypeID] += item.quantity
+	}
+
+	for equipmentTypeID, typeUnits := range unitsByType {
+		if _, ok := requestedByType[equipmentTypeID]; !ok {
+			return claimBorrowResponse{}, fmt.Errorf("%w: %s", errUnitTypeMismatch, typeUnits[0].assetTag)
+		}
+	}
+	for equipmentTypeID, quantity := range requestedByType {
+		if len(unitsByType[equipmentTypeID]) != quantity {
+			return claimBorrowResponse{}, errUnitQuantityMismatch
+		}
+	}
+
+	// Handing over an available unit instead of a reserved one means one of
+	// the reserved units for this type is no longer spoken for.
+	releaseQuery := `
+	UPDATE equipment
+	SET equipment_status_id = $1, updated_at = NOW()
+	WHERE equipment_id IN (
+		SELECT equipment_id
+		FROM equipment
+		WHERE equipment_type_id = $2 AND equipment_status_id = $3 AND site_id = $6
+		AND NOT (equipment_id = ANY($4::uuid[]))
+		ORDER BY asset_tag
+		LIMIT $5
+		FOR UPDATE
+	)
+	`
+
+	for equipmentTypeID, typeUnits := range unitsByType {
+		var (
+			unitIDs        []string
+			availableCount int
+		)
+		for _, unit := range typeUnits {
+			unitIDs = append(unitIDs, unit.unitID)
+			if unit.status == available {
+				availableCount++
+			}
+		}
+
+		if !isReserved || availableCount == 0 {
+			continue
+		}
+
+		if _, err := tx.Exec(ctx, releaseQuery, available, equipmentTypeID, reserved, unitIDs, availableCount, siteID); err != nil {
+			return claimBorrowResponse{}, err
+		}
+	}
+
+	borrowEquipmentQuery := `
+	UPDATE equipment
+	SET equipment_status_id = $1, updated_at = NOW()
+	WHERE equipment_id = $2
+	`
+
+	transactionQuery := `
+	INSERT INTO borrow_transaction (borrow_request_item_id, equipment_id)
+	VALUES ($1, $2)
+	`
+
+	res := claimBorrowResponse{
+		BorrowRequestID: arg.BorrowRequestID,
+		Units:           make([]claimedUnit, 0, len(units)),
+	}
+
+	for _, item := range items {
+		typeUnits := unitsByType[item.equipmentTypeID]
+		for _, unit := range typeUnits[:item.quantity] {
+			if _, err := tx.Exec(ctx, borrowEquipmentQuery, borrowed, unit.unitID); err != nil {
+				return claimBorrowResponse{}, err
+			}
+
+			if _, err := tx.Exec(ctx, transactionQuery, item.itemID, unit.unitID); err != nil {
+				return claimBorrowResponse{}, err
+			}
+
+			res.Units = append(res.Units, claimedUnit{
+				UnitID:              unit.unitID,
+				AssetTag:            unit.assetTag,
+				EquipmentTypeID:     unit.equipmentTypeID,
+				BorrowRequestItemID: item.itemID,
+			})
+		}
+		unitsByType[item.equipmentTypeID] = typeUnits[item.quantity:]
+	}
+
+	updateQuery := `
+	WITH claimed_request AS (
+		UPDATE borrow_request
+		SET borrow_request_status_id = $1, claimed_at = NOW()
+		WHERE borrow_request_id = $2
+		RETURNING borrow_request_status_id
+	)
+	SELECT jsonb_build_object(
+		'id', borrow_request_status.borrow_request_status_id,
+		'code', borrow_request_status.code,
+		'label', borrow_request_status.label
+	) AS status
+	FROM claimed_request
+	JOIN borrow_request_status USING (borrow_request_status_id)
+	`
+	if err := tx.QueryRow(ctx, updateQuery, claimed, arg.BorrowRequestID).Scan(&res.Status); err != nil {
+		return claimBorrowResponse{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return claimBorrowResponse{}, err
+	}
+
+	return res, nil
+}
+
+func (s *Server) claimBorrowRequest(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data claimBorrowRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("claim borrow request: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid claim borrow request.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("claim borrow request", err)
+	}
+	data.BorrowRequestID = r.PathValue("id")
+	data.ClaimedBy = claims.UserID
+
+	units := make([]string, 0, len(data.Units))
+	for _, unit := range data.Units {
+		if unit = strings.TrimSpace(unit); unit != "" {
+			units = append(units, unit)
+		}
+	}
+	data.Units = units
+
+	if len(data.Units) == 0 {
+		return api.Response{
+			Error:   fmt.Errorf("claim borrow request: %w", errEmptyScannedUnitList),
+			Code:    http.StatusBadRequest,
+			Message: "Scan at least one equipment unit to hand out.",
+		}
+	}
+
+	res, err := s.repository.claimBorrowRequest(ctx, data)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("claim borrow request: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Borrow request not found.",
+			}
+		}
+
+		if errors.Is(err, errBorrowRequestNotClaimable) {
+			return api.Response{
+				Error:   fmt.Errorf("claim borrow request: %w", err),
+				Code:    http.StatusConflict,
+				Message: "Only approved borrow requests can be claimed.",
+			}
+		}
+
+		if res, ok := siteAccessErrorResponse("claim borrow request", err); ok {
+			return res
+		}
+
+		if errors.Is(err, errClaimTooEarly) {
+			return api.Response{
+				Error:   fmt.Errorf("claim borrow request: %w", err),
+				Code:    http.StatusConflict,
+				Message: "This borrow request can't be claimed until closer to its claim time.",
+			}
+		}
+
+		if message, ok := scannedUnitErrorMessage(err); ok {
+			return api.Response{
+				Error:   fmt.Errorf("claim borrow request: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: message,
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("claim borrow request: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to claim borrow request.",
+		}
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventBorrowRequestUpdate,
+		Data:  res,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("claim borrow request: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to claim borrow request.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("claim borrow request: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to claim borrow request.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully claimed borrow request.",
+		Data:    res,
+	}
+}
+
+// scannedUnitErrorMessage maps errors caused by a bad scan into something the
+// person holding the scanner can act on.
+func scannedUnitErrorMessage(err error) (string, bool) {
+	switch {
+	case errors.Is(err, errEmptyScannedUnitList):
+		return "Scan at least one equipment unit.", true
+	case errors.Is(err, errUnitNotFound):
+		return "One or more scanned units do not exist.", true
+	case errors.Is(err, errDuplicateScannedUnit):
+		return "The same unit was scanned more than once.", true
+	case errors.Is(err, errUnitTypeMismatch):
+		return "A scanned unit does not match the requested equipment.", true
+	case errors.Is(err, errUnitUnavailable):
+		return "A scanned unit is not available for hand out.", true
+	case errors.Is(err, errUnitAtOtherSite):
+		return "A scanned unit is kept at another site.", true
+	case errors.Is(err, errUnitQuantityMismatch):
+		return "The number of scanned units does not match the requested quantity.", true
+	case errors.Is(err, errUnitNotBorrowed):
+		return "A scanned unit was not borrowed under this request.", true
+	case errors.Is(err, errUnitNotReturned):
+		return "A unit marked as damaged or lost is not part of this return.", true
+	case errors.Is(err, errUnitNotInTransfer):
+		return "A scanned unit was not sent with this transfer.", true
+	default:
+		return "", false
+	}
+}
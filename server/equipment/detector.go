@@ -0,0 +1,272 @@
+package equipment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAnomalyServiceTimeout = 5 * time.Second
+	defaultAnomalyServiceRetries = 2
+
+	// The breaker opens after this many failed detections in a row and lets
+	// a request through again once the cooldown is over.
+	anomalyBreakerThreshold = 5
+	anomalyBreakerCooldown  = 1 * time.Minute
+)
+
+var (
+	errAnomalyServiceUnavailable = fmt.Errorf("anomaly service is unavailable")
+	errEmptyAnomalyResult        = fmt.Errorf("anomaly service returned no result")
+)
+
+type anomalyDetector string
+
+const (
+	anomalyDetectorBuiltin anomalyDetector = "builtin"
+	anomalyDetectorRemote  anomalyDetector = "remote"
+)
+
+// Detector scores a borrow request for anomalies.
+type Detector interface {
+	Detect(ctx context.Context, arg createBorrowResponse) (anomaly, error)
+}
+
+// localDetector is the built-in scorer, which only needs the database.
+type localDetector struct {
+	repository Repository
+	scorer     anomalyScorer
+}
+
+func (d localDetector) Detect(ctx context.Context, arg createBorrowResponse) (anomaly, error) {
+	target, history, err := d.repository.getBorrowFeatures(ctx, arg.BorrowRequestID, d.scorer.location)
+	if err != nil {
+		return anomaly{}, fmt.Errorf("get borrow features: %w", err)
+	}
+
+	// Only the built-in detector learns from reviews. The anomaly service
+	// scores on its own scale and isn't sent the labels, so its results are
+	// used as they come.
+	calibration, err := d.repository.getAnomalyCalibration(
+		ctx,
+		anomalyDetectorBuiltin,
+		time.Now().Add(-anomalyCalibrationWindow),
+	)
+	if err != nil {
+		return anomaly{}, fmt.Errorf("get anomaly calibration: %w", err)
+	}
+
+	score, isAnomaly := d.scorer.calibrate(calibration).score(target, history)
+	return anomaly{
+		BorrowRequestID: arg.BorrowRequestID,
+		Score:           float32(score),
+		IsAnomaly:       isAnomaly,
+		Detector:        anomalyDetectorBuiltin,
+	}, nil
+}
+
+// circuitBreaker stops calling a service that keeps failing so every borrow
+// request doesn't wait out the timeouts and retries.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+
+	threshold int
+	cooldown  time.Duration
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !time.Now().Before(b.openUntil)
+}
+
+// record keeps the failure count at the threshold while the breaker is open,
+// so a failed trial request after the cooldown opens it again right away.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// anomalyServiceError is a non-2xx response from the anomaly service. Only
+// server errors are worth retrying.
+type anomalyServiceError struct {
+	statusCode int
+	body       string
+}
+
+func (e anomalyServiceError) Error() string {
+	return fmt.Sprintf("anomaly service responded with %d: %s", e.statusCode, e.body)
+}
+
+// remoteDetector calls the anomaly service in /ml. Manager reviews don't
+// calibrate it, since the service keeps its own threshold.
+type remoteDetector struct {
+	url      string
+	client   *http.Client
+	retries  int
+	location *time.Location
+	breaker  *circuitBreaker
+}
+
+func newRemoteDetector(url string, timeout time.Duration, location *time.Location) *remoteDetector {
+	return &remoteDetector{
+		url:      strings.TrimSuffix(url, "/") + "/anomalies",
+		client:   &http.Client{Timeout: timeout},
+		retries:  defaultAnomalyServiceRetries,
+		location: location,
+		breaker: &circuitBreaker{
+			threshold: anomalyBreakerThreshold,
+			cooldown:  anomalyBreakerCooldown,
+		},
+	}
+}
+
+func (d *remoteDetector) Detect(ctx context.Context, arg createBorrowResponse) (anomaly, error) {
+	if !d.breaker.allow() {
+		return anomaly{}, errAnomalyServiceUnavailable
+	}
+
+	// The service is sensitive to the hour of the day, which only makes
+	// sense in PH time
+	arg.CreatedAt = arg.CreatedAt.In(d.location)
+	arg.ExpectedClaimAt = arg.ExpectedClaimAt.In(d.location)
+	arg.ExpectedReturnAt = arg.ExpectedReturnAt.In(d.location)
+
+	payload, err := json.Marshal([]createBorrowResponse{arg})
+	if err != nil {
+		return anomaly{}, err
+	}
+
+	var result anomaly
+	for attempt := 0; ; attempt++ {
+		result, err = d.post(ctx, payload)
+
+		var serviceErr anomalyServiceError
+		retryable := err != nil && ctx.Err() == nil &&
+			(!errors.As(err, &serviceErr) || serviceErr.statusCode >= http.StatusInternalServerError)
+		if !retryable || attempt >= d.retries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Duration(attempt+1) * 200 * time.Millisecond):
+		}
+	}
+
+	d.breaker.record(err)
+	if err != nil {
+		return anomaly{}, err
+	}
+
+	if result.BorrowRequestID != arg.BorrowRequestID {
+		return anomaly{}, fmt.Errorf("anomaly service scored borrow request %q instead", result.BorrowRequestID)
+	}
+
+	result.Detector = anomalyDetectorRemote
+	return result, nil
+}
+
+func (d *remoteDetector) post(ctx context.Context, payload []byte) (anomaly, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(payload))
+	if err != nil {
+		return anomaly{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return anomaly{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return anomaly{}, anomalyServiceError{statusCode: resp.StatusCode, body: string(body)}
+	}
+
+	var results []anomaly
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return anomaly{}, fmt.Errorf("decode anomaly result: %w", err)
+	}
+
+	// The service has nothing to say until its models are trained
+	if len(results) == 0 {
+		return anomaly{}, errEmptyAnomalyResult
+	}
+
+	return results[0], nil
+}
+
+// fallbackDetector uses the fallback whenever the primary fails.
+type fallbackDetector struct {
+	primary  Detector
+	fallback Detector
+}
+
+func (d fallbackDetector) Detect(ctx context.Context, arg createBorrowResponse) (anomaly, error) {
+	result, err := d.primary.Detect(ctx, arg)
+	if err == nil {
+		return result, nil
+	}
+
+	slog.Warn("Anomaly service failed, using built-in detector", "error", err)
+	return d.fallback.Detect(ctx, arg)
+}
+
+// loadDetector uses the anomaly service at ANOMALY_SERVICE_URL when it is
+// set, falling back to the built-in scorer whenever the service is down.
+func loadDetector(repo Repository, scorer anomalyScorer) Detector {
+	local := localDetector{repository: repo, scorer: scorer}
+
+	url := os.Getenv("ANOMALY_SERVICE_URL")
+	if url == "" {
+		return local
+	}
+
+	timeout := defaultAnomalyServiceTimeout
+	if v := os.Getenv("ANOMALY_SERVICE_TIMEOUT"); v != "" {
+		t, err := time.ParseDuration(v)
+		if err != nil || t <= 0 {
+			slog.Warn("Invalid ANOMALY_SERVICE_TIMEOUT, using default: " + v)
+		} else {
+			timeout = t
+		}
+	}
+
+	remote := newRemoteDetector(url, timeout, scorer.location)
+	if v := os.Getenv("ANOMALY_SERVICE_RETRIES"); v != "" {
+		retries, err := strconv.Atoi(v)
+		if err != nil || retries < 0 {
+			slog.Warn("Invalid ANOMALY_SERVICE_RETRIES, using default: " + v)
+		} else {
+			remote.retries = retries
+		}
+	}
+
+	return fallbackDetector{primary: remote, fallback: local}
+}
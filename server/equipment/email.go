@@ -0,0 +1,80 @@
+package equipment
+
+import (
+	"fmt"
+	"html"
+	"os"
+
+	"github.com/xGihyun/hirami/api"
+)
+
+// sendNotificationEmail sends a borrower a short notice about one of their
+// requests. Servers started without a Gmail client, like the test suite,
+// skip it.
+func (s *Server) sendNotificationEmail(to, subject, fullName, heading, message string) error {
+	if s.gmailService == nil {
+		return nil
+	}
+
+	webClientURL := os.Getenv("WEB_CLIENT_URL")
+	if webClientURL == "" {
+		webClientURL = "http://localhost:3000"
+	}
+
+	bodyHTML := fmt.Sprintf(`
+<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1.0"/>
+  <title>%s</title>
+</head>
+<body style="margin:0;padding:0;font-family:'Segoe UI',Arial,sans-serif;background-color:#f9fafb;">
+  <table width="100%%" cellpadding="0" cellspacing="0" style="padding:40px 0;">
+    <tr>
+      <td align="center">
+        <table width="380" cellpadding="0" cellspacing="0" style="background-color:#ffffff;border-radius:16px;overflow:hidden;padding:40px 32px;max-width:380px;border:1px solid #e5e7eb;">
+          <tr>
+            <td align="center" style="padding-bottom:24px;">
+              <h1 style="margin:0;font-size:26px;font-weight:700;color:#111827;line-height:1.3;">
+                %s
+              </h1>
+            </td>
+          </tr>
+          <tr>
+            <td style="padding-bottom:28px;color:#374151;font-size:14px;line-height:1.6;text-align:justify;">
+              <p style="margin:0 0 8px 0;">
+                Hello <strong>%s</strong>,
+              </p>
+              <p style="margin:0;">
+                %s
+              </p>
+            </td>
+          </tr>
+          <tr>
+            <td align="center">
+              <a href="%s" style="display:block;width:100%%;padding:16px 0;background-color:#92400e;color:#ffffff;text-decoration:none;border-radius:8px;font-size:15px;font-weight:600;text-align:center;box-sizing:border-box;">Open Hirami</a>
+            </td>
+          </tr>
+          <tr>
+            <td style="padding-top:32px;font-size:14px;color:#374151;line-height:1.6;">
+              Sincerely,<br/>
+              The Hirami Team
+            </td>
+          </tr>
+        </table>
+      </td>
+    </tr>
+  </table>
+</body>
+</html>
+`,
+		html.EscapeString(heading),
+		html.EscapeString(heading),
+		html.EscapeString(fullName),
+		html.EscapeString(message),
+		webClientURL,
+	)
+
+	return api.SendGmail(s.gmailService, to, subject, bodyHTML)
+}
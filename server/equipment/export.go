@@ -0,0 +1,429 @@
+package equipment
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xGihyun/hirami/api"
+	"github.com/xuri/excelize/v2"
+)
+
+type borrowHistoryExportRow struct {
+	BorrowRequestID   string
+	RequestedAt       time.Time
+	BorrowerName      string
+	BorrowerEmail     string
+	EquipmentName     string
+	Brand             *string
+	Model             *string
+	RequestedQuantity int
+	BorrowedQuantity  int
+	ReturnedQuantity  int
+	Status            string
+	Location          string
+	Purpose           string
+	ExpectedClaimAt   time.Time
+	ClaimedAt         *time.Time
+	ExpectedReturnAt  time.Time
+	ReturnedAt        *time.Time
+	ReviewedBy        *string
+	ReviewedAt        *time.Time
+	ReviewRemarks     *string
+	ReturnRemarks     *string
+}
+
+var borrowHistoryExportHeader = []string{
+	"Borrow Request ID",
+	"Requested At",
+	"Borrower",
+	"Borrower Email",
+	"Equipment",
+	"Brand",
+	"Model",
+	"Requested Quantity",
+	"Borrowed Quantity",
+	"Returned Quantity",
+	"Status",
+	"Location",
+	"Purpose",
+	"Expected Claim At",
+	"Claimed At",
+	"Expected Return At",
+	"Returned At",
+	"Reviewed By",
+	"Reviewed At",
+	"Review Remarks",
+	"Return Remarks",
+}
+
+// exportBorrowHistory calls fn for every borrowed item matching the borrow
+// history filters, one row at a time, so large exports never have to be held
+// in memory.
+func (r *repository) exportBorrowHistory(ctx context.Context, params borrowHistoryParams, fn func(borrowHistoryExportRow) error) error {
+	query := `
+	WITH latest_return_data AS (
+		SELECT
+			borrow_request_id,
+			created_at
+		FROM return_request
+		WHERE (borrow_request_id, created_at) IN (
+			SELECT borrow_request_id, MAX(created_at)
+			FROM return_request
+			GROUP BY borrow_request_id
+		)
+	)
+	SELECT
+		borrow_request.borrow_request_id,
+		borrow_request.created_at,
+		CONCAT_WS(' ', person.first_name, person.middle_name, person.last_name) AS borrower_name,
+		person.email,
+		equipment_type.name,
+		equipment_type.brand,
+		equipment_type.model,
+		borrow_request_item.quantity,
+		item_transactions.borrowed_quantity,
+		item_transactions.returned_quantity,
+		borrow_request_status.label,
+		borrow_request.location,
+		borrow_request.purpose,
+		borrow_request.expected_claim_at,
+		borrow_request.claimed_at,
+		borrow_request.expected_return_at,
+		item_transactions.returned_at,
+		CASE
+			WHEN person_borrow_reviewer.person_id IS NULL THEN NULL
+			ELSE CONCAT_WS(
+				' ',
+				person_borrow_reviewer.first_name,
+				person_borrow_reviewer.middle_name,
+				person_borrow_reviewer.last_name
+			)
+		END AS reviewed_by,
+		borrow_request.reviewed_at,
+		borrow_request.remarks,
+		item_returns.remarks
+	FROM borrow_request
+	JOIN borrow_request_status USING (borrow_request_status_id)
+	JOIN borrow_request_item ON borrow_request_item.borrow_request_id = borrow_request.borrow_request_id
+	JOIN equipment_type ON equipment_type.equipment_type_id = borrow_request_item.equipment_type_id
+	JOIN person ON person.person_id = borrow_request.requested_by
+	LEFT JOIN person person_borrow_reviewer ON person_borrow_reviewer.person_id = borrow_request.reviewed_by
+	LEFT JOIN latest_return_data ON latest_return_data.borrow_request_id = borrow_request.borrow_request_id
+	LEFT JOIN LATERAL (
+		SELECT
+			COUNT(DISTINCT borrow_transaction.borrow_transaction_id) AS borrowed_quantity,
+			COUNT(DISTINCT return_transaction.return_transaction_id) AS returned_quantity,
+			MAX(return_transaction.created_at) AS returned_at
+		FROM borrow_transaction
+		LEFT JOIN return_transaction USING (borrow_transaction_id)
+		WHERE borrow_transaction.borrow_request_item_id = borrow_request_item.borrow_request_item_id
+	) item_transactions ON TRUE
+	LEFT JOIN LATERAL (
+		SELECT string_agg(return_request_item.remarks, '; ' ORDER BY return_request.created_at) AS remarks
+		FROM return_request_item
+		JOIN return_request USING (return_request_id)
+		WHERE return_request_item.borrow_request_item_id = borrow_request_item.borrow_request_item_id
+		AND return_request_item.remarks <> ''
+	) item_returns ON TRUE
+	WHERE TRUE
+	`
+
+	filters, args := borrowHistoryFilters(params)
+	query += filters
+
+	_, sortByColumn, sortDirection := borrowHistorySort(params)
+	query += fmt.Sprintf(
+		" ORDER BY %s %s, borrow_request.borrow_request_id %s, equipment_type.name",
+		sortByColumn,
+		sortDirection,
+		sortDirection,
+	)
+
+	rows, err := r.querier.Query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row borrowHistoryExportRow
+		if err := rows.Scan(
+			&row.BorrowRequestID,
+			&row.RequestedAt,
+			&row.BorrowerName,
+			&row.BorrowerEmail,
+			&row.EquipmentName,
+			&row.Brand,
+			&row.Model,
+			&row.RequestedQuantity,
+			&row.BorrowedQuantity,
+			&row.ReturnedQuantity,
+			&row.Status,
+			&row.Location,
+			&row.Purpose,
+			&row.ExpectedClaimAt,
+			&row.ClaimedAt,
+			&row.ExpectedReturnAt,
+			&row.ReturnedAt,
+			&row.ReviewedBy,
+			&row.ReviewedAt,
+			&row.ReviewRemarks,
+			&row.ReturnRemarks,
+		); err != nil {
+			return err
+		}
+
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *Server) exportBorrowHistory(w http.ResponseWriter, r *http.Request) api.Response {
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("export borrow history", err)
+	}
+
+	params := parseBorrowHistoryParams(r, claims)
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "csv":
+		return s.writeBorrowHistoryCSV(w, r, params)
+	case "xlsx":
+		return s.writeBorrowHistoryXLSX(w, r, params)
+	default:
+		return api.Response{
+			Error:   fmt.Errorf("export borrow history: unsupported format %q", format),
+			Code:    http.StatusBadRequest,
+			Message: "Export format must be either csv or xlsx.",
+		}
+	}
+}
+
+// Flush the CSV to the client every so often so long exports start
+// downloading right away.
+const csvFlushInterval = 500
+
+func (s *Server) writeBorrowHistoryCSV(w http.ResponseWriter, r *http.Request, params borrowHistoryParams) api.Response {
+	ctx := r.Context()
+
+	filename := fmt.Sprintf("borrow_history_%s.csv", time.Now().Format("20060102"))
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+	controller := http.NewResponseController(w)
+	writer := csv.NewWriter(w)
+	if err := writer.Write(borrowHistoryExportHeader); err != nil {
+		return api.Response{
+			Error: fmt.Errorf("export borrow history csv: %w", err),
+			Raw:   true,
+		}
+	}
+
+	count := 0
+	err := s.repository.exportBorrowHistory(ctx, params, func(row borrowHistoryExportRow) error {
+		record := []string{
+			row.BorrowRequestID,
+			formatExportTime(&row.RequestedAt),
+			row.BorrowerName,
+			row.BorrowerEmail,
+			row.EquipmentName,
+			formatExportString(row.Brand),
+			formatExportString(row.Model),
+			strconv.Itoa(row.RequestedQuantity),
+			strconv.Itoa(row.BorrowedQuantity),
+			strconv.Itoa(row.ReturnedQuantity),
+			row.Status,
+			row.Location,
+			row.Purpose,
+			formatExportTime(&row.ExpectedClaimAt),
+			formatExportTime(row.ClaimedAt),
+			formatExportTime(&row.ExpectedReturnAt),
+			formatExportTime(row.ReturnedAt),
+			formatExportString(row.ReviewedBy),
+			formatExportTime(row.ReviewedAt),
+			formatExportString(row.ReviewRemarks),
+			formatExportString(row.ReturnRemarks),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+
+		count++
+		if count%csvFlushInterval == 0 {
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				return err
+			}
+			controller.Flush()
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		// Nothing has reached the client until the first row is written, so
+		// the request can still fail normally.
+		if count == 0 {
+			w.Header().Del("Content-Disposition")
+			return api.Response{
+				Error:   fmt.Errorf("export borrow history csv: %w", err),
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to export borrow history.",
+			}
+		}
+
+		return api.Response{
+			Error: fmt.Errorf("export borrow history csv: %w", err),
+			Raw:   true,
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return api.Response{
+			Error: fmt.Errorf("export borrow history csv: %w", err),
+			Raw:   true,
+		}
+	}
+
+	return api.Response{Raw: true}
+}
+
+func (s *Server) writeBorrowHistoryXLSX(w http.ResponseWriter, r *http.Request, params borrowHistoryParams) api.Response {
+	ctx := r.Context()
+
+	file := excelize.NewFile()
+	defer file.Close()
+
+	const sheet = "Borrow History"
+	if err := file.SetSheetName("Sheet1", sheet); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("export borrow history xlsx: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to export borrow history.",
+		}
+	}
+
+	// The stream writer spills rows to a temporary file instead of building
+	// the whole sheet in memory.
+	stream, err := file.NewStreamWriter(sheet)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("export borrow history xlsx: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to export borrow history.",
+		}
+	}
+
+	headerStyle, err := file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("export borrow history xlsx: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to export borrow history.",
+		}
+	}
+
+	header := make([]any, len(borrowHistoryExportHeader))
+	for i, title := range borrowHistoryExportHeader {
+		header[i] = excelize.Cell{StyleID: headerStyle, Value: title}
+	}
+	if err := stream.SetRow("A1", header); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("export borrow history xlsx: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to export borrow history.",
+		}
+	}
+
+	rowIdx := 2
+	err = s.repository.exportBorrowHistory(ctx, params, func(row borrowHistoryExportRow) error {
+		cell, err := excelize.CoordinatesToCellName(1, rowIdx)
+		if err != nil {
+			return err
+		}
+		rowIdx++
+
+		return stream.SetRow(cell, []any{
+			row.BorrowRequestID,
+			row.RequestedAt,
+			row.BorrowerName,
+			row.BorrowerEmail,
+			row.EquipmentName,
+			cellValue(row.Brand),
+			cellValue(row.Model),
+			row.RequestedQuantity,
+			row.BorrowedQuantity,
+			row.ReturnedQuantity,
+			row.Status,
+			row.Location,
+			row.Purpose,
+			row.ExpectedClaimAt,
+			cellValue(row.ClaimedAt),
+			row.ExpectedReturnAt,
+			cellValue(row.ReturnedAt),
+			cellValue(row.ReviewedBy),
+			cellValue(row.ReviewedAt),
+			cellValue(row.ReviewRemarks),
+			cellValue(row.ReturnRemarks),
+		})
+	})
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("export borrow history xlsx: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to export borrow history.",
+		}
+	}
+
+	if err := stream.Flush(); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("export borrow history xlsx: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to export borrow history.",
+		}
+	}
+
+	filename := fmt.Sprintf("borrow_history_%s.xlsx", time.Now().Format("20060102"))
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	if err := file.Write(w); err != nil {
+		return api.Response{
+			Error: fmt.Errorf("export borrow history xlsx: %w", err),
+			Raw:   true,
+		}
+	}
+
+	return api.Response{Raw: true}
+}
+
+func formatExportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatExportString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// cellValue dereferences nullable columns so excelize writes an empty cell
+// instead of the pointer.
+func cellValue[T any](v *T) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
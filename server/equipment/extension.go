@@ -0,0 +1,638 @@
+package equipment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/sse"
+	"github.com/xGihyun/hirami/user"
+)
+
+type extensionStatus = string
+
+const (
+	extensionPending  extensionStatus = "pending"
+	extensionApproved extensionStatus = "approved"
+	extensionRejected extensionStatus = "rejected"
+)
+
+var (
+	errBorrowRequestNotExtendable = fmt.Errorf("only claimed borrow requests can be extended")
+	errInvalidExtension           = fmt.Errorf("extended return time must be after the current return time")
+	errExtensionPending           = fmt.Errorf("borrow request already has a pending extension")
+	errExtensionAlreadyReviewed   = fmt.Errorf("extension is already reviewed")
+	errInvalidExtensionStatus     = fmt.Errorf("invalid extension status")
+)
+
+type borrowRequestExtension struct {
+	BorrowRequestExtensionID string          `json:"id"`
+	CreatedAt                time.Time       `json:"createdAt"`
+	BorrowRequestID          string          `json:"borrowRequestId"`
+	RequestedBy              user.BasicInfo  `json:"requestedBy"`
+	PreviousReturnAt         time.Time       `json:"previousReturnAt"`
+	RequestedReturnAt        time.Time       `json:"requestedReturnAt"`
+	Reason                   *string         `json:"reason"`
+	Status                   extensionStatus `json:"status"`
+	ReviewedBy               *user.BasicInfo `json:"reviewedBy"`
+	ReviewedAt               *time.Time      `json:"reviewedAt"`
+	Remarks                  *string         `json:"remarks"`
+}
+
+const borrowRequestExtensionSelect = `
+	SELECT
+		borrow_request_extension.borrow_request_extension_id,
+		borrow_request_extension.created_at,
+		borrow_request_extension.borrow_request_id,
+		jsonb_build_object(
+			'id', requester.person_id,
+			'firstName', requester.first_name,
+			'middleName', requester.middle_name,
+			'lastName', requester.last_name,
+			'avatarUrl', requester.avatar_url
+		) AS requested_by,
+		borrow_request_extension.previous_return_at,
+		borrow_request_extension.requested_return_at,
+		borrow_request_extension.reason,
+		borrow_request_extension.status,
+		CASE
+			WHEN reviewer.person_id IS NULL THEN NULL
+			ELSE jsonb_build_object(
+				'id', reviewer.person_id,
+				'firstName', reviewer.first_name,
+				'middleName', reviewer.middle_name,
+				'lastName', reviewer.last_name,
+				'avatarUrl', reviewer.avatar_url
+			)
+		END AS reviewed_by,
+		borrow_request_extension.reviewed_at,
+		borrow_request_extension.remarks
+	FROM borrow_request_extension
+	JOIN person requester ON requester.person_id = borrow_request_extension.requested_by
+	LEFT JOIN person reviewer ON reviewer.person_id = borrow_request_extension.reviewed_by
+`
+
+// originalReturnAtColumn selects the due date a borrow request had before
+// its first approved extension, which is just its due date if it was never
+// extended.
+const originalReturnAtColumn = `
+		COALESCE(
+			(
+				SELECT borrow_request_extension.previous_return_at
+				FROM borrow_request_extension
+				WHERE borrow_request_extension.borrow_request_id = borrow_request.borrow_request_id
+					AND borrow_request_extension.status = 'approved'
+				ORDER BY borrow_request_extension.created_at
+				LIMIT 1
+			),
+			borrow_request.expected_return_at
+		) AS original_return_at`
+
+func getBorrowRequestExtension(ctx context.Context, q dbQuerier, id string) (borrowRequestExtension, error) {
+	query := borrowRequestExtensionSelect + " WHERE borrow_request_extension.borrow_request_extension_id = $1"
+
+	rows, err := q.Query(ctx, query, id)
+	if err != nil {
+		return borrowRequestExtension{}, err
+	}
+
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[borrowRequestExtension])
+}
+
+// getOutstandingItems counts the units of a claimed request that haven't
+// been returned yet. Only these need to stay available for an extension.
+func getOutstandingItems(ctx context.Context, q dbQuerier, borrowRequestID string) ([]borrowEquipmentItem, error) {
+	query := `
+	SELECT borrow_request_item.equipment_type_id, COUNT(borrow_transaction.borrow_transaction_id)
+	FROM borrow_request_item
+	JOIN borrow_transaction USING (borrow_request_item_id)
+	WHERE borrow_request_item.borrow_request_id = $1
+		AND NOT EXISTS (
+			SELECT 1
+			FROM return_transaction
+			WHERE return_transaction.borrow_transaction_id = borrow_transaction.borrow_transaction_id
+		)
+	GROUP BY borrow_request_item.equipment_type_id
+	`
+
+	rows, err := q.Query(ctx, query, borrowRequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (borrowEquipmentItem, error) {
+		var item borrowEquipmentItem
+		err := row.Scan(&item.EquipmentTypeID, &item.Quantity)
+		return item, err
+	})
+}
+
+// checkExtension makes sure the longer loan still follows the borrow
+// policies of the outstanding units and that they can be kept from the
+// current due date, or now if that has passed, until the new one.
+func checkExtension(
+	ctx context.Context,
+	q dbQuerier,
+	borrowRequestID string,
+	currentReturnAt, requestedReturnAt time.Time,
+) error {
+	items, err := getOutstandingItems(ctx, q, borrowRequestID)
+	if err != nil {
+		return err
+	}
+
+	var (
+		siteID    string
+		claimedAt time.Time
+	)
+	query := `
+	SELECT site_id, COALESCE(claimed_at, expected_claim_at)
+	FROM borrow_request
+	WHERE borrow_request_id = $1
+	`
+	if err := q.QueryRow(ctx, query, borrowRequestID).Scan(&siteID, &claimedAt); err != nil {
+		return err
+	}
+
+	if err := checkLoanPolicies(ctx, q, items, claimedAt, requestedReturnAt); err != nil {
+		return err
+	}
+
+	from := currentReturnAt
+	if now := time.Now(); from.Before(now) {
+		from = now
+	}
+
+	return checkAvailability(ctx, q, siteID, items, from, requestedReturnAt, borrowRequestID)
+}
+
+type createBorrowRequestExtension struct {
+	BorrowRequestID  string    `json:"borrowRequestId"`
+	RequestedBy      string    `json:"requestedBy"`
+	ExpectedReturnAt time.Time `json:"expectedReturnAt"`
+	Reason           *string   `json:"reason"`
+}
+
+func (r *repository) createBorrowRequestExtension(ctx context.Context, arg createBorrowRequestExtension) (borrowRequestExtension, error) {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return borrowRequestExtension{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	status, err := lockOwnBorrowRequest(ctx, tx, arg.BorrowRequestID, arg.RequestedBy)
+	if err != nil {
+		return borrowRequestExtension{}, err
+	}
+
+	if status != claimed {
+		return borrowRequestExtension{}, errBorrowRequestNotExtendable
+	}
+
+	var currentReturnAt time.Time
+	returnAtQuery := "SELECT expected_return_at FROM borrow_request WHERE borrow_request_id = $1"
+	if err := tx.QueryRow(ctx, returnAtQuery, arg.BorrowRequestID).Scan(&currentReturnAt); err != nil {
+		return borrowRequestExtension{}, err
+	}
+
+	if !arg.ExpectedReturnAt.After(currentReturnAt) || !arg.ExpectedReturnAt.After(time.Now()) {
+		return borrowRequestExtension{}, errInvalidExtension
+	}
+
+	// Checked again when a manager approves it, but there's no point in
+	// filing an extension that can't be granted.
+	if err := checkExtension(ctx, tx, arg.BorrowRequestID, currentReturnAt, arg.ExpectedReturnAt); err != nil {
+		return borrowRequestExtension{}, err
+	}
+
+	query := `
+	INSERT INTO borrow_request_extension (borrow_request_id, requested_by, previous_return_at, requested_return_at, reason)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING borrow_request_extension_id
+	`
+
+	var id string
+	if err := tx.QueryRow(
+		ctx,
+		query,
+		arg.BorrowRequestID,
+		arg.RequestedBy,
+		currentReturnAt,
+		arg.ExpectedReturnAt,
+		arg.Reason,
+	).Scan(&id); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return borrowRequestExtension{}, errExtensionPending
+		}
+		return borrowRequestExtension{}, err
+	}
+
+	extension, err := getBorrowRequestExtension(ctx, tx, id)
+	if err != nil {
+		return borrowRequestExtension{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return borrowRequestExtension{}, err
+	}
+
+	return extension, nil
+}
+
+type getBorrowRequestExtensionsParams struct {
+	borrowRequestID *string
+	requestedBy     *string
+	status          *string
+}
+
+func (r *repository) getBorrowRequestExtensions(ctx context.Context, params getBorrowRequestExtensionsParams) ([]borrowRequestExtension, error) {
+	query := borrowRequestExtensionSelect + " WHERE TRUE"
+
+	var args []any
+	if params.borrowRequestID != nil && *params.borrowRequestID != "" {
+		args = append(args, *params.borrowRequestID)
+		query += fmt.Sprintf(" AND borrow_request_extension.borrow_request_id = $%d", len(args))
+	}
+
+	if params.requestedBy != nil && *params.requestedBy != "" {
+		args = append(args, *params.requestedBy)
+		query += fmt.Sprintf(" AND borrow_request_extension.requested_by = $%d", len(args))
+	}
+
+	if params.status != nil && *params.status != "" {
+		args = append(args, *params.status)
+		query += fmt.Sprintf(" AND borrow_request_extension.status = $%d", len(args))
+	}
+
+	query += " ORDER BY borrow_request_extension.created_at"
+
+	rows, err := r.querier.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	extensions, err := pgx.CollectRows(rows, pgx.RowToStructByName[borrowRequestExtension])
+	if err != nil {
+		return nil, err
+	}
+
+	if extensions == nil {
+		extensions = []borrowRequestExtension{}
+	}
+
+	return extensions, nil
+}
+
+type reviewBorrowRequestExtension struct {
+	BorrowRequestExtensionID string          `json:"id"`
+	ReviewedBy               string          `json:"reviewedBy"`
+	Remarks                  *string         `json:"remarks"`
+	Status                   extensionStatus `json:"status"`
+}
+
+// reviewBorrowRequestExtension approves or rejects a pending extension. An
+// approval moves the request's due date, clears its overdue flag if the new
+// due date is still ahead and restarts its return reminders.
+func (r *repository) reviewBorrowRequestExtension(ctx context.Context, arg reviewBorrowRequestExtension) (borrowRequestExtension, error) {
+	if arg.Status != extensionApproved && arg.Status != extensionRejected {
+		return borrowRequestExtension{}, errInvalidExtensionStatus
+	}
+
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return borrowRequestExtension{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	lockQuery := `
+	SELECT borrow_request_id, requested_return_at, status
+	FROM borrow_request_extension
+	WHERE borrow_request_extension_id = $1
+	FOR UPDATE
+	`
+
+	var (
+		borrowRequestID   string
+		requestedReturnAt time.Time
+		status            extensionStatus
+	)
+	if err := tx.QueryRow(ctx, lockQuery, arg.BorrowRequestExtensionID).Scan(
+		&borrowRequestID,
+		&requestedReturnAt,
+		&status,
+	); err != nil {
+		return borrowRequestExtension{}, err
+	}
+
+	if status != extensionPending {
+		return borrowRequestExtension{}, errExtensionAlreadyReviewed
+	}
+
+	if arg.Status == extensionApproved {
+		// Locks the equipment types like any other approval
+		_, _, returnAt, err := lockBorrowRequestItems(ctx, tx, borrowRequestID)
+		if err != nil {
+			return borrowRequestExtension{}, err
+		}
+
+		var requestStatus borrowRequestStatus
+		statusQuery := "SELECT borrow_request_status_id FROM borrow_request WHERE borrow_request_id = $1"
+		if err := tx.QueryRow(ctx, statusQuery, borrowRequestID).Scan(&requestStatus); err != nil {
+			return borrowRequestExtension{}, err
+		}
+
+		if requestStatus != claimed {
+			return borrowRequestExtension{}, errBorrowRequestNotExtendable
+		}
+
+		if err := checkExtension(ctx, tx, borrowRequestID, returnAt, requestedReturnAt); err != nil {
+			return borrowRequestExtension{}, err
+		}
+
+		extendQuery := `
+		UPDATE borrow_request
+		SET
+			expected_return_at = $1,
+			overdue_at = CASE WHEN $1 > NOW() THEN NULL ELSE overdue_at END,
+			updated_at = NOW()
+		WHERE borrow_request_id = $2
+		`
+		if _, err := tx.Exec(ctx, extendQuery, requestedReturnAt, borrowRequestID); err != nil {
+			return borrowRequestExtension{}, err
+		}
+
+		resetRemindersQuery := "DELETE FROM borrow_request_reminder WHERE borrow_request_id = $1"
+		if _, err := tx.Exec(ctx, resetRemindersQuery, borrowRequestID); err != nil {
+			return borrowRequestExtension{}, err
+		}
+	}
+
+	reviewQuery := `
+	UPDATE borrow_request_extension
+	SET status = $1, reviewed_by = $2, remarks = $3, reviewed_at = NOW(), updated_at = NOW()
+	WHERE borrow_request_extension_id = $4
+	`
+	if _, err := tx.Exec(
+		ctx,
+		reviewQuery,
+		arg.Status,
+		arg.ReviewedBy,
+		arg.Remarks,
+		arg.BorrowRequestExtensionID,
+	); err != nil {
+		return borrowRequestExtension{}, err
+	}
+
+	extension, err := getBorrowRequestExtension(ctx, tx, arg.BorrowRequestExtensionID)
+	if err != nil {
+		return borrowRequestExtension{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return borrowRequestExtension{}, err
+	}
+
+	return extension, nil
+}
+
+func (s *Server) createBorrowRequestExtension(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data createBorrowRequestExtension
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create borrow request extension: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid create borrow request extension.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("create borrow request extension", err)
+	}
+	data.RequestedBy = claims.UserID
+	data.BorrowRequestID = r.PathValue("id")
+
+	extension, err := s.repository.createBorrowRequestExtension(ctx, data)
+	if err != nil {
+		if res, ok := ownBorrowRequestErrorResponse("create borrow request extension", err); ok {
+			return res
+		}
+
+		if errors.Is(err, errBorrowRequestNotExtendable) {
+			return api.Response{
+				Error:   fmt.Errorf("create borrow request extension: %w", err),
+				Code:    http.StatusConflict,
+				Message: "Only claimed borrow requests can be extended.",
+			}
+		}
+
+		if errors.Is(err, errInvalidExtension) {
+			return api.Response{
+				Error:   fmt.Errorf("create borrow request extension: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "New return time must be after the current return time.",
+			}
+		}
+
+		if errors.Is(err, errExtensionPending) {
+			return api.Response{
+				Error:   fmt.Errorf("create borrow request extension: %w", err),
+				Code:    http.StatusConflict,
+				Message: "This borrow request already has an extension waiting for review.",
+			}
+		}
+
+		if errors.Is(err, errInsufficientEquipmentQuantity) {
+			return api.Response{
+				Error:   fmt.Errorf("create borrow request extension: %w", err),
+				Code:    http.StatusConflict,
+				Message: "The equipment is already booked by someone else for the extended time.",
+			}
+		}
+
+		if res, ok := policyViolationResponse("create borrow request extension", err); ok {
+			return res
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("create borrow request extension: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to create borrow request extension.",
+		}
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventExtensionCreate,
+		Data:  extension,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create borrow request extension: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to create borrow request extension.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create borrow request extension: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to create borrow request extension.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusCreated,
+		Message: "Successfully created borrow request extension.",
+		Data:    extension,
+	}
+}
+
+func (s *Server) getBorrowRequestExtensions(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("get borrow request extensions", err)
+	}
+
+	// Borrowers only see the extensions they filed
+	requestedBy := scopedUserID(claims, user.PermissionBorrowView, "")
+	params := getBorrowRequestExtensionsParams{requestedBy: &requestedBy}
+	if borrowRequestID := r.PathValue("id"); borrowRequestID != "" {
+		params.borrowRequestID = &borrowRequestID
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		params.status = &status
+	}
+
+	extensions, err := s.repository.getBorrowRequestExtensions(ctx, params)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get borrow request extensions: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get borrow request extensions.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched borrow request extensions.",
+		Data:    extensions,
+	}
+}
+
+func (s *Server) reviewBorrowRequestExtension(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data reviewBorrowRequestExtension
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("review borrow request extension: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid review borrow request extension.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("review borrow request extension", err)
+	}
+	data.ReviewedBy = claims.UserID
+
+	extension, err := s.repository.reviewBorrowRequestExtension(ctx, data)
+	if err != nil {
+		if errors.Is(err, errInvalidExtensionStatus) {
+			return api.Response{
+				Error:   fmt.Errorf("review borrow request extension: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Extensions can only be approved or rejected.",
+			}
+		}
+
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("review borrow request extension: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Borrow request extension not found.",
+			}
+		}
+
+		if errors.Is(err, errExtensionAlreadyReviewed) {
+			return api.Response{
+				Error:   fmt.Errorf("review borrow request extension: %w", err),
+				Code:    http.StatusConflict,
+				Message: "This extension has already been reviewed.",
+			}
+		}
+
+		if errors.Is(err, errBorrowRequestNotExtendable) {
+			return api.Response{
+				Error:   fmt.Errorf("review borrow request extension: %w", err),
+				Code:    http.StatusConflict,
+				Message: "The equipment has already been returned.",
+			}
+		}
+
+		if errors.Is(err, errInsufficientEquipmentQuantity) {
+			return api.Response{
+				Error:   fmt.Errorf("review borrow request extension: %w", err),
+				Code:    http.StatusConflict,
+				Message: "The equipment is already booked by someone else for the extended time.",
+			}
+		}
+
+		if res, ok := policyViolationResponse("review borrow request extension", err); ok {
+			return res
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("review borrow request extension: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to review borrow request extension.",
+		}
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventExtensionReview,
+		Data:  extension,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("review borrow request extension: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to review borrow request extension.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("review borrow request extension: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to review borrow request extension.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully reviewed borrow request extension.",
+		Data:    extension,
+	}
+}
@@ -0,0 +1,386 @@
+package equipment
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/xGihyun/hirami/api"
+)
+
+const (
+	defaultForecastWeeks = 4
+	maxForecastWeeks     = 26
+
+	// forecastHistoryWeeks covers two years so there's a full season to
+	// compare against even for the last forecast week.
+	forecastHistoryWeeks = 104
+
+	// seasonWeeks is the length of a school year, which is when borrowing
+	// patterns repeat.
+	seasonWeeks = 52
+
+	// forecastSmoothing weighs recent weeks over older ones.
+	forecastSmoothing = 0.3
+)
+
+// Forecasting methods.
+const (
+	forecastSeasonalNaive        = "seasonal-naive"
+	forecastExponentialSmoothing = "exponential-smoothing"
+)
+
+var errInvalidForecastWeeks = fmt.Errorf("forecast weeks must be between 1 and %d", maxForecastWeeks)
+
+// demandInterval is the time a borrow request wants units of an equipment
+// type for.
+type demandInterval struct {
+	start    time.Time
+	end      time.Time
+	quantity int
+}
+
+type forecastEquipment struct {
+	id        string
+	name      string
+	createdAt time.Time
+
+	availableUnits   int
+	circulatingUnits int
+}
+
+type weeklyDemand struct {
+	WeekStart time.Time `json:"weekStart"`
+	Units     float64   `json:"units"`
+}
+
+type demandForecast struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	// AvailableUnits are the ones on the shelf right now, while
+	// CirculatingUnits also counts the reserved and borrowed ones that will
+	// come back.
+	AvailableUnits   int `json:"availableUnits"`
+	CirculatingUnits int `json:"circulatingUnits"`
+
+	RecentDemand []weeklyDemand `json:"recentDemand"`
+	Forecast     []weeklyDemand `json:"forecast"`
+	Method       string         `json:"method"`
+
+	// RecommendedQuantity is how many units to add through increaseQuantity
+	// so the circulating units cover the busiest forecast week.
+	RecommendedQuantity int `json:"recommendedQuantity"`
+}
+
+type forecastReport struct {
+	WeekStart  time.Time        `json:"weekStart"`
+	Weeks      int              `json:"weeks"`
+	Equipments []demandForecast `json:"equipments"`
+}
+
+type getForecastParams struct {
+	weekStart       time.Time
+	weeks           int
+	siteID          string
+	equipmentTypeID string
+}
+
+// getForecastData returns the equipment types along with what borrow
+// requests wanted of them since the start of the history. Only cancelled
+// requests are left out since rejected ones are demand that wasn't met.
+func (r *repository) getForecastData(
+	ctx context.Context,
+	params getForecastParams,
+	historyStart time.Time,
+) ([]forecastEquipment, map[string][]demandInterval, error) {
+	equipmentQuery := `
+	SELECT
+		equipment_type.equipment_type_id,
+		equipment_type.name,
+		equipment_type.created_at,
+		COUNT(equipment.equipment_id) FILTER (WHERE equipment.equipment_status_id = $3)::int,
+		COUNT(equipment.equipment_id) FILTER (WHERE equipment.equipment_status_id IN ($3, $4, $5))::int
+	FROM equipment_type
+	LEFT JOIN equipment ON equipment.equipment_type_id = equipment_type.equipment_type_id
+		AND ($1 = '' OR equipment.site_id::text = $1)
+	WHERE $2 = '' OR equipment_type.equipment_type_id::text = $2
+	GROUP BY equipment_type.equipment_type_id
+	ORDER BY equipment_type.name, equipment_type.equipment_type_id
+	`
+
+	rows, err := r.querier.Query(ctx, equipmentQuery, params.siteID, params.equipmentTypeID, available, reserved, borrowed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var equipments []forecastEquipment
+	for rows.Next() {
+		var e forecastEquipment
+		if err := rows.Scan(&e.id, &e.name, &e.createdAt, &e.availableUnits, &e.circulatingUnits); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		equipments = append(equipments, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	demandQuery := `
+	SELECT
+		borrow_request_item.equipment_type_id,
+		COALESCE(borrow_request.claimed_at, borrow_request.expected_claim_at, borrow_request.created_at) AS start_at,
+		borrow_request.expected_return_at,
+		borrow_request_item.quantity
+	FROM borrow_request
+	JOIN borrow_request_item ON borrow_request_item.borrow_request_id = borrow_request.borrow_request_id
+	WHERE borrow_request.borrow_request_status_id <> $1
+	AND borrow_request.expected_return_at > $2
+	AND COALESCE(borrow_request.claimed_at, borrow_request.expected_claim_at, borrow_request.created_at) < $3
+	AND ($4 = '' OR borrow_request.site_id::text = $4)
+	AND ($5 = '' OR borrow_request_item.equipment_type_id::text = $5)
+	`
+
+	rows, err = r.querier.Query(
+		ctx,
+		demandQuery,
+		cancelled,
+		historyStart,
+		params.weekStart,
+		params.siteID,
+		params.equipmentTypeID,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	demand := make(map[string][]demandInterval)
+	for rows.Next() {
+		var equipmentTypeID string
+		var d demandInterval
+		if err := rows.Scan(&equipmentTypeID, &d.start, &d.end, &d.quantity); err != nil {
+			return nil, nil, err
+		}
+		demand[equipmentTypeID] = append(demand[equipmentTypeID], d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return equipments, demand, nil
+}
+
+// startOfWeek is the Monday midnight of the week t falls in.
+func startOfWeek(t time.Time, location *time.Location) time.Time {
+	t = t.In(location)
+	daysSinceMonday := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, location)
+}
+
+// weeklyPeakDemand is the most units wanted at the same time within each week.
+func weeklyPeakDemand(intervals []demandInterval, weekStarts []time.Time) []float64 {
+	type event struct {
+		at    time.Time
+		delta int
+	}
+
+	peaks := make([]float64, len(weekStarts))
+	for i, start := range weekStarts {
+		end := start.AddDate(0, 0, 7)
+
+		var events []event
+		for _, d := range intervals {
+			if !d.start.Before(end) || !d.end.After(start) {
+				continue
+			}
+			events = append(events,
+				event{at: maxTime(d.start, start), delta: d.quantity},
+				event{at: minTime(d.end, end), delta: -d.quantity},
+			)
+		}
+
+		// Units handed back sort before the ones handed out at the same time
+		slices.SortFunc(events, func(a, b event) int {
+			if c := a.at.Compare(b.at); c != 0 {
+				return c
+			}
+			return a.delta - b.delta
+		})
+
+		var current, peak int
+		for _, e := range events {
+			current += e.delta
+			peak = max(peak, current)
+		}
+		peaks[i] = float64(peak)
+	}
+
+	return peaks
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// forecastDemand projects the next weeks of a weekly series. With a full
+// season of history each week repeats the same week a year before, which
+// catches the start of semesters and exam weeks. Otherwise the forecast is
+// the exponentially smoothed level of the weeks so far.
+func forecastDemand(history []float64, weeks int) ([]float64, string) {
+	forecast := make([]float64, weeks)
+	if len(history) == 0 {
+		return forecast, forecastExponentialSmoothing
+	}
+
+	if len(history) >= seasonWeeks {
+		for h := range weeks {
+			forecast[h] = history[len(history)-seasonWeeks+h%seasonWeeks]
+		}
+		return forecast, forecastSeasonalNaive
+	}
+
+	level := history[0]
+	for _, v := range history[1:] {
+		level = forecastSmoothing*v + (1-forecastSmoothing)*level
+	}
+
+	for h := range weeks {
+		forecast[h] = level
+	}
+	return forecast, forecastExponentialSmoothing
+}
+
+// getDemandForecast projects weekly peak demand per equipment type from the start
+// of the current week. Weeks before an equipment type was added are left out
+// of its history so they don't drag the forecast down.
+func (s *Server) getDemandForecast(ctx context.Context, params getForecastParams) (forecastReport, error) {
+	historyStart := params.weekStart.AddDate(0, 0, -7*forecastHistoryWeeks)
+
+	equipments, demand, err := s.repository.getForecastData(ctx, params, historyStart)
+	if err != nil {
+		return forecastReport{}, err
+	}
+
+	report := forecastReport{
+		WeekStart:  params.weekStart,
+		Weeks:      params.weeks,
+		Equipments: []demandForecast{},
+	}
+
+	for _, e := range equipments {
+		if e.circulatingUnits == 0 && len(demand[e.id]) == 0 {
+			continue
+		}
+
+		first := maxTime(startOfWeek(e.createdAt, s.location), historyStart)
+
+		var weekStarts []time.Time
+		for w := first; w.Before(params.weekStart); w = w.AddDate(0, 0, 7) {
+			weekStarts = append(weekStarts, w)
+		}
+		history := weeklyPeakDemand(demand[e.id], weekStarts)
+
+		projected, method := forecastDemand(history, params.weeks)
+
+		f := demandForecast{
+			ID:               e.id,
+			Name:             e.name,
+			AvailableUnits:   e.availableUnits,
+			CirculatingUnits: e.circulatingUnits,
+			RecentDemand:     []weeklyDemand{},
+			Forecast:         make([]weeklyDemand, params.weeks),
+			Method:           method,
+		}
+
+		for i := max(0, len(history)-params.weeks); i < len(history); i++ {
+			f.RecentDemand = append(f.RecentDemand, weeklyDemand{WeekStart: weekStarts[i], Units: history[i]})
+		}
+
+		var busiest float64
+		for h, units := range projected {
+			units = math.Round(units*100) / 100
+			f.Forecast[h] = weeklyDemand{WeekStart: params.weekStart.AddDate(0, 0, 7*h), Units: units}
+			busiest = max(busiest, units)
+		}
+		f.RecommendedQuantity = max(0, int(math.Ceil(busiest))-e.circulatingUnits)
+
+		report.Equipments = append(report.Equipments, f)
+	}
+
+	return report, nil
+}
+
+func (params getForecastParams) cacheKey() string {
+	return fmt.Sprintf(
+		"analytics:forecast:%d:%d:%s:%s",
+		params.weekStart.Unix(),
+		params.weeks,
+		params.siteID,
+		params.equipmentTypeID,
+	)
+}
+
+func (s *Server) getForecast(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	query := r.URL.Query()
+	params := getForecastParams{
+		weekStart:       startOfWeek(time.Now(), s.location),
+		weeks:           defaultForecastWeeks,
+		siteID:          query.Get("site"),
+		equipmentTypeID: query.Get("equipmentTypeId"),
+	}
+
+	if v := query.Get("weeks"); v != "" {
+		weeks, err := strconv.Atoi(v)
+		if err != nil || weeks < 1 || weeks > maxForecastWeeks {
+			return api.Response{
+				Error:   fmt.Errorf("get forecast: %w", errInvalidForecastWeeks),
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Forecast between 1 and %d weeks ahead.", maxForecastWeeks),
+			}
+		}
+		params.weeks = weeks
+	}
+
+	key := params.cacheKey()
+
+	var report forecastReport
+	if !s.getCachedReport(ctx, key, &report) {
+		var err error
+		report, err = s.getDemandForecast(ctx, params)
+		if err != nil {
+			return api.Response{
+				Error:   fmt.Errorf("get forecast: %w", err),
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to get forecast.",
+			}
+		}
+
+		s.cacheReport(ctx, key, report)
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched forecast.",
+		Data:    report,
+	}
+}
@@ -0,0 +1,233 @@
+package equipment
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf/v2"
+	"github.com/skip2/go-qrcode"
+	"github.com/xGihyun/hirami/api"
+)
+
+type unitLabel struct {
+	UnitID       string
+	AssetTag     string
+	SerialNumber *string
+	Name         string
+	Brand        *string
+	Model        *string
+}
+
+type unitLabelParams struct {
+	equipmentTypeID *string
+	categoryID      *string
+	unitIDs         []string
+}
+
+func (r *repository) getUnitLabels(ctx context.Context, params unitLabelParams) ([]unitLabel, error) {
+	query := `
+	SELECT
+		equipment.equipment_id,
+		equipment.asset_tag,
+		equipment.serial_number,
+		equipment_type.name,
+		equipment_type.brand,
+		equipment_type.model
+	FROM equipment
+	JOIN equipment_type USING (equipment_type_id)
+	WHERE equipment.equipment_status_id != $1
+	`
+
+	args := []any{disposed}
+	argIdx := len(args) + 1
+
+	if params.equipmentTypeID != nil && *params.equipmentTypeID != "" {
+		query += fmt.Sprintf(" AND equipment.equipment_type_id = $%d", argIdx)
+		args = append(args, *params.equipmentTypeID)
+		argIdx++
+	}
+
+	if params.categoryID != nil && *params.categoryID != "" {
+		query += fmt.Sprintf(` AND equipment.equipment_type_id IN (
+			SELECT equipment_type_id
+			FROM equipment_type_category
+			WHERE category_id = $%d
+		)`, argIdx)
+		args = append(args, *params.categoryID)
+		argIdx++
+	}
+
+	if len(params.unitIDs) > 0 {
+		query += fmt.Sprintf(" AND (equipment.equipment_id::text = ANY($%d) OR equipment.asset_tag = ANY($%d))", argIdx, argIdx)
+		args = append(args, params.unitIDs)
+		argIdx++
+	}
+
+	query += " ORDER BY equipment_type.name, equipment.asset_tag"
+
+	rows, err := r.querier.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var labels []unitLabel
+	for rows.Next() {
+		var label unitLabel
+		if err := rows.Scan(
+			&label.UnitID,
+			&label.AssetTag,
+			&label.SerialNumber,
+			&label.Name,
+			&label.Brand,
+			&label.Model,
+		); err != nil {
+			return nil, err
+		}
+		labels = append(labels, label)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+// Label sheet layout in millimeters, sized for a 3 x 8 grid on A4.
+const (
+	labelColumns      = 3
+	labelRows         = 8
+	labelWidth        = 70.0
+	labelHeight       = 37.0
+	labelMarginX      = 0.0
+	labelMarginY      = 0.5
+	labelPadding      = 3.0
+	labelQRSize       = 28.0
+	labelQRResolution = 256
+)
+
+func (s *Server) getEquipmentLabelsPDF(w http.ResponseWriter, r *http.Request) api.Response {
+	equipmentTypeID := r.PathValue("equipmentTypeId")
+	params := unitLabelParams{
+		equipmentTypeID: &equipmentTypeID,
+	}
+	if ids := r.URL.Query().Get("unitIds"); ids != "" {
+		params.unitIDs = strings.Split(ids, ",")
+	}
+
+	return s.writeUnitLabelsPDF(w, r, params, "equipment_labels.pdf")
+}
+
+func (s *Server) getCategoryLabelsPDF(w http.ResponseWriter, r *http.Request) api.Response {
+	categoryID := r.PathValue("id")
+	params := unitLabelParams{
+		categoryID: &categoryID,
+	}
+
+	return s.writeUnitLabelsPDF(w, r, params, "category_labels.pdf")
+}
+
+func (s *Server) writeUnitLabelsPDF(w http.ResponseWriter, r *http.Request, params unitLabelParams, filename string) api.Response {
+	ctx := r.Context()
+
+	labels, err := s.repository.getUnitLabels(ctx, params)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get unit labels: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get equipment units for labels.",
+		}
+	}
+
+	if len(labels) == 0 {
+		return api.Response{
+			Error:   fmt.Errorf("get unit labels: no units found"),
+			Code:    http.StatusNotFound,
+			Message: "No equipment units found to label.",
+		}
+	}
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(labelMarginX, labelMarginY, labelMarginX)
+	pdf.SetAutoPageBreak(false, 0)
+
+	textWidth := labelWidth - labelQRSize - labelPadding*3
+	perPage := labelColumns * labelRows
+
+	for i, label := range labels {
+		if i%perPage == 0 {
+			pdf.AddPage()
+		}
+
+		col := (i % perPage) % labelColumns
+		row := (i % perPage) / labelColumns
+		x := labelMarginX + float64(col)*labelWidth
+		y := labelMarginY + float64(row)*labelHeight
+
+		png, err := qrcode.Encode(label.AssetTag, qrcode.Medium, labelQRResolution)
+		if err != nil {
+			return api.Response{
+				Error:   fmt.Errorf("generate unit label: %w", err),
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to generate labels.",
+			}
+		}
+
+		imageOptions := gofpdf.ImageOptions{ImageType: "PNG"}
+		pdf.RegisterImageOptionsReader(label.UnitID, imageOptions, bytes.NewReader(png))
+		pdf.ImageOptions(label.UnitID, x+labelPadding, y+(labelHeight-labelQRSize)/2, labelQRSize, labelQRSize, false, imageOptions, 0, "")
+
+		textX := x + labelQRSize + labelPadding*2
+		pdf.SetXY(textX, y+labelPadding+2)
+
+		pdf.SetFont("Arial", "B", 10)
+		pdf.MultiCell(textWidth, 4.5, label.Name, "", "L", false)
+
+		var details []string
+		if label.Brand != nil && *label.Brand != "" {
+			details = append(details, *label.Brand)
+		}
+		if label.Model != nil && *label.Model != "" {
+			details = append(details, *label.Model)
+		}
+		pdf.SetFont("Arial", "", 8)
+		if len(details) > 0 {
+			pdf.SetX(textX)
+			pdf.MultiCell(textWidth, 4, strings.Join(details, " "), "", "L", false)
+		}
+
+		pdf.SetX(textX)
+		pdf.SetFont("Courier", "B", 10)
+		pdf.CellFormat(textWidth, 5, label.AssetTag, "", 1, "L", false, 0, "")
+
+		if label.SerialNumber != nil && *label.SerialNumber != "" {
+			pdf.SetX(textX)
+			pdf.SetFont("Arial", "", 7)
+			pdf.CellFormat(textWidth, 4, "S/N: "+*label.SerialNumber, "", 1, "L", false, 0, "")
+		}
+	}
+
+	if err := pdf.Error(); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("generate unit labels: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to generate labels.",
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+	if err := pdf.Output(w); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("pdf output: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to generate PDF.",
+		}
+	}
+
+	return api.Response{Raw: true}
+}
@@ -0,0 +1,1043 @@
+package equipment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/sse"
+	"github.com/xGihyun/hirami/user"
+)
+
+type maintenanceTicketStatus = string
+
+const (
+	maintenanceTicketOpen     maintenanceTicketStatus = "open"
+	maintenanceTicketRepaired maintenanceTicketStatus = "repaired"
+	maintenanceTicketDisposed maintenanceTicketStatus = "disposed"
+)
+
+var (
+	errInvalidMaintenanceTicket  = fmt.Errorf("maintenance ticket needs a unit and a description")
+	errInvalidMaintenanceOutcome = fmt.Errorf("maintenance ticket must be closed as repaired or disposed with a resolution")
+	errUnitNotInMaintenance      = fmt.Errorf("equipment unit is not damaged or under maintenance")
+	errMaintenanceTicketExists   = fmt.Errorf("equipment unit already has an open maintenance ticket")
+	errMaintenanceTicketClosed   = fmt.Errorf("maintenance ticket is already closed")
+	errInvalidAssignee           = fmt.Errorf("assignee not found")
+	errPhotoTooLarge             = fmt.Errorf("photo size exceeds 5MB limit")
+	errInvalidPhotoType          = fmt.Errorf("photo must be a JPG or PNG")
+)
+
+type maintenanceUnit struct {
+	UnitID          string                `json:"id"`
+	EquipmentTypeID string                `json:"equipmentTypeId"`
+	Name            string                `json:"name"`
+	AssetTag        string                `json:"assetTag"`
+	Status          equipmentStatusDetail `json:"status"`
+	Site            siteDetail            `json:"site"`
+}
+
+type maintenanceTicket struct {
+	MaintenanceTicketID  string                  `json:"id"`
+	CreatedAt            time.Time               `json:"createdAt"`
+	Unit                 maintenanceUnit         `json:"unit"`
+	Description          string                  `json:"description"`
+	Photos               []string                `json:"photos"`
+	OpenedBy             *user.BasicInfo         `json:"openedBy"`
+	AssignedTo           *user.BasicInfo         `json:"assignedTo"`
+	Cost                 *float64                `json:"cost"`
+	ExpectedCompletionAt *time.Time              `json:"expectedCompletionAt"`
+	IsOverdue            bool                    `json:"isOverdue"`
+	Status               maintenanceTicketStatus `json:"status"`
+	Resolution           *string                 `json:"resolution"`
+	ClosedBy             *user.BasicInfo         `json:"closedBy"`
+	ClosedAt             *time.Time              `json:"closedAt"`
+
+	// ScheduleID is set for tickets opened by a preventive maintenance
+	// schedule, which have no OpenedBy.
+	ScheduleID *string `json:"scheduleId"`
+}
+
+const maintenanceTicketSelect = `
+	SELECT
+		maintenance_ticket.maintenance_ticket_id,
+		maintenance_ticket.created_at,
+		jsonb_build_object(
+			'id', equipment.equipment_id,
+			'equipmentTypeId', equipment.equipment_type_id,
+			'name', equipment_type.name,
+			'assetTag', equipment.asset_tag,
+			'status', jsonb_build_object(
+				'id', equipment_status.equipment_status_id,
+				'code', equipment_status.code,
+				'label', equipment_status.label
+			),
+			'site', jsonb_build_object(
+				'id', site.site_id,
+				'name', site.name,
+				'building', site.building,
+				'room', site.room
+			)
+		) AS unit,
+		maintenance_ticket.description,
+		COALESCE(photo_agg.photos, '[]'::jsonb) AS photos,
+		CASE
+			WHEN opener.person_id IS NULL THEN NULL
+			ELSE jsonb_build_object(
+				'id', opener.person_id,
+				'firstName', opener.first_name,
+				'middleName', opener.middle_name,
+				'lastName', opener.last_name,
+				'avatarUrl', opener.avatar_url
+			)
+		END AS opened_by,
+		CASE
+			WHEN assignee.person_id IS NULL THEN NULL
+			ELSE jsonb_build_object(
+				'id', assignee.person_id,
+				'firstName', assignee.first_name,
+				'middleName', assignee.middle_name,
+				'lastName', assignee.last_name,
+				'avatarUrl', assignee.avatar_url
+			)
+		END AS assigned_to,
+		maintenance_ticket.cost::float8 AS cost,
+		maintenance_ticket.expected_completion_at,
+		COALESCE(
+			maintenance_ticket.status = 'open' AND maintenance_ticket.expected_completion_at < NOW(),
+			FALSE
+		) AS is_overdue,
+		maintenance_ticket.status,
+		maintenance_ticket.resolution,
+		CASE
+			WHEN closer.person_id IS NULL THEN NULL
+			ELSE jsonb_build_object(
+				'id', closer.person_id,
+				'firstName', closer.first_name,
+				'middleName', closer.middle_name,
+				'lastName', closer.last_name,
+				'avatarUrl', closer.avatar_url
+			)
+		END AS closed_by,
+		maintenance_ticket.closed_at,
+		maintenance_ticket.maintenance_schedule_id AS schedule_id
+	FROM maintenance_ticket
+	JOIN equipment ON equipment.equipment_id = maintenance_ticket.equipment_id
+	JOIN equipment_type ON equipment_type.equipment_type_id = equipment.equipment_type_id
+	JOIN equipment_status ON equipment_status.equipment_status_id = equipment.equipment_status_id
+	JOIN site ON site.site_id = equipment.site_id
+	LEFT JOIN person opener ON opener.person_id = maintenance_ticket.opened_by
+	LEFT JOIN person assignee ON assignee.person_id = maintenance_ticket.assigned_to
+	LEFT JOIN person closer ON closer.person_id = maintenance_ticket.closed_by
+	LEFT JOIN LATERAL (
+		SELECT jsonb_agg(maintenance_ticket_photo.url ORDER BY maintenance_ticket_photo.created_at) AS photos
+		FROM maintenance_ticket_photo
+		WHERE maintenance_ticket_photo.maintenance_ticket_id = maintenance_ticket.maintenance_ticket_id
+	) photo_agg ON TRUE
+`
+
+func getMaintenanceTicket(ctx context.Context, q dbQuerier, id string) (maintenanceTicket, error) {
+	query := maintenanceTicketSelect + " WHERE maintenance_ticket.maintenance_ticket_id = $1"
+
+	rows, err := q.Query(ctx, query, id)
+	if err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[maintenanceTicket])
+}
+
+func insertMaintenancePhotos(ctx context.Context, tx pgx.Tx, ticketID string, photoURLs []string) error {
+	if len(photoURLs) == 0 {
+		return nil
+	}
+
+	query := `
+	INSERT INTO maintenance_ticket_photo (maintenance_ticket_id, url)
+	SELECT $1, unnest($2::text[])
+	`
+	_, err := tx.Exec(ctx, query, ticketID, photoURLs)
+	return err
+}
+
+// maintenanceTicketError maps constraint violations on the ticket's columns.
+func maintenanceTicketError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			return errMaintenanceTicketExists
+		case "23503":
+			return errInvalidAssignee
+		}
+	}
+	return err
+}
+
+type createMaintenanceTicket struct {
+	// UnitID is the unit's ID or asset tag.
+	UnitID               string
+	Description          string
+	AssignedTo           *string
+	Cost                 *float64
+	ExpectedCompletionAt *time.Time
+	PhotoURLs            []string
+	OpenedBy             string
+}
+
+func (arg createMaintenanceTicket) validate() error {
+	if strings.TrimSpace(arg.UnitID) == "" || strings.TrimSpace(arg.Description) == "" {
+		return errInvalidMaintenanceTicket
+	}
+	if arg.Cost != nil && *arg.Cost < 0 {
+		return errInvalidMaintenanceTicket
+	}
+	return nil
+}
+
+// createMaintenanceTicket opens a ticket for a unit that was already moved
+// to damaged or maintenance, e.g. through reallocate.
+func (r *repository) createMaintenanceTicket(ctx context.Context, arg createMaintenanceTicket) (maintenanceTicket, error) {
+	if err := arg.validate(); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return maintenanceTicket{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	units, err := lockScannedUnits(ctx, tx, []string{arg.UnitID})
+	if err != nil {
+		return maintenanceTicket{}, err
+	}
+	unit := units[0]
+
+	if unit.status != damaged && unit.status != maintenance {
+		return maintenanceTicket{}, fmt.Errorf("%w: %s", errUnitNotInMaintenance, unit.assetTag)
+	}
+
+	if err := checkSiteManager(ctx, tx, arg.OpenedBy, unit.siteID); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	query := `
+	INSERT INTO maintenance_ticket (equipment_id, description, opened_by, assigned_to, cost, expected_completion_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING maintenance_ticket_id
+	`
+
+	var ticketID string
+	if err := tx.QueryRow(
+		ctx,
+		query,
+		unit.unitID,
+		strings.TrimSpace(arg.Description),
+		arg.OpenedBy,
+		arg.AssignedTo,
+		arg.Cost,
+		arg.ExpectedCompletionAt,
+	).Scan(&ticketID); err != nil {
+		return maintenanceTicket{}, maintenanceTicketError(err)
+	}
+
+	if err := insertMaintenancePhotos(ctx, tx, ticketID, arg.PhotoURLs); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	ticket, err := getMaintenanceTicket(ctx, tx, ticketID)
+	if err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	return ticket, nil
+}
+
+type getMaintenanceTicketsParams struct {
+	status          *string
+	assignedTo      *string
+	equipmentTypeID *string
+	siteID          *string
+	page            api.Page
+}
+
+// maintenanceTicketFilters builds the WHERE clause shared by the ticket list
+// and the repair metrics.
+func maintenanceTicketFilters(params getMaintenanceTicketsParams) (string, []any) {
+	where := " WHERE TRUE"
+	var args []any
+
+	if params.status != nil && *params.status != "" {
+		args = append(args, *params.status)
+		where += fmt.Sprintf(" AND maintenance_ticket.status = $%d", len(args))
+	}
+
+	if params.assignedTo != nil && *params.assignedTo != "" {
+		args = append(args, *params.assignedTo)
+		where += fmt.Sprintf(" AND maintenance_ticket.assigned_to::text = $%d", len(args))
+	}
+
+	if params.equipmentTypeID != nil && *params.equipmentTypeID != "" {
+		args = append(args, *params.equipmentTypeID)
+		where += fmt.Sprintf(" AND equipment.equipment_type_id::text = $%d", len(args))
+	}
+
+	if params.siteID != nil && *params.siteID != "" {
+		args = append(args, *params.siteID)
+		where += fmt.Sprintf(" AND equipment.site_id::text = $%d", len(args))
+	}
+
+	return where, args
+}
+
+// getMaintenanceTickets lists tickets oldest first, so the ones that have
+// been waiting the longest come up on top.
+func (r *repository) getMaintenanceTickets(ctx context.Context, params getMaintenanceTicketsParams) ([]maintenanceTicket, *string, error) {
+	where, args := maintenanceTicketFilters(params)
+	query := maintenanceTicketSelect + where
+
+	if params.page.Cursor != "" {
+		var cursorCreatedAt time.Time
+		var cursorID string
+		if err := api.DecodeCursor(params.page.Cursor, &cursorCreatedAt, &cursorID); err != nil {
+			return nil, nil, err
+		}
+
+		args = append(args, cursorCreatedAt, cursorID)
+		query += fmt.Sprintf(
+			" AND (maintenance_ticket.created_at, maintenance_ticket.maintenance_ticket_id) > ($%d, $%d)",
+			len(args)-1,
+			len(args),
+		)
+	}
+
+	query += " ORDER BY maintenance_ticket.created_at, maintenance_ticket.maintenance_ticket_id"
+
+	if params.page.Limit > 0 {
+		args = append(args, params.page.Limit+1)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := r.querier.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tickets, err := pgx.CollectRows(rows, pgx.RowToStructByName[maintenanceTicket])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if tickets == nil {
+		tickets = []maintenanceTicket{}
+	}
+
+	return api.NextPage(tickets, params.page, func(t maintenanceTicket) []any {
+		return []any{t.CreatedAt, t.MaintenanceTicketID}
+	})
+}
+
+func (r *repository) getMaintenanceTicket(ctx context.Context, id string) (maintenanceTicket, error) {
+	return getMaintenanceTicket(ctx, r.querier, id)
+}
+
+type updateMaintenanceTicket struct {
+	MaintenanceTicketID  string     `json:"-"`
+	Description          *string    `json:"description"`
+	AssignedTo           *string    `json:"assignedTo"`
+	Cost                 *float64   `json:"cost"`
+	ExpectedCompletionAt *time.Time `json:"expectedCompletionAt"`
+	PhotoURLs            []string   `json:"-"`
+}
+
+// updateMaintenanceTicket changes the fields that were sent and attaches any
+// new photos while the ticket is still open.
+func (r *repository) updateMaintenanceTicket(ctx context.Context, arg updateMaintenanceTicket) (maintenanceTicket, error) {
+	if arg.Description != nil && strings.TrimSpace(*arg.Description) == "" {
+		return maintenanceTicket{}, errInvalidMaintenanceTicket
+	}
+	if arg.Cost != nil && *arg.Cost < 0 {
+		return maintenanceTicket{}, errInvalidMaintenanceTicket
+	}
+
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return maintenanceTicket{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var status maintenanceTicketStatus
+	lockQuery := "SELECT status FROM maintenance_ticket WHERE maintenance_ticket_id = $1 FOR UPDATE"
+	if err := tx.QueryRow(ctx, lockQuery, arg.MaintenanceTicketID).Scan(&status); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	if status != maintenanceTicketOpen {
+		return maintenanceTicket{}, errMaintenanceTicketClosed
+	}
+
+	query := `
+	UPDATE maintenance_ticket
+	SET
+		description = COALESCE($1, description),
+		assigned_to = COALESCE($2, assigned_to),
+		cost = COALESCE($3, cost),
+		expected_completion_at = COALESCE($4, expected_completion_at),
+		updated_at = NOW()
+	WHERE maintenance_ticket_id = $5
+	`
+	if _, err := tx.Exec(
+		ctx,
+		query,
+		arg.Description,
+		arg.AssignedTo,
+		arg.Cost,
+		arg.ExpectedCompletionAt,
+		arg.MaintenanceTicketID,
+	); err != nil {
+		return maintenanceTicket{}, maintenanceTicketError(err)
+	}
+
+	if err := insertMaintenancePhotos(ctx, tx, arg.MaintenanceTicketID, arg.PhotoURLs); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	ticket, err := getMaintenanceTicket(ctx, tx, arg.MaintenanceTicketID)
+	if err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	return ticket, nil
+}
+
+type closeMaintenanceTicket struct {
+	MaintenanceTicketID string `json:"-"`
+
+	// Outcome is either repaired, which puts the unit back to available, or
+	// disposed.
+	Outcome    maintenanceTicketStatus `json:"outcome"`
+	Resolution string                  `json:"resolution"`
+	Cost       *float64                `json:"cost"`
+	ClosedBy   string                  `json:"-"`
+}
+
+func (arg closeMaintenanceTicket) validate() error {
+	if arg.Outcome != maintenanceTicketRepaired && arg.Outcome != maintenanceTicketDisposed {
+		return errInvalidMaintenanceOutcome
+	}
+	if strings.TrimSpace(arg.Resolution) == "" {
+		return errInvalidMaintenanceOutcome
+	}
+	if arg.Cost != nil && *arg.Cost < 0 {
+		return errInvalidMaintenanceOutcome
+	}
+	return nil
+}
+
+// closeMaintenanceTicket records how the repair ended and moves the unit on.
+// A unit that is reserved, lent out or was reallocated by hand in the
+// meantime keeps its status.
+func (r *repository) closeMaintenanceTicket(ctx context.Context, arg closeMaintenanceTicket) (maintenanceTicket, error) {
+	if err := arg.validate(); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return maintenanceTicket{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	lockQuery := `
+	SELECT maintenance_ticket.status, equipment.equipment_id, equipment.site_id
+	FROM maintenance_ticket
+	JOIN equipment ON equipment.equipment_id = maintenance_ticket.equipment_id
+	WHERE maintenance_ticket.maintenance_ticket_id = $1
+	FOR UPDATE
+	`
+
+	var status maintenanceTicketStatus
+	var unitID, siteID string
+	if err := tx.QueryRow(ctx, lockQuery, arg.MaintenanceTicketID).Scan(&status, &unitID, &siteID); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	if status != maintenanceTicketOpen {
+		return maintenanceTicket{}, errMaintenanceTicketClosed
+	}
+
+	if err := checkSiteManager(ctx, tx, arg.ClosedBy, siteID); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	closeQuery := `
+	UPDATE maintenance_ticket
+	SET
+		status = $1,
+		resolution = $2,
+		cost = COALESCE($3, cost),
+		closed_by = $4,
+		closed_at = NOW(),
+		updated_at = NOW()
+	WHERE maintenance_ticket_id = $5
+	`
+	if _, err := tx.Exec(
+		ctx,
+		closeQuery,
+		arg.Outcome,
+		strings.TrimSpace(arg.Resolution),
+		arg.Cost,
+		arg.ClosedBy,
+		arg.MaintenanceTicketID,
+	); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	newStatus := available
+	if arg.Outcome == maintenanceTicketDisposed {
+		newStatus = disposed
+	}
+
+	unitQuery := `
+	UPDATE equipment
+	SET equipment_status_id = $1, updated_at = NOW()
+	WHERE equipment_id = $2 AND equipment_status_id IN ($3, $4, $5)
+	`
+	if _, err := tx.Exec(ctx, unitQuery, newStatus, unitID, available, damaged, maintenance); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	ticket, err := getMaintenanceTicket(ctx, tx, arg.MaintenanceTicketID)
+	if err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	return ticket, nil
+}
+
+type maintenanceMetrics struct {
+	OpenCount     int `json:"openCount"`
+	OverdueCount  int `json:"overdueCount"`
+	RepairedCount int `json:"repairedCount"`
+	DisposedCount int `json:"disposedCount"`
+
+	// AverageRepairHours is the mean time from opening to closing a ticket
+	// as repaired, or nil if nothing has been repaired yet.
+	AverageRepairHours *float64 `json:"averageRepairHours"`
+	TotalCost          float64  `json:"totalCost"`
+}
+
+func (r *repository) getMaintenanceMetrics(ctx context.Context, params getMaintenanceTicketsParams) (maintenanceMetrics, error) {
+	where, args := maintenanceTicketFilters(params)
+
+	query := `
+	SELECT
+		COUNT(*) FILTER (WHERE maintenance_ticket.status = 'open'),
+		COUNT(*) FILTER (
+			WHERE maintenance_ticket.status = 'open' AND maintenance_ticket.expected_completion_at < NOW()
+		),
+		COUNT(*) FILTER (WHERE maintenance_ticket.status = 'repaired'),
+		COUNT(*) FILTER (WHERE maintenance_ticket.status = 'disposed'),
+		(
+			AVG(EXTRACT(EPOCH FROM maintenance_ticket.closed_at - maintenance_ticket.created_at))
+			FILTER (WHERE maintenance_ticket.status = 'repaired') / 3600
+		)::float8,
+		COALESCE(SUM(maintenance_ticket.cost), 0)::float8
+	FROM maintenance_ticket
+	JOIN equipment ON equipment.equipment_id = maintenance_ticket.equipment_id
+	` + where
+
+	var metrics maintenanceMetrics
+	err := r.querier.QueryRow(ctx, query, args...).Scan(
+		&metrics.OpenCount,
+		&metrics.OverdueCount,
+		&metrics.RepairedCount,
+		&metrics.DisposedCount,
+		&metrics.AverageRepairHours,
+		&metrics.TotalCost,
+	)
+	return metrics, err
+}
+
+// uploadMaintenancePhotos stores the photos of a damaged unit, which follow
+// the same rules as equipment images.
+func uploadMaintenancePhotos(headers []*multipart.FileHeader) ([]string, error) {
+	var photoURLs []string
+
+	for _, header := range headers {
+		if header.Size > maxImageSize {
+			return nil, errPhotoTooLarge
+		}
+
+		contentType := header.Header.Get("Content-Type")
+		if contentType != "image/jpeg" && contentType != "image/jpg" && contentType != "image/png" {
+			return nil, fmt.Errorf("%w: %s", errInvalidPhotoType, contentType)
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		uploadedURL, err := api.UploadFile(file, header, "maintenance")
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		photoURLs = append(photoURLs, uploadedURL)
+	}
+
+	return photoURLs, nil
+}
+
+// maintenanceTicketErrorResponse maps the errors shared by the maintenance
+// ticket endpoints.
+func maintenanceTicketErrorResponse(op string, err error) api.Response {
+	if res, ok := siteAccessErrorResponse(op, err); ok {
+		return res
+	}
+
+	if message, ok := scannedUnitErrorMessage(err); ok {
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusBadRequest,
+			Message: message,
+		}
+	}
+
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusNotFound,
+			Message: "Maintenance ticket not found.",
+		}
+	case errors.Is(err, errInvalidMaintenanceTicket):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusBadRequest,
+			Message: "Scan a unit and describe what needs fixing.",
+		}
+	case errors.Is(err, errInvalidMaintenanceOutcome):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusBadRequest,
+			Message: "Close the ticket as repaired or disposed and describe the resolution.",
+		}
+	case errors.Is(err, errInvalidAssignee):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusBadRequest,
+			Message: "Assignee not found.",
+		}
+	case errors.Is(err, errUnitNotInMaintenance):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "Mark the unit as damaged or under maintenance first.",
+		}
+	case errors.Is(err, errMaintenanceTicketExists):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "This unit already has an open maintenance ticket.",
+		}
+	case errors.Is(err, errMaintenanceTicketClosed):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "This maintenance ticket is already closed.",
+		}
+	}
+
+	return api.Response{
+		Error:   fmt.Errorf("%s: %w", op, err),
+		Code:    http.StatusInternalServerError,
+		Message: fmt.Sprintf("Failed to %s.", op),
+	}
+}
+
+// photoUploadErrorResponse maps the errors of uploadMaintenancePhotos.
+func photoUploadErrorResponse(op string, err error) api.Response {
+	switch {
+	case errors.Is(err, errPhotoTooLarge):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusBadRequest,
+			Message: "Photo size must not exceed 5MB.",
+		}
+	case errors.Is(err, errInvalidPhotoType):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusBadRequest,
+			Message: "Photos must be in JPG or PNG format.",
+		}
+	}
+
+	return api.Response{
+		Error:   fmt.Errorf("%s: %w", op, err),
+		Code:    http.StatusInternalServerError,
+		Message: "Failed to upload maintenance photos.",
+	}
+}
+
+func (s *Server) createMaintenanceTicket(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create maintenance ticket: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid maintenance ticket.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("create maintenance ticket", err)
+	}
+
+	data := createMaintenanceTicket{
+		UnitID:      strings.TrimSpace(r.FormValue("unitId")),
+		Description: r.FormValue("description"),
+		OpenedBy:    claims.UserID,
+	}
+
+	if assignedTo := r.FormValue("assignedTo"); assignedTo != "" {
+		data.AssignedTo = &assignedTo
+	}
+
+	if costStr := r.FormValue("cost"); costStr != "" {
+		cost, err := strconv.ParseFloat(costStr, 64)
+		if err != nil {
+			return api.Response{
+				Error:   fmt.Errorf("create maintenance ticket: invalid cost %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid cost value.",
+			}
+		}
+		data.Cost = &cost
+	}
+
+	if completionStr := r.FormValue("expectedCompletionAt"); completionStr != "" {
+		expectedCompletionAt, err := time.Parse(time.RFC3339, completionStr)
+		if err != nil {
+			return api.Response{
+				Error:   fmt.Errorf("create maintenance ticket: invalid expected completion %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid expected completion date format.",
+			}
+		}
+		data.ExpectedCompletionAt = &expectedCompletionAt
+	}
+
+	if err := data.validate(); err != nil {
+		return maintenanceTicketErrorResponse("create maintenance ticket", err)
+	}
+
+	data.PhotoURLs, err = uploadMaintenancePhotos(r.MultipartForm.File["photos"])
+	if err != nil {
+		return photoUploadErrorResponse("create maintenance ticket", err)
+	}
+
+	ticket, err := s.repository.createMaintenanceTicket(ctx, data)
+	if err != nil {
+		return maintenanceTicketErrorResponse("create maintenance ticket", err)
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventMaintenanceTicketCreate,
+		Data:  ticket,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create maintenance ticket: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to create maintenance ticket.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create maintenance ticket: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to create maintenance ticket.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusCreated,
+		Message: "Successfully created maintenance ticket.",
+		Data:    ticket,
+	}
+}
+
+func parseMaintenanceTicketsParams(r *http.Request) getMaintenanceTicketsParams {
+	query := r.URL.Query()
+	status := query.Get("status")
+	assignedTo := query.Get("assignedTo")
+	equipmentTypeID := query.Get("equipmentTypeId")
+	siteID := query.Get("site")
+
+	return getMaintenanceTicketsParams{
+		status:          &status,
+		assignedTo:      &assignedTo,
+		equipmentTypeID: &equipmentTypeID,
+		siteID:          &siteID,
+	}
+}
+
+func (s *Server) getMaintenanceTickets(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	page, err := api.ParsePage(r)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get maintenance tickets: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid limit.",
+		}
+	}
+
+	params := parseMaintenanceTicketsParams(r)
+	params.page = page
+
+	tickets, nextCursor, err := s.repository.getMaintenanceTickets(ctx, params)
+	if err != nil {
+		if errors.Is(err, api.ErrInvalidCursor) {
+			return api.Response{
+				Error:   fmt.Errorf("get maintenance tickets: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid cursor.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("get maintenance tickets: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get maintenance tickets.",
+		}
+	}
+
+	return api.Response{
+		Code:       http.StatusOK,
+		Message:    "Successfully fetched maintenance tickets.",
+		Data:       tickets,
+		NextCursor: nextCursor,
+	}
+}
+
+func (s *Server) getMaintenanceTicket(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	ticket, err := s.repository.getMaintenanceTicket(ctx, r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("get maintenance ticket: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Maintenance ticket not found.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("get maintenance ticket: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get maintenance ticket.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched maintenance ticket.",
+		Data:    ticket,
+	}
+}
+
+func (s *Server) getMaintenanceMetrics(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	params := parseMaintenanceTicketsParams(r)
+	params.status = nil
+
+	metrics, err := s.repository.getMaintenanceMetrics(ctx, params)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get maintenance metrics: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get maintenance metrics.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched maintenance metrics.",
+		Data:    metrics,
+	}
+}
+
+func (s *Server) updateMaintenanceTicket(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data updateMaintenanceTicket
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("update maintenance ticket: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid maintenance ticket.",
+		}
+	}
+	data.MaintenanceTicketID = r.PathValue("id")
+
+	ticket, err := s.repository.updateMaintenanceTicket(ctx, data)
+	if err != nil {
+		return maintenanceTicketErrorResponse("update maintenance ticket", err)
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventMaintenanceTicketUpdate,
+		Data:  ticket,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("update maintenance ticket: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to update maintenance ticket.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("update maintenance ticket: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to update maintenance ticket.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully updated maintenance ticket.",
+		Data:    ticket,
+	}
+}
+
+func (s *Server) addMaintenancePhotos(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("add maintenance photos: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid maintenance photos.",
+		}
+	}
+
+	photoURLs, err := uploadMaintenancePhotos(r.MultipartForm.File["photos"])
+	if err != nil {
+		return photoUploadErrorResponse("add maintenance photos", err)
+	}
+
+	data := updateMaintenanceTicket{
+		MaintenanceTicketID: r.PathValue("id"),
+		PhotoURLs:           photoURLs,
+	}
+
+	ticket, err := s.repository.updateMaintenanceTicket(ctx, data)
+	if err != nil {
+		return maintenanceTicketErrorResponse("add maintenance photos", err)
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully added maintenance photos.",
+		Data:    ticket,
+	}
+}
+
+func (s *Server) closeMaintenanceTicket(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data closeMaintenanceTicket
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("close maintenance ticket: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid maintenance ticket.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("close maintenance ticket", err)
+	}
+	data.MaintenanceTicketID = r.PathValue("id")
+	data.ClosedBy = claims.UserID
+
+	ticket, err := s.repository.closeMaintenanceTicket(ctx, data)
+	if err != nil {
+		return maintenanceTicketErrorResponse("close maintenance ticket", err)
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventMaintenanceTicketClose,
+		Data:  ticket,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("close maintenance ticket: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to close maintenance ticket.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("close maintenance ticket: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to close maintenance ticket.",
+		}
+	}
+
+	if ticket.Status == maintenanceTicketRepaired {
+		go s.offerWaitlistedEquipment(context.WithoutCancel(ctx))
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully closed maintenance ticket.",
+		Data:    ticket,
+	}
+}
@@ -0,0 +1,505 @@
+package equipment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/sse"
+)
+
+var (
+	errBorrowRequestNotEditable    = fmt.Errorf("only pending borrow requests can be edited")
+	errBorrowRequestNotCancellable = fmt.Errorf("only pending or approved borrow requests can be cancelled")
+	errNotBorrowRequestOwner       = fmt.Errorf("borrow request belongs to another borrower")
+)
+
+// lockOwnBorrowRequest locks a borrow request for the borrower who made it
+// and returns its current status.
+func lockOwnBorrowRequest(ctx context.Context, tx pgx.Tx, borrowRequestID, personID string) (borrowRequestStatus, error) {
+	query := `
+	SELECT borrow_request_status_id, requested_by
+	FROM borrow_request
+	WHERE borrow_request_id = $1
+	FOR UPDATE
+	`
+
+	var (
+		status      borrowRequestStatus
+		requestedBy string
+	)
+	if err := tx.QueryRow(ctx, query, borrowRequestID).Scan(&status, &requestedBy); err != nil {
+		return 0, err
+	}
+
+	if requestedBy != personID {
+		return 0, errNotBorrowRequestOwner
+	}
+
+	return status, nil
+}
+
+type editBorrowRequest struct {
+	BorrowRequestID string `json:"id"`
+	createBorrowRequest
+}
+
+// editBorrowRequest replaces the items, site, location, purpose and time
+// window of a pending request. Borrow policies and availability are checked again as
+// if the request was new.
+func (r *repository) editBorrowRequest(ctx context.Context, arg editBorrowRequest) (createBorrowResponse, error) {
+	if len(arg.Equipments) == 0 {
+		return createBorrowResponse{}, errEmptyEquipmentList
+	}
+
+	for _, item := range arg.Equipments {
+		if item.Quantity <= 0 {
+			return createBorrowResponse{}, errInvalidBorrowQuantity
+		}
+	}
+
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return createBorrowResponse{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	status, err := lockOwnBorrowRequest(ctx, tx, arg.BorrowRequestID, arg.RequestedBy)
+	if err != nil {
+		return createBorrowResponse{}, err
+	}
+
+	if status != pending {
+		return createBorrowResponse{}, errBorrowRequestNotEditable
+	}
+
+	siteID, err := resolveSiteID(ctx, tx, arg.SiteID)
+	if err != nil {
+		return createBorrowResponse{}, err
+	}
+
+	if _, err := checkBorrowPolicies(
+		ctx,
+		tx,
+		arg.RequestedBy,
+		arg.Equipments,
+		arg.ExpectedClaimAt,
+		arg.ExpectedReturnAt,
+		arg.BorrowRequestID,
+	); err != nil {
+		return createBorrowResponse{}, err
+	}
+
+	if err := checkAvailability(
+		ctx,
+		tx,
+		siteID,
+		arg.Equipments,
+		arg.ExpectedClaimAt,
+		arg.ExpectedReturnAt,
+		arg.BorrowRequestID,
+	); err != nil {
+		return createBorrowResponse{}, err
+	}
+
+	updateQuery := `
+	UPDATE borrow_request
+	SET location = $1, purpose = $2, expected_claim_at = $3, expected_return_at = $4, site_id = $6, updated_at = NOW()
+	WHERE borrow_request_id = $5
+	`
+	if _, err := tx.Exec(
+		ctx,
+		updateQuery,
+		arg.Location,
+		arg.Purpose,
+		arg.ExpectedClaimAt,
+		arg.ExpectedReturnAt,
+		arg.BorrowRequestID,
+		siteID,
+	); err != nil {
+		return createBorrowResponse{}, err
+	}
+
+	// Pending requests have no units handed out yet, so the items can simply
+	// be replaced.
+	deleteItemsQuery := "DELETE FROM borrow_request_item WHERE borrow_request_id = $1"
+	if _, err := tx.Exec(ctx, deleteItemsQuery, arg.BorrowRequestID); err != nil {
+		return createBorrowResponse{}, err
+	}
+
+	equipmentTypeIDs := make([]string, len(arg.Equipments))
+	quantities := make([]int16, len(arg.Equipments))
+	for i, item := range arg.Equipments {
+		equipmentTypeIDs[i] = item.EquipmentTypeID
+		quantities[i] = int16(item.Quantity)
+	}
+
+	insertItemsQuery := `
+	INSERT INTO borrow_request_item (borrow_request_id, equipment_type_id, quantity)
+	SELECT $1, unnest($2::uuid[]), unnest($3::smallint[])
+	`
+	if _, err := tx.Exec(ctx, insertItemsQuery, arg.BorrowRequestID, equipmentTypeIDs, quantities); err != nil {
+		return createBorrowResponse{}, err
+	}
+
+	query := `
+	SELECT
+		jsonb_build_object(
+			'id', person.person_id,
+			'firstName', person.first_name,
+			'middleName', person.middle_name,
+			'lastName', person.last_name,
+			'avatarUrl', person.avatar_url
+		) AS borrower,
+		jsonb_agg(
+			jsonb_build_object(
+				'id', equipment_type.equipment_type_id,
+				'name', equipment_type.name,
+				'brand', equipment_type.brand,
+				'model', equipment_type.model,
+				'imageUrl', equipment_type.image_url,
+				'quantity', borrow_request_item.quantity
+			)
+		) AS equipments,
+		borrow_request.borrow_request_id,
+		borrow_request.location,
+		borrow_request.purpose,
+		borrow_request.expected_claim_at,
+		borrow_request.expected_return_at,
+		borrow_request.created_at,
+		` + siteJSON("borrow_request.site_id") + ` AS site
+	FROM borrow_request
+	JOIN person ON person.person_id = borrow_request.requested_by
+	JOIN borrow_request_item ON borrow_request_item.borrow_request_id = borrow_request.borrow_request_id
+	JOIN equipment_type ON equipment_type.equipment_type_id = borrow_request_item.equipment_type_id
+	WHERE borrow_request.borrow_request_id = $1
+	GROUP BY borrow_request.borrow_request_id, person.person_id
+	`
+
+	var res createBorrowResponse
+	if err := tx.QueryRow(ctx, query, arg.BorrowRequestID).Scan(
+		&res.Borrower,
+		&res.Equipments,
+		&res.BorrowRequestID,
+		&res.Location,
+		&res.Purpose,
+		&res.ExpectedClaimAt,
+		&res.ExpectedReturnAt,
+		&res.CreatedAt,
+		&res.Site,
+	); err != nil {
+		return createBorrowResponse{}, err
+	}
+	res.Status = pending
+
+	if err := tx.Commit(ctx); err != nil {
+		return createBorrowResponse{}, err
+	}
+
+	return res, nil
+}
+
+type cancelBorrowRequest struct {
+	BorrowRequestID string `json:"id"`
+	RequestedBy     string `json:"requestedBy"`
+}
+
+// cancelBorrowRequest cancels a pending or approved request. Units that
+// were already reserved for it are released right away and its OTP is
+// removed so it can't be claimed anymore.
+func (r *repository) cancelBorrowRequest(ctx context.Context, arg cancelBorrowRequest) (updateBorrowResponse, error) {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return updateBorrowResponse{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	status, err := lockOwnBorrowRequest(ctx, tx, arg.BorrowRequestID, arg.RequestedBy)
+	if err != nil {
+		return updateBorrowResponse{}, err
+	}
+
+	if status != pending && status != approved {
+		return updateBorrowResponse{}, errBorrowRequestNotCancellable
+	}
+
+	itemsToReleaseQuery := `
+	SELECT borrow_request_item.equipment_type_id, borrow_request.site_id, SUM(borrow_request_item.quantity)
+	FROM borrow_request_item
+	JOIN borrow_request USING (borrow_request_id)
+	WHERE borrow_request.borrow_request_id = $1
+		AND borrow_request.reserved_at IS NOT NULL
+	GROUP BY borrow_request_item.equipment_type_id, borrow_request.site_id
+	`
+	if err := releaseReservedUnits(ctx, tx, itemsToReleaseQuery, arg.BorrowRequestID); err != nil {
+		return updateBorrowResponse{}, err
+	}
+
+	deleteOTPQuery := "DELETE FROM borrow_request_otp WHERE borrow_request_id = $1"
+	if _, err := tx.Exec(ctx, deleteOTPQuery, arg.BorrowRequestID); err != nil {
+		return updateBorrowResponse{}, err
+	}
+
+	query := `
+	WITH cancelled_request AS (
+		UPDATE borrow_request
+		SET borrow_request_status_id = $1, cancelled_at = NOW(), updated_at = NOW()
+		WHERE borrow_request_id = $2
+		RETURNING borrow_request_id, borrow_request_status_id
+	)
+	SELECT cancelled_request.borrow_request_id,
+		jsonb_build_object(
+			'id', borrow_request_status.borrow_request_status_id,
+			'code', borrow_request_status.code,
+			'label', borrow_request_status.label
+		) AS status
+	FROM cancelled_request
+	JOIN borrow_request_status USING (borrow_request_status_id)
+	`
+
+	var res updateBorrowResponse
+	if err := tx.QueryRow(ctx, query, cancelled, arg.BorrowRequestID).Scan(
+		&res.BorrowRequestID,
+		&res.Status,
+	); err != nil {
+		return updateBorrowResponse{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return updateBorrowResponse{}, err
+	}
+
+	return res, nil
+}
+
+// ownBorrowRequestErrorResponse maps the errors shared by the endpoints
+// that let borrowers change their own requests.
+func ownBorrowRequestErrorResponse(op string, err error) (api.Response, bool) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusNotFound,
+			Message: "Borrow request not found.",
+		}, true
+	}
+
+	if errors.Is(err, errNotBorrowRequestOwner) {
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusForbidden,
+			Message: "You can only change your own borrow requests.",
+		}, true
+	}
+
+	return api.Response{}, false
+}
+
+func (s *Server) editBorrowRequest(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data editBorrowRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("edit borrow request: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid edit borrow request.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("edit borrow request", err)
+	}
+	data.RequestedBy = claims.UserID
+	data.BorrowRequestID = r.PathValue("id")
+
+	if len(data.Equipments) == 0 {
+		return api.Response{
+			Error:   fmt.Errorf("edit borrow request: at least one equipment is required"),
+			Code:    http.StatusBadRequest,
+			Message: "At least one equipment is required to create a borrow request.",
+		}
+	}
+
+	if strings.TrimSpace(data.Location) == "" {
+		return api.Response{
+			Error:   fmt.Errorf("edit borrow request: location is required"),
+			Code:    http.StatusBadRequest,
+			Message: "Location is required.",
+		}
+	}
+
+	if strings.TrimSpace(data.Purpose) == "" {
+		return api.Response{
+			Error:   fmt.Errorf("edit borrow request: purpose is required"),
+			Code:    http.StatusBadRequest,
+			Message: "Purpose is required.",
+		}
+	}
+
+	if !data.ExpectedReturnAt.After(data.ExpectedClaimAt) {
+		return api.Response{
+			Error:   fmt.Errorf("edit borrow request: expected return time must be after claim time"),
+			Code:    http.StatusBadRequest,
+			Message: "Expected return time must be after claim time.",
+		}
+	}
+
+	if res, suspended := s.checkSuspension(ctx, "edit borrow request", data.RequestedBy); suspended {
+		return res
+	}
+
+	res, err := s.repository.editBorrowRequest(ctx, data)
+	if err != nil {
+		if res, ok := siteAccessErrorResponse("edit borrow request", err); ok {
+			return res
+		}
+
+		if res, ok := ownBorrowRequestErrorResponse("edit borrow request", err); ok {
+			return res
+		}
+
+		if errors.Is(err, errBorrowRequestNotEditable) {
+			return api.Response{
+				Error:   fmt.Errorf("edit borrow request: %w", err),
+				Code:    http.StatusConflict,
+				Message: "Only pending borrow requests can be edited.",
+			}
+		}
+
+		if errors.Is(err, errInvalidBorrowQuantity) {
+			return api.Response{
+				Error:   fmt.Errorf("edit borrow request: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Borrow quantity must be greater than zero.",
+			}
+		}
+
+		if errors.Is(err, errInsufficientEquipmentQuantity) {
+			return api.Response{
+				Error:   fmt.Errorf("edit borrow request: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Requested quantity exceeds available equipment.",
+			}
+		}
+
+		if res, ok := policyViolationResponse("edit borrow request", err); ok {
+			return res
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("edit borrow request: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to edit borrow request.",
+		}
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventBorrowRequestEdit,
+		Data:  res,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("edit borrow request: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to edit borrow request.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("edit borrow request: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to edit borrow request.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully edited borrow request.",
+		Data:    res,
+	}
+}
+
+func (s *Server) cancelBorrowRequest(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data cancelBorrowRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("cancel borrow request: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid cancel borrow request.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("cancel borrow request", err)
+	}
+	data.RequestedBy = claims.UserID
+	data.BorrowRequestID = r.PathValue("id")
+
+	res, err := s.repository.cancelBorrowRequest(ctx, data)
+	if err != nil {
+		if res, ok := ownBorrowRequestErrorResponse("cancel borrow request", err); ok {
+			return res
+		}
+
+		if errors.Is(err, errBorrowRequestNotCancellable) {
+			return api.Response{
+				Error:   fmt.Errorf("cancel borrow request: %w", err),
+				Code:    http.StatusConflict,
+				Message: "Only pending or approved borrow requests can be cancelled.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("cancel borrow request: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to cancel borrow request.",
+		}
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventBorrowRequestCancel,
+		Data:  res,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("cancel borrow request: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to cancel borrow request.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("cancel borrow request: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to cancel borrow request.",
+		}
+	}
+
+	// The released units may cover someone on the waitlist
+	go s.offerWaitlistedEquipment(context.WithoutCancel(ctx))
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully cancelled borrow request.",
+		Data:    res,
+	}
+}
@@ -26,6 +26,8 @@ func generateRandomOTP(length int, prefix string) string {
 }
 
 func (s *Server) StartExpirationWorker(ctx context.Context) {
+	reminderCfg := loadOverdueReminderConfig()
+
 	ticker := time.NewTicker(1 * time.Minute)
 	go func() {
 		for range ticker.C {
@@ -33,9 +35,23 @@ func (s *Server) StartExpirationWorker(ctx context.Context) {
 				slog.Error("Error processing expired borrow requests: " + err.Error())
 			}
 
+			s.offerWaitlistedEquipment(ctx)
+
 			if err := s.repository.renewExpiredReturnRequests(ctx); err != nil {
 				slog.Error("Error renewing expired return requests: " + err.Error())
 			}
+
+			if err := s.repository.reserveUpcomingBorrowRequests(ctx); err != nil {
+				slog.Error("Error reserving upcoming borrow requests: " + err.Error())
+			}
+
+			if err := s.processOverdueBorrowRequests(ctx, reminderCfg); err != nil {
+				slog.Error("Error processing overdue borrow requests: " + err.Error())
+			}
+
+			if err := s.processMaintenanceSchedules(ctx); err != nil {
+				slog.Error("Error processing maintenance schedules: " + err.Error())
+			}
 		}
 	}()
 	slog.Info("Started expiration worker.")
@@ -43,25 +59,37 @@ func (s *Server) StartExpirationWorker(ctx context.Context) {
 
 // OTP for Borrow Requests
 
-func (r *repository) createBorrowRequestWithOTP(ctx context.Context, borrowRequestID string) error {
+func (r *repository) createBorrowRequestWithOTP(ctx context.Context, borrowRequestID string, tx pgx.Tx) error {
 	maxRetries := 5
 
+	// Requests booked ahead of time keep their OTP until 30 minutes past the
+	// expected claim time instead of 30 minutes after approval.
 	query := `
 	INSERT INTO borrow_request_otp (borrow_request_id, code, expires_at)
-	VALUES ($1, $2, $3)
+	SELECT borrow_request_id, $2, GREATEST(NOW(), expected_claim_at) + $3::interval
+	FROM borrow_request
+	WHERE borrow_request_id = $1
 	`
 
 	for range maxRetries {
 		otp := generateRandomOTP(6, borrowPrefix)
 
-		_, err := r.querier.Exec(
+		// Each attempt runs in a savepoint so a collision doesn't abort the
+		// transaction that approved the request.
+		savepoint, err := tx.Begin(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = savepoint.Exec(
 			ctx,
 			query,
 			borrowRequestID,
 			otp,
-			time.Now().Add(30*time.Minute),
+			30*time.Minute,
 		)
 		if err != nil {
+			savepoint.Rollback(ctx)
 			if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
 				slog.Debug("Borrow OTP collision detected, retrying generation.")
 				continue
@@ -69,30 +97,17 @@ func (r *repository) createBorrowRequestWithOTP(ctx context.Context, borrowReque
 			return err
 		}
 
-		return nil
+		return savepoint.Commit(ctx)
 	}
 
 	return fmt.Errorf("failed to generate unique OTP after retries")
 }
 
-func (r *repository) processExpiredBorrowRequests(ctx context.Context) error {
-	tx, err := r.querier.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
-
-	// We sum up the quantity for each equipment type belonging to all currently expired requests.
-	itemsToReleaseQuery := `
-    SELECT bri.equipment_type_id, SUM(bri.quantity)
-    FROM borrow_request_item bri
-    JOIN borrow_request br ON bri.borrow_request_id = br.borrow_request_id
-    JOIN borrow_request_otp otp ON br.borrow_request_id = otp.borrow_request_id
-    WHERE br.borrow_request_status_id = $1 AND otp.expires_at < NOW()
-    GROUP BY bri.equipment_type_id
-    `
-
-	rows, err := tx.Query(ctx, itemsToReleaseQuery, approved)
+// releaseReservedUnits puts reserved units back to available. The query
+// must select the equipment type, the site of the request and the quantity
+// to release for each pair, so only units at that site are freed.
+func releaseReservedUnits(ctx context.Context, tx pgx.Tx, itemsQuery string, args ...any) error {
+	rows, err := tx.Query(ctx, itemsQuery, args...)
 	if err != nil {
 		return err
 	}
@@ -100,13 +115,14 @@ func (r *repository) processExpiredBorrowRequests(ctx context.Context) error {
 
 	type releaseItem struct {
 		equipmentTypeID string
+		siteID          string
 		quantity        int
 	}
 
 	var items []releaseItem
 	for rows.Next() {
 		var item releaseItem
-		if err := rows.Scan(&item.equipmentTypeID, &item.quantity); err != nil {
+		if err := rows.Scan(&item.equipmentTypeID, &item.siteID, &item.quantity); err != nil {
 			return err
 		}
 		items = append(items, item)
@@ -119,32 +135,65 @@ func (r *repository) processExpiredBorrowRequests(ctx context.Context) error {
     WHERE equipment_id IN (
         SELECT equipment_id
         FROM equipment
-        WHERE equipment_type_id = $2 AND equipment_status_id = $3
-        ORDER BY equipment_id
+        WHERE equipment_type_id = $2 AND equipment_status_id = $3 AND site_id = $5
+        ORDER BY asset_tag
         LIMIT $4
     )
     `
 
 	for _, item := range items {
-		_, err := tx.Exec(ctx, releaseEquipmentQuery, available, item.equipmentTypeID, reserved, item.quantity)
+		_, err := tx.Exec(ctx, releaseEquipmentQuery, available, item.equipmentTypeID, reserved, item.quantity, item.siteID)
 		if err != nil {
 			return err
 		}
 	}
 
+	return rows.Err()
+}
+
+func (r *repository) processExpiredBorrowRequests(ctx context.Context) error {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// We sum up the quantity for each equipment type and site belonging to all currently expired requests.
+	itemsToReleaseQuery := `
+    SELECT bri.equipment_type_id, br.site_id, SUM(bri.quantity)
+    FROM borrow_request_item bri
+    JOIN borrow_request br ON bri.borrow_request_id = br.borrow_request_id
+    JOIN borrow_request_otp otp ON br.borrow_request_id = otp.borrow_request_id
+    WHERE br.borrow_request_status_id = $1 AND otp.expires_at < NOW()
+    AND br.reserved_at IS NOT NULL
+    GROUP BY bri.equipment_type_id, br.site_id
+    `
+
+	if err := releaseReservedUnits(ctx, tx, itemsToReleaseQuery, approved); err != nil {
+		return err
+	}
+
+	// Borrowers who never showed up to claim their request get a strike.
 	updateRequestQuery := `
     WITH expired_ids AS (
         SELECT borrow_request.borrow_request_id
         FROM borrow_request
         JOIN borrow_request_otp USING (borrow_request_id)
         WHERE borrow_request_status_id = $1 AND borrow_request_otp.expires_at < NOW()
+    ),
+    updated_requests AS (
+        UPDATE borrow_request
+        SET borrow_request_status_id = $2
+        WHERE borrow_request_id IN (SELECT borrow_request_id FROM expired_ids)
+        RETURNING borrow_request_id, requested_by
     )
-    UPDATE borrow_request
-    SET borrow_request_status_id = $2
-    WHERE borrow_request_id IN (SELECT borrow_request_id FROM expired_ids)
+    INSERT INTO borrower_strike (person_id, borrow_request_id, reason)
+    SELECT requested_by, borrow_request_id, $3
+    FROM updated_requests
+    ON CONFLICT DO NOTHING
     `
 
-	if _, err := tx.Exec(ctx, updateRequestQuery, approved, unclaimed); err != nil {
+	if _, err := tx.Exec(ctx, updateRequestQuery, approved, unclaimed, strikeUnclaimed); err != nil {
 		return err
 	}
 
@@ -0,0 +1,440 @@
+package equipment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/sse"
+	"github.com/xGihyun/hirami/user"
+)
+
+const (
+	defaultReminderBefore   = 1 * time.Hour
+	defaultReminderInterval = 24 * time.Hour
+)
+
+// Reminder stages. Reminders sent after the due time are numbered, e.g.
+// "overdue-2" is the second reminder after the request became overdue.
+const (
+	reminderStageBefore  = "before"
+	reminderStageDue     = "due"
+	reminderStageOverdue = "overdue-%d"
+)
+
+type overdueReminderConfig struct {
+	// How long before the expected return time the first reminder is sent.
+	before time.Duration
+
+	// How often reminders are repeated once the request is overdue.
+	interval time.Duration
+}
+
+// loadOverdueReminderConfig reads the reminder schedule from
+// OVERDUE_REMINDER_BEFORE and OVERDUE_REMINDER_INTERVAL, e.g. "1h" and "24h".
+func loadOverdueReminderConfig() overdueReminderConfig {
+	cfg := overdueReminderConfig{
+		before:   defaultReminderBefore,
+		interval: defaultReminderInterval,
+	}
+
+	if v := os.Getenv("OVERDUE_REMINDER_BEFORE"); v != "" {
+		before, err := time.ParseDuration(v)
+		if err != nil || before < 0 {
+			slog.Warn("Invalid OVERDUE_REMINDER_BEFORE, using default: " + v)
+		} else {
+			cfg.before = before
+		}
+	}
+
+	if v := os.Getenv("OVERDUE_REMINDER_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval <= 0 {
+			slog.Warn("Invalid OVERDUE_REMINDER_INTERVAL, using default: " + v)
+		} else {
+			cfg.interval = interval
+		}
+	}
+
+	return cfg
+}
+
+// reminderStage returns which reminder is due for a request at the given
+// time, or false if it is still too early for one.
+func (cfg overdueReminderConfig) reminderStage(expectedReturnAt, now time.Time) (string, bool) {
+	if now.Before(expectedReturnAt) {
+		if now.Before(expectedReturnAt.Add(-cfg.before)) {
+			return "", false
+		}
+		return reminderStageBefore, true
+	}
+
+	n := int(now.Sub(expectedReturnAt) / cfg.interval)
+	if n == 0 {
+		return reminderStageDue, true
+	}
+
+	return fmt.Sprintf(reminderStageOverdue, n), true
+}
+
+type overdueBorrowRequest struct {
+	BorrowRequestID  string         `json:"id"`
+	Borrower         user.BasicInfo `json:"borrower"`
+	Equipments       []equipment    `json:"equipments"`
+	Location         string         `json:"location"`
+	Purpose          string         `json:"purpose"`
+	ExpectedReturnAt time.Time      `json:"expectedReturnAt"`
+	OverdueAt        *time.Time     `json:"overdueAt"`
+	RemindersSent    int            `json:"remindersSent"`
+	LastRemindedAt   *time.Time     `json:"lastRemindedAt"`
+
+	email string
+}
+
+// overdueBorrowRequestSelect lists claimed requests along with the units
+// that haven't been returned yet. $1 must be the claimed status.
+const overdueBorrowRequestSelect = `
+SELECT
+	borrow_request.borrow_request_id,
+	jsonb_build_object(
+		'id', person.person_id,
+		'firstName', person.first_name,
+		'middleName', person.middle_name,
+		'lastName', person.last_name,
+		'avatarUrl', person.avatar_url
+	) AS borrower,
+	COALESCE((
+		SELECT jsonb_agg(
+			jsonb_build_object(
+				'id', equipment_type.equipment_type_id,
+				'name', equipment_type.name,
+				'brand', equipment_type.brand,
+				'model', equipment_type.model,
+				'imageUrl', equipment_type.image_url,
+				'quantity', outstanding.quantity
+			)
+			ORDER BY equipment_type.name
+		)
+		FROM (
+			SELECT borrow_request_item.equipment_type_id, COUNT(*) AS quantity
+			FROM borrow_request_item
+			JOIN borrow_transaction USING (borrow_request_item_id)
+			WHERE borrow_request_item.borrow_request_id = borrow_request.borrow_request_id
+			AND NOT EXISTS (
+				SELECT 1
+				FROM return_transaction
+				WHERE return_transaction.borrow_transaction_id = borrow_transaction.borrow_transaction_id
+			)
+			GROUP BY borrow_request_item.equipment_type_id
+		) outstanding
+		JOIN equipment_type ON equipment_type.equipment_type_id = outstanding.equipment_type_id
+	), '[]'::jsonb) AS equipments,
+	borrow_request.location,
+	borrow_request.purpose,
+	borrow_request.expected_return_at,
+	borrow_request.overdue_at,
+	(
+		SELECT COUNT(*)::int
+		FROM borrow_request_reminder
+		WHERE borrow_request_reminder.borrow_request_id = borrow_request.borrow_request_id
+	) AS reminders_sent,
+	(
+		SELECT MAX(borrow_request_reminder.created_at)
+		FROM borrow_request_reminder
+		WHERE borrow_request_reminder.borrow_request_id = borrow_request.borrow_request_id
+	) AS last_reminded_at,
+	person.email
+FROM borrow_request
+JOIN person ON person.person_id = borrow_request.requested_by
+WHERE borrow_request.borrow_request_status_id = $1
+`
+
+func collectOverdueBorrowRequests(rows pgx.Rows) ([]overdueBorrowRequest, error) {
+	defer rows.Close()
+
+	borrowRequests := []overdueBorrowRequest{}
+	for rows.Next() {
+		var b overdueBorrowRequest
+		if err := rows.Scan(
+			&b.BorrowRequestID,
+			&b.Borrower,
+			&b.Equipments,
+			&b.Location,
+			&b.Purpose,
+			&b.ExpectedReturnAt,
+			&b.OverdueAt,
+			&b.RemindersSent,
+			&b.LastRemindedAt,
+			&b.email,
+		); err != nil {
+			return nil, err
+		}
+		borrowRequests = append(borrowRequests, b)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return borrowRequests, nil
+}
+
+// flagOverdueBorrowRequests marks claimed requests that are past their
+// expected return time and returns the ones that were just flagged.
+func (r *repository) flagOverdueBorrowRequests(ctx context.Context) ([]overdueBorrowRequest, error) {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	flagQuery := `
+	UPDATE borrow_request
+	SET overdue_at = NOW()
+	WHERE borrow_request_status_id = $1
+	AND expected_return_at < NOW()
+	AND overdue_at IS NULL
+	RETURNING borrow_request_id
+	`
+
+	rows, err := tx.Query(ctx, flagQuery, claimed)
+	if err != nil {
+		return nil, err
+	}
+
+	borrowRequestIDs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(borrowRequestIDs) == 0 {
+		return nil, nil
+	}
+
+	rows, err = tx.Query(
+		ctx,
+		overdueBorrowRequestSelect+" AND borrow_request.borrow_request_id = ANY($2)",
+		claimed,
+		borrowRequestIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	borrowRequests, err := collectOverdueBorrowRequests(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return borrowRequests, nil
+}
+
+func (r *repository) getOverdueBorrowRequests(ctx context.Context, page api.Page) ([]overdueBorrowRequest, *string, error) {
+	query := overdueBorrowRequestSelect + " AND borrow_request.overdue_at IS NOT NULL"
+
+	args := []any{claimed}
+	argIdx := len(args) + 1
+
+	if page.Cursor != "" {
+		var cursorExpectedReturnAt time.Time
+		var cursorID string
+		if err := api.DecodeCursor(page.Cursor, &cursorExpectedReturnAt, &cursorID); err != nil {
+			return nil, nil, err
+		}
+
+		query += fmt.Sprintf(" AND (borrow_request.expected_return_at, borrow_request.borrow_request_id) > ($%d, $%d)", argIdx, argIdx+1)
+		args = append(args, cursorExpectedReturnAt, cursorID)
+		argIdx += 2
+	}
+
+	query += " ORDER BY borrow_request.expected_return_at, borrow_request.borrow_request_id"
+
+	if page.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", argIdx)
+		args = append(args, page.Limit+1)
+		argIdx++
+	}
+
+	rows, err := r.querier.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	borrowRequests, err := collectOverdueBorrowRequests(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return api.NextPage(borrowRequests, page, func(b overdueBorrowRequest) []any {
+		return []any{b.ExpectedReturnAt, b.BorrowRequestID}
+	})
+}
+
+// getBorrowRequestsDueBy returns claimed requests that are expected back at
+// or before the given time, including the ones that are already overdue.
+func (r *repository) getBorrowRequestsDueBy(ctx context.Context, dueBy time.Time) ([]overdueBorrowRequest, error) {
+	query := overdueBorrowRequestSelect + `
+	AND borrow_request.expected_return_at <= $2
+	ORDER BY borrow_request.expected_return_at
+	`
+
+	rows, err := r.querier.Query(ctx, query, claimed, dueBy)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectOverdueBorrowRequests(rows)
+}
+
+// createBorrowRequestReminder records that a reminder stage was sent. It
+// returns false if that stage had already been sent before.
+func (r *repository) createBorrowRequestReminder(ctx context.Context, borrowRequestID, stage string) (bool, error) {
+	query := `
+	INSERT INTO borrow_request_reminder (borrow_request_id, stage)
+	VALUES ($1, $2)
+	ON CONFLICT (borrow_request_id, stage) DO NOTHING
+	`
+
+	tag, err := r.querier.Exec(ctx, query, borrowRequestID, stage)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// processOverdueBorrowRequests flags late requests for the managers and
+// emails borrowers according to the reminder schedule.
+func (s *Server) processOverdueBorrowRequests(ctx context.Context, cfg overdueReminderConfig) error {
+	flagged, err := s.repository.flagOverdueBorrowRequests(ctx)
+	if err != nil {
+		return fmt.Errorf("flag overdue borrow requests: %w", err)
+	}
+
+	for _, b := range flagged {
+		eventRes := sse.EventResponse{
+			Event: eventBorrowRequestOverdue,
+			Data:  b,
+		}
+		jsonData, err := json.Marshal(eventRes)
+		if err != nil {
+			return fmt.Errorf("flag overdue borrow requests: %w", err)
+		}
+
+		pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+		if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+			slog.Error("Error publishing overdue borrow request: " + res.Error().Error())
+		}
+	}
+
+	now := time.Now()
+	borrowRequests, err := s.repository.getBorrowRequestsDueBy(ctx, now.Add(cfg.before))
+	if err != nil {
+		return fmt.Errorf("get borrow requests due: %w", err)
+	}
+
+	for _, b := range borrowRequests {
+		stage, ok := cfg.reminderStage(b.ExpectedReturnAt, now)
+		if !ok {
+			continue
+		}
+
+		// The stage is recorded before sending so a failed email isn't
+		// retried every minute.
+		created, err := s.repository.createBorrowRequestReminder(ctx, b.BorrowRequestID, stage)
+		if err != nil {
+			return fmt.Errorf("create borrow request reminder: %w", err)
+		}
+		if !created {
+			continue
+		}
+
+		if err := s.sendReturnReminder(b, stage, now); err != nil {
+			slog.Error("Error sending return reminder: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) sendReturnReminder(b overdueBorrowRequest, stage string, now time.Time) error {
+	var items []string
+	for _, e := range b.Equipments {
+		items = append(items, fmt.Sprintf("%s (x%d)", e.Name, e.Quantity))
+	}
+
+	dueAt := b.ExpectedReturnAt.Format("Jan 2, 2006 3:04 PM")
+
+	var subject, heading, message string
+	switch stage {
+	case reminderStageBefore:
+		subject = "Your Borrowed Equipment Is Due Soon"
+		heading = "Return Reminder"
+		message = fmt.Sprintf("Please return %s by %s.", strings.Join(items, ", "), dueAt)
+	case reminderStageDue:
+		subject = "Your Borrowed Equipment Is Due"
+		heading = "Equipment Due"
+		message = fmt.Sprintf("%s was due back at %s. Please return it as soon as possible.", strings.Join(items, ", "), dueAt)
+	default:
+		subject = "Your Borrowed Equipment Is Overdue"
+		heading = "Equipment Overdue"
+		message = fmt.Sprintf(
+			"%s was due back at %s and is now %s late. Please return it as soon as possible.",
+			strings.Join(items, ", "),
+			dueAt,
+			now.Sub(b.ExpectedReturnAt).Round(time.Hour),
+		)
+	}
+
+	fullName := fmt.Sprintf("%s %s", b.Borrower.FirstName, b.Borrower.LastName)
+	return s.sendNotificationEmail(b.email, subject, fullName, heading, message)
+}
+
+func (s *Server) getOverdueBorrowRequests(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	page, err := api.ParsePage(r)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get overdue borrow requests: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid limit.",
+		}
+	}
+
+	borrowRequests, nextCursor, err := s.repository.getOverdueBorrowRequests(ctx, page)
+	if err != nil {
+		if errors.Is(err, api.ErrInvalidCursor) {
+			return api.Response{
+				Error:   fmt.Errorf("get overdue borrow requests: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid cursor.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("get overdue borrow requests: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get overdue borrow requests.",
+		}
+	}
+
+	return api.Response{
+		Code:       http.StatusOK,
+		Message:    "Successfully fetched overdue borrow requests.",
+		Data:       borrowRequests,
+		NextCursor: nextCursor,
+	}
+}
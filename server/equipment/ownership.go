@@ -0,0 +1,48 @@
+package equipment
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/middleware"
+	"github.com/xGihyun/hirami/user"
+)
+
+var errMissingClaims = fmt.Errorf("missing user claims")
+
+// userClaims returns the signed in user. Identities like the borrower or the
+// reviewer always come from here instead of the request body.
+func userClaims(r *http.Request) (*middleware.UserClaims, error) {
+	claims, ok := middleware.GetClaims(r.Context())
+	if !ok {
+		return nil, errMissingClaims
+	}
+
+	return claims, nil
+}
+
+func unauthorizedResponse(op string, err error) api.Response {
+	return api.Response{
+		Error:   fmt.Errorf("%s: %w", op, err),
+		Code:    http.StatusUnauthorized,
+		Message: "Unauthorized.",
+	}
+}
+
+// canAccessUser reports whether the signed in user can see another user's
+// borrowing data. Borrowers can only see their own.
+func canAccessUser(claims *middleware.UserClaims, userID string) bool {
+	return claims.Can(user.PermissionBorrowView) || claims.UserID == userID
+}
+
+// scopedUserID limits listings to the signed in user's own records unless
+// their role grants the permission, in which case they can filter by any
+// user, or none at all.
+func scopedUserID(claims *middleware.UserClaims, permission user.Permission, userID string) string {
+	if claims.Can(permission) {
+		return userID
+	}
+
+	return claims.UserID
+}
@@ -0,0 +1,675 @@
+package equipment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/xGihyun/hirami/api"
+)
+
+// systemReviewerEmail identifies the person recorded as the reviewer of
+// requests that are approved without a manager.
+const systemReviewerEmail = "system@hirami.local"
+
+var (
+	errPolicyViolation     = fmt.Errorf("borrow policy violated")
+	errInvalidBorrowPolicy = fmt.Errorf("invalid borrow policy")
+	errBorrowPolicyExists  = fmt.Errorf("borrow policy already exists")
+	errBorrowPolicyTarget  = fmt.Errorf("borrow policy target not found")
+)
+
+// policyViolationError names the policy a borrow request broke so the
+// borrower knows which rule to follow.
+type policyViolationError struct {
+	policy  string
+	message string
+}
+
+func (e *policyViolationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.policy, e.message)
+}
+
+func (e *policyViolationError) Unwrap() error {
+	return errPolicyViolation
+}
+
+type borrowPolicy struct {
+	BorrowPolicyID     string    `json:"id"`
+	CreatedAt          time.Time `json:"createdAt"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+	Name               string    `json:"name"`
+	EquipmentTypeID    *string   `json:"equipmentTypeId"`
+	CategoryID         *string   `json:"categoryId"`
+	MaxQuantity        *int      `json:"maxQuantity"`
+	LeadTimeMinutes    int       `json:"leadTimeMinutes"`
+	MinLoanMinutes     int       `json:"minLoanMinutes"`
+	MaxLoanMinutes     *int      `json:"maxLoanMinutes"`
+	MaxConcurrentLoans *int      `json:"maxConcurrentLoans"`
+	RequiresApproval   bool      `json:"requiresApproval"`
+}
+
+// defaultBorrowPolicy applies when no policy matches at all, e.g. if the
+// global policy was deleted.
+var defaultBorrowPolicy = borrowPolicy{
+	Name:             "Default",
+	LeadTimeMinutes:  60,
+	MinLoanMinutes:   60,
+	RequiresApproval: true,
+}
+
+const borrowPolicyColumns = `
+	borrow_policy.borrow_policy_id,
+	borrow_policy.created_at,
+	borrow_policy.updated_at,
+	borrow_policy.name,
+	borrow_policy.equipment_type_id,
+	borrow_policy.category_id,
+	borrow_policy.max_quantity,
+	borrow_policy.lead_time_minutes,
+	borrow_policy.min_loan_minutes,
+	borrow_policy.max_loan_minutes,
+	borrow_policy.max_concurrent_loans,
+	borrow_policy.requires_approval
+`
+
+func borrowPolicyFields(p *borrowPolicy) []any {
+	return []any{
+		&p.BorrowPolicyID,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+		&p.Name,
+		&p.EquipmentTypeID,
+		&p.CategoryID,
+		&p.MaxQuantity,
+		&p.LeadTimeMinutes,
+		&p.MinLoanMinutes,
+		&p.MaxLoanMinutes,
+		&p.MaxConcurrentLoans,
+		&p.RequiresApproval,
+	}
+}
+
+// formatMinutes turns a policy duration into something a borrower can read,
+// e.g. "1 hour" or "90 minutes".
+func formatMinutes(minutes int) string {
+	unit := "minute"
+	value := minutes
+	switch {
+	case minutes >= 24*60 && minutes%(24*60) == 0:
+		unit, value = "day", minutes/(24*60)
+	case minutes >= 60 && minutes%60 == 0:
+		unit, value = "hour", minutes/60
+	}
+
+	if value != 1 {
+		unit += "s"
+	}
+
+	return fmt.Sprintf("%d %s", value, unit)
+}
+
+// getApplicablePolicies returns the policies that govern each requested
+// equipment type. A policy for the type itself wins over category policies,
+// which in turn win over the global policy. An equipment type in several
+// categories is governed by all of their policies.
+func getApplicablePolicies(ctx context.Context, q dbQuerier, equipmentTypeIDs []string) (map[string][]borrowPolicy, error) {
+	query := `
+	SELECT
+		requested.equipment_type_id,
+		CASE
+			WHEN borrow_policy.equipment_type_id IS NOT NULL THEN 1
+			WHEN borrow_policy.category_id IS NOT NULL THEN 2
+			ELSE 3
+		END AS scope,
+	` + borrowPolicyColumns + `
+	FROM unnest($1::uuid[]) AS requested(equipment_type_id)
+	JOIN borrow_policy ON borrow_policy.equipment_type_id = requested.equipment_type_id
+		OR borrow_policy.category_id IN (
+			SELECT category_id
+			FROM equipment_type_category
+			WHERE equipment_type_category.equipment_type_id = requested.equipment_type_id
+		)
+		OR (borrow_policy.equipment_type_id IS NULL AND borrow_policy.category_id IS NULL)
+	ORDER BY requested.equipment_type_id, scope, borrow_policy.name
+	`
+
+	rows, err := q.Query(ctx, query, equipmentTypeIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := make(map[string][]borrowPolicy)
+	scopes := make(map[string]int)
+	for rows.Next() {
+		var (
+			equipmentTypeID string
+			scope           int
+			policy          borrowPolicy
+		)
+		fields := append([]any{&equipmentTypeID, &scope}, borrowPolicyFields(&policy)...)
+		if err := rows.Scan(fields...); err != nil {
+			return nil, err
+		}
+
+		// Rows are ordered by scope, so only keep the most specific ones.
+		if best, ok := scopes[equipmentTypeID]; ok && scope > best {
+			continue
+		}
+		scopes[equipmentTypeID] = scope
+		policies[equipmentTypeID] = append(policies[equipmentTypeID], policy)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, equipmentTypeID := range equipmentTypeIDs {
+		if len(policies[equipmentTypeID]) == 0 {
+			policies[equipmentTypeID] = []borrowPolicy{defaultBorrowPolicy}
+		}
+	}
+
+	return policies, nil
+}
+
+// countActiveLoans counts the borrower's pending, approved and claimed
+// requests that fall under the policy.
+func countActiveLoans(ctx context.Context, q dbQuerier, personID string, policy borrowPolicy, excludeBorrowRequestID string) (int, error) {
+	query := `
+	SELECT COUNT(DISTINCT borrow_request.borrow_request_id)
+	FROM borrow_request
+	JOIN borrow_request_item USING (borrow_request_id)
+	WHERE borrow_request.requested_by = $1
+	AND borrow_request.borrow_request_status_id IN ($2, $3, $4)
+	AND borrow_request.borrow_request_id::text <> $5
+	AND (
+		($6::uuid IS NULL AND $7::uuid IS NULL)
+		OR borrow_request_item.equipment_type_id = $6
+		OR borrow_request_item.equipment_type_id IN (
+			SELECT equipment_type_id
+			FROM equipment_type_category
+			WHERE category_id = $7
+		)
+	)
+	`
+
+	var count int
+	if err := q.QueryRow(
+		ctx,
+		query,
+		personID,
+		pending,
+		approved,
+		claimed,
+		excludeBorrowRequestID,
+		policy.EquipmentTypeID,
+		policy.CategoryID,
+	).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// checkBorrowPolicies validates a borrow request against every policy that
+// governs its items and reports whether any of them requires a manager to
+// approve it.
+func checkBorrowPolicies(
+	ctx context.Context,
+	q dbQuerier,
+	personID string,
+	items []borrowEquipmentItem,
+	claimAt, returnAt time.Time,
+	excludeBorrowRequestID string,
+) (bool, error) {
+	equipmentTypeIDs := make([]string, len(items))
+	for i, item := range items {
+		equipmentTypeIDs[i] = item.EquipmentTypeID
+	}
+
+	applicable, err := getApplicablePolicies(ctx, q, equipmentTypeIDs)
+	if err != nil {
+		return false, err
+	}
+
+	// The same policy can cover several items, e.g. a category policy, so
+	// quantities are added up per policy.
+	var policies []borrowPolicy
+	quantities := make(map[string]int)
+	for _, item := range items {
+		for _, policy := range applicable[item.EquipmentTypeID] {
+			if _, ok := quantities[policy.BorrowPolicyID]; !ok {
+				policies = append(policies, policy)
+			}
+			quantities[policy.BorrowPolicyID] += int(item.Quantity)
+		}
+	}
+
+	now := time.Now()
+	loan := returnAt.Sub(claimAt)
+	requiresApproval := false
+
+	for _, policy := range policies {
+		violation := func(format string, args ...any) error {
+			return &policyViolationError{policy: policy.Name, message: fmt.Sprintf(format, args...)}
+		}
+
+		if claimAt.Before(now.Add(time.Duration(policy.LeadTimeMinutes) * time.Minute)) {
+			return false, violation("Expected claim time must be at least %s from now.", formatMinutes(policy.LeadTimeMinutes))
+		}
+
+		if loan < time.Duration(policy.MinLoanMinutes)*time.Minute {
+			return false, violation("Expected return time must be at least %s after claim time.", formatMinutes(policy.MinLoanMinutes))
+		}
+
+		if policy.MaxLoanMinutes != nil && loan > time.Duration(*policy.MaxLoanMinutes)*time.Minute {
+			return false, violation("Equipment can be borrowed for at most %s.", formatMinutes(*policy.MaxLoanMinutes))
+		}
+
+		if policy.MaxQuantity != nil && quantities[policy.BorrowPolicyID] > *policy.MaxQuantity {
+			return false, violation("At most %d unit(s) can be borrowed per request.", *policy.MaxQuantity)
+		}
+
+		if policy.MaxConcurrentLoans != nil {
+			count, err := countActiveLoans(ctx, q, personID, policy, excludeBorrowRequestID)
+			if err != nil {
+				return false, err
+			}
+
+			if count >= *policy.MaxConcurrentLoans {
+				return false, violation("You can only have %d active request(s) at a time.", *policy.MaxConcurrentLoans)
+			}
+		}
+
+		requiresApproval = requiresApproval || policy.RequiresApproval
+	}
+
+	return requiresApproval, nil
+}
+
+// checkLoanPolicies makes sure a loan that has already been claimed, e.g.
+// one being extended, isn't longer than its policies allow. The other rules
+// only matter when the request is filed.
+func checkLoanPolicies(ctx context.Context, q dbQuerier, items []borrowEquipmentItem, claimedAt, returnAt time.Time) error {
+	equipmentTypeIDs := make([]string, len(items))
+	for i, item := range items {
+		equipmentTypeIDs[i] = item.EquipmentTypeID
+	}
+
+	applicable, err := getApplicablePolicies(ctx, q, equipmentTypeIDs)
+	if err != nil {
+		return err
+	}
+
+	loan := returnAt.Sub(claimedAt)
+	for _, equipmentTypeID := range equipmentTypeIDs {
+		for _, policy := range applicable[equipmentTypeID] {
+			if policy.MaxLoanMinutes != nil && loan > time.Duration(*policy.MaxLoanMinutes)*time.Minute {
+				return &policyViolationError{
+					policy:  policy.Name,
+					message: fmt.Sprintf("Equipment can be borrowed for at most %s.", formatMinutes(*policy.MaxLoanMinutes)),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// approveBorrowRequest approves a request on behalf of the system reviewer.
+// Like a manager's approval, units are reserved if the claim time is close
+// and the borrow OTP is generated.
+func (r *repository) approveBorrowRequest(ctx context.Context, tx pgx.Tx, borrowRequestID string, remarks string) error {
+	items, claimAt, returnAt, err := lockBorrowRequestItems(ctx, tx, borrowRequestID)
+	if err != nil {
+		return err
+	}
+
+	siteID, err := getBorrowRequestSiteID(ctx, tx, borrowRequestID)
+	if err != nil {
+		return err
+	}
+
+	if err := checkAvailability(ctx, tx, siteID, items, claimAt, returnAt, borrowRequestID); err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE borrow_request
+	SET
+		borrow_request_status_id = $1,
+		reviewed_by = (SELECT person_id FROM person WHERE email = $2),
+		remarks = $3,
+		reviewed_at = NOW()
+	WHERE borrow_request_id = $4
+	`
+	if _, err := tx.Exec(ctx, query, approved, systemReviewerEmail, remarks, borrowRequestID); err != nil {
+		return err
+	}
+
+	if claimAt.Before(time.Now().Add(reservationLeadTime)) {
+		if err := reserveBorrowRequestUnits(ctx, tx, borrowRequestID); err != nil {
+			return err
+		}
+	}
+
+	return r.createBorrowRequestWithOTP(ctx, borrowRequestID, tx)
+}
+
+type borrowPolicyRequest struct {
+	BorrowPolicyID     string  `json:"id"`
+	Name               string  `json:"name"`
+	EquipmentTypeID    *string `json:"equipmentTypeId"`
+	CategoryID         *string `json:"categoryId"`
+	MaxQuantity        *int    `json:"maxQuantity"`
+	LeadTimeMinutes    int     `json:"leadTimeMinutes"`
+	MinLoanMinutes     int     `json:"minLoanMinutes"`
+	MaxLoanMinutes     *int    `json:"maxLoanMinutes"`
+	MaxConcurrentLoans *int    `json:"maxConcurrentLoans"`
+	RequiresApproval   bool    `json:"requiresApproval"`
+}
+
+func (arg borrowPolicyRequest) validate() error {
+	switch {
+	case strings.TrimSpace(arg.Name) == "":
+		return fmt.Errorf("%w: name is required", errInvalidBorrowPolicy)
+	case arg.EquipmentTypeID != nil && arg.CategoryID != nil:
+		return fmt.Errorf("%w: policy can't target both an equipment and a category", errInvalidBorrowPolicy)
+	case arg.LeadTimeMinutes < 0 || arg.MinLoanMinutes < 0:
+		return fmt.Errorf("%w: durations can't be negative", errInvalidBorrowPolicy)
+	case arg.MaxLoanMinutes != nil && *arg.MaxLoanMinutes < max(arg.MinLoanMinutes, 1):
+		return fmt.Errorf("%w: max loan duration is shorter than min loan duration", errInvalidBorrowPolicy)
+	case arg.MaxQuantity != nil && *arg.MaxQuantity < 1:
+		return fmt.Errorf("%w: max quantity must be greater than zero", errInvalidBorrowPolicy)
+	case arg.MaxConcurrentLoans != nil && *arg.MaxConcurrentLoans < 1:
+		return fmt.Errorf("%w: max concurrent loans must be greater than zero", errInvalidBorrowPolicy)
+	}
+
+	return nil
+}
+
+// borrowPolicyError maps constraint violations to the errors the handlers
+// know how to report.
+func borrowPolicyError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			return errBorrowPolicyExists
+		case "23503":
+			return errBorrowPolicyTarget
+		}
+	}
+	return err
+}
+
+func (r *repository) getBorrowPolicies(ctx context.Context) ([]borrowPolicy, error) {
+	query := `SELECT ` + borrowPolicyColumns + `
+	FROM borrow_policy
+	ORDER BY
+		borrow_policy.equipment_type_id IS NULL,
+		borrow_policy.category_id IS NULL,
+		borrow_policy.name
+	`
+
+	rows, err := r.querier.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []borrowPolicy{}
+	for rows.Next() {
+		var policy borrowPolicy
+		if err := rows.Scan(borrowPolicyFields(&policy)...); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+func (r *repository) createBorrowPolicy(ctx context.Context, arg borrowPolicyRequest) (borrowPolicy, error) {
+	query := `
+	INSERT INTO borrow_policy (
+		name,
+		equipment_type_id,
+		category_id,
+		max_quantity,
+		lead_time_minutes,
+		min_loan_minutes,
+		max_loan_minutes,
+		max_concurrent_loans,
+		requires_approval
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING ` + borrowPolicyColumns
+
+	var policy borrowPolicy
+	if err := r.querier.QueryRow(
+		ctx,
+		query,
+		arg.Name,
+		arg.EquipmentTypeID,
+		arg.CategoryID,
+		arg.MaxQuantity,
+		arg.LeadTimeMinutes,
+		arg.MinLoanMinutes,
+		arg.MaxLoanMinutes,
+		arg.MaxConcurrentLoans,
+		arg.RequiresApproval,
+	).Scan(borrowPolicyFields(&policy)...); err != nil {
+		return borrowPolicy{}, borrowPolicyError(err)
+	}
+
+	return policy, nil
+}
+
+func (r *repository) updateBorrowPolicy(ctx context.Context, arg borrowPolicyRequest) (borrowPolicy, error) {
+	query := `
+	UPDATE borrow_policy
+	SET
+		updated_at = NOW(),
+		name = $2,
+		equipment_type_id = $3,
+		category_id = $4,
+		max_quantity = $5,
+		lead_time_minutes = $6,
+		min_loan_minutes = $7,
+		max_loan_minutes = $8,
+		max_concurrent_loans = $9,
+		requires_approval = $10
+	WHERE borrow_policy_id = $1
+	RETURNING ` + borrowPolicyColumns
+
+	var policy borrowPolicy
+	if err := r.querier.QueryRow(
+		ctx,
+		query,
+		arg.BorrowPolicyID,
+		arg.Name,
+		arg.EquipmentTypeID,
+		arg.CategoryID,
+		arg.MaxQuantity,
+		arg.LeadTimeMinutes,
+		arg.MinLoanMinutes,
+		arg.MaxLoanMinutes,
+		arg.MaxConcurrentLoans,
+		arg.RequiresApproval,
+	).Scan(borrowPolicyFields(&policy)...); err != nil {
+		return borrowPolicy{}, borrowPolicyError(err)
+	}
+
+	return policy, nil
+}
+
+func (r *repository) deleteBorrowPolicy(ctx context.Context, id string) error {
+	tag, err := r.querier.Exec(ctx, "DELETE FROM borrow_policy WHERE borrow_policy_id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// policyViolationResponse reports a broken borrow policy as a bad request.
+func policyViolationResponse(op string, err error) (api.Response, bool) {
+	var violation *policyViolationError
+	if !errors.As(err, &violation) {
+		return api.Response{}, false
+	}
+
+	return api.Response{
+		Error:   fmt.Errorf("%s: %w", op, err),
+		Code:    http.StatusBadRequest,
+		Message: fmt.Sprintf("%s policy: %s", violation.policy, violation.message),
+	}, true
+}
+
+func (s *Server) getBorrowPolicies(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	policies, err := s.repository.getBorrowPolicies(ctx)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get borrow policies: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get borrow policies.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched borrow policies.",
+		Data:    policies,
+	}
+}
+
+func (s *Server) createBorrowPolicy(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data borrowPolicyRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create borrow policy: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid create borrow policy request.",
+		}
+	}
+
+	if err := data.validate(); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create borrow policy: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid borrow policy.",
+		}
+	}
+
+	policy, err := s.repository.createBorrowPolicy(ctx, data)
+	if err != nil {
+		return borrowPolicyErrorResponse("create borrow policy", err)
+	}
+
+	return api.Response{
+		Code:    http.StatusCreated,
+		Message: "Successfully created borrow policy.",
+		Data:    policy,
+	}
+}
+
+func (s *Server) updateBorrowPolicy(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data borrowPolicyRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("update borrow policy: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid update borrow policy request.",
+		}
+	}
+
+	data.BorrowPolicyID = r.PathValue("id")
+
+	if err := data.validate(); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("update borrow policy: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid borrow policy.",
+		}
+	}
+
+	policy, err := s.repository.updateBorrowPolicy(ctx, data)
+	if err != nil {
+		return borrowPolicyErrorResponse("update borrow policy", err)
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully updated borrow policy.",
+		Data:    policy,
+	}
+}
+
+func (s *Server) deleteBorrowPolicy(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	if err := s.repository.deleteBorrowPolicy(ctx, r.PathValue("id")); err != nil {
+		return borrowPolicyErrorResponse("delete borrow policy", err)
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully deleted borrow policy.",
+	}
+}
+
+func borrowPolicyErrorResponse(op string, err error) api.Response {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusNotFound,
+			Message: "Borrow policy not found.",
+		}
+	case errors.Is(err, errBorrowPolicyExists):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "A borrow policy already exists for this equipment or category.",
+		}
+	case errors.Is(err, errBorrowPolicyTarget):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusNotFound,
+			Message: "Equipment or category not found.",
+		}
+	default:
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusInternalServerError,
+			Message: fmt.Sprintf("Failed to %s.", op),
+		}
+	}
+}
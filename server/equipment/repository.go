@@ -3,6 +3,7 @@ package equipment
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -14,40 +15,105 @@ import (
 
 type Repository interface {
 	createEquipment(ctx context.Context, arg createRequest) (createResponse, error)
-	getAll(ctx context.Context, params getEquipmentParams) ([]equipmentWithBorrower, error)
+	getAll(ctx context.Context, params getEquipmentParams) ([]equipmentWithBorrower, *string, error)
 	getByID(ctx context.Context, id string) (equipmentWithBorrower, error)
 	getEquipmentTypeByID(ctx context.Context, id string) (equipmentInventoryStatus, error)
 	getEquipmentNames(ctx context.Context) ([]string, error)
 	update(ctx context.Context, arg updateRequest) error
 	reallocate(ctx context.Context, arg reallocateRequest) error
-	increaseQuantity(ctx context.Context, id string, quantity uint, acquisitionDate time.Time) error
+	increaseQuantity(ctx context.Context, id string, quantity uint, acquisitionDate time.Time, siteID string) error
 	deleteEquipment(ctx context.Context, id string, quantity *uint) error
 
-	createBorrowRequest(ctx context.Context, arg createBorrowRequest) (createBorrowResponse, error)
+	getUnits(ctx context.Context, params getUnitParams) ([]equipmentUnit, error)
+	getUnit(ctx context.Context, equipmentTypeID, unitID string) (equipmentUnit, error)
+	updateUnit(ctx context.Context, arg updateUnitRequest) (string, error)
+	getUnitLabels(ctx context.Context, params unitLabelParams) ([]unitLabel, error)
+
+	createBorrowRequest(ctx context.Context, arg createBorrowRequest, rules autoApprovalRules) (createBorrowResponse, error)
+	editBorrowRequest(ctx context.Context, arg editBorrowRequest) (createBorrowResponse, error)
+	cancelBorrowRequest(ctx context.Context, arg cancelBorrowRequest) (updateBorrowResponse, error)
+	createBorrowRequestExtension(ctx context.Context, arg createBorrowRequestExtension) (borrowRequestExtension, error)
+	getBorrowRequestExtensions(ctx context.Context, params getBorrowRequestExtensionsParams) ([]borrowRequestExtension, error)
+	reviewBorrowRequestExtension(ctx context.Context, arg reviewBorrowRequestExtension) (borrowRequestExtension, error)
 	reviewBorrowRequest(ctx context.Context, arg reviewBorrowRequest) (reviewBorrowResponse, error)
-	getBorrowRequests(ctx context.Context) ([]borrowRequest, error)
+	getBorrowRequests(ctx context.Context, params getBorrowRequestsParams) ([]borrowRequest, *string, error)
 	getBorrowRequestByID(ctx context.Context, id string) (borrowRequest, error)
 	getBorrowRequestByOTP(ctx context.Context, otp string) (borrowRequest, error)
 	updateBorrowRequest(ctx context.Context, arg updateBorrowRequest) (updateBorrowResponse, error)
+	claimBorrowRequest(ctx context.Context, arg claimBorrowRequest) (claimBorrowResponse, error)
+	getOverdueBorrowRequests(ctx context.Context, page api.Page) ([]overdueBorrowRequest, *string, error)
 
 	createReturnRequest(ctx context.Context, arg createReturnRequest) (createReturnResponse, error)
 	confirmReturnRequest(ctx context.Context, arg confirmReturnRequest) (confirmReturnRequest, error)
-	getReturnRequests(ctx context.Context, params getReturnRequestParams) ([]returnRequest, error)
+	getReturnRequests(ctx context.Context, params getReturnRequestParams) ([]returnRequest, *string, error)
 	getReturnRequestByID(ctx context.Context, id string) (returnRequest, error)
 	getReturnRequestByOTP(ctx context.Context, otp string) (returnRequest, error)
 
-	getBorrowHistory(ctx context.Context, params borrowHistoryParams) ([]borrowRequest, error)
+	getBorrowHistory(ctx context.Context, params borrowHistoryParams) ([]borrowRequest, *string, error)
+	getAvailability(ctx context.Context, params getAvailabilityParams) (equipmentAvailability, error)
+	reserveUpcomingBorrowRequests(ctx context.Context) error
+	exportBorrowHistory(ctx context.Context, params borrowHistoryParams, fn func(borrowHistoryExportRow) error) error
 	getBorrowedItems(ctx context.Context, params borrowedItemParams) ([]borrowRequestItem, error)
 
-	createCategory(ctx context.Context, name string, backgroundColor, foregroundColor *string) (categoryDetail, error)
-	updateCategory(ctx context.Context, id string, name string, backgroundColor, foregroundColor *string) (categoryDetail, error)
+	getBorrowPolicies(ctx context.Context) ([]borrowPolicy, error)
+	createBorrowPolicy(ctx context.Context, arg borrowPolicyRequest) (borrowPolicy, error)
+	updateBorrowPolicy(ctx context.Context, arg borrowPolicyRequest) (borrowPolicy, error)
+	deleteBorrowPolicy(ctx context.Context, id string) error
+
+	joinWaitlist(ctx context.Context, arg joinWaitlistRequest) (waitlistEntry, error)
+	getWaitlist(ctx context.Context, params getWaitlistParams) ([]waitlistEntry, error)
+	moveWaitlistEntry(ctx context.Context, arg moveWaitlistEntryRequest) error
+	deleteWaitlistEntry(ctx context.Context, waitlistEntryID string) error
+	clearWaitlist(ctx context.Context, equipmentTypeID string) (int64, error)
+	processWaitlist(ctx context.Context) ([]waitlistOffer, error)
+
+	getStrikes(ctx context.Context, personID string) ([]borrowerStrike, error)
+	getActiveStrikeTimes(ctx context.Context, personID string, since time.Time) ([]time.Time, error)
+	waiveStrike(ctx context.Context, arg waiveStrikeRequest) error
+
+	createCategory(ctx context.Context, name string, backgroundColor, foregroundColor *string, isSelfService bool) (categoryDetail, error)
+	updateCategory(ctx context.Context, id string, name string, backgroundColor, foregroundColor *string, isSelfService bool) (categoryDetail, error)
 	getCategories(ctx context.Context) ([]categoryDetail, error)
 	deleteCategory(ctx context.Context, id string) error
 
 	createAnomalyResult(ctx context.Context, arg anomaly) error
+	getBorrowFeatures(ctx context.Context, borrowRequestID string, location *time.Location) (borrowFeatures, []borrowFeatures, error)
+	getAnomalyCalibration(ctx context.Context, detector anomalyDetector, since time.Time) (anomalyCalibration, error)
+	getFlaggedBorrowRequests(ctx context.Context, params getFlaggedBorrowRequestsParams) ([]flaggedBorrowRequest, *string, error)
+	reviewAnomaly(ctx context.Context, arg reviewAnomaly) (flaggedBorrowRequest, error)
+	getUtilization(ctx context.Context, params getUtilizationParams) (utilizationReport, error)
+	getForecastData(ctx context.Context, params getForecastParams, historyStart time.Time) ([]forecastEquipment, map[string][]demandInterval, error)
+
+	getSites(ctx context.Context) ([]site, error)
+	saveSite(ctx context.Context, arg siteRequest) (site, error)
+	deleteSite(ctx context.Context, siteID string) error
+	assignSiteManager(ctx context.Context, siteID, personID string) error
+	removeSiteManager(ctx context.Context, siteID, personID string) error
+	transferUnits(ctx context.Context, arg transferRequest) (siteTransfer, error)
+	createSiteTransfer(ctx context.Context, arg createSiteTransfer) (siteTransfer, error)
+	getSiteTransfers(ctx context.Context, params getSiteTransfersParams) ([]siteTransfer, *string, error)
+	getSiteTransfer(ctx context.Context, id string) (siteTransfer, error)
+	dispatchSiteTransfer(ctx context.Context, arg dispatchSiteTransfer) (siteTransfer, error)
+	receiveSiteTransfer(ctx context.Context, arg receiveSiteTransfer) (siteTransfer, error)
+	cancelSiteTransfer(ctx context.Context, arg cancelSiteTransfer) (siteTransfer, error)
+
+	createMaintenanceTicket(ctx context.Context, arg createMaintenanceTicket) (maintenanceTicket, error)
+	getMaintenanceTickets(ctx context.Context, params getMaintenanceTicketsParams) ([]maintenanceTicket, *string, error)
+	getMaintenanceTicket(ctx context.Context, id string) (maintenanceTicket, error)
+	updateMaintenanceTicket(ctx context.Context, arg updateMaintenanceTicket) (maintenanceTicket, error)
+	closeMaintenanceTicket(ctx context.Context, arg closeMaintenanceTicket) (maintenanceTicket, error)
+	getMaintenanceMetrics(ctx context.Context, params getMaintenanceTicketsParams) (maintenanceMetrics, error)
+	getMaintenanceSchedules(ctx context.Context, equipmentTypeID string) ([]maintenanceSchedule, error)
+	createMaintenanceSchedule(ctx context.Context, arg maintenanceScheduleRequest) (maintenanceSchedule, error)
+	updateMaintenanceSchedule(ctx context.Context, arg maintenanceScheduleRequest) (maintenanceSchedule, error)
+	deleteMaintenanceSchedule(ctx context.Context, id string) error
 
 	processExpiredBorrowRequests(ctx context.Context) error
 	renewExpiredReturnRequests(ctx context.Context) error
+	flagOverdueBorrowRequests(ctx context.Context) ([]overdueBorrowRequest, error)
+	getBorrowRequestsDueBy(ctx context.Context, dueBy time.Time) ([]overdueBorrowRequest, error)
+	createBorrowRequestReminder(ctx context.Context, borrowRequestID, stage string) (bool, error)
+	processMaintenanceSchedules(ctx context.Context) ([]maintenanceTicket, error)
 }
 
 type categoryDetail struct {
@@ -55,6 +121,7 @@ type categoryDetail struct {
 	Name            string  `json:"name"`
 	BackgroundColor *string `json:"backgroundColor"`
 	ForegroundColor *string `json:"foregroundColor"`
+	IsSelfService   bool    `json:"isSelfService"`
 }
 
 type repository struct {
@@ -75,6 +142,7 @@ type createRequest struct {
 	AcquisitionDate time.Time `json:"acquisitionDate"`
 	Quantity        uint      `json:"quantity"`
 	CategoryIDs     []string  `json:"categoryIds"`
+	SiteID          string    `json:"siteId"`
 }
 
 type createResponse struct {
@@ -86,6 +154,7 @@ type createResponse struct {
 	AcquisitionDate time.Time        `json:"acquisitionDate"`
 	Quantity        uint             `json:"quantity"`
 	Categories      []categoryDetail `json:"categories"`
+	Site            siteDetail       `json:"site"`
 }
 
 var ErrEquipmentAlreadyExists = fmt.Errorf("equipment already exists")
@@ -114,13 +183,28 @@ func (r *repository) createEquipment(ctx context.Context, arg createRequest) (cr
 		return createResponse{}, ErrEquipmentAlreadyExists
 	}
 
+	siteID, err := resolveSiteID(ctx, tx, arg.SiteID)
+	if err != nil {
+		return createResponse{}, err
+	}
+
+	var equipment createResponse
+	siteQuery := "SELECT site_id, name, building, room FROM site WHERE site_id = $1"
+	if err := tx.QueryRow(ctx, siteQuery, siteID).Scan(
+		&equipment.Site.SiteID,
+		&equipment.Site.Name,
+		&equipment.Site.Building,
+		&equipment.Site.Room,
+	); err != nil {
+		return createResponse{}, err
+	}
+
 	query := `
 	INSERT INTO equipment_type (name, brand, model, image_url)
 	VALUES ($1, $2, $3, $4)
 	RETURNING equipment_type_id, name, brand, model, image_url
 	`
 
-	var equipment createResponse
 	row := tx.QueryRow(ctx, query, arg.Name, arg.Brand, arg.Model, arg.ImageURL)
 	if err := row.Scan(
 		&equipment.EquipmentTypeID,
@@ -146,7 +230,7 @@ func (r *repository) createEquipment(ctx context.Context, arg createRequest) (cr
 
 		// Fetch linked categories for response
 		fetchCategoriesQuery := `
-		SELECT category_id, name, background_color, foreground_color
+		SELECT category_id, name, background_color, foreground_color, is_self_service
 		FROM category
 		JOIN equipment_type_category USING (category_id)
 		WHERE equipment_type_id = $1
@@ -158,7 +242,7 @@ func (r *repository) createEquipment(ctx context.Context, arg createRequest) (cr
 		defer rows.Close()
 		for rows.Next() {
 			var cat categoryDetail
-			if err := rows.Scan(&cat.CategoryID, &cat.Name, &cat.BackgroundColor, &cat.ForegroundColor); err != nil {
+			if err := rows.Scan(&cat.CategoryID, &cat.Name, &cat.BackgroundColor, &cat.ForegroundColor, &cat.IsSelfService); err != nil {
 				return createResponse{}, err
 			}
 			equipment.Categories = append(equipment.Categories, cat)
@@ -166,8 +250,8 @@ func (r *repository) createEquipment(ctx context.Context, arg createRequest) (cr
 	}
 
 	query = `
-	INSERT INTO equipment (equipment_type_id, acquired_at, equipment_status_id)
-	SELECT $1, $2, $3
+	INSERT INTO equipment (equipment_type_id, acquired_at, equipment_status_id, site_id)
+	SELECT $1, $2, $3, $5
 	FROM generate_series(1, $4)
 	`
 	if _, err := tx.Exec(
@@ -177,6 +261,7 @@ func (r *repository) createEquipment(ctx context.Context, arg createRequest) (cr
 		arg.AcquisitionDate,
 		available,
 		arg.Quantity,
+		siteID,
 	); err != nil {
 		return createResponse{}, err
 	}
@@ -221,9 +306,12 @@ type getEquipmentParams struct {
 	name   *string
 	status *string
 	search *string
+	siteID *string
+
+	page api.Page
 }
 
-func (r *repository) getAll(ctx context.Context, params getEquipmentParams) ([]equipmentWithBorrower, error) {
+func (r *repository) getAll(ctx context.Context, params getEquipmentParams) ([]equipmentWithBorrower, *string, error) {
 	query := `
 	SELECT 
 		jsonb_build_object(
@@ -253,7 +341,8 @@ func (r *repository) getAll(ctx context.Context, params getEquipmentParams) ([]e
 			'id', category.category_id,
 			'name', category.name,
 			'backgroundColor', category.background_color,
-			'foregroundColor', category.foreground_color
+			'foregroundColor', category.foreground_color,
+			'isSelfService', category.is_self_service
 		)) AS categories
 		FROM category
 		JOIN equipment_type_category USING (category_id)
@@ -345,6 +434,29 @@ func (r *repository) getAll(ctx context.Context, params getEquipmentParams) ([]e
 		argIdx++
 	}
 
+	if params.siteID != nil && *params.siteID != "" {
+		query += fmt.Sprintf(" AND equipment.site_id::text = $%d", argIdx)
+		args = append(args, *params.siteID)
+		argIdx++
+	}
+
+	if params.page.Cursor != "" {
+		var cursorName, cursorID string
+		var cursorStatus int
+		if err := api.DecodeCursor(params.page.Cursor, &cursorName, &cursorID, &cursorStatus); err != nil {
+			return nil, nil, err
+		}
+
+		query += fmt.Sprintf(
+			" AND (equipment_type.name, equipment_type.equipment_type_id, equipment_status.equipment_status_id) > ($%d, $%d, $%d)",
+			argIdx,
+			argIdx+1,
+			argIdx+2,
+		)
+		args = append(args, cursorName, cursorID, cursorStatus)
+		argIdx += 3
+	}
+
 	query += ` 
 	GROUP BY 
 		equipment_type.equipment_type_id,
@@ -357,17 +469,30 @@ func (r *repository) getAll(ctx context.Context, params getEquipmentParams) ([]e
 		equipment_status.label,
 		active_borrow_request.borrowers,
 		categories_agg.categories
+	ORDER BY
+		equipment_type.name,
+		equipment_type.equipment_type_id,
+		equipment_status.equipment_status_id
 	`
 
+	if params.page.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", argIdx)
+		args = append(args, params.page.Limit+1)
+		argIdx++
+	}
+
 	rows, err := r.querier.Query(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	equipments, err := pgx.CollectRows(rows, pgx.RowToStructByName[equipmentWithBorrower])
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return equipments, nil
+
+	return api.NextPage(equipments, params.page, func(e equipmentWithBorrower) []any {
+		return []any{e.Equipment.Name, e.Equipment.EquipmentTypeID, e.Equipment.Status.ID}
+	})
 }
 
 func (r *repository) getByID(ctx context.Context, id string) (equipmentWithBorrower, error) {
@@ -396,7 +521,8 @@ func (r *repository) getByID(ctx context.Context, id string) (equipmentWithBorro
 			'id', category.category_id,
 			'name', category.name,
 			'backgroundColor', category.background_color,
-			'foregroundColor', category.foreground_color
+			'foregroundColor', category.foreground_color,
+			'isSelfService', category.is_self_service
 		)) AS categories
 		FROM category
 		JOIN equipment_type_category USING (category_id)
@@ -525,7 +651,8 @@ func (r *repository) getEquipmentTypeByID(ctx context.Context, id string) (equip
 					'id', c.category_id,
 					'name', c.name,
 					'backgroundColor', c.background_color,
-					'foregroundColor', c.foreground_color
+					'foregroundColor', c.foreground_color,
+					'isSelfService', c.is_self_service
 				)
 			) AS categories
 		FROM equipment_type_category etc
@@ -674,6 +801,10 @@ type reallocateRequest struct {
 	Quantity        int             `json:"quantity"`
 	OldStatus       equipmentStatus `json:"oldStatus"`
 	NewStatus       equipmentStatus `json:"newStatus"`
+
+	// UnitIDs optionally pins the exact units (by ID or asset tag) to move,
+	// e.g. the one ball that came back damaged.
+	UnitIDs []string `json:"unitIds"`
 }
 
 func (r *repository) reallocate(ctx context.Context, arg reallocateRequest) error {
@@ -687,11 +818,26 @@ func (r *repository) reallocate(ctx context.Context, arg reallocateRequest) erro
     SELECT equipment_id
     FROM equipment
     WHERE equipment_type_id = $1 AND equipment_status_id = $2
+    ORDER BY asset_tag
     LIMIT $3
     FOR UPDATE
     `
+	args := []any{arg.EquipmentTypeID, arg.OldStatus, arg.Quantity}
+
+	if len(arg.UnitIDs) > 0 {
+		selectQuery = `
+		SELECT equipment_id
+		FROM equipment
+		WHERE equipment_type_id = $1 AND equipment_status_id = $2
+		AND (equipment_id::text = ANY($3) OR asset_tag = ANY($3))
+		ORDER BY asset_tag
+		FOR UPDATE
+		`
+		args = []any{arg.EquipmentTypeID, arg.OldStatus, arg.UnitIDs}
+		arg.Quantity = len(arg.UnitIDs)
+	}
 
-	rows, err := tx.Query(ctx, selectQuery, arg.EquipmentTypeID, arg.OldStatus, arg.Quantity)
+	rows, err := tx.Query(ctx, selectQuery, args...)
 	if err != nil {
 		return err
 	}
@@ -744,6 +890,10 @@ type createBorrowRequest struct {
 	ExpectedClaimAt  time.Time             `json:"expectedClaimAt"`
 	ExpectedReturnAt time.Time             `json:"expectedReturnAt"`
 	RequestedBy      string                `json:"requestedBy"`
+
+	// SiteID is where the borrower will claim the equipment. Empty means the
+	// default site.
+	SiteID string `json:"siteId"`
 }
 
 type borrowRequestItem struct {
@@ -760,6 +910,12 @@ type createBorrowResponse struct {
 	Purpose          string         `json:"purpose"`
 	ExpectedClaimAt  time.Time      `json:"expectedClaimAt"`
 	ExpectedReturnAt time.Time      `json:"expectedReturnAt"`
+	Site             siteDetail     `json:"site"`
+
+	// Status is approved instead of pending when one of the auto-approval
+	// rules applies, with the rule explained in the remarks.
+	Status  borrowRequestStatus `json:"status"`
+	Remarks *string             `json:"remarks"`
 }
 
 var (
@@ -768,7 +924,7 @@ var (
 	errEmptyEquipmentList            = fmt.Errorf("equipments list cannot be empty")
 )
 
-func (r *repository) createBorrowRequest(ctx context.Context, arg createBorrowRequest) (createBorrowResponse, error) {
+func (r *repository) createBorrowRequest(ctx context.Context, arg createBorrowRequest, rules autoApprovalRules) (createBorrowResponse, error) {
 	if len(arg.Equipments) == 0 {
 		return createBorrowResponse{}, errEmptyEquipmentList
 	}
@@ -780,32 +936,49 @@ func (r *repository) createBorrowRequest(ctx context.Context, arg createBorrowRe
 		}
 	}
 
-	// Check availability for all equipment types
-	availabilityQuery := `
-	SELECT COUNT(equipment_id) AS available_quantity
-	FROM equipment
-	WHERE equipment_type_id = $1 AND equipment_status_id = $2
-	`
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return createBorrowResponse{}, err
+	}
+	defer tx.Rollback(ctx)
 
-	for _, item := range arg.Equipments {
-		var availableQuantity uint
+	siteID, err := resolveSiteID(ctx, tx, arg.SiteID)
+	if err != nil {
+		return createBorrowResponse{}, err
+	}
 
-		row := r.querier.QueryRow(ctx, availabilityQuery, item.EquipmentTypeID, available)
-		if err := row.Scan(&availableQuantity); err != nil {
-			return createBorrowResponse{}, err
-		}
+	requiresApproval, err := checkBorrowPolicies(
+		ctx,
+		tx,
+		arg.RequestedBy,
+		arg.Equipments,
+		arg.ExpectedClaimAt,
+		arg.ExpectedReturnAt,
+		"",
+	)
+	if err != nil {
+		return createBorrowResponse{}, err
+	}
 
-		if item.Quantity > availableQuantity {
-			return createBorrowResponse{}, errInsufficientEquipmentQuantity
-		}
+	// Check availability for all equipment types over the requested window
+	if err := checkAvailability(
+		ctx,
+		tx,
+		siteID,
+		arg.Equipments,
+		arg.ExpectedClaimAt,
+		arg.ExpectedReturnAt,
+		"",
+	); err != nil {
+		return createBorrowResponse{}, err
 	}
 
 	// Insert multiple borrow requests (one per equipment type)
 	query := `
 	WITH inserted_request AS (
-		INSERT INTO borrow_request (location, purpose, expected_claim_at, expected_return_at, requested_by)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING borrow_request_id, location, purpose, expected_claim_at, expected_return_at, requested_by, created_at
+		INSERT INTO borrow_request (location, purpose, expected_claim_at, expected_return_at, requested_by, site_id)
+		VALUES ($1, $2, $3, $4, $5, $8)
+		RETURNING borrow_request_id, location, purpose, expected_claim_at, expected_return_at, requested_by, created_at, site_id
 	),
 	inserted_items AS (
 		INSERT INTO borrow_request_item 
@@ -840,7 +1013,8 @@ func (r *repository) createBorrowRequest(ctx context.Context, arg createBorrowRe
 		inserted_request.purpose,
 		inserted_request.expected_claim_at,
 		inserted_request.expected_return_at,
-		inserted_request.created_at
+		inserted_request.created_at,
+		` + siteJSON("inserted_request.site_id") + ` AS site
 	FROM inserted_request
 	JOIN person ON person.person_id = inserted_request.requested_by
 	JOIN inserted_items ON inserted_items.borrow_request_id = inserted_request.borrow_request_id
@@ -856,7 +1030,8 @@ func (r *repository) createBorrowRequest(ctx context.Context, arg createBorrowRe
 		inserted_request.purpose,
 		inserted_request.expected_claim_at,
 		inserted_request.expected_return_at,
-		inserted_request.created_at
+		inserted_request.created_at,
+		inserted_request.site_id
 	`
 
 	// Prepare arrays for PostgreSQL
@@ -866,7 +1041,7 @@ func (r *repository) createBorrowRequest(ctx context.Context, arg createBorrowRe
 		equipmentTypeIDs[i] = item.EquipmentTypeID
 		quantities[i] = int16(item.Quantity)
 	}
-	row := r.querier.QueryRow(
+	row := tx.QueryRow(
 		ctx,
 		query,
 		arg.Location,
@@ -876,6 +1051,7 @@ func (r *repository) createBorrowRequest(ctx context.Context, arg createBorrowRe
 		arg.RequestedBy,
 		equipmentTypeIDs,
 		quantities,
+		siteID,
 	)
 	var res createBorrowResponse
 	if err := row.Scan(
@@ -887,9 +1063,29 @@ func (r *repository) createBorrowRequest(ctx context.Context, arg createBorrowRe
 		&res.ExpectedClaimAt,
 		&res.ExpectedReturnAt,
 		&res.CreatedAt,
+		&res.Site,
 	); err != nil {
 		return createBorrowResponse{}, err
 	}
+
+	remarks, err := autoApprovalRemarks(ctx, tx, arg, requiresApproval, rules)
+	if err != nil {
+		return createBorrowResponse{}, err
+	}
+
+	res.Status = pending
+	if remarks != "" {
+		if err := r.approveBorrowRequest(ctx, tx, res.BorrowRequestID, remarks); err != nil {
+			return createBorrowResponse{}, err
+		}
+		res.Status = approved
+		res.Remarks = &remarks
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return createBorrowResponse{}, err
+	}
+
 	return res, nil
 }
 
@@ -912,8 +1108,20 @@ func (r *repository) updateBorrowRequest(ctx context.Context, arg updateBorrowRe
 
 	status := stringToBorrowRequestStatus[arg.Status]
 	claimedAtUpdate := ""
+	claimFrom := reserved
 	if status == claimed {
 		claimedAtUpdate = ", claimed_at = NOW()"
+
+		isReserved, err := lockBorrowRequestForClaim(ctx, tx, arg.BorrowRequestID)
+		if err != nil {
+			return updateBorrowResponse{}, err
+		}
+
+		// Units only get reserved close to the claim time, so a request that
+		// is claimed right away takes whatever is available.
+		if !isReserved {
+			claimFrom = available
+		}
 	}
 	query := fmt.Sprintf(`
 	WITH updated_borrow_request AS (
@@ -976,10 +1184,11 @@ func (r *repository) updateBorrowRequest(ctx context.Context, arg updateBorrowRe
 		}
 
 		equipmentQuery := `
-		SELECT DISTINCT equipment_id
+		SELECT equipment_id
 		FROM equipment
 		WHERE equipment_type_id = $1 AND equipment_status_id = $2
-		ORDER BY equipment_id
+		AND site_id = (SELECT site_id FROM borrow_request WHERE borrow_request_id = $4)
+		ORDER BY asset_tag
 		LIMIT $3
 		`
 
@@ -997,7 +1206,7 @@ func (r *repository) updateBorrowRequest(ctx context.Context, arg updateBorrowRe
 		for _, item := range items {
 			quantity := int(item.quantity)
 
-			equipmentRows, err := tx.Query(ctx, equipmentQuery, item.equipmentTypeID, reserved, quantity)
+			equipmentRows, err := tx.Query(ctx, equipmentQuery, item.equipmentTypeID, claimFrom, quantity, arg.BorrowRequestID)
 			if err != nil {
 				return updateBorrowResponse{}, err
 			}
@@ -1039,6 +1248,10 @@ type reviewBorrowRequest struct {
 	ReviewedBy      string  `json:"reviewedBy"`
 	Remarks         *string `json:"remarks"`
 	Status          string  `json:"status"`
+
+	// Items lowers the quantities of the request's items when approving.
+	// Items that are left out keep their requested quantity.
+	Items []reviewBorrowRequestItem `json:"items"`
 }
 
 type reviewBorrowResponse struct {
@@ -1046,6 +1259,7 @@ type reviewBorrowResponse struct {
 	ReviewedBy      user.BasicInfo            `json:"reviewedBy"`
 	Remarks         *string                   `json:"remarks"`
 	Status          borrowRequestStatusDetail `json:"status"`
+	Adjustments     []itemAdjustment          `json:"adjustments"`
 }
 
 func (r *repository) reviewBorrowRequest(ctx context.Context, arg reviewBorrowRequest) (reviewBorrowResponse, error) {
@@ -1056,6 +1270,32 @@ func (r *repository) reviewBorrowRequest(ctx context.Context, arg reviewBorrowRe
 	defer tx.Rollback(ctx)
 
 	status := stringToBorrowRequestStatus[arg.Status]
+	if len(arg.Items) > 0 && status != approved {
+		return reviewBorrowResponse{}, errAdjustmentNotApproved
+	}
+
+	if err := lockPendingBorrowRequest(ctx, tx, arg.BorrowRequestID); err != nil {
+		return reviewBorrowResponse{}, err
+	}
+
+	var adjustments []itemAdjustment
+	if len(arg.Items) > 0 {
+		adjustments, err = adjustBorrowRequestItems(ctx, tx, arg.BorrowRequestID, arg.Items)
+		if err != nil {
+			return reviewBorrowResponse{}, err
+		}
+	}
+	remarks := adjustmentRemarks(arg.Remarks, adjustments)
+
+	siteID, err := getBorrowRequestSiteID(ctx, tx, arg.BorrowRequestID)
+	if err != nil {
+		return reviewBorrowResponse{}, err
+	}
+
+	if err := checkSiteManager(ctx, tx, arg.ReviewedBy, siteID); err != nil {
+		return reviewBorrowResponse{}, err
+	}
+
 	query := `
 	WITH reviewed_request AS (
 		UPDATE borrow_request
@@ -1088,7 +1328,7 @@ func (r *repository) reviewBorrowRequest(ctx context.Context, arg reviewBorrowRe
 		query,
 		status,
 		arg.ReviewedBy,
-		arg.Remarks,
+		remarks,
 		arg.BorrowRequestID,
 	)
 
@@ -1102,80 +1342,28 @@ func (r *repository) reviewBorrowRequest(ctx context.Context, arg reviewBorrowRe
 		return reviewBorrowResponse{}, err
 	}
 
-	res.Remarks = arg.Remarks
+	res.Remarks = remarks
+	res.Adjustments = adjustments
 
 	if status == approved {
-		// Get all items in this borrow request
-		itemsQuery := `
-		SELECT borrow_request_item_id, equipment_type_id, quantity
-		FROM borrow_request_item
-		WHERE borrow_request_id = $1
-		`
-
-		itemRows, err := tx.Query(ctx, itemsQuery, arg.BorrowRequestID)
+		items, claimAt, returnAt, err := lockBorrowRequestItems(ctx, tx, arg.BorrowRequestID)
 		if err != nil {
 			return reviewBorrowResponse{}, err
 		}
 
-		type requestItem struct {
-			itemID          string
-			equipmentTypeID string
-			quantity        int16
+		if err := checkAvailability(ctx, tx, siteID, items, claimAt, returnAt, arg.BorrowRequestID); err != nil {
+			return reviewBorrowResponse{}, err
 		}
 
-		var items []requestItem
-		for itemRows.Next() {
-			var item requestItem
-			if err := itemRows.Scan(&item.itemID, &item.equipmentTypeID, &item.quantity); err != nil {
+		// Requests further out are reserved by the expiration worker once
+		// their claim time gets close.
+		if claimAt.Before(time.Now().Add(reservationLeadTime)) {
+			if err := reserveBorrowRequestUnits(ctx, tx, arg.BorrowRequestID); err != nil {
 				return reviewBorrowResponse{}, err
 			}
-			items = append(items, item)
 		}
 
-		equipmentQuery := `
-		SELECT DISTINCT equipment_id 
-		FROM equipment
-		WHERE equipment_type_id = $1 AND equipment_status_id = $2
-		ORDER BY equipment_id
-		LIMIT $3
-		`
-
-		reserveEquipmentQuery := `
-		UPDATE equipment
-		SET equipment_status_id = $1
-		WHERE equipment_id = $2
-		`
-
-		for _, item := range items {
-			quantity := int(item.quantity)
-
-			equipmentRows, err := tx.Query(ctx, equipmentQuery, item.equipmentTypeID, available, quantity)
-			if err != nil {
-				return reviewBorrowResponse{}, err
-			}
-
-			var equipmentIDs []string
-			for equipmentRows.Next() {
-				var equipmentID string
-				if err := equipmentRows.Scan(&equipmentID); err != nil {
-					return reviewBorrowResponse{}, err
-				}
-				equipmentIDs = append(equipmentIDs, equipmentID)
-
-			}
-
-			if len(equipmentIDs) < quantity {
-				return reviewBorrowResponse{}, errInsufficientEquipmentQuantity
-			}
-
-			for _, id := range equipmentIDs {
-				if _, err := tx.Exec(ctx, reserveEquipmentQuery, reserved, id); err != nil {
-					return reviewBorrowResponse{}, err
-				}
-			}
-		}
-
-		if err := r.createBorrowRequestWithOTP(ctx, res.BorrowRequestID); err != nil {
+		if err := r.createBorrowRequestWithOTP(ctx, res.BorrowRequestID, tx); err != nil {
 			return reviewBorrowResponse{}, err
 		}
 	}
@@ -1194,6 +1382,9 @@ type returnEquipmentItem struct {
 
 type createReturnRequest struct {
 	Items []returnEquipmentItem `json:"items"`
+
+	// RequestedBy is the signed in borrower, who must own every item.
+	RequestedBy string `json:"-"`
 }
 
 type returnedEquipmentItem struct {
@@ -1268,7 +1459,7 @@ func (r *repository) createReturnRequest(ctx context.Context, arg createReturnRe
 	}
 
 	statusQuery := `
-	SELECT borrow_request_id, borrow_request_status_id
+	SELECT borrow_request_id, borrow_request_status_id, requested_by
 	FROM borrow_request
 	WHERE borrow_request_id = ANY($1)
 	`
@@ -1281,12 +1472,16 @@ func (r *repository) createReturnRequest(ctx context.Context, arg createReturnRe
 
 	for statusRows.Next() {
 		var (
-			id     string
-			status borrowRequestStatus
+			id          string
+			status      borrowRequestStatus
+			requestedBy string
 		)
-		if err := statusRows.Scan(&id, &status); err != nil {
+		if err := statusRows.Scan(&id, &status, &requestedBy); err != nil {
 			return createReturnResponse{}, err
 		}
+		if requestedBy != arg.RequestedBy {
+			return createReturnResponse{}, errNotBorrowRequestOwner
+		}
 		if status != claimed {
 			return createReturnResponse{}, errInvalidBorrowRequestStatus
 		}
@@ -1458,7 +1653,15 @@ func (r *repository) createReturnRequest(ctx context.Context, arg createReturnRe
 	return res, nil
 }
 
-func (r *repository) getBorrowRequests(ctx context.Context) ([]borrowRequest, error) {
+// Pending requests are listed oldest first, in the order they should be
+// reviewed.
+type getBorrowRequestsParams struct {
+	siteID *string
+
+	page api.Page
+}
+
+func (r *repository) getBorrowRequests(ctx context.Context, params getBorrowRequestsParams) ([]borrowRequest, *string, error) {
 	query := `
 	WITH latest_return_data AS (
 		SELECT 
@@ -1545,6 +1748,8 @@ func (r *repository) getBorrowRequests(ctx context.Context) ([]borrowRequest, er
 		borrow_request.purpose,
 		borrow_request.expected_claim_at,
 		borrow_request.expected_return_at,
+		` + originalReturnAtColumn + `,
+		` + siteJSON("borrow_request.site_id") + ` AS site,
 		latest_return_data.created_at AS actual_return_at,
 		borrow_request.claimed_at,
 		jsonb_build_object(
@@ -1610,6 +1815,30 @@ func (r *repository) getBorrowRequests(ctx context.Context) ([]borrowRequest, er
 	) requested_items_agg ON TRUE
 
 	WHERE borrow_request.borrow_request_status_id = $1
+	`
+
+	args := []any{pending}
+	argIdx := len(args) + 1
+
+	if params.siteID != nil && *params.siteID != "" {
+		query += fmt.Sprintf(" AND borrow_request.site_id::text = $%d", argIdx)
+		args = append(args, *params.siteID)
+		argIdx++
+	}
+
+	if params.page.Cursor != "" {
+		var cursorRequestedAt time.Time
+		var cursorID string
+		if err := api.DecodeCursor(params.page.Cursor, &cursorRequestedAt, &cursorID); err != nil {
+			return nil, nil, err
+		}
+
+		query += fmt.Sprintf(" AND (borrow_request.created_at, borrow_request.borrow_request_id) > ($%d, $%d)", argIdx, argIdx+1)
+		args = append(args, cursorRequestedAt, cursorID)
+		argIdx += 2
+	}
+
+	query += `
 	GROUP BY
 		person.person_id,
 		person.first_name,
@@ -1628,17 +1857,27 @@ func (r *repository) getBorrowRequests(ctx context.Context) ([]borrowRequest, er
 		borrow_request_otp.borrow_request_otp_id,
 		return_confirmation_agg.return_confirmations,
 		requested_items_agg.items_agg
+	ORDER BY borrow_request.created_at, borrow_request.borrow_request_id
 	`
 
-	rows, err := r.querier.Query(ctx, query, pending)
+	if params.page.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", argIdx)
+		args = append(args, params.page.Limit+1)
+		argIdx++
+	}
+
+	rows, err := r.querier.Query(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	borrowRequests, err := pgx.CollectRows(rows, pgx.RowToStructByName[borrowRequest])
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return borrowRequests, nil
+
+	return api.NextPage(borrowRequests, params.page, func(b borrowRequest) []any {
+		return []any{b.RequestedAt, b.BorrowRequestID}
+	})
 }
 
 func (r *repository) getBorrowRequestByID(ctx context.Context, id string) (borrowRequest, error) {
@@ -1740,6 +1979,8 @@ func (r *repository) getBorrowRequestByID(ctx context.Context, id string) (borro
 		borrow_request.purpose,
 		borrow_request.expected_claim_at,
 		borrow_request.expected_return_at,
+		` + originalReturnAtColumn + `,
+		` + siteJSON("borrow_request.site_id") + ` AS site,
 		latest_return_data.created_at AS actual_return_at,
 		borrow_request.claimed_at,
 		jsonb_build_object(
@@ -1825,6 +2066,8 @@ func (r *repository) getBorrowRequestByID(ctx context.Context, id string) (borro
 		&req.Purpose,
 		&req.ExpectedClaimAt,
 		&req.ExpectedReturnAt,
+		&req.OriginalReturnAt,
+		&req.Site,
 		&req.ActualReturnAt,
 		&req.ClaimedAt,
 		&req.Status,
@@ -1936,6 +2179,8 @@ func (r *repository) getBorrowRequestByOTP(ctx context.Context, otp string) (bor
 		borrow_request.purpose,
 		borrow_request.expected_claim_at,
 		borrow_request.expected_return_at,
+		` + originalReturnAtColumn + `,
+		` + siteJSON("borrow_request.site_id") + ` AS site,
 		latest_return_data.created_at AS actual_return_at,
 		borrow_request.claimed_at,
 		jsonb_build_object(
@@ -2021,6 +2266,8 @@ func (r *repository) getBorrowRequestByOTP(ctx context.Context, otp string) (bor
 		&req.Purpose,
 		&req.ExpectedClaimAt,
 		&req.ExpectedReturnAt,
+		&req.OriginalReturnAt,
+		&req.Site,
 		&req.ActualReturnAt,
 		&req.ClaimedAt,
 		&req.Status,
@@ -2037,6 +2284,16 @@ type confirmReturnRequest struct {
 	ReturnRequestID string  `json:"returnRequestId"`
 	ReviewedBy      string  `json:"reviewedBy"`
 	Remarks         *string `json:"remarks"`
+
+	// Units optionally holds the scanned unit IDs or asset tags that came
+	// back. When empty, the oldest outstanding units are assumed.
+	Units []string `json:"units"`
+
+	// DamagedUnits and LostUnits hold the unit IDs or asset tags, among the
+	// ones being returned, that came back damaged or not at all. They are
+	// taken out of circulation and count as strikes against the borrower.
+	DamagedUnits []string `json:"damagedUnits"`
+	LostUnits    []string `json:"lostUnits"`
 }
 
 var errReturnRequestAlreadyConfirmed = fmt.Errorf("return request is already confirmed")
@@ -2074,6 +2331,15 @@ func (r *repository) confirmReturnRequest(ctx context.Context, arg confirmReturn
 		return confirmReturnRequest{}, err
 	}
 
+	siteID, err := getBorrowRequestSiteID(ctx, tx, borrowRequestID)
+	if err != nil {
+		return confirmReturnRequest{}, err
+	}
+
+	if err := checkSiteManager(ctx, tx, arg.ReviewedBy, siteID); err != nil {
+		return confirmReturnRequest{}, err
+	}
+
 	itemsQuery := `
 	SELECT 
 		return_request_item.return_request_item_id,
@@ -2102,6 +2368,67 @@ func (r *repository) confirmReturnRequest(ctx context.Context, arg confirmReturn
 		items = append(items, item)
 	}
 
+	// Map each scanned unit to the outstanding borrow transaction it was
+	// handed out under.
+	var returnedTransactions map[string][]string
+	if len(arg.Units) > 0 {
+		units, err := lockScannedUnits(ctx, tx, arg.Units)
+		if err != nil {
+			return confirmReturnRequest{}, err
+		}
+
+		unitIDs := make([]string, len(units))
+		for i, unit := range units {
+			unitIDs[i] = unit.unitID
+		}
+
+		borrowRequestItemIDs := make([]string, len(items))
+		for i, item := range items {
+			borrowRequestItemIDs[i] = item.borrowRequestItemID
+		}
+
+		outstandingQuery := `
+		SELECT borrow_transaction.borrow_transaction_id, borrow_transaction.borrow_request_item_id
+		FROM borrow_transaction
+		WHERE borrow_transaction.borrow_request_item_id = ANY($1::uuid[])
+		AND borrow_transaction.equipment_id = ANY($2::uuid[])
+		AND NOT EXISTS (
+			SELECT 1
+			FROM return_transaction
+			WHERE return_transaction.borrow_transaction_id = borrow_transaction.borrow_transaction_id
+		)
+		`
+
+		rows, err := tx.Query(ctx, outstandingQuery, borrowRequestItemIDs, unitIDs)
+		if err != nil {
+			return confirmReturnRequest{}, err
+		}
+
+		returnedTransactions = make(map[string][]string)
+		matched := 0
+		for rows.Next() {
+			var transactionID, borrowRequestItemID string
+			if err := rows.Scan(&transactionID, &borrowRequestItemID); err != nil {
+				return confirmReturnRequest{}, err
+			}
+			returnedTransactions[borrowRequestItemID] = append(returnedTransactions[borrowRequestItemID], transactionID)
+			matched++
+		}
+		if err := rows.Err(); err != nil {
+			return confirmReturnRequest{}, err
+		}
+
+		if matched != len(units) {
+			return confirmReturnRequest{}, errUnitNotBorrowed
+		}
+
+		for _, item := range items {
+			if len(returnedTransactions[item.borrowRequestItemID]) != item.quantity {
+				return confirmReturnRequest{}, errUnitQuantityMismatch
+			}
+		}
+	}
+
 	// For each item, validate and create return_transactions
 	for _, item := range items {
 		remainingQuery := `
@@ -2127,21 +2454,32 @@ func (r *repository) confirmReturnRequest(ctx context.Context, arg confirmReturn
 				item.quantity, remainingQuantity, item.borrowRequestItemID)
 		}
 
-		transactionQuery := `
-		INSERT INTO return_transaction (borrow_transaction_id, return_request_item_id)
-		SELECT 
-			borrow_transaction.borrow_transaction_id,
-			$1
-		FROM borrow_transaction
-		WHERE borrow_transaction.borrow_request_item_id = $2
-		AND borrow_transaction.borrow_transaction_id NOT IN (
-			-- Exclude already returned items
-			SELECT borrow_transaction_id FROM return_transaction
-		)
-		LIMIT $3
-		`
-		if _, err := tx.Exec(ctx, transactionQuery, item.returnRequestItemID, item.borrowRequestItemID, item.quantity); err != nil {
-			return confirmReturnRequest{}, err
+		if returnedTransactions != nil {
+			transactionQuery := `
+			INSERT INTO return_transaction (borrow_transaction_id, return_request_item_id)
+			SELECT unnest($1::uuid[]), $2
+			`
+			if _, err := tx.Exec(ctx, transactionQuery, returnedTransactions[item.borrowRequestItemID], item.returnRequestItemID); err != nil {
+				return confirmReturnRequest{}, err
+			}
+		} else {
+			transactionQuery := `
+			INSERT INTO return_transaction (borrow_transaction_id, return_request_item_id)
+			SELECT 
+				borrow_transaction.borrow_transaction_id,
+				$1
+			FROM borrow_transaction
+			WHERE borrow_transaction.borrow_request_item_id = $2
+			AND borrow_transaction.borrow_transaction_id NOT IN (
+				-- Exclude already returned items
+				SELECT borrow_transaction_id FROM return_transaction
+			)
+			ORDER BY borrow_transaction.created_at
+			LIMIT $3
+			`
+			if _, err := tx.Exec(ctx, transactionQuery, item.returnRequestItemID, item.borrowRequestItemID, item.quantity); err != nil {
+				return confirmReturnRequest{}, err
+			}
 		}
 
 		updateEquipmentQuery := `
@@ -2160,6 +2498,26 @@ func (r *repository) confirmReturnRequest(ctx context.Context, arg confirmReturn
 		}
 	}
 
+	damagedUnitIDs, err := markReturnedUnits(ctx, tx, arg.ReturnRequestID, arg.DamagedUnits, damaged)
+	if err != nil {
+		return confirmReturnRequest{}, err
+	}
+
+	lostUnitIDs, err := markReturnedUnits(ctx, tx, arg.ReturnRequestID, arg.LostUnits, lost)
+	if err != nil {
+		return confirmReturnRequest{}, err
+	}
+
+	for _, unitID := range lostUnitIDs {
+		if slices.Contains(damagedUnitIDs, unitID) {
+			return confirmReturnRequest{}, errDuplicateScannedUnit
+		}
+	}
+
+	if err := recordReturnStrikes(ctx, tx, arg.ReturnRequestID, damagedUnitIDs, lostUnitIDs); err != nil {
+		return confirmReturnRequest{}, err
+	}
+
 	// Check if all items in the borrow_request are fully returned
 	allReturnedQuery := `
 	SELECT 
@@ -2222,9 +2580,11 @@ type getReturnRequestParams struct {
 	userID   *string
 	sort     *api.Sort
 	category *string
+
+	page api.Page
 }
 
-func (r *repository) getReturnRequests(ctx context.Context, params getReturnRequestParams) ([]returnRequest, error) {
+func (r *repository) getReturnRequests(ctx context.Context, params getReturnRequestParams) ([]returnRequest, *string, error) {
 	query := `
 	SELECT 
 		return_request.return_request_id,
@@ -2268,19 +2628,49 @@ func (r *repository) getReturnRequests(ctx context.Context, params getReturnRequ
 	`
 
 	var args []any
+	argIdx := 1
+
 	if params.userID != nil && *params.userID != "" {
-		query += " AND person.person_id = $1"
+		query += fmt.Sprintf(" AND person.person_id = $%d", argIdx)
 		args = append(args, *params.userID)
+		argIdx++
 	}
 
 	if params.category != nil && *params.category != "" {
-		query += ` AND EXISTS (
+		query += fmt.Sprintf(` AND EXISTS (
 			SELECT 1 FROM equipment_type_category 
 			JOIN category USING (category_id)
 			WHERE equipment_type_category.equipment_type_id = equipment_type.equipment_type_id
-			AND category.name = $2
-		)`
+			AND category.name = $%d
+		)`, argIdx)
 		args = append(args, *params.category)
+		argIdx++
+	}
+
+	sortDirection := "DESC"
+	if params.sort != nil {
+		sortDirection = params.sort.Direction(api.Desc)
+	}
+
+	if params.page.Cursor != "" {
+		var cursorExpectedReturnAt time.Time
+		var cursorID string
+		if err := api.DecodeCursor(params.page.Cursor, &cursorExpectedReturnAt, &cursorID); err != nil {
+			return nil, nil, err
+		}
+
+		comparison := "<"
+		if sortDirection == "ASC" {
+			comparison = ">"
+		}
+		query += fmt.Sprintf(
+			" AND (borrow_request.expected_return_at, return_request.return_request_id) %s ($%d, $%d)",
+			comparison,
+			argIdx,
+			argIdx+1,
+		)
+		args = append(args, cursorExpectedReturnAt, cursorID)
+		argIdx += 2
 	}
 
 	query += `
@@ -2296,21 +2686,30 @@ func (r *repository) getReturnRequests(ctx context.Context, params getReturnRequ
 		return_request_otp.return_request_otp_id
 	`
 
-	if params.sort != nil && *params.sort != "" {
-		query += fmt.Sprintf(" ORDER BY borrow_request.expected_return_at %s", *params.sort)
-	} else {
-		query += " ORDER BY borrow_request.expected_return_at DESC"
+	query += fmt.Sprintf(
+		" ORDER BY borrow_request.expected_return_at %s, return_request.return_request_id %s",
+		sortDirection,
+		sortDirection,
+	)
+
+	if params.page.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", argIdx)
+		args = append(args, params.page.Limit+1)
+		argIdx++
 	}
 
 	rows, err := r.querier.Query(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	returnRequests, err := pgx.CollectRows(rows, pgx.RowToStructByName[returnRequest])
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return returnRequests, nil
+
+	return api.NextPage(returnRequests, params.page, func(r returnRequest) []any {
+		return []any{r.ExpectedReturnAt, r.ReturnRequestID}
+	})
 }
 
 func (r *repository) getReturnRequestByID(ctx context.Context, id string) (returnRequest, error) {
@@ -2439,6 +2838,9 @@ type anomaly struct {
 	Score           float32 `json:"score"`
 	IsAnomaly       bool    `json:"isAnomaly"`
 	IsFalsePositive *bool   `json:"isFalsePositive"`
+
+	// Detector is the one that produced the score.
+	Detector anomalyDetector `json:"-"`
 }
 
 type OTP struct {
@@ -2476,9 +2878,13 @@ type borrowRequest struct {
 	Status          borrowRequestStatusDetail `json:"status"`
 	Review          *borrowReview             `json:"review"`
 
-	ExpectedClaimAt     time.Time            `json:"expectedClaimAt"`
-	ExpectedReturnAt    time.Time            `json:"expectedReturnAt"`
-	ActualReturnAt      *time.Time           `json:"actualReturnAt"`
+	ExpectedClaimAt  time.Time  `json:"expectedClaimAt"`
+	ExpectedReturnAt time.Time  `json:"expectedReturnAt"`
+	ActualReturnAt   *time.Time `json:"actualReturnAt"`
+
+	// OriginalReturnAt is the due date before any extension was approved.
+	OriginalReturnAt    time.Time            `json:"originalReturnAt"`
+	Site                siteDetail           `json:"site"`
 	ClaimedAt           *time.Time           `json:"claimedAt"`
 	ReturnConfirmations []returnConfirmation `json:"returnConfirmations"`
 
@@ -2496,9 +2902,16 @@ type borrowHistoryParams struct {
 	startDate    *time.Time
 	endDate      *time.Time
 	equipmentIDs []string
+	siteID       *string
+
+	page api.Page
 }
 
-func (r *repository) getBorrowHistory(ctx context.Context, params borrowHistoryParams) ([]borrowRequest, error) {
+// Borrow requests that haven't been returned sort as if they were returned at
+// this time, matching where PostgreSQL puts NULLs so the cursor stays usable.
+var unreturnedSortKey = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+func (r *repository) getBorrowHistory(ctx context.Context, params borrowHistoryParams) ([]borrowRequest, *string, error) {
 	query := `
 	WITH latest_return_data AS (
 		SELECT 
@@ -2585,6 +2998,8 @@ func (r *repository) getBorrowHistory(ctx context.Context, params borrowHistoryP
 		borrow_request.purpose,
 		borrow_request.expected_claim_at,
 		borrow_request.expected_return_at,
+		` + originalReturnAtColumn + `,
+		` + siteJSON("borrow_request.site_id") + ` AS site,
 		latest_return_data.created_at AS actual_return_at,
 		borrow_request.claimed_at,
 		jsonb_build_object(
@@ -2652,6 +3067,108 @@ func (r *repository) getBorrowHistory(ctx context.Context, params borrowHistoryP
 	WHERE TRUE
 	`
 
+	filters, args := borrowHistoryFilters(params)
+	query += filters
+	argIdx := len(args) + 1
+
+	sortBy, sortByColumn, sortDirection := borrowHistorySort(params)
+
+	if params.page.Cursor != "" {
+		var cursorSortBy, cursorID string
+		var cursorKey any = &time.Time{}
+		if sortBy == "status" {
+			cursorKey = new(int)
+		}
+
+		if err := api.DecodeCursor(params.page.Cursor, &cursorSortBy, cursorKey, &cursorID); err != nil {
+			return nil, nil, err
+		}
+		if cursorSortBy != sortBy {
+			return nil, nil, api.ErrInvalidCursor
+		}
+
+		comparison := "<"
+		if sortDirection == "ASC" {
+			comparison = ">"
+		}
+		query += fmt.Sprintf(
+			" AND (%s, borrow_request.borrow_request_id) %s ($%d, $%d)",
+			sortByColumn,
+			comparison,
+			argIdx,
+			argIdx+1,
+		)
+		args = append(args, cursorKey, cursorID)
+		argIdx += 2
+	}
+
+	query += `
+	GROUP BY
+		person.person_id,
+		person.first_name,
+		person.middle_name,
+		person.last_name,
+		person.avatar_url,
+		person_borrow_reviewer.person_id,
+		person_borrow_reviewer.first_name,
+		person_borrow_reviewer.middle_name,
+		person_borrow_reviewer.last_name,
+		person_borrow_reviewer.avatar_url,
+		borrow_request.borrow_request_id,
+		borrow_request_status.borrow_request_status_id,
+		latest_return_data.created_at,
+		anomaly_result.anomaly_result_id,
+		borrow_request_otp.borrow_request_otp_id,
+		return_confirmation_agg.return_confirmations,
+		requested_items_agg.items_agg
+	`
+
+	query += fmt.Sprintf(
+		" ORDER BY %s %s, borrow_request.borrow_request_id %s",
+		sortByColumn,
+		sortDirection,
+		sortDirection,
+	)
+
+	if params.page.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", argIdx)
+		args = append(args, params.page.Limit+1)
+		argIdx++
+	}
+
+	rows, err := r.querier.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	history, err := pgx.CollectRows(rows, pgx.RowToStructByName[borrowRequest])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return api.NextPage(history, params.page, func(b borrowRequest) []any {
+		var key any = b.RequestedAt
+		switch sortBy {
+		case "expectedReturnAt":
+			key = b.ExpectedReturnAt
+		case "returnedAt":
+			key = unreturnedSortKey
+			if b.ActualReturnAt != nil {
+				key = *b.ActualReturnAt
+			}
+		case "status":
+			key = b.Status.ID
+		}
+
+		return []any{sortBy, key, b.BorrowRequestID}
+	})
+}
+
+// borrowHistoryFilters builds the conditions shared by the borrow history
+// listing and its exports. They expect the borrower to be joined as `person`
+// and the reviewer as `person_borrow_reviewer`.
+func borrowHistoryFilters(params borrowHistoryParams) (string, []any) {
+	var query string
 	var args []any
 	argIdx := 1
 
@@ -2738,59 +3255,44 @@ func (r *repository) getBorrowHistory(ctx context.Context, params borrowHistoryP
 		argIdx++
 	}
 
-	query += `
-	GROUP BY
-		person.person_id,
-		person.first_name,
-		person.middle_name,
-		person.last_name,
-		person.avatar_url,
-		person_borrow_reviewer.person_id,
-		person_borrow_reviewer.first_name,
-		person_borrow_reviewer.middle_name,
-		person_borrow_reviewer.last_name,
-		person_borrow_reviewer.avatar_url,
-		borrow_request.borrow_request_id,
-		borrow_request_status.borrow_request_status_id,
-		latest_return_data.created_at,
-		anomaly_result.anomaly_result_id,
-		borrow_request_otp.borrow_request_otp_id,
-		return_confirmation_agg.return_confirmations,
-		requested_items_agg.items_agg
-	`
+	if params.siteID != nil && *params.siteID != "" {
+		query += fmt.Sprintf(" AND borrow_request.site_id::text = $%d", argIdx)
+		args = append(args, *params.siteID)
+		argIdx++
+	}
 
-	sortByColumn := "borrow_request.created_at"
+	return query, args
+}
+
+func borrowHistorySort(params borrowHistoryParams) (sortBy, sortByColumn, sortDirection string) {
+	sortBy = "borrowedAt"
+	sortByColumn = "borrow_request.created_at"
 	if params.sortBy != nil {
 		switch *params.sortBy {
 		case "borrowedAt":
+			sortBy = "borrowedAt"
 			sortByColumn = "borrow_request.created_at"
 		case "expectedReturnAt":
+			sortBy = "expectedReturnAt"
 			sortByColumn = "borrow_request.expected_return_at"
 		case "returnedAt":
-			sortByColumn = "latest_return_data.created_at"
+			sortBy = "returnedAt"
+			sortByColumn = fmt.Sprintf(
+				"COALESCE(latest_return_data.created_at, '%s'::timestamptz)",
+				unreturnedSortKey.Format(time.RFC3339),
+			)
 		case "status":
+			sortBy = "status"
 			sortByColumn = "borrow_request.borrow_request_status_id"
 		}
 	}
 
-	sortDirection := "DESC"
-	if params.sort != nil && *params.sort != "" {
-		sortDirection = string(*params.sort)
-	}
-
-	query += fmt.Sprintf(" ORDER BY %s %s", sortByColumn, sortDirection)
-
-	rows, err := r.querier.Query(ctx, query, args...)
-	if err != nil {
-		return nil, err
+	sortDirection = "DESC"
+	if params.sort != nil {
+		sortDirection = params.sort.Direction(api.Desc)
 	}
 
-	history, err := pgx.CollectRows(rows, pgx.RowToStructByName[borrowRequest])
-	if err != nil {
-		return nil, err
-	}
-
-	return history, nil
+	return sortBy, sortByColumn, sortDirection
 }
 
 type borrowedItemParams struct {
@@ -2879,8 +3381,17 @@ func (r *repository) getBorrowedItems(ctx context.Context, params borrowedItemPa
 
 func (r *repository) createAnomalyResult(ctx context.Context, arg anomaly) error {
 	query := `
-	INSERT INTO anomaly_result (score, is_anomaly, is_false_positive, borrow_request_id)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO anomaly_result (score, is_anomaly, is_false_positive, borrow_request_id, detector)
+	VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+	ON CONFLICT (borrow_request_id) DO UPDATE
+	SET
+		score = EXCLUDED.score,
+		is_anomaly = EXCLUDED.is_anomaly,
+		is_false_positive = EXCLUDED.is_false_positive,
+		detector = EXCLUDED.detector,
+		reviewed_by = NULL,
+		reviewed_at = NULL,
+		updated_at = NOW()
 	`
 
 	if _, err := r.querier.Exec(
@@ -2890,6 +3401,7 @@ func (r *repository) createAnomalyResult(ctx context.Context, arg anomaly) error
 		arg.IsAnomaly,
 		arg.IsFalsePositive,
 		arg.BorrowRequestID,
+		string(arg.Detector),
 	); err != nil {
 		return err
 	}
@@ -2910,6 +3422,7 @@ func (r *repository) deleteEquipment(ctx context.Context, id string, quantity *u
 			WHERE equipment.equipment_type_id = $1 
 			AND equipment.equipment_status_id = $2
 			AND borrow_transaction.borrow_transaction_id IS NULL
+			ORDER BY equipment.asset_tag DESC
 			LIMIT $3
 		)
 		`
@@ -2948,22 +3461,22 @@ func (r *repository) deleteEquipment(ctx context.Context, id string, quantity *u
 	return err
 }
 
-func (r *repository) createCategory(ctx context.Context, name string, backgroundColor, foregroundColor *string) (categoryDetail, error) {
-	query := "INSERT INTO category (name, background_color, foreground_color) VALUES ($1, $2, $3) RETURNING category_id, name, background_color, foreground_color"
+func (r *repository) createCategory(ctx context.Context, name string, backgroundColor, foregroundColor *string, isSelfService bool) (categoryDetail, error) {
+	query := "INSERT INTO category (name, background_color, foreground_color, is_self_service) VALUES ($1, $2, $3, $4) RETURNING category_id, name, background_color, foreground_color, is_self_service"
 	var res categoryDetail
-	err := r.querier.QueryRow(ctx, query, name, backgroundColor, foregroundColor).Scan(&res.CategoryID, &res.Name, &res.BackgroundColor, &res.ForegroundColor)
+	err := r.querier.QueryRow(ctx, query, name, backgroundColor, foregroundColor, isSelfService).Scan(&res.CategoryID, &res.Name, &res.BackgroundColor, &res.ForegroundColor, &res.IsSelfService)
 	return res, err
 }
 
-func (r *repository) updateCategory(ctx context.Context, id string, name string, backgroundColor, foregroundColor *string) (categoryDetail, error) {
-	query := "UPDATE category SET name = $1, background_color = $2, foreground_color = $3 WHERE category_id = $4 RETURNING category_id, name, background_color, foreground_color"
+func (r *repository) updateCategory(ctx context.Context, id string, name string, backgroundColor, foregroundColor *string, isSelfService bool) (categoryDetail, error) {
+	query := "UPDATE category SET name = $1, background_color = $2, foreground_color = $3, is_self_service = $4 WHERE category_id = $5 RETURNING category_id, name, background_color, foreground_color, is_self_service"
 	var res categoryDetail
-	err := r.querier.QueryRow(ctx, query, name, backgroundColor, foregroundColor, id).Scan(&res.CategoryID, &res.Name, &res.BackgroundColor, &res.ForegroundColor)
+	err := r.querier.QueryRow(ctx, query, name, backgroundColor, foregroundColor, isSelfService, id).Scan(&res.CategoryID, &res.Name, &res.BackgroundColor, &res.ForegroundColor, &res.IsSelfService)
 	return res, err
 }
 
 func (r *repository) getCategories(ctx context.Context) ([]categoryDetail, error) {
-	query := "SELECT category_id, name, background_color, foreground_color FROM category ORDER BY name"
+	query := "SELECT category_id, name, background_color, foreground_color, is_self_service FROM category ORDER BY name"
 	rows, err := r.querier.Query(ctx, query)
 	if err != nil {
 		return nil, err
@@ -2973,7 +3486,7 @@ func (r *repository) getCategories(ctx context.Context) ([]categoryDetail, error
 	var categories []categoryDetail
 	for rows.Next() {
 		var cat categoryDetail
-		if err := rows.Scan(&cat.CategoryID, &cat.Name, &cat.BackgroundColor, &cat.ForegroundColor); err != nil {
+		if err := rows.Scan(&cat.CategoryID, &cat.Name, &cat.BackgroundColor, &cat.ForegroundColor, &cat.IsSelfService); err != nil {
 			return nil, err
 		}
 		categories = append(categories, cat)
@@ -2987,12 +3500,17 @@ func (r *repository) deleteCategory(ctx context.Context, id string) error {
 	return err
 }
 
-func (r *repository) increaseQuantity(ctx context.Context, id string, quantity uint, acquisitionDate time.Time) error {
+func (r *repository) increaseQuantity(ctx context.Context, id string, quantity uint, acquisitionDate time.Time, siteID string) error {
+	siteID, err := resolveSiteID(ctx, r.querier, siteID)
+	if err != nil {
+		return err
+	}
+
 	query := `
-	INSERT INTO equipment (equipment_type_id, acquired_at, equipment_status_id)
-	SELECT $1, $2, $3
+	INSERT INTO equipment (equipment_type_id, acquired_at, equipment_status_id, site_id)
+	SELECT $1, $2, $3, $5
 	FROM generate_series(1, $4)
 	`
-	_, err := r.querier.Exec(ctx, query, id, acquisitionDate, available, quantity)
+	_, err = r.querier.Exec(ctx, query, id, acquisitionDate, available, quantity, siteID)
 	return err
 }
@@ -0,0 +1,560 @@
+package equipment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/sse"
+)
+
+var (
+	errInvalidMaintenanceSchedule = fmt.Errorf("invalid maintenance schedule")
+	errMaintenanceScheduleExists  = fmt.Errorf("maintenance schedule already exists")
+	errMaintenanceScheduleTarget  = fmt.Errorf("equipment not found")
+)
+
+// maintenanceSchedule is a preventive check that comes around every
+// IntervalDays or every IntervalLoans, whichever is hit first.
+type maintenanceSchedule struct {
+	MaintenanceScheduleID string    `json:"id"`
+	CreatedAt             time.Time `json:"createdAt"`
+	EquipmentTypeID       string    `json:"equipmentTypeId"`
+	Name                  string    `json:"name"`
+	Description           *string   `json:"description"`
+	IntervalDays          *int      `json:"intervalDays"`
+	IntervalLoans         *int      `json:"intervalLoans"`
+
+	// PullFromCirculation moves due units to maintenance, as long as the
+	// bookings at their site can do without them.
+	PullFromCirculation bool `json:"pullFromCirculation"`
+	IsActive            bool `json:"isActive"`
+}
+
+const maintenanceScheduleColumns = `
+	maintenance_schedule.maintenance_schedule_id,
+	maintenance_schedule.created_at,
+	maintenance_schedule.equipment_type_id,
+	maintenance_schedule.name,
+	maintenance_schedule.description,
+	maintenance_schedule.interval_days,
+	maintenance_schedule.interval_loans,
+	maintenance_schedule.pull_from_circulation,
+	maintenance_schedule.is_active
+`
+
+func maintenanceScheduleFields(m *maintenanceSchedule) []any {
+	return []any{
+		&m.MaintenanceScheduleID,
+		&m.CreatedAt,
+		&m.EquipmentTypeID,
+		&m.Name,
+		&m.Description,
+		&m.IntervalDays,
+		&m.IntervalLoans,
+		&m.PullFromCirculation,
+		&m.IsActive,
+	}
+}
+
+type maintenanceScheduleRequest struct {
+	MaintenanceScheduleID string  `json:"id"`
+	EquipmentTypeID       string  `json:"equipmentTypeId"`
+	Name                  string  `json:"name"`
+	Description           *string `json:"description"`
+	IntervalDays          *int    `json:"intervalDays"`
+	IntervalLoans         *int    `json:"intervalLoans"`
+	PullFromCirculation   bool    `json:"pullFromCirculation"`
+	IsActive              bool    `json:"isActive"`
+}
+
+func (arg maintenanceScheduleRequest) validate() error {
+	switch {
+	case strings.TrimSpace(arg.Name) == "":
+		return fmt.Errorf("%w: name is required", errInvalidMaintenanceSchedule)
+	case arg.EquipmentTypeID == "":
+		return fmt.Errorf("%w: equipment is required", errInvalidMaintenanceSchedule)
+	case arg.IntervalDays == nil && arg.IntervalLoans == nil:
+		return fmt.Errorf("%w: an interval in days or loans is required", errInvalidMaintenanceSchedule)
+	case arg.IntervalDays != nil && *arg.IntervalDays < 1:
+		return fmt.Errorf("%w: interval in days must be greater than zero", errInvalidMaintenanceSchedule)
+	case arg.IntervalLoans != nil && *arg.IntervalLoans < 1:
+		return fmt.Errorf("%w: interval in loans must be greater than zero", errInvalidMaintenanceSchedule)
+	}
+
+	return nil
+}
+
+// maintenanceScheduleError maps constraint violations to the errors the
+// handlers know how to report.
+func maintenanceScheduleError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			return errMaintenanceScheduleExists
+		case "23503":
+			return errMaintenanceScheduleTarget
+		}
+	}
+	return err
+}
+
+func (r *repository) getMaintenanceSchedules(ctx context.Context, equipmentTypeID string) ([]maintenanceSchedule, error) {
+	query := `SELECT ` + maintenanceScheduleColumns + `
+	FROM maintenance_schedule
+	WHERE ($1 = '' OR maintenance_schedule.equipment_type_id::text = $1)
+	ORDER BY maintenance_schedule.equipment_type_id, maintenance_schedule.name
+	`
+
+	rows, err := r.querier.Query(ctx, query, equipmentTypeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []maintenanceSchedule{}
+	for rows.Next() {
+		var schedule maintenanceSchedule
+		if err := rows.Scan(maintenanceScheduleFields(&schedule)...); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+func (r *repository) createMaintenanceSchedule(ctx context.Context, arg maintenanceScheduleRequest) (maintenanceSchedule, error) {
+	query := `
+	INSERT INTO maintenance_schedule (
+		equipment_type_id,
+		name,
+		description,
+		interval_days,
+		interval_loans,
+		pull_from_circulation,
+		is_active
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING ` + maintenanceScheduleColumns
+
+	var schedule maintenanceSchedule
+	if err := r.querier.QueryRow(
+		ctx,
+		query,
+		arg.EquipmentTypeID,
+		strings.TrimSpace(arg.Name),
+		arg.Description,
+		arg.IntervalDays,
+		arg.IntervalLoans,
+		arg.PullFromCirculation,
+		arg.IsActive,
+	).Scan(maintenanceScheduleFields(&schedule)...); err != nil {
+		return maintenanceSchedule{}, maintenanceScheduleError(err)
+	}
+
+	return schedule, nil
+}
+
+func (r *repository) updateMaintenanceSchedule(ctx context.Context, arg maintenanceScheduleRequest) (maintenanceSchedule, error) {
+	query := `
+	UPDATE maintenance_schedule
+	SET
+		updated_at = NOW(),
+		equipment_type_id = $2,
+		name = $3,
+		description = $4,
+		interval_days = $5,
+		interval_loans = $6,
+		pull_from_circulation = $7,
+		is_active = $8
+	WHERE maintenance_schedule_id = $1
+	RETURNING ` + maintenanceScheduleColumns
+
+	var schedule maintenanceSchedule
+	if err := r.querier.QueryRow(
+		ctx,
+		query,
+		arg.MaintenanceScheduleID,
+		arg.EquipmentTypeID,
+		strings.TrimSpace(arg.Name),
+		arg.Description,
+		arg.IntervalDays,
+		arg.IntervalLoans,
+		arg.PullFromCirculation,
+		arg.IsActive,
+	).Scan(maintenanceScheduleFields(&schedule)...); err != nil {
+		return maintenanceSchedule{}, maintenanceScheduleError(err)
+	}
+
+	return schedule, nil
+}
+
+func (r *repository) deleteMaintenanceSchedule(ctx context.Context, id string) error {
+	tag, err := r.querier.Exec(ctx, "DELETE FROM maintenance_schedule WHERE maintenance_schedule_id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+type dueMaintenance struct {
+	maintenanceScheduleID string
+	unitID                string
+	equipmentTypeID       string
+	pullFromCirculation   bool
+}
+
+// getDueMaintenance finds the units whose schedules came around. A unit is
+// last serviced when a ticket from the same schedule was closed for it, or
+// when the unit or the schedule was added if that never happened. Loans are
+// the unit's borrow transactions since then. Units that are gone or already
+// have an open ticket are skipped.
+func getDueMaintenance(ctx context.Context, q dbQuerier) ([]dueMaintenance, error) {
+	query := `
+	SELECT
+		maintenance_schedule.maintenance_schedule_id,
+		equipment.equipment_id,
+		equipment.equipment_type_id,
+		maintenance_schedule.pull_from_circulation
+	FROM maintenance_schedule
+	JOIN equipment ON equipment.equipment_type_id = maintenance_schedule.equipment_type_id
+	CROSS JOIN LATERAL (
+		SELECT GREATEST(
+			maintenance_schedule.created_at,
+			equipment.created_at,
+			(
+				SELECT MAX(maintenance_ticket.closed_at)
+				FROM maintenance_ticket
+				WHERE maintenance_ticket.maintenance_schedule_id = maintenance_schedule.maintenance_schedule_id
+				AND maintenance_ticket.equipment_id = equipment.equipment_id
+			)
+		) AS serviced_at
+	) last_service
+	WHERE maintenance_schedule.is_active
+	AND equipment.equipment_status_id NOT IN ($1, $2)
+	AND NOT EXISTS (
+		SELECT 1
+		FROM maintenance_ticket
+		WHERE maintenance_ticket.equipment_id = equipment.equipment_id
+		AND maintenance_ticket.status = 'open'
+	)
+	AND (
+		last_service.serviced_at + make_interval(days => maintenance_schedule.interval_days) <= NOW()
+		OR (
+			SELECT COUNT(*)
+			FROM borrow_transaction
+			WHERE borrow_transaction.equipment_id = equipment.equipment_id
+			AND borrow_transaction.created_at > last_service.serviced_at
+		) >= maintenance_schedule.interval_loans
+	)
+	ORDER BY maintenance_schedule.created_at, equipment.asset_tag
+	`
+
+	rows, err := q.Query(ctx, query, lost, disposed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []dueMaintenance
+	for rows.Next() {
+		var d dueMaintenance
+		if err := rows.Scan(&d.maintenanceScheduleID, &d.unitID, &d.equipmentTypeID, &d.pullFromCirculation); err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+
+	return due, rows.Err()
+}
+
+// processMaintenanceSchedules opens a ticket for every unit that is due.
+// Each unit gets its own transaction so one failure doesn't hold up the rest
+// until the next tick.
+func (r *repository) processMaintenanceSchedules(ctx context.Context) ([]maintenanceTicket, error) {
+	due, err := getDueMaintenance(ctx, r.querier)
+	if err != nil {
+		return nil, err
+	}
+
+	var tickets []maintenanceTicket
+	for _, d := range due {
+		ticket, err := r.openScheduledMaintenance(ctx, d)
+		if err != nil {
+			if errors.Is(err, errMaintenanceTicketExists) {
+				continue
+			}
+			if ctx.Err() != nil {
+				return tickets, ctx.Err()
+			}
+
+			slog.Error("Error opening scheduled maintenance ticket", "unitId", d.unitID, "error", err)
+			continue
+		}
+		tickets = append(tickets, ticket)
+	}
+
+	return tickets, nil
+}
+
+func (r *repository) openScheduledMaintenance(ctx context.Context, due dueMaintenance) (maintenanceTicket, error) {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return maintenanceTicket{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockEquipmentType(ctx, tx, due.equipmentTypeID); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	var status equipmentStatus
+	var siteID string
+	unitQuery := "SELECT equipment_status_id, site_id FROM equipment WHERE equipment_id = $1 FOR UPDATE"
+	if err := tx.QueryRow(ctx, unitQuery, due.unitID).Scan(&status, &siteID); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	// A manager may have opened a ticket since the unit was found due
+	ticketQuery := `
+	INSERT INTO maintenance_ticket (equipment_id, description, maintenance_schedule_id)
+	SELECT $1, concat_ws(': ', name, description), maintenance_schedule_id
+	FROM maintenance_schedule
+	WHERE maintenance_schedule_id = $2
+	ON CONFLICT (equipment_id) WHERE status = 'open' DO NOTHING
+	RETURNING maintenance_ticket_id
+	`
+
+	var ticketID string
+	if err := tx.QueryRow(ctx, ticketQuery, due.unitID, due.maintenanceScheduleID).Scan(&ticketID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return maintenanceTicket{}, errMaintenanceTicketExists
+		}
+		return maintenanceTicket{}, err
+	}
+
+	if due.pullFromCirculation && status == available {
+		if err := pullFromCirculation(ctx, tx, due, siteID); err != nil {
+			return maintenanceTicket{}, err
+		}
+	}
+
+	ticket, err := getMaintenanceTicket(ctx, tx, ticketID)
+	if err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return maintenanceTicket{}, err
+	}
+
+	return ticket, nil
+}
+
+// pullFromCirculation moves a due unit to maintenance unless its site needs
+// it for upcoming bookings, in which case it stays available and the open
+// ticket is left for a manager to act on.
+func pullFromCirculation(ctx context.Context, tx pgx.Tx, due dueMaintenance, siteID string) error {
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer savepoint.Rollback(ctx)
+
+	updateQuery := `
+	UPDATE equipment
+	SET equipment_status_id = $1, updated_at = NOW()
+	WHERE equipment_id = $2
+	`
+	if _, err := savepoint.Exec(ctx, updateQuery, maintenance, due.unitID); err != nil {
+		return err
+	}
+
+	items := []borrowEquipmentItem{{EquipmentTypeID: due.equipmentTypeID}}
+	if err := checkAvailability(ctx, savepoint, siteID, items, time.Now(), endOfTime, ""); err != nil {
+		if errors.Is(err, errInsufficientEquipmentQuantity) {
+			return nil
+		}
+		return err
+	}
+
+	return savepoint.Commit(ctx)
+}
+
+// processMaintenanceSchedules runs on the background worker and lets the
+// managers know about the tickets it opened.
+func (s *Server) processMaintenanceSchedules(ctx context.Context) error {
+	tickets, err := s.repository.processMaintenanceSchedules(ctx)
+
+	for _, ticket := range tickets {
+		eventRes := sse.EventResponse{
+			Event: eventMaintenanceTicketCreate,
+			Data:  ticket,
+		}
+		jsonData, err := json.Marshal(eventRes)
+		if err != nil {
+			return fmt.Errorf("process maintenance schedules: %w", err)
+		}
+
+		pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+		if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+			slog.Error("Error publishing scheduled maintenance ticket: " + res.Error().Error())
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("process maintenance schedules: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Server) getMaintenanceSchedules(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	schedules, err := s.repository.getMaintenanceSchedules(ctx, r.URL.Query().Get("equipmentTypeId"))
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get maintenance schedules: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get maintenance schedules.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched maintenance schedules.",
+		Data:    schedules,
+	}
+}
+
+func (s *Server) createMaintenanceSchedule(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data maintenanceScheduleRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create maintenance schedule: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid create maintenance schedule request.",
+		}
+	}
+
+	if err := data.validate(); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create maintenance schedule: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid maintenance schedule.",
+		}
+	}
+
+	schedule, err := s.repository.createMaintenanceSchedule(ctx, data)
+	if err != nil {
+		return maintenanceScheduleErrorResponse("create maintenance schedule", err)
+	}
+
+	return api.Response{
+		Code:    http.StatusCreated,
+		Message: "Successfully created maintenance schedule.",
+		Data:    schedule,
+	}
+}
+
+func (s *Server) updateMaintenanceSchedule(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data maintenanceScheduleRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("update maintenance schedule: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid update maintenance schedule request.",
+		}
+	}
+
+	data.MaintenanceScheduleID = r.PathValue("id")
+
+	if err := data.validate(); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("update maintenance schedule: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid maintenance schedule.",
+		}
+	}
+
+	schedule, err := s.repository.updateMaintenanceSchedule(ctx, data)
+	if err != nil {
+		return maintenanceScheduleErrorResponse("update maintenance schedule", err)
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully updated maintenance schedule.",
+		Data:    schedule,
+	}
+}
+
+func (s *Server) deleteMaintenanceSchedule(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	if err := s.repository.deleteMaintenanceSchedule(ctx, r.PathValue("id")); err != nil {
+		return maintenanceScheduleErrorResponse("delete maintenance schedule", err)
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully deleted maintenance schedule.",
+	}
+}
+
+func maintenanceScheduleErrorResponse(op string, err error) api.Response {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusNotFound,
+			Message: "Maintenance schedule not found.",
+		}
+	case errors.Is(err, errMaintenanceScheduleExists):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "This equipment already has a maintenance schedule with that name.",
+		}
+	case errors.Is(err, errMaintenanceScheduleTarget):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusNotFound,
+			Message: "Equipment not found.",
+		}
+	default:
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusInternalServerError,
+			Message: fmt.Sprintf("Failed to %s.", op),
+		}
+	}
+}
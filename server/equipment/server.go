@@ -1,9 +1,11 @@
 package equipment
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -13,65 +15,152 @@ import (
 	"github.com/jung-kurt/gofpdf/v2"
 	"github.com/valkey-io/valkey-go"
 	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/middleware"
 	"github.com/xGihyun/hirami/sse"
 	"github.com/xGihyun/hirami/user"
+	"google.golang.org/api/gmail/v1"
 )
 
 type Server struct {
-	repository   Repository
-	valkeyClient valkey.Client
+	repository        Repository
+	valkeyClient      valkey.Client
+	gmailService      *gmail.Service
+	strikePolicy      strikePolicy
+	autoApproval      autoApprovalRules
+	detector          Detector
+	analyticsCacheTTL time.Duration
+
+	// location is where weeks and days start for reports.
+	location *time.Location
 }
 
-func NewServer(repo Repository, valkeyClient valkey.Client) *Server {
+func NewServer(repo Repository, valkeyClient valkey.Client, svc *gmail.Service) *Server {
+	strikePolicy := loadStrikePolicy()
+	location := loadManilaLocation()
+
 	return &Server{
-		repository:   repo,
-		valkeyClient: valkeyClient,
+		repository:        repo,
+		valkeyClient:      valkeyClient,
+		gmailService:      svc,
+		strikePolicy:      strikePolicy,
+		autoApproval:      loadAutoApprovalRules(strikePolicy),
+		detector:          loadDetector(repo, loadAnomalyScorer(location)),
+		analyticsCacheTTL: loadAnalyticsCacheTTL(),
+		location:          location,
 	}
 }
 
 func (s *Server) SetupRoutes(
 	mux *http.ServeMux,
 	auth func(http.Handler) http.Handler,
-	requireRole func(...user.Role) func(http.Handler) http.Handler,
+	requirePermission func(...user.Permission) func(http.Handler) http.Handler,
 ) {
-	// Equipment Management (Managers only)
-	mux.Handle("POST /equipments", auth(requireRole(user.EquipmentManager)(api.Handler(s.createEquipment))))
-	mux.Handle("PATCH /equipments/{equipmentTypeId}", auth(requireRole(user.EquipmentManager)(api.Handler(s.update))))
-	mux.Handle("POST /equipments/{equipmentTypeId}/reallocate", auth(requireRole(user.EquipmentManager)(api.Handler(s.reallocate))))
-	mux.Handle("POST /equipments/{equipmentTypeId}/increase", auth(requireRole(user.EquipmentManager)(api.Handler(s.increaseQuantity))))
-	mux.Handle("DELETE /equipments/{equipmentTypeId}", auth(requireRole(user.EquipmentManager)(api.Handler(s.deleteEquipment))))
+	// Equipment Management
+	mux.Handle("POST /equipments", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.createEquipment))))
+	mux.Handle("PATCH /equipments/{equipmentTypeId}", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.update))))
+	mux.Handle("POST /equipments/{equipmentTypeId}/reallocate", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.reallocate))))
+	mux.Handle("POST /equipments/{equipmentTypeId}/transfer", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.transferUnits))))
+	mux.Handle("POST /equipments/{equipmentTypeId}/increase", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.increaseQuantity))))
+	mux.Handle("DELETE /equipments/{equipmentTypeId}", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.deleteEquipment))))
+	mux.Handle("PATCH /equipments/{equipmentTypeId}/units/{unitId}", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.updateUnit))))
+	mux.Handle("GET /equipments/{equipmentTypeId}/labels.pdf", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.getEquipmentLabelsPDF))))
 
 	// Equipment Catalog (All authed users)
 	mux.Handle("GET /equipments", auth(api.Handler(s.getAll)))
 	mux.Handle("GET /equipments/{equipmentTypeId}", auth(api.Handler(s.getEquipmentByID)))
 	mux.Handle("GET /equipments/{equipmentTypeId}/status", auth(api.Handler(s.getEquipmentInventoryStatusByID)))
+	mux.Handle("GET /equipments/{equipmentTypeId}/units", auth(api.Handler(s.getUnits)))
+	mux.Handle("GET /equipments/{equipmentTypeId}/units/{unitId}", auth(api.Handler(s.getUnit)))
+	mux.Handle("GET /equipments/{equipmentTypeId}/availability", auth(api.Handler(s.getAvailability)))
 	mux.Handle("GET /equipment-names", auth(api.Handler(s.getEquipmentNames)))
 
 	// Borrow Requests (All authed users)
 	mux.Handle("POST /borrow-requests", auth(api.Handler(s.createBorrowRequest)))
-	mux.Handle("PATCH /borrow-requests/{id}", auth(api.Handler(s.updateBorrowRequest)))
-	mux.Handle("PATCH /review-borrow-requests", auth(requireRole(user.EquipmentManager)(api.Handler(s.reviewBorrowRequest))))
-	mux.Handle("GET /borrow-requests", auth(api.Handler(s.getBorrowRequests)))
+	mux.Handle("PATCH /borrow-requests/{id}", auth(requirePermission(user.PermissionBorrowReview)(api.Handler(s.updateBorrowRequest))))
+	mux.Handle("PUT /borrow-requests/{id}", auth(api.Handler(s.editBorrowRequest)))
+	mux.Handle("POST /borrow-requests/{id}/cancel", auth(api.Handler(s.cancelBorrowRequest)))
+	mux.Handle("POST /borrow-requests/{id}/extensions", auth(api.Handler(s.createBorrowRequestExtension)))
+	mux.Handle("GET /borrow-requests/{id}/extensions", auth(api.Handler(s.getBorrowRequestExtensions)))
+	mux.Handle("GET /borrow-request-extensions", auth(requirePermission(user.PermissionBorrowView)(api.Handler(s.getBorrowRequestExtensions))))
+	mux.Handle("PATCH /review-borrow-request-extensions", auth(requirePermission(user.PermissionBorrowReview)(api.Handler(s.reviewBorrowRequestExtension))))
+	mux.Handle("PATCH /review-borrow-requests", auth(requirePermission(user.PermissionBorrowReview)(api.Handler(s.reviewBorrowRequest))))
+	mux.Handle("POST /borrow-requests/{id}/claim", auth(requirePermission(user.PermissionBorrowFulfill)(api.Handler(s.claimBorrowRequest))))
+	mux.Handle("GET /borrow-requests", auth(requirePermission(user.PermissionBorrowView)(api.Handler(s.getBorrowRequests))))
+	mux.Handle("GET /borrow-requests/overdue", auth(requirePermission(user.PermissionBorrowView)(api.Handler(s.getOverdueBorrowRequests))))
+	mux.Handle("GET /borrow-requests/anomalies", auth(requirePermission(user.PermissionBorrowView)(api.Handler(s.getFlaggedBorrowRequests))))
+	mux.Handle("PATCH /borrow-requests/{id}/anomaly", auth(requirePermission(user.PermissionBorrowReview)(api.Handler(s.reviewAnomaly))))
 	mux.Handle("GET /borrow-requests/{id}", auth(api.Handler(s.getBorrowRequestByID)))
-	mux.Handle("GET /borrow-requests/otp/{code}", auth(api.Handler(s.getBorrowRequestByOTP)))
+	mux.Handle("GET /borrow-requests/otp/{code}", auth(requirePermission(user.PermissionBorrowFulfill)(api.Handler(s.getBorrowRequestByOTP))))
 
 	// Return Requests (All authed users)
 	mux.Handle("POST /return-requests", auth(api.Handler(s.createReturnRequest)))
-	mux.Handle("PATCH /return-requests/{id}", auth(requireRole(user.EquipmentManager)(api.Handler(s.confirmReturnRequest))))
+	mux.Handle("PATCH /return-requests/{id}", auth(requirePermission(user.PermissionBorrowFulfill)(api.Handler(s.confirmReturnRequest))))
 	mux.Handle("GET /return-requests", auth(api.Handler(s.getReturnRequests)))
 	mux.Handle("GET /return-requests/{id}", auth(api.Handler(s.getReturnRequestByID)))
-	mux.Handle("GET /return-requests/otp/{code}", auth(api.Handler(s.getReturnRequestByOTP)))
+	mux.Handle("GET /return-requests/otp/{code}", auth(requirePermission(user.PermissionBorrowFulfill)(api.Handler(s.getReturnRequestByOTP))))
 
 	// History and Stats
 	mux.Handle("GET /borrow-history", auth(api.Handler(s.getBorrowHistory)))
 	mux.Handle("GET /borrow-history/pdf", auth(api.Handler(s.getBorrowHistoryPDF)))
+	mux.Handle("GET /borrow-history/export", auth(api.Handler(s.exportBorrowHistory)))
 	mux.Handle("GET /users/{userId}/borrowed-equipments", auth(api.Handler(s.getBorrowedItems)))
 
-	// Categories (Managers only)
-	mux.Handle("POST /categories", auth(requireRole(user.EquipmentManager)(api.Handler(s.createCategory))))
-	mux.Handle("PATCH /categories/{id}", auth(requireRole(user.EquipmentManager)(api.Handler(s.updateCategory))))
+	// Strikes
+	mux.Handle("GET /users/{userId}/strikes", auth(api.Handler(s.getStrikes)))
+	mux.Handle("POST /users/{userId}/strikes/{strikeId}/waive", auth(requirePermission(user.PermissionBorrowReview)(api.Handler(s.waiveStrike))))
+
+	// Borrow Policies
+	mux.Handle("GET /borrow-policies", auth(api.Handler(s.getBorrowPolicies)))
+	mux.Handle("POST /borrow-policies", auth(requirePermission(user.PermissionBorrowReview)(api.Handler(s.createBorrowPolicy))))
+	mux.Handle("PATCH /borrow-policies/{id}", auth(requirePermission(user.PermissionBorrowReview)(api.Handler(s.updateBorrowPolicy))))
+	mux.Handle("DELETE /borrow-policies/{id}", auth(requirePermission(user.PermissionBorrowReview)(api.Handler(s.deleteBorrowPolicy))))
+
+	// Waitlist
+	mux.Handle("POST /waitlist", auth(api.Handler(s.joinWaitlist)))
+	mux.Handle("GET /waitlist", auth(requirePermission(user.PermissionBorrowView)(api.Handler(s.getWaitlist))))
+	mux.Handle("PATCH /waitlist/{id}", auth(requirePermission(user.PermissionBorrowReview)(api.Handler(s.moveWaitlistEntry))))
+	mux.Handle("DELETE /waitlist/{id}", auth(requirePermission(user.PermissionBorrowReview)(api.Handler(s.deleteWaitlistEntry))))
+	mux.Handle("DELETE /equipments/{equipmentTypeId}/waitlist", auth(requirePermission(user.PermissionBorrowReview)(api.Handler(s.clearWaitlist))))
+
+	// Categories
+	mux.Handle("POST /categories", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.createCategory))))
+	mux.Handle("PATCH /categories/{id}", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.updateCategory))))
 	mux.Handle("GET /categories", auth(api.Handler(s.getCategories)))
-	mux.Handle("DELETE /categories/{id}", auth(requireRole(user.EquipmentManager)(api.Handler(s.deleteCategory))))
+	mux.Handle("DELETE /categories/{id}", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.deleteCategory))))
+	mux.Handle("GET /categories/{id}/labels.pdf", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.getCategoryLabelsPDF))))
+
+	// Sites
+	mux.Handle("GET /sites", auth(api.Handler(s.getSites)))
+	mux.Handle("POST /sites", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.createSite))))
+	mux.Handle("PATCH /sites/{id}", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.updateSite))))
+	mux.Handle("DELETE /sites/{id}", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.deleteSite))))
+	mux.Handle("PUT /sites/{id}/managers/{userId}", auth(requirePermission(user.PermissionUsersManage)(api.Handler(s.assignSiteManager))))
+	mux.Handle("DELETE /sites/{id}/managers/{userId}", auth(requirePermission(user.PermissionUsersManage)(api.Handler(s.removeSiteManager))))
+
+	// Site transfers
+	mux.Handle("GET /site-transfers", auth(requirePermission(user.PermissionEquipmentWrite, user.PermissionReportsView)(api.Handler(s.getSiteTransfers))))
+	mux.Handle("GET /site-transfers/{id}", auth(requirePermission(user.PermissionEquipmentWrite, user.PermissionReportsView)(api.Handler(s.getSiteTransfer))))
+	mux.Handle("POST /site-transfers", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.createSiteTransfer))))
+	mux.Handle("POST /site-transfers/{id}/dispatch", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.dispatchSiteTransfer))))
+	mux.Handle("POST /site-transfers/{id}/receive", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.receiveSiteTransfer))))
+	mux.Handle("POST /site-transfers/{id}/cancel", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.cancelSiteTransfer))))
+
+	// Maintenance
+	mux.Handle("GET /maintenance-tickets", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.getMaintenanceTickets))))
+	mux.Handle("GET /maintenance-tickets/metrics", auth(requirePermission(user.PermissionEquipmentWrite, user.PermissionReportsView)(api.Handler(s.getMaintenanceMetrics))))
+	mux.Handle("GET /maintenance-tickets/{id}", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.getMaintenanceTicket))))
+	mux.Handle("POST /maintenance-tickets", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.createMaintenanceTicket))))
+	mux.Handle("PATCH /maintenance-tickets/{id}", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.updateMaintenanceTicket))))
+	mux.Handle("POST /maintenance-tickets/{id}/photos", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.addMaintenancePhotos))))
+	mux.Handle("POST /maintenance-tickets/{id}/close", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.closeMaintenanceTicket))))
+	mux.Handle("GET /maintenance-schedules", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.getMaintenanceSchedules))))
+	mux.Handle("POST /maintenance-schedules", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.createMaintenanceSchedule))))
+	mux.Handle("PATCH /maintenance-schedules/{id}", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.updateMaintenanceSchedule))))
+	mux.Handle("DELETE /maintenance-schedules/{id}", auth(requirePermission(user.PermissionEquipmentWrite)(api.Handler(s.deleteMaintenanceSchedule))))
+
+	// Analytics
+	mux.Handle("GET /analytics/utilization", auth(requirePermission(user.PermissionEquipmentWrite, user.PermissionReportsView)(api.Handler(s.getUtilization))))
+	mux.Handle("GET /analytics/forecast", auth(requirePermission(user.PermissionEquipmentWrite, user.PermissionReportsView)(api.Handler(s.getForecast))))
 }
 
 const (
@@ -171,6 +260,7 @@ func (s *Server) createEquipment(w http.ResponseWriter, r *http.Request) api.Res
 		AcquisitionDate: acquisitionDate,
 		Quantity:        uint(quantity),
 		CategoryIDs:     categoryIDs,
+		SiteID:          r.FormValue("siteId"),
 	}
 
 	if data.Name == "" {
@@ -183,6 +273,10 @@ func (s *Server) createEquipment(w http.ResponseWriter, r *http.Request) api.Res
 
 	equipment, err := s.repository.createEquipment(ctx, data)
 	if err != nil {
+		if res, ok := siteAccessErrorResponse("create equipment", err); ok {
+			return res
+		}
+
 		if errors.Is(err, ErrEquipmentAlreadyExists) {
 			return api.Response{
 				Error:   fmt.Errorf("create equipment: %w", err),
@@ -230,16 +324,36 @@ func (s *Server) createEquipment(w http.ResponseWriter, r *http.Request) api.Res
 func (s *Server) getAll(w http.ResponseWriter, r *http.Request) api.Response {
 	ctx := r.Context()
 
+	page, err := api.ParsePage(r)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get equipments: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid limit.",
+		}
+	}
+
 	name := r.URL.Query().Get("name")
 	status := r.URL.Query().Get("status")
 	search := r.URL.Query().Get("search")
+	siteID := r.URL.Query().Get("site")
 	params := getEquipmentParams{
 		name:   &name,
 		status: &status,
 		search: &search,
+		siteID: &siteID,
+		page:   page,
 	}
-	equipments, err := s.repository.getAll(ctx, params)
+	equipments, nextCursor, err := s.repository.getAll(ctx, params)
 	if err != nil {
+		if errors.Is(err, api.ErrInvalidCursor) {
+			return api.Response{
+				Error:   fmt.Errorf("get equipments: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid cursor.",
+			}
+		}
+
 		return api.Response{
 			Error:   fmt.Errorf("get equipments: %w", err),
 			Code:    http.StatusInternalServerError,
@@ -248,9 +362,10 @@ func (s *Server) getAll(w http.ResponseWriter, r *http.Request) api.Response {
 	}
 
 	return api.Response{
-		Code:    http.StatusOK,
-		Message: "Successfully fetched equipments.",
-		Data:    equipments,
+		Code:       http.StatusOK,
+		Message:    "Successfully fetched equipments.",
+		Data:       equipments,
+		NextCursor: nextCursor,
 	}
 }
 
@@ -456,6 +571,8 @@ func (s *Server) reallocate(w http.ResponseWriter, r *http.Request) api.Response
 		}
 	}
 
+	go s.offerWaitlistedEquipment(context.WithoutCancel(ctx))
+
 	return api.Response{
 		Code:    http.StatusOK,
 		Message: "Successfully reallocated equipments.",
@@ -476,6 +593,12 @@ func (s *Server) createBorrowRequest(w http.ResponseWriter, r *http.Request) api
 		}
 	}
 
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("create borrow request", err)
+	}
+	data.RequestedBy = claims.UserID
+
 	if len(data.Equipments) == 0 {
 		return api.Response{
 			Error:   fmt.Errorf("create borrow request: at least one equipment is required"),
@@ -500,32 +623,24 @@ func (s *Server) createBorrowRequest(w http.ResponseWriter, r *http.Request) api
 		}
 	}
 
-	if data.ExpectedClaimAt.Before(time.Now().Add(1 * time.Hour)) {
+	if !data.ExpectedReturnAt.After(data.ExpectedClaimAt) {
 		return api.Response{
-			Error:   fmt.Errorf("create borrow request: expected claim time must be at least 1 hour from now"),
+			Error:   fmt.Errorf("create borrow request: expected return time must be after claim time"),
 			Code:    http.StatusBadRequest,
-			Message: "Expected claim time must be at least 1 hour from now to allow for preparation.",
+			Message: "Expected return time must be after claim time.",
 		}
 	}
 
-	if data.ExpectedReturnAt.Before(data.ExpectedClaimAt.Add(1 * time.Hour)) {
-		return api.Response{
-			Error:   fmt.Errorf("create borrow request: expected return time must be at least 1 hour after claim time"),
-			Code:    http.StatusBadRequest,
-			Message: "Expected return time must be at least 1 hour after claim time.",
-		}
+	if res, suspended := s.checkSuspension(ctx, "create borrow request", data.RequestedBy); suspended {
+		return res
 	}
 
-	if data.ExpectedReturnAt.Before(time.Now().Add(2 * time.Hour)) {
-		return api.Response{
-			Error:   fmt.Errorf("create borrow request: expected return time must be at least 2 hours from now"),
-			Code:    http.StatusBadRequest,
-			Message: "Expected return time must be at least 2 hours from now to allow for preparation.",
+	res, err := s.repository.createBorrowRequest(ctx, data, s.autoApproval)
+	if err != nil {
+		if res, ok := siteAccessErrorResponse("create borrow request", err); ok {
+			return res
 		}
-	}
 
-	res, err := s.repository.createBorrowRequest(ctx, data)
-	if err != nil {
 		if errors.Is(err, errInvalidBorrowQuantity) {
 			return api.Response{
 				Error:   fmt.Errorf("create borrow request: %w", err),
@@ -538,10 +653,14 @@ func (s *Server) createBorrowRequest(w http.ResponseWriter, r *http.Request) api
 			return api.Response{
 				Error:   fmt.Errorf("create borrow request: %w", err),
 				Code:    http.StatusBadRequest,
-				Message: "Requested quantity exceeds available equipment.",
+				Message: "Requested quantity exceeds available equipment. You can join the waitlist to be offered units once they free up.",
 			}
 		}
 
+		if res, ok := policyViolationResponse("create borrow request", err); ok {
+			return res
+		}
+
 		return api.Response{
 			Error:   fmt.Errorf("create borrow request: %w", err),
 			Code:    http.StatusInternalServerError,
@@ -549,21 +668,14 @@ func (s *Server) createBorrowRequest(w http.ResponseWriter, r *http.Request) api
 		}
 	}
 
-	// NOTE: Ignore anomaly errors for now since it is not required
-	// go func() {
-	// 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	// 	defer cancel()
-	// 	if err := s.detectAnomaly(ctx, res); err != nil {
-	// 		slog.Error("Failed to detect anomaly", "error", err)
-	// 	}
-	// }()
-
-	// 	return api.Response{
-	// 		Error:   fmt.Errorf("create borrow request: %w", err),
-	// 		Code:    http.StatusInternalServerError,
-	// 		Message: "Failed to create borrow request anomaly result.",
-	// 	}
-	// }
+	// NOTE: Ignore anomaly errors since they shouldn't fail the request
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		if err := s.detectAnomaly(ctx, res); err != nil {
+			slog.Error("Failed to detect anomaly", "error", err)
+		}
+	}()
 
 	eventRes := sse.EventResponse{
 		Event: eventBorrowRequestCreate,
@@ -617,8 +729,41 @@ func (s *Server) updateBorrowRequest(w http.ResponseWriter, r *http.Request) api
 		}
 	}
 
+	// Cancelling goes through its own endpoint so reserved units are released
+	if data.Status == "cancelled" {
+		return api.Response{
+			Error:   fmt.Errorf("update borrow request: %w", errInvalidBorrowRequestStatus),
+			Code:    http.StatusBadRequest,
+			Message: "Use the cancel endpoint to cancel a borrow request.",
+		}
+	}
+
 	res, err := s.repository.updateBorrowRequest(ctx, data)
 	if err != nil {
+		if errors.Is(err, errBorrowRequestNotClaimable) {
+			return api.Response{
+				Error:   fmt.Errorf("update borrow request: %w", err),
+				Code:    http.StatusConflict,
+				Message: "Only approved borrow requests can be claimed.",
+			}
+		}
+
+		if errors.Is(err, errClaimTooEarly) {
+			return api.Response{
+				Error:   fmt.Errorf("update borrow request: %w", err),
+				Code:    http.StatusConflict,
+				Message: "This borrow request can't be claimed until closer to its claim time.",
+			}
+		}
+
+		if errors.Is(err, errInsufficientEquipmentQuantity) {
+			return api.Response{
+				Error:   fmt.Errorf("update borrow request: %w", err),
+				Code:    http.StatusConflict,
+				Message: "Not enough equipment is on hand to hand out this request.",
+			}
+		}
+
 		return api.Response{
 			Error:   fmt.Errorf("update borrow request: %w", err),
 			Code:    http.StatusInternalServerError,
@@ -669,6 +814,12 @@ func (s *Server) reviewBorrowRequest(w http.ResponseWriter, r *http.Request) api
 		}
 	}
 
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("review borrow request", err)
+	}
+	data.ReviewedBy = claims.UserID
+
 	// borrowRequestID := r.PathValue("id")
 	// if data.BorrowRequestID != borrowRequestID {
 	// 	return api.Response{
@@ -680,11 +831,63 @@ func (s *Server) reviewBorrowRequest(w http.ResponseWriter, r *http.Request) api
 
 	res, err := s.repository.reviewBorrowRequest(ctx, data)
 	if err != nil {
+		if res, ok := siteAccessErrorResponse("review borrow request", err); ok {
+			return res
+		}
+
 		if errors.Is(err, errInsufficientEquipmentQuantity) {
 			return api.Response{
 				Error:   fmt.Errorf("review borrow request: %w", err),
 				Code:    http.StatusBadRequest,
-				Message: "Requested quantity exceeds available equipment.",
+				Message: "Requested quantity exceeds available equipment. Lower the quantities to approve it partially.",
+			}
+		}
+
+		if errors.Is(err, errBorrowRequestItemNotFound) {
+			return api.Response{
+				Error:   fmt.Errorf("review borrow request: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Adjusted item is not part of this borrow request.",
+			}
+		}
+
+		if errors.Is(err, errInvalidItemAdjustment) {
+			return api.Response{
+				Error:   fmt.Errorf("review borrow request: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Approved quantity cannot exceed the requested quantity.",
+			}
+		}
+
+		if errors.Is(err, errAllItemsDropped) {
+			return api.Response{
+				Error:   fmt.Errorf("review borrow request: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "At least one item must be approved. Reject the request instead.",
+			}
+		}
+
+		if errors.Is(err, errAdjustmentNotApproved) {
+			return api.Response{
+				Error:   fmt.Errorf("review borrow request: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Item quantities can only be adjusted when approving.",
+			}
+		}
+
+		if errors.Is(err, errBorrowRequestReviewed) {
+			return api.Response{
+				Error:   fmt.Errorf("review borrow request: %w", err),
+				Code:    http.StatusConflict,
+				Message: "Only pending borrow requests can be reviewed.",
+			}
+		}
+
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("review borrow request: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Borrow request not found.",
 			}
 		}
 
@@ -717,6 +920,11 @@ func (s *Server) reviewBorrowRequest(w http.ResponseWriter, r *http.Request) api
 		}
 	}
 
+	// Lowered quantities free up units for the waitlist
+	if len(res.Adjustments) > 0 {
+		go s.offerWaitlistedEquipment(context.WithoutCancel(ctx))
+	}
+
 	return api.Response{
 		Code:    http.StatusOK,
 		Message: "Successfully reviewed borrow request.",
@@ -738,6 +946,12 @@ func (s *Server) createReturnRequest(w http.ResponseWriter, r *http.Request) api
 		}
 	}
 
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("create return request", err)
+	}
+	data.RequestedBy = claims.UserID
+
 	if len(data.Items) == 0 {
 		return api.Response{
 			Error:   fmt.Errorf("create return request: at least one equipment is required"),
@@ -758,6 +972,14 @@ func (s *Server) createReturnRequest(w http.ResponseWriter, r *http.Request) api
 
 	res, err := s.repository.createReturnRequest(ctx, data)
 	if err != nil {
+		if errors.Is(err, errNotBorrowRequestOwner) {
+			return api.Response{
+				Error:   fmt.Errorf("create return request: %w", err),
+				Code:    http.StatusForbidden,
+				Message: "You can only return equipment you borrowed.",
+			}
+		}
+
 		if errors.Is(err, errInvalidReturnQuantity) {
 			return api.Response{
 				Error:   fmt.Errorf("create return request: %w", err),
@@ -821,8 +1043,30 @@ func (s *Server) createReturnRequest(w http.ResponseWriter, r *http.Request) api
 func (s *Server) getBorrowRequests(w http.ResponseWriter, r *http.Request) api.Response {
 	ctx := r.Context()
 
-	borrowRequests, err := s.repository.getBorrowRequests(ctx)
+	page, err := api.ParsePage(r)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get borrow requests: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid limit.",
+		}
+	}
+
+	siteID := r.URL.Query().Get("site")
+	params := getBorrowRequestsParams{
+		siteID: &siteID,
+		page:   page,
+	}
+	borrowRequests, nextCursor, err := s.repository.getBorrowRequests(ctx, params)
 	if err != nil {
+		if errors.Is(err, api.ErrInvalidCursor) {
+			return api.Response{
+				Error:   fmt.Errorf("get borrow requests: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid cursor.",
+			}
+		}
+
 		return api.Response{
 			Error:   fmt.Errorf("get borrow requests: %w", err),
 			Code:    http.StatusInternalServerError,
@@ -831,18 +1075,32 @@ func (s *Server) getBorrowRequests(w http.ResponseWriter, r *http.Request) api.R
 	}
 
 	return api.Response{
-		Code:    http.StatusOK,
-		Message: "Successfully fetched borrow requests.",
-		Data:    borrowRequests,
+		Code:       http.StatusOK,
+		Message:    "Successfully fetched borrow requests.",
+		Data:       borrowRequests,
+		NextCursor: nextCursor,
 	}
 }
 
 func (s *Server) getBorrowRequestByID(w http.ResponseWriter, r *http.Request) api.Response {
 	ctx := r.Context()
 
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("get borrow request", err)
+	}
+
 	borrowRequestID := r.PathValue("id")
 	borrowRequests, err := s.repository.getBorrowRequestByID(ctx, borrowRequestID)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("get borrow request: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Borrow request not found.",
+			}
+		}
+
 		return api.Response{
 			Error:   fmt.Errorf("get borrow requests: %w", err),
 			Code:    http.StatusInternalServerError,
@@ -850,6 +1108,14 @@ func (s *Server) getBorrowRequestByID(w http.ResponseWriter, r *http.Request) ap
 		}
 	}
 
+	if !canAccessUser(claims, borrowRequests.Borrower.UserID) {
+		return api.Response{
+			Error:   fmt.Errorf("get borrow request: %w", errNotBorrowRequestOwner),
+			Code:    http.StatusForbidden,
+			Message: "You can only view your own borrow requests.",
+		}
+	}
+
 	return api.Response{
 		Code:    http.StatusOK,
 		Message: "Successfully fetched borrow requests.",
@@ -891,6 +1157,12 @@ func (s *Server) confirmReturnRequest(w http.ResponseWriter, r *http.Request) ap
 		}
 	}
 
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("confirm return request", err)
+	}
+	data.ReviewedBy = claims.UserID
+
 	returnRequestID := r.PathValue("id")
 	if strings.TrimSpace(returnRequestID) == "" {
 		return api.Response{
@@ -910,6 +1182,10 @@ func (s *Server) confirmReturnRequest(w http.ResponseWriter, r *http.Request) ap
 
 	res, err := s.repository.confirmReturnRequest(ctx, data)
 	if err != nil {
+		if res, ok := siteAccessErrorResponse("confirm return request", err); ok {
+			return res
+		}
+
 		if errors.Is(err, errReturnRequestAlreadyConfirmed) {
 			return api.Response{
 				Error:   fmt.Errorf("confirm return request: %w", err),
@@ -918,6 +1194,14 @@ func (s *Server) confirmReturnRequest(w http.ResponseWriter, r *http.Request) ap
 			}
 		}
 
+		if message, ok := scannedUnitErrorMessage(err); ok {
+			return api.Response{
+				Error:   fmt.Errorf("confirm return request: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: message,
+			}
+		}
+
 		return api.Response{
 			Error:   fmt.Errorf("confirm return request: %w", err),
 			Code:    http.StatusInternalServerError,
@@ -947,6 +1231,8 @@ func (s *Server) confirmReturnRequest(w http.ResponseWriter, r *http.Request) ap
 		}
 	}
 
+	go s.offerWaitlistedEquipment(context.WithoutCancel(ctx))
+
 	return api.Response{
 		Code:    http.StatusOK,
 		Message: "Successfully confirmed return request.",
@@ -957,16 +1243,39 @@ func (s *Server) confirmReturnRequest(w http.ResponseWriter, r *http.Request) ap
 func (s *Server) getReturnRequests(w http.ResponseWriter, r *http.Request) api.Response {
 	ctx := r.Context()
 
-	userID := r.URL.Query().Get("userId")
+	page, err := api.ParsePage(r)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get return requests: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid limit.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("get return requests", err)
+	}
+
+	userID := scopedUserID(claims, user.PermissionBorrowView, r.URL.Query().Get("userId"))
 	sort := api.Sort(r.URL.Query().Get("sort"))
 	category := r.URL.Query().Get("category")
 	params := getReturnRequestParams{
 		userID:   &userID,
 		sort:     &sort,
 		category: &category,
+		page:     page,
 	}
-	returnRequests, err := s.repository.getReturnRequests(ctx, params)
+	returnRequests, nextCursor, err := s.repository.getReturnRequests(ctx, params)
 	if err != nil {
+		if errors.Is(err, api.ErrInvalidCursor) {
+			return api.Response{
+				Error:   fmt.Errorf("get return requests: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid cursor.",
+			}
+		}
+
 		return api.Response{
 			Error:   fmt.Errorf("get return requests: %w", err),
 			Code:    http.StatusInternalServerError,
@@ -975,18 +1284,32 @@ func (s *Server) getReturnRequests(w http.ResponseWriter, r *http.Request) api.R
 	}
 
 	return api.Response{
-		Code:    http.StatusOK,
-		Message: "Successfully fetched return requests.",
-		Data:    returnRequests,
+		Code:       http.StatusOK,
+		Message:    "Successfully fetched return requests.",
+		Data:       returnRequests,
+		NextCursor: nextCursor,
 	}
 }
 
 func (s *Server) getReturnRequestByID(w http.ResponseWriter, r *http.Request) api.Response {
 	ctx := r.Context()
 
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("get return request", err)
+	}
+
 	returnRequestID := r.PathValue("id")
 	returnRequest, err := s.repository.getReturnRequestByID(ctx, returnRequestID)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("get return request: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Return request not found.",
+			}
+		}
+
 		return api.Response{
 			Error:   fmt.Errorf("get return request: %w", err),
 			Code:    http.StatusInternalServerError,
@@ -994,6 +1317,14 @@ func (s *Server) getReturnRequestByID(w http.ResponseWriter, r *http.Request) ap
 		}
 	}
 
+	if !canAccessUser(claims, returnRequest.Borrower.UserID) {
+		return api.Response{
+			Error:   fmt.Errorf("get return request: %w", errNotBorrowRequestOwner),
+			Code:    http.StatusForbidden,
+			Message: "You can only view your own return requests.",
+		}
+	}
+
 	return api.Response{
 		Code:    http.StatusOK,
 		Message: "Successfully fetched return request.",
@@ -1024,12 +1355,58 @@ func (s *Server) getReturnRequestByOTP(w http.ResponseWriter, r *http.Request) a
 func (s *Server) getBorrowHistory(w http.ResponseWriter, r *http.Request) api.Response {
 	ctx := r.Context()
 
-	userID := r.URL.Query().Get("userId")
+	page, err := api.ParsePage(r)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get borrow history: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid limit.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("get borrow history", err)
+	}
+
+	params := parseBorrowHistoryParams(r, claims)
+	params.page = page
+
+	history, nextCursor, err := s.repository.getBorrowHistory(ctx, params)
+	if err != nil {
+		if errors.Is(err, api.ErrInvalidCursor) {
+			return api.Response{
+				Error:   fmt.Errorf("get borrow history: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid cursor.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("get borrow history: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get borrow history.",
+		}
+	}
+
+	return api.Response{
+		Code:       http.StatusOK,
+		Message:    "Successfully fetched borrow history.",
+		Data:       history,
+		NextCursor: nextCursor,
+	}
+}
+
+// parseBorrowHistoryParams reads the history filters. Users who can't view
+// reports only ever get their own history, whatever user they ask for.
+func parseBorrowHistoryParams(r *http.Request, claims *middleware.UserClaims) borrowHistoryParams {
+	userID := scopedUserID(claims, user.PermissionReportsView, r.URL.Query().Get("userId"))
 	status := r.URL.Query().Get("status")
 	sort := api.Sort(r.URL.Query().Get("sort"))
 	sortBy := r.URL.Query().Get("sortBy")
 	category := r.URL.Query().Get("category")
 	search := r.URL.Query().Get("search")
+	siteID := r.URL.Query().Get("site")
 
 	var startDate, endDate *time.Time
 	if s := r.URL.Query().Get("startDate"); s != "" {
@@ -1058,27 +1435,29 @@ func (s *Server) getBorrowHistory(w http.ResponseWriter, r *http.Request) api.Re
 		startDate:    startDate,
 		endDate:      endDate,
 		equipmentIDs: equipmentIDs,
-	}
-	history, err := s.repository.getBorrowHistory(ctx, params)
-	if err != nil {
-		return api.Response{
-			Error:   fmt.Errorf("get borrow history: %w", err),
-			Code:    http.StatusInternalServerError,
-			Message: "Failed to get borrow history.",
-		}
+		siteID:       &siteID,
 	}
 
-	return api.Response{
-		Code:    http.StatusOK,
-		Message: "Successfully fetched borrow history.",
-		Data:    history,
-	}
+	return params
 }
 
 func (s *Server) getBorrowedItems(w http.ResponseWriter, r *http.Request) api.Response {
 	ctx := r.Context()
 
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("get borrowed items", err)
+	}
+
 	userID := r.PathValue("userId")
+	if !canAccessUser(claims, userID) {
+		return api.Response{
+			Error:   fmt.Errorf("get borrowed items: %w", errNotBorrowRequestOwner),
+			Code:    http.StatusForbidden,
+			Message: "You can only view your own borrowed equipment.",
+		}
+	}
+
 	status := r.URL.Query().Get("status")
 	sort := api.Sort(r.URL.Query().Get("sort"))
 	category := r.URL.Query().Get("category")
@@ -1148,6 +1527,7 @@ func (s *Server) createCategory(w http.ResponseWriter, r *http.Request) api.Resp
 		Name            string  `json:"name"`
 		BackgroundColor *string `json:"backgroundColor"`
 		ForegroundColor *string `json:"foregroundColor"`
+		IsSelfService   bool    `json:"isSelfService"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		return api.Response{
@@ -1163,7 +1543,7 @@ func (s *Server) createCategory(w http.ResponseWriter, r *http.Request) api.Resp
 			Message: "Category name is required.",
 		}
 	}
-	res, err := s.repository.createCategory(ctx, body.Name, body.BackgroundColor, body.ForegroundColor)
+	res, err := s.repository.createCategory(ctx, body.Name, body.BackgroundColor, body.ForegroundColor, body.IsSelfService)
 	if err != nil {
 		return api.Response{
 			Error:   fmt.Errorf("create category: %w", err),
@@ -1185,6 +1565,7 @@ func (s *Server) updateCategory(w http.ResponseWriter, r *http.Request) api.Resp
 		Name            string  `json:"name"`
 		BackgroundColor *string `json:"backgroundColor"`
 		ForegroundColor *string `json:"foregroundColor"`
+		IsSelfService   bool    `json:"isSelfService"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		return api.Response{
@@ -1200,7 +1581,7 @@ func (s *Server) updateCategory(w http.ResponseWriter, r *http.Request) api.Resp
 			Message: "Category name is required.",
 		}
 	}
-	res, err := s.repository.updateCategory(ctx, id, body.Name, body.BackgroundColor, body.ForegroundColor)
+	res, err := s.repository.updateCategory(ctx, id, body.Name, body.BackgroundColor, body.ForegroundColor, body.IsSelfService)
 	if err != nil {
 		return api.Response{
 			Error:   fmt.Errorf("update category: %w", err),
@@ -1250,42 +1631,14 @@ func (s *Server) deleteCategory(w http.ResponseWriter, r *http.Request) api.Resp
 
 func (s *Server) getBorrowHistoryPDF(w http.ResponseWriter, r *http.Request) api.Response {
 	ctx := r.Context()
-	userID := r.URL.Query().Get("userId")
-	status := r.URL.Query().Get("status")
-	sort := api.Sort(r.URL.Query().Get("sort"))
-	sortBy := r.URL.Query().Get("sortBy")
-	category := r.URL.Query().Get("category")
-	search := r.URL.Query().Get("search")
-
-	var startDate, endDate *time.Time
-	if s := r.URL.Query().Get("startDate"); s != "" {
-		if t, err := time.Parse(time.RFC3339, s); err == nil {
-			startDate = &t
-		}
-	}
-	if e := r.URL.Query().Get("endDate"); e != "" {
-		if t, err := time.Parse(time.RFC3339, e); err == nil {
-			endDate = &t
-		}
-	}
 
-	var equipmentIDs []string
-	if ids := r.URL.Query().Get("equipmentIds"); ids != "" {
-		equipmentIDs = strings.Split(ids, ",")
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("get borrow history", err)
 	}
 
-	params := borrowHistoryParams{
-		userID:       &userID,
-		status:       &status,
-		sort:         &sort,
-		sortBy:       &sortBy,
-		category:     &category,
-		search:       &search,
-		startDate:    startDate,
-		endDate:      endDate,
-		equipmentIDs: equipmentIDs,
-	}
-	history, err := s.repository.getBorrowHistory(ctx, params)
+	params := parseBorrowHistoryParams(r, claims)
+	history, _, err := s.repository.getBorrowHistory(ctx, params)
 	if err != nil {
 		return api.Response{
 			Error:   fmt.Errorf("get borrow history: %w", err),
@@ -1354,6 +1707,7 @@ func (s *Server) increaseQuantity(w http.ResponseWriter, r *http.Request) api.Re
 	var body struct {
 		Quantity        uint      `json:"quantity"`
 		AcquisitionDate time.Time `json:"acquisitionDate"`
+		SiteID          string    `json:"siteId"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		return api.Response{
@@ -1369,7 +1723,11 @@ func (s *Server) increaseQuantity(w http.ResponseWriter, r *http.Request) api.Re
 			Message: "Quantity must be greater than zero.",
 		}
 	}
-	if err := s.repository.increaseQuantity(ctx, id, body.Quantity, body.AcquisitionDate); err != nil {
+	if err := s.repository.increaseQuantity(ctx, id, body.Quantity, body.AcquisitionDate, body.SiteID); err != nil {
+		if res, ok := siteAccessErrorResponse("increase quantity", err); ok {
+			return res
+		}
+
 		return api.Response{
 			Error:   fmt.Errorf("increase quantity: %w", err),
 			Code:    http.StatusInternalServerError,
@@ -3,16 +3,31 @@ package equipment
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/suite"
 	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/middleware"
 	"github.com/xGihyun/hirami/testhelpers"
+	"github.com/xGihyun/hirami/user"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	testManagerID  = "00000000-0000-0000-0000-000000000000"
+	testUserHeader = "X-User-Id"
 )
 
 type TestSuite struct {
@@ -38,10 +53,39 @@ func (suite *TestSuite) SetupSuite() {
 	suite.pgContainer = pgContainer
 	suite.valkeyContainer = valkeyContainer
 
-	server := *NewServer(NewRepository(pgContainer.Pool), valkeyContainer.Client)
+	server := *NewServer(NewRepository(pgContainer.Pool), valkeyContainer.Client, nil)
+
+	// Requests act as the user in the X-User-Id header with their role's
+	// permissions, or as a manager when there's none.
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := &middleware.UserClaims{
+				UserID:      testManagerID,
+				Role:        user.EquipmentManager,
+				Permissions: user.AllPermissions(),
+			}
+			if userID := r.Header.Get(testUserHeader); userID != "" {
+				claims = &middleware.UserClaims{UserID: userID}
+				query := `
+				SELECT person_role.code, person_role.permissions
+				FROM person
+				JOIN person_role USING (person_role_id)
+				WHERE person.person_id = $1
+				`
+				err := pgContainer.Pool.QueryRow(r.Context(), query, userID).Scan(&claims.Role, &claims.Permissions)
+				if err != nil {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), middleware.UserContextKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 
 	mux := http.NewServeMux()
-	server.SetupRoutes(mux)
+	server.SetupRoutes(mux, auth, (&middleware.Middleware{}).RequirePermission)
 
 	suite.httpServer = httptest.NewServer(mux)
 }
@@ -87,6 +131,12 @@ func CreateEquipment(serverURL string, data createRequest) error {
 	if data.Model != nil {
 		writer.WriteField("model", *data.Model)
 	}
+	if data.SiteID != "" {
+		writer.WriteField("siteId", data.SiteID)
+	}
+	if len(data.CategoryIDs) > 0 {
+		writer.WriteField("categoryIds", strings.Join(data.CategoryIDs, ","))
+	}
 	writer.WriteField("quantity", "1")
 	writer.WriteField("acquisitionDate", "2025-11-26T01:42:59.367Z")
 	writer.Close()
@@ -97,5 +147,1895 @@ func CreateEquipment(serverURL string, data createRequest) error {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("create equipment %q: unexpected status %d", data.Name, resp.StatusCode)
+	}
+
 	return nil
 }
+
+func (suite *TestSuite) TestGetEquipmentsPaginated() {
+	for _, name := range []string{"Basketball", "Shuttlecock", "Table Tennis Paddle"} {
+		err := CreateEquipment(suite.httpServer.URL, createRequest{Name: name})
+		suite.Require().NoError(err)
+	}
+
+	seen := map[string]bool{}
+	cursor := ""
+	for {
+		url := suite.httpServer.URL + "/equipments?limit=1"
+		if cursor != "" {
+			url += "&cursor=" + cursor
+		}
+
+		resp, err := http.Get(url)
+		suite.Require().NoError(err)
+
+		var result struct {
+			api.Response
+			Data []equipmentWithBorrower `json:"data"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		suite.Require().NoError(err)
+
+		suite.Equal(http.StatusOK, resp.StatusCode)
+		suite.LessOrEqual(len(result.Data), 1)
+
+		for _, e := range result.Data {
+			key := e.Equipment.EquipmentTypeID + ":" + e.Equipment.Status.Code
+			suite.False(seen[key], "equipment %s returned twice", key)
+			seen[key] = true
+		}
+
+		if result.NextCursor == nil {
+			break
+		}
+		cursor = *result.NextCursor
+	}
+
+	suite.GreaterOrEqual(len(seen), 3)
+
+	resp, err := http.Get(suite.httpServer.URL + "/equipments?cursor=invalid")
+	suite.Require().NoError(err)
+	defer resp.Body.Close()
+
+	suite.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func (suite *TestSuite) TestGetAvailability() {
+	url := suite.httpServer.URL + "/equipments/00000000-0000-0000-0000-000000000000/availability"
+
+	resp, err := http.Get(url)
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Get(url + "?from=2026-01-02T00:00:00Z&to=2026-01-01T00:00:00Z")
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(url + "?slot=1m")
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	from := time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+	bookings := []booking{
+		{startAt: from, endAt: from.Add(2 * time.Hour), quantity: 1},
+		{startAt: from.Add(time.Hour), endAt: from.Add(3 * time.Hour), quantity: 2},
+		{startAt: from.Add(2 * time.Hour), endAt: from.Add(4 * time.Hour), quantity: 1},
+	}
+	suite.Equal(3, peakDemand(bookings, from, from.Add(4*time.Hour)))
+	suite.Equal(1, peakDemand(bookings, from, from.Add(time.Hour)))
+	suite.Equal(3, peakDemand(bookings, from.Add(90*time.Minute), from.Add(2*time.Hour)))
+	suite.Equal(0, peakDemand(bookings, from.Add(4*time.Hour), from.Add(5*time.Hour)))
+
+	// Back to back bookings never need their units at the same time
+	suite.Equal(2, peakDemand([]booking{
+		{startAt: from, endAt: from.Add(time.Hour), quantity: 2},
+		{startAt: from.Add(time.Hour), endAt: from.Add(2 * time.Hour), quantity: 2},
+	}, from, from.Add(2*time.Hour)))
+}
+
+func (suite *TestSuite) TestOverlappingBookings() {
+	borrower := suite.createPerson("overlap-borrower@test.local", user.Borrower)
+	manager := suite.createPerson("overlap-manager@test.local", user.EquipmentManager)
+
+	racketID := suite.createEquipmentType(createRequest{Name: "Tennis Racket"})
+	increase := `{"quantity": 1, "acquisitionDate": "2025-11-26T01:42:59.367Z"}`
+	suite.Require().Equal(
+		http.StatusOK,
+		suite.requestAs("", http.MethodPost, "/equipments/"+racketID+"/increase", increase),
+	)
+
+	claimAt := time.Now().Add(2 * time.Hour).UTC().Truncate(time.Second)
+	book := func(start, end time.Duration) int {
+		data := borrowRequestFor(racketID, 1)
+		data.ExpectedClaimAt = claimAt.Add(start)
+		data.ExpectedReturnAt = claimAt.Add(end)
+
+		code, created := suite.createBorrowRequestAs(borrower, data)
+		if code != http.StatusOK {
+			return code
+		}
+
+		review := `{"id": "` + created.BorrowRequestID + `", "status": "approved"}`
+		return suite.requestAs(manager, http.MethodPatch, "/review-borrow-requests", review)
+	}
+
+	suite.Require().Equal(http.StatusOK, book(0, 2*time.Hour))
+	suite.Require().Equal(http.StatusOK, book(time.Hour, 3*time.Hour))
+
+	// Both units are taken in the hour the two bookings overlap
+	suite.Equal(http.StatusBadRequest, book(90*time.Minute, 150*time.Minute))
+	suite.Equal(http.StatusOK, book(3*time.Hour, 4*time.Hour))
+
+	query := url.Values{}
+	query.Set("from", claimAt.Format(time.RFC3339))
+	query.Set("to", claimAt.Add(5*time.Hour).Format(time.RFC3339))
+	query.Set("slot", "1h")
+
+	var availability equipmentAvailability
+	code, _ := suite.requestDataAs(
+		borrower,
+		http.MethodGet,
+		"/equipments/"+racketID+"/availability?"+query.Encode(),
+		"",
+		&availability,
+	)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Equal(2, availability.Capacity)
+
+	available := make([]int, len(availability.Slots))
+	for i, slot := range availability.Slots {
+		available[i] = slot.Available
+	}
+	suite.Equal([]int{1, 0, 1, 1, 2}, available)
+}
+
+func (suite *TestSuite) TestConcurrentApprovals() {
+	manager := suite.createPerson("race-manager@test.local", user.EquipmentManager)
+	ballID := suite.createEquipmentType(createRequest{Name: "Squash Ball"})
+
+	// Pending requests don't hold units, so both fit until one is approved
+	var reviews []string
+	for _, email := range []string{"race-first@test.local", "race-second@test.local"} {
+		borrower := suite.createPerson(email, user.Borrower)
+		code, created := suite.createBorrowRequestAs(borrower, borrowRequestFor(ballID, 1))
+		suite.Require().Equal(http.StatusOK, code)
+		reviews = append(reviews, `{"id": "`+created.BorrowRequestID+`", "status": "approved"}`)
+	}
+
+	codes := make(chan int, len(reviews))
+	for _, review := range reviews {
+		go func() {
+			req, err := http.NewRequest(
+				http.MethodPatch,
+				suite.httpServer.URL+"/review-borrow-requests",
+				strings.NewReader(review),
+			)
+			if err != nil {
+				codes <- 0
+				return
+			}
+			req.Header.Set(testUserHeader, manager)
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				codes <- 0
+				return
+			}
+			resp.Body.Close()
+			codes <- resp.StatusCode
+		}()
+	}
+
+	counts := map[int]int{}
+	for range reviews {
+		counts[<-codes]++
+	}
+	suite.Equal(map[int]int{http.StatusOK: 1, http.StatusBadRequest: 1}, counts)
+
+	var approvedCount int
+	err := suite.pgContainer.Pool.QueryRow(
+		suite.ctx,
+		`
+		SELECT COUNT(*)
+		FROM borrow_request
+		JOIN borrow_request_item USING (borrow_request_id)
+		WHERE borrow_request_item.equipment_type_id = $1 AND borrow_request.borrow_request_status_id = $2
+		`,
+		ballID,
+		approved,
+	).Scan(&approvedCount)
+	suite.Require().NoError(err)
+	suite.Equal(1, approvedCount)
+}
+
+func (suite *TestSuite) TestEquipmentUnits() {
+	equipmentTypeID := suite.createEquipmentType(createRequest{Name: "Medicine Ball"})
+	path := "/equipments/" + equipmentTypeID + "/units"
+
+	increase := `{"quantity": 1, "acquisitionDate": "2025-11-26T01:42:59.367Z"}`
+	suite.Require().Equal(
+		http.StatusOK,
+		suite.requestAs("", http.MethodPost, "/equipments/"+equipmentTypeID+"/increase", increase),
+	)
+
+	var units []equipmentUnit
+	code, _ := suite.requestDataAs("", http.MethodGet, path+"?status=available", "", &units)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Require().Len(units, 2)
+	suite.Regexp(`^HRM-\d{6}$`, units[0].AssetTag)
+	suite.NotEqual(units[0].AssetTag, units[1].AssetTag)
+	suite.Nil(units[0].SerialNumber)
+	suite.Equal("available", units[0].Status.Code)
+
+	// Labels carry the asset tag, so that's enough to find a unit
+	var unit equipmentUnit
+	code, _ = suite.requestDataAs("", http.MethodGet, path+"/"+units[0].AssetTag, "", &unit)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Equal(units[0].UnitID, unit.UnitID)
+	suite.Empty(unit.Transactions)
+
+	code, _ = suite.requestDataAs("", http.MethodPatch, path+"/"+unit.UnitID, `{"serialNumber": " MB-0001 "}`, &unit)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Require().NotNil(unit.SerialNumber)
+	suite.Equal("MB-0001", *unit.SerialNumber)
+
+	duplicate := `{"serialNumber": "MB-0001"}`
+	suite.Equal(http.StatusConflict, suite.requestAs("", http.MethodPatch, path+"/"+units[1].UnitID, duplicate))
+
+	duplicate = `{"assetTag": "` + units[0].AssetTag + `"}`
+	suite.Equal(http.StatusConflict, suite.requestAs("", http.MethodPatch, path+"/"+units[1].UnitID, duplicate))
+
+	suite.Equal(http.StatusBadRequest, suite.requestAs("", http.MethodPatch, path+"/"+units[1].UnitID, `{"assetTag": " "}`))
+	suite.Equal(http.StatusBadRequest, suite.requestAs("", http.MethodGet, path+"?status=misplaced", ""))
+	suite.Equal(http.StatusNotFound, suite.requestAs("", http.MethodGet, path+"/HRM-999999", ""))
+}
+
+func (suite *TestSuite) TestWaitlist() {
+	claimAt := time.Now().Add(2 * time.Hour)
+	body, err := json.Marshal(joinWaitlistRequest{
+		EquipmentTypeID:  "00000000-0000-0000-0000-000000000000",
+		Quantity:         1,
+		Location:         "Gym",
+		Purpose:          "Practice",
+		ExpectedClaimAt:  claimAt,
+		ExpectedReturnAt: claimAt.Add(2 * time.Hour),
+		RequestedBy:      "00000000-0000-0000-0000-000000000000",
+	})
+	suite.Require().NoError(err)
+
+	resp, err := http.Post(suite.httpServer.URL+"/waitlist", "application/json", bytes.NewReader(body))
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Get(suite.httpServer.URL + "/waitlist")
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusOK, resp.StatusCode)
+
+	req, err := http.NewRequest(
+		http.MethodPatch,
+		suite.httpServer.URL+"/waitlist/00000000-0000-0000-0000-000000000000",
+		strings.NewReader(`{"position": 1}`),
+	)
+	suite.Require().NoError(err)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusNotFound, resp.StatusCode)
+
+	first := suite.createPerson("waitlist-first@test.local", user.Borrower)
+	waiting := suite.createPerson("waitlist-waiting@test.local", user.Borrower)
+	manager := suite.createPerson("waitlist-manager@test.local", user.EquipmentManager)
+	hoopID := suite.createEquipmentType(createRequest{Name: "Hula Hoop"})
+
+	data := borrowRequestFor(hoopID, 1)
+	code, created := suite.createBorrowRequestAs(first, data)
+	suite.Require().Equal(http.StatusOK, code)
+	review := `{"id": "` + created.BorrowRequestID + `", "status": "approved"}`
+	suite.Require().Equal(http.StatusOK, suite.requestAs(manager, http.MethodPatch, "/review-borrow-requests", review))
+
+	body, err = json.Marshal(joinWaitlistRequest{
+		EquipmentTypeID:  hoopID,
+		Quantity:         1,
+		Location:         "Gym",
+		Purpose:          "Practice",
+		ExpectedClaimAt:  data.ExpectedClaimAt.Add(30 * time.Minute),
+		ExpectedReturnAt: data.ExpectedReturnAt.Add(30 * time.Minute),
+	})
+	suite.Require().NoError(err)
+
+	var entry waitlistEntry
+	code, _ = suite.requestDataAs(waiting, http.MethodPost, "/waitlist", string(body), &entry)
+	suite.Require().Equal(http.StatusCreated, code)
+	suite.Equal(1, entry.Position)
+
+	// Freeing the only unit offers it to the borrower who is waiting for it
+	cancel := "/borrow-requests/" + created.BorrowRequestID + "/cancel"
+	suite.Require().Equal(http.StatusOK, suite.requestAs(first, http.MethodPost, cancel, `{}`))
+
+	var offeredBorrowRequestID string
+	suite.Eventually(func() bool {
+		err := suite.pgContainer.Pool.QueryRow(
+			suite.ctx,
+			"SELECT COALESCE(borrow_request_id::text, '') FROM waitlist_entry WHERE waitlist_entry_id = $1",
+			entry.WaitlistEntryID,
+		).Scan(&offeredBorrowRequestID)
+		return err == nil && offeredBorrowRequestID != ""
+	}, 5*time.Second, 50*time.Millisecond)
+
+	var offered borrowRequest
+	code, _ = suite.requestDataAs(waiting, http.MethodGet, "/borrow-requests/"+offeredBorrowRequestID, "", &offered)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Equal("pending", offered.Status.Code)
+	suite.Equal(waiting, offered.Borrower.UserID)
+
+	var queue []waitlistEntry
+	code, _ = suite.requestDataAs(manager, http.MethodGet, "/waitlist?equipmentTypeId="+hoopID, "", &queue)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Empty(queue)
+}
+
+func (suite *TestSuite) TestGetOverdueBorrowRequests() {
+	resp, err := http.Get(suite.httpServer.URL + "/borrow-requests/overdue")
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusOK, resp.StatusCode)
+
+	cfg := overdueReminderConfig{before: time.Hour, interval: 24 * time.Hour}
+	dueAt := time.Now()
+
+	_, ok := cfg.reminderStage(dueAt, dueAt.Add(-2*time.Hour))
+	suite.False(ok)
+
+	stage, _ := cfg.reminderStage(dueAt, dueAt.Add(-30*time.Minute))
+	suite.Equal(reminderStageBefore, stage)
+
+	stage, _ = cfg.reminderStage(dueAt, dueAt.Add(time.Hour))
+	suite.Equal(reminderStageDue, stage)
+
+	stage, _ = cfg.reminderStage(dueAt, dueAt.Add(50*time.Hour))
+	suite.Equal("overdue-2", stage)
+}
+
+func (suite *TestSuite) TestGetStrikes() {
+	resp, err := http.Get(suite.httpServer.URL + "/users/00000000-0000-0000-0000-000000000000/strikes")
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusOK, resp.StatusCode)
+
+	policy := strikePolicy{threshold: 2, window: 24 * time.Hour, suspension: 48 * time.Hour}
+	now := time.Now()
+
+	suite.Nil(policy.suspendedUntil([]time.Time{now.Add(-3 * 24 * time.Hour), now}))
+
+	until := policy.suspendedUntil([]time.Time{now.Add(-time.Hour), now})
+	suite.Require().NotNil(until)
+	suite.True(until.Equal(now.Add(48 * time.Hour)))
+}
+
+func (suite *TestSuite) TestBorrowPolicies() {
+	resp, err := http.Get(suite.httpServer.URL + "/borrow-policies")
+	suite.Require().NoError(err)
+
+	var result struct {
+		api.Response
+		Data []borrowPolicy `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusOK, resp.StatusCode)
+
+	hasDefault := false
+	for _, policy := range result.Data {
+		if policy.EquipmentTypeID == nil && policy.CategoryID == nil {
+			hasDefault = true
+		}
+	}
+	suite.True(hasDefault)
+
+	body := `{"name": "Invalid", "leadTimeMinutes": 60, "minLoanMinutes": 120, "maxLoanMinutes": 60}`
+	resp, err = http.Post(suite.httpServer.URL+"/borrow-policies", "application/json", strings.NewReader(body))
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	borrower := suite.createPerson("policy-borrower@test.local", user.Borrower)
+	oarID := suite.createEquipmentType(createRequest{Name: "Rowing Oar"})
+	increase := `{"quantity": 1, "acquisitionDate": "2025-11-26T01:42:59.367Z"}`
+	suite.Require().Equal(
+		http.StatusOK,
+		suite.requestAs("", http.MethodPost, "/equipments/"+oarID+"/increase", increase),
+	)
+
+	body = `{
+		"name": "Oar Limit",
+		"equipmentTypeId": "` + oarID + `",
+		"maxQuantity": 1,
+		"leadTimeMinutes": 60,
+		"minLoanMinutes": 60,
+		"maxLoanMinutes": 180,
+		"requiresApproval": true
+	}`
+	suite.Require().Equal(http.StatusCreated, suite.requestAs("", http.MethodPost, "/borrow-policies", body))
+
+	tooMany := borrowRequestFor(oarID, 2)
+	tooLong := borrowRequestFor(oarID, 1)
+	tooLong.ExpectedReturnAt = tooLong.ExpectedClaimAt.Add(4 * time.Hour)
+	tooSoon := borrowRequestFor(oarID, 1)
+	tooSoon.ExpectedClaimAt = time.Now().Add(30 * time.Minute)
+	tooSoon.ExpectedReturnAt = tooSoon.ExpectedClaimAt.Add(2 * time.Hour)
+
+	violations := map[*createBorrowRequest]string{
+		&tooMany: "Oar Limit policy: At most 1 unit(s) can be borrowed per request.",
+		&tooLong: "Oar Limit policy: Equipment can be borrowed for at most 3 hours.",
+		&tooSoon: "Oar Limit policy: Expected claim time must be at least 1 hour from now.",
+	}
+	for data, message := range violations {
+		payload, err := json.Marshal(data)
+		suite.Require().NoError(err)
+
+		code, msg := suite.requestDataAs(borrower, http.MethodPost, "/borrow-requests", string(payload), nil)
+		suite.Equal(http.StatusBadRequest, code)
+		suite.Equal(message, msg)
+	}
+
+	code, _ := suite.createBorrowRequestAs(borrower, borrowRequestFor(oarID, 1))
+	suite.Equal(http.StatusOK, code)
+
+	suite.Equal("1 hour", formatMinutes(60))
+	suite.Equal("90 minutes", formatMinutes(90))
+	suite.Equal("2 days", formatMinutes(2*24*60))
+}
+
+func (suite *TestSuite) TestAutoApproval() {
+	body := `{"name": "Self-service", "isSelfService": true}`
+	resp, err := http.Post(suite.httpServer.URL+"/categories", "application/json", strings.NewReader(body))
+	suite.Require().NoError(err)
+
+	var result struct {
+		api.Response
+		Data categoryDetail `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusCreated, resp.StatusCode)
+	suite.True(result.Data.IsSelfService)
+
+	var categories []categoryDetail
+	code, _ := suite.requestDataAs("", http.MethodGet, "/categories", "", &categories)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Contains(categories, result.Data)
+
+	// Equipment in a self-service category skips the manager's queue
+	borrower := suite.createPerson("auto-approval-borrower@test.local", user.Borrower)
+	matID := suite.createEquipmentType(createRequest{Name: "Yoga Mat", CategoryIDs: []string{result.Data.CategoryID}})
+
+	code, created := suite.createBorrowRequestAs(borrower, borrowRequestFor(matID, 1))
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Equal(approved, created.Status)
+	suite.NotNil(created.Remarks)
+
+	suite.T().Setenv("AUTO_APPROVE_MAX_QUANTITY", "2")
+	rules := loadAutoApprovalRules(strikePolicy{window: time.Hour})
+	suite.Equal(2, rules.maxQuantity)
+	suite.Equal(time.Hour, rules.strikeWindow)
+}
+
+func (suite *TestSuite) TestReviewBorrowRequestAdjustments() {
+	body := `{
+		"id": "00000000-0000-0000-0000-000000000000",
+		"reviewedBy": "00000000-0000-0000-0000-000000000000",
+		"status": "rejected",
+		"items": [{"borrowRequestItemId": "00000000-0000-0000-0000-000000000000", "quantity": 1}]
+	}`
+	req, err := http.NewRequest(http.MethodPatch, suite.httpServer.URL+"/review-borrow-requests", strings.NewReader(body))
+	suite.Require().NoError(err)
+
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	remarks := "Some balls are under maintenance."
+	res := adjustmentRemarks(&remarks, []itemAdjustment{
+		{Name: "Basketball", RequestedQuantity: 10, ApprovedQuantity: 6},
+		{Name: "Net", RequestedQuantity: 1, ApprovedQuantity: 0},
+	})
+	suite.Require().NotNil(res)
+	suite.Equal(
+		"Some balls are under maintenance.\nApproved 6 of the 10 requested unit(s) of Basketball.\nNet was removed from the request.",
+		*res,
+	)
+	suite.Equal(&remarks, adjustmentRemarks(&remarks, nil))
+
+	borrower := suite.createPerson("adjust-borrower@test.local", user.Borrower)
+	manager := suite.createPerson("adjust-manager@test.local", user.EquipmentManager)
+	ballID := suite.createEquipmentType(createRequest{Name: "Water Polo Ball"})
+	increase := `{"quantity": 2, "acquisitionDate": "2025-11-26T01:42:59.367Z"}`
+	suite.Require().Equal(
+		http.StatusOK,
+		suite.requestAs("", http.MethodPost, "/equipments/"+ballID+"/increase", increase),
+	)
+
+	code, created := suite.createBorrowRequestAs(borrower, borrowRequestFor(ballID, 3))
+	suite.Require().Equal(http.StatusOK, code)
+
+	var itemID string
+	err = suite.pgContainer.Pool.QueryRow(
+		suite.ctx,
+		"SELECT borrow_request_item_id FROM borrow_request_item WHERE borrow_request_id = $1",
+		created.BorrowRequestID,
+	).Scan(&itemID)
+	suite.Require().NoError(err)
+
+	body = `{
+		"id": "` + created.BorrowRequestID + `",
+		"status": "approved",
+		"items": [{"borrowRequestItemId": "` + itemID + `", "quantity": 2}]
+	}`
+	var reviewed reviewBorrowResponse
+	code, _ = suite.requestDataAs(manager, http.MethodPatch, "/review-borrow-requests", body, &reviewed)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Equal("approved", reviewed.Status.Code)
+	suite.Require().Len(reviewed.Adjustments, 1)
+	suite.Equal(uint(2), reviewed.Adjustments[0].ApprovedQuantity)
+	suite.Require().NotNil(reviewed.Remarks)
+	suite.Equal("Approved 2 of the 3 requested unit(s) of Water Polo Ball.", *reviewed.Remarks)
+
+	var quantity int
+	err = suite.pgContainer.Pool.QueryRow(
+		suite.ctx,
+		"SELECT quantity FROM borrow_request_item WHERE borrow_request_item_id = $1",
+		itemID,
+	).Scan(&quantity)
+	suite.Require().NoError(err)
+	suite.Equal(2, quantity)
+
+	// A request is only reviewed once, whatever the second review says
+	for _, status := range []string{"approved", "rejected"} {
+		body := `{"id": "` + created.BorrowRequestID + `", "status": "` + status + `"}`
+		suite.Equal(http.StatusConflict, suite.requestAs(manager, http.MethodPatch, "/review-borrow-requests", body), status)
+	}
+	body = `{
+		"id": "` + created.BorrowRequestID + `",
+		"status": "approved",
+		"items": [{"borrowRequestItemId": "` + itemID + `", "quantity": 1}]
+	}`
+	suite.Equal(http.StatusConflict, suite.requestAs(manager, http.MethodPatch, "/review-borrow-requests", body))
+
+	body = `{"id": "00000000-0000-0000-0000-000000000000", "status": "approved"}`
+	suite.Equal(http.StatusNotFound, suite.requestAs(manager, http.MethodPatch, "/review-borrow-requests", body))
+}
+
+func (suite *TestSuite) TestCancelBorrowRequest() {
+	url := suite.httpServer.URL + "/borrow-requests/00000000-0000-0000-0000-000000000000"
+
+	body := `{"requestedBy": "00000000-0000-0000-0000-000000000000"}`
+	resp, err := http.Post(url+"/cancel", "application/json", strings.NewReader(body))
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusNotFound, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(`{"equipments": []}`))
+	suite.Require().NoError(err)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	body = `{"id": "00000000-0000-0000-0000-000000000000", "status": "cancelled"}`
+	req, err = http.NewRequest(http.MethodPatch, url, strings.NewReader(body))
+	suite.Require().NoError(err)
+
+	resp, err = http.DefaultClient.Do(req)
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func (suite *TestSuite) TestCancelApprovedBorrowRequest() {
+	owner := suite.createPerson("cancel-owner@test.local", user.Borrower)
+	other := suite.createPerson("cancel-other@test.local", user.Borrower)
+	manager := suite.createPerson("cancel-manager@test.local", user.EquipmentManager)
+	gloveID := suite.createEquipmentType(createRequest{Name: "Boxing Glove"})
+
+	code, created := suite.createBorrowRequestAs(owner, borrowRequestFor(gloveID, 1))
+	suite.Require().Equal(http.StatusOK, code)
+
+	// Approving a request this close to its claim time reserves its units
+	_, err := suite.pgContainer.Pool.Exec(
+		suite.ctx,
+		"UPDATE borrow_request SET expected_claim_at = NOW() + INTERVAL '30 minutes' WHERE borrow_request_id = $1",
+		created.BorrowRequestID,
+	)
+	suite.Require().NoError(err)
+
+	review := `{"id": "` + created.BorrowRequestID + `", "status": "approved"}`
+	suite.Require().Equal(http.StatusOK, suite.requestAs(manager, http.MethodPatch, "/review-borrow-requests", review))
+	suite.Equal(map[string]int{"reserved": 1}, suite.unitStatuses(gloveID))
+
+	// The owner comes from the signed in user, not the body
+	cancel := "/borrow-requests/" + created.BorrowRequestID + "/cancel"
+	suite.Equal(http.StatusForbidden, suite.requestAs(other, http.MethodPost, cancel, `{"requestedBy": "`+owner+`"}`))
+	suite.Equal(map[string]int{"reserved": 1}, suite.unitStatuses(gloveID))
+
+	var cancelled updateBorrowResponse
+	code, _ = suite.requestDataAs(owner, http.MethodPost, cancel, `{}`, &cancelled)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Equal("cancelled", cancelled.Status.Code)
+	suite.Equal(map[string]int{"available": 1}, suite.unitStatuses(gloveID))
+
+	suite.Equal(http.StatusConflict, suite.requestAs(owner, http.MethodPost, cancel, `{}`))
+}
+
+func (suite *TestSuite) TestCancelReleasesUnitsAtSite() {
+	owner := suite.createPerson("release-owner@test.local", user.Borrower)
+	manager := suite.createPerson("release-manager@test.local", user.EquipmentManager)
+
+	body := `{"name": "Pool House", "building": "Aquatics Center", "room": "2"}`
+	resp, err := http.Post(suite.httpServer.URL+"/sites", "application/json", strings.NewReader(body))
+	suite.Require().NoError(err)
+
+	var created struct {
+		api.Response
+		Data site `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+	suite.Require().Equal(http.StatusCreated, resp.StatusCode)
+	poolHouseID := created.Data.SiteID
+
+	kickboardID := suite.createEquipmentType(createRequest{Name: "Kickboard"})
+	increase := `{"quantity": 1, "acquisitionDate": "2025-11-26T01:42:59.367Z"}`
+	suite.Require().Equal(http.StatusOK, suite.requestAs(manager, http.MethodPost, "/equipments/"+kickboardID+"/increase", increase))
+
+	// The first unit is reserved at the pool house for someone else, so only
+	// the second one is left at the default site
+	_, err = suite.pgContainer.Pool.Exec(suite.ctx, `
+	UPDATE equipment
+	SET site_id = $2, equipment_status_id = (SELECT equipment_status_id FROM equipment_status WHERE code = 'reserved')
+	WHERE equipment_id = (
+		SELECT equipment_id FROM equipment WHERE equipment_type_id = $1 ORDER BY asset_tag LIMIT 1
+	)
+	`, kickboardID, poolHouseID)
+	suite.Require().NoError(err)
+
+	code, borrow := suite.createBorrowRequestAs(owner, borrowRequestFor(kickboardID, 1))
+	suite.Require().Equal(http.StatusOK, code)
+	_, err = suite.pgContainer.Pool.Exec(
+		suite.ctx,
+		"UPDATE borrow_request SET expected_claim_at = NOW() + INTERVAL '30 minutes' WHERE borrow_request_id = $1",
+		borrow.BorrowRequestID,
+	)
+	suite.Require().NoError(err)
+
+	review := `{"id": "` + borrow.BorrowRequestID + `", "status": "approved"}`
+	suite.Require().Equal(http.StatusOK, suite.requestAs(manager, http.MethodPatch, "/review-borrow-requests", review))
+	suite.Equal(map[string]int{"reserved": 2}, suite.unitStatuses(kickboardID))
+
+	cancel := "/borrow-requests/" + borrow.BorrowRequestID + "/cancel"
+	suite.Require().Equal(http.StatusOK, suite.requestAs(owner, http.MethodPost, cancel, `{}`))
+
+	var poolHouseStatus string
+	err = suite.pgContainer.Pool.QueryRow(suite.ctx, `
+	SELECT equipment_status.code
+	FROM equipment
+	JOIN equipment_status USING (equipment_status_id)
+	WHERE equipment.equipment_type_id = $1 AND equipment.site_id = $2
+	`, kickboardID, poolHouseID).Scan(&poolHouseStatus)
+	suite.Require().NoError(err)
+	suite.Equal("reserved", poolHouseStatus)
+	suite.Equal(map[string]int{"available": 1, "reserved": 1}, suite.unitStatuses(kickboardID))
+}
+
+func (suite *TestSuite) TestBorrowRequestExtensions() {
+	resp, err := http.Get(suite.httpServer.URL + "/borrow-request-extensions?status=pending")
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusOK, resp.StatusCode)
+
+	body := `{"requestedBy": "00000000-0000-0000-0000-000000000000", "expectedReturnAt": "2030-01-01T00:00:00Z"}`
+	resp, err = http.Post(
+		suite.httpServer.URL+"/borrow-requests/00000000-0000-0000-0000-000000000000/extensions",
+		"application/json",
+		strings.NewReader(body),
+	)
+	suite.Require().NoError(err)
+	resp.Body.Close()
+	suite.Equal(http.StatusNotFound, resp.StatusCode)
+
+	for status, code := range map[string]int{"pending": http.StatusBadRequest, "approved": http.StatusNotFound} {
+		body := `{"id": "00000000-0000-0000-0000-000000000000", "status": "` + status + `"}`
+		req, err := http.NewRequest(
+			http.MethodPatch,
+			suite.httpServer.URL+"/review-borrow-request-extensions",
+			strings.NewReader(body),
+		)
+		suite.Require().NoError(err)
+
+		resp, err := http.DefaultClient.Do(req)
+		suite.Require().NoError(err)
+		resp.Body.Close()
+		suite.Equal(code, resp.StatusCode)
+	}
+}
+
+func (suite *TestSuite) TestExtendBorrowRequest() {
+	borrower := suite.createPerson("extend-borrower@test.local", user.Borrower)
+	manager := suite.createPerson("extend-manager@test.local", user.EquipmentManager)
+	ropeID := suite.createEquipmentType(createRequest{Name: "Climbing Rope"})
+
+	policy := `{
+		"name": "Rope Limit",
+		"equipmentTypeId": "` + ropeID + `",
+		"leadTimeMinutes": 60,
+		"minLoanMinutes": 60,
+		"maxLoanMinutes": 360,
+		"requiresApproval": true
+	}`
+	suite.Require().Equal(http.StatusCreated, suite.requestAs("", http.MethodPost, "/borrow-policies", policy))
+
+	code, created := suite.createBorrowRequestAs(borrower, borrowRequestFor(ropeID, 1))
+	suite.Require().Equal(http.StatusOK, code)
+	suite.approveForClaim(manager, created.BorrowRequestID)
+
+	path := "/borrow-requests/" + created.BorrowRequestID
+	claim := `{"units": ["` + suite.assetTags(ropeID)[0] + `"]}`
+	suite.Require().Equal(http.StatusOK, suite.requestAs(manager, http.MethodPost, path+"/claim", claim))
+
+	extend := func(returnAt time.Time) (int, borrowRequestExtension) {
+		body := `{"expectedReturnAt": "` + returnAt.Format(time.RFC3339) + `"}`
+		var extension borrowRequestExtension
+		code, _ := suite.requestDataAs(borrower, http.MethodPost, path+"/extensions", body, &extension)
+		return code, extension
+	}
+	review := func(extensionID, status string) (int, string) {
+		body := `{"id": "` + extensionID + `", "status": "` + status + `"}`
+		return suite.requestDataAs(manager, http.MethodPatch, "/review-borrow-request-extensions", body, nil)
+	}
+
+	originalReturnAt := created.ExpectedReturnAt.Truncate(time.Second)
+	extendedReturnAt := originalReturnAt.Add(time.Hour)
+
+	code, extension := extend(extendedReturnAt)
+	suite.Require().Equal(http.StatusCreated, code)
+	suite.Equal(extensionPending, extension.Status)
+
+	code, _ = extend(extendedReturnAt.Add(time.Hour))
+	suite.Equal(http.StatusConflict, code)
+
+	code, _ = review(extension.BorrowRequestExtensionID, extensionApproved)
+	suite.Require().Equal(http.StatusOK, code)
+
+	var extended borrowRequest
+	code, _ = suite.requestDataAs(borrower, http.MethodGet, path, "", &extended)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.True(extended.ExpectedReturnAt.Equal(extendedReturnAt))
+	suite.WithinDuration(created.ExpectedReturnAt, extended.OriginalReturnAt, time.Millisecond)
+
+	code, _ = review(extension.BorrowRequestExtensionID, extensionRejected)
+	suite.Equal(http.StatusConflict, code)
+
+	// Extensions can't stretch the loan past the policy's limit
+	code, _ = extend(extendedReturnAt.Add(5 * time.Hour))
+	suite.Equal(http.StatusBadRequest, code)
+
+	code, extension = extend(extendedReturnAt.Add(30 * time.Minute))
+	suite.Require().Equal(http.StatusCreated, code)
+
+	_, err := suite.pgContainer.Pool.Exec(
+		suite.ctx,
+		"UPDATE borrow_policy SET max_loan_minutes = 120 WHERE name = 'Rope Limit'",
+	)
+	suite.Require().NoError(err)
+
+	code, message := review(extension.BorrowRequestExtensionID, extensionApproved)
+	suite.Equal(http.StatusBadRequest, code)
+	suite.Equal("Rope Limit policy: Equipment can be borrowed for at most 2 hours.", message)
+}
+
+func (suite *TestSuite) createPerson(email string, role user.Role) string {
+	query := `
+	INSERT INTO person (email, password_hash, first_name, last_name, person_role_id)
+	SELECT $1, 'hash', 'Test', 'User', person_role_id
+	FROM person_role
+	WHERE code = $2
+	RETURNING person_id
+	`
+
+	var personID string
+	err := suite.pgContainer.Pool.QueryRow(suite.ctx, query, email, role).Scan(&personID)
+	suite.Require().NoError(err)
+
+	return personID
+}
+
+// requestAs sends a request as the given user and returns the status code.
+func (suite *TestSuite) requestAs(userID, method, path, body string) int {
+	req, err := http.NewRequest(method, suite.httpServer.URL+path, strings.NewReader(body))
+	suite.Require().NoError(err)
+	req.Header.Set(testUserHeader, userID)
+
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().NoError(err)
+	resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+// requestDataAs sends a request as the given user, decodes the response data
+// into dst and returns the status code along with the message.
+func (suite *TestSuite) requestDataAs(userID, method, path, body string, dst any) (int, string) {
+	req, err := http.NewRequest(method, suite.httpServer.URL+path, strings.NewReader(body))
+	suite.Require().NoError(err)
+	req.Header.Set(testUserHeader, userID)
+
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().NoError(err)
+	defer resp.Body.Close()
+
+	var result struct {
+		api.Response
+		Data json.RawMessage `json:"data"`
+	}
+	suite.Require().NoError(json.NewDecoder(resp.Body).Decode(&result))
+
+	if dst != nil && len(result.Data) > 0 {
+		suite.Require().NoError(json.Unmarshal(result.Data, dst))
+	}
+
+	return resp.StatusCode, result.Message
+}
+
+// createEquipmentType adds a new equipment type with a single unit and
+// returns its ID. Names have to be unique across the suite.
+func (suite *TestSuite) createEquipmentType(data createRequest) string {
+	err := CreateEquipment(suite.httpServer.URL, data)
+	suite.Require().NoError(err)
+
+	var equipmentTypeID string
+	err = suite.pgContainer.Pool.QueryRow(
+		suite.ctx,
+		"SELECT equipment_type_id FROM equipment_type WHERE name = $1",
+		data.Name,
+	).Scan(&equipmentTypeID)
+	suite.Require().NoError(err)
+
+	return equipmentTypeID
+}
+
+// borrowRequestFor is a request for quantity units of an equipment type,
+// claimed in two hours and returned two hours after that.
+func borrowRequestFor(equipmentTypeID string, quantity uint) createBorrowRequest {
+	claimAt := time.Now().Add(2 * time.Hour)
+	return createBorrowRequest{
+		Equipments:       []borrowEquipmentItem{{EquipmentTypeID: equipmentTypeID, Quantity: quantity}},
+		Location:         "Field",
+		Purpose:          "Practice",
+		ExpectedClaimAt:  claimAt,
+		ExpectedReturnAt: claimAt.Add(2 * time.Hour),
+	}
+}
+
+// createBorrowRequestAs files a borrow request as the given user and returns
+// the status code along with the created request.
+func (suite *TestSuite) createBorrowRequestAs(userID string, data createBorrowRequest) (int, createBorrowResponse) {
+	body, err := json.Marshal(data)
+	suite.Require().NoError(err)
+
+	req, err := http.NewRequest(http.MethodPost, suite.httpServer.URL+"/borrow-requests", bytes.NewReader(body))
+	suite.Require().NoError(err)
+	req.Header.Set(testUserHeader, userID)
+
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().NoError(err)
+	defer resp.Body.Close()
+
+	var result struct {
+		api.Response
+		Data createBorrowResponse `json:"data"`
+	}
+	suite.Require().NoError(json.NewDecoder(resp.Body).Decode(&result))
+
+	return resp.StatusCode, result.Data
+}
+
+// approveForClaim approves a pending borrow request as the manager and moves
+// its claim time close enough for the units to be handed out.
+func (suite *TestSuite) approveForClaim(manager, borrowRequestID string) {
+	review := `{"id": "` + borrowRequestID + `", "status": "approved"}`
+	suite.Require().Equal(http.StatusOK, suite.requestAs(manager, http.MethodPatch, "/review-borrow-requests", review))
+
+	_, err := suite.pgContainer.Pool.Exec(
+		suite.ctx,
+		"UPDATE borrow_request SET expected_claim_at = NOW() + INTERVAL '10 minutes' WHERE borrow_request_id = $1",
+		borrowRequestID,
+	)
+	suite.Require().NoError(err)
+}
+
+// assetTags lists the asset tags of an equipment type's units.
+func (suite *TestSuite) assetTags(equipmentTypeID string) []string {
+	rows, err := suite.pgContainer.Pool.Query(
+		suite.ctx,
+		"SELECT asset_tag FROM equipment WHERE equipment_type_id = $1 ORDER BY asset_tag",
+		equipmentTypeID,
+	)
+	suite.Require().NoError(err)
+
+	tags, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	suite.Require().NoError(err)
+
+	return tags
+}
+
+// unitStatuses counts an equipment type's units by status code.
+func (suite *TestSuite) unitStatuses(equipmentTypeID string) map[string]int {
+	rows, err := suite.pgContainer.Pool.Query(
+		suite.ctx,
+		`
+		SELECT equipment_status.code, COUNT(*)::int
+		FROM equipment
+		JOIN equipment_status USING (equipment_status_id)
+		WHERE equipment.equipment_type_id = $1
+		GROUP BY equipment_status.code
+		`,
+		equipmentTypeID,
+	)
+	suite.Require().NoError(err)
+	defer rows.Close()
+
+	statuses := map[string]int{}
+	for rows.Next() {
+		var code string
+		var count int
+		suite.Require().NoError(rows.Scan(&code, &count))
+		statuses[code] = count
+	}
+	suite.Require().NoError(rows.Err())
+
+	return statuses
+}
+
+func (suite *TestSuite) TestClaimBorrowRequest() {
+	borrower := suite.createPerson("claim-borrower@test.local", user.Borrower)
+	manager := suite.createPerson("claim-manager@test.local", user.EquipmentManager)
+
+	batID := suite.createEquipmentType(createRequest{Name: "Baseball Bat"})
+	coneID := suite.createEquipmentType(createRequest{Name: "Pylon Cone"})
+	batTag := suite.assetTags(batID)[0]
+	coneTag := suite.assetTags(coneID)[0]
+
+	code, created := suite.createBorrowRequestAs(borrower, borrowRequestFor(batID, 1))
+	suite.Require().Equal(http.StatusOK, code)
+	suite.approveForClaim(manager, created.BorrowRequestID)
+
+	path := "/borrow-requests/" + created.BorrowRequestID + "/claim"
+
+	// Nothing is handed out unless the scanned units match the request
+	rejected := []string{
+		`{"units": []}`,
+		`{"units": ["HRM-999999"]}`,
+		`{"units": ["` + coneTag + `"]}`,
+		`{"units": ["` + batTag + `", "` + batTag + `"]}`,
+		`{"units": ["` + batTag + `", "` + coneTag + `"]}`,
+	}
+	for _, body := range rejected {
+		suite.Equal(http.StatusBadRequest, suite.requestAs(manager, http.MethodPost, path, body), body)
+	}
+	suite.Equal(map[string]int{"available": 1}, suite.unitStatuses(batID))
+
+	var claimed claimBorrowResponse
+	code, _ = suite.requestDataAs(manager, http.MethodPost, path, `{"units": [" `+batTag+` "]}`, &claimed)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Equal("claimed", claimed.Status.Code)
+	suite.Require().Len(claimed.Units, 1)
+	suite.Equal(batTag, claimed.Units[0].AssetTag)
+	suite.Equal(map[string]int{"borrowed": 1}, suite.unitStatuses(batID))
+	suite.Equal(map[string]int{"available": 1}, suite.unitStatuses(coneID))
+
+	suite.Equal(http.StatusConflict, suite.requestAs(manager, http.MethodPost, path, `{"units": ["`+batTag+`"]}`))
+}
+
+// createCategory adds a category and returns its ID.
+func (suite *TestSuite) createCategory(body string) string {
+	var category categoryDetail
+	code, _ := suite.requestDataAs("", http.MethodPost, "/categories", body, &category)
+	suite.Require().Equal(http.StatusCreated, code)
+
+	return category.CategoryID
+}
+
+// download fetches a file as the given user and returns the status code
+// along with its content type and contents.
+func (suite *TestSuite) download(userID, path string) (int, string, []byte) {
+	req, err := http.NewRequest(http.MethodGet, suite.httpServer.URL+path, nil)
+	suite.Require().NoError(err)
+	req.Header.Set(testUserHeader, userID)
+
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().NoError(err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	suite.Require().NoError(err)
+
+	return resp.StatusCode, resp.Header.Get("Content-Type"), body
+}
+
+func (suite *TestSuite) TestUnitLabels() {
+	borrower := suite.createPerson("labels-borrower@test.local", user.Borrower)
+
+	categoryID := suite.createCategory(`{"name": "Hockey"}`)
+	stickID := suite.createEquipmentType(createRequest{Name: "Hockey Stick"})
+	stickTag := suite.assetTags(stickID)[0]
+
+	_, err := suite.pgContainer.Pool.Exec(
+		suite.ctx,
+		"INSERT INTO equipment_type_category (equipment_type_id, category_id) VALUES ($1, $2)",
+		stickID,
+		categoryID,
+	)
+	suite.Require().NoError(err)
+
+	paths := []string{
+		"/equipments/" + stickID + "/labels.pdf",
+		"/equipments/" + stickID + "/labels.pdf?unitIds=" + stickTag,
+		"/categories/" + categoryID + "/labels.pdf",
+	}
+	for _, path := range paths {
+		code, contentType, pdf := suite.download("", path)
+		suite.Require().Equal(http.StatusOK, code, path)
+		suite.Equal("application/pdf", contentType)
+		suite.True(bytes.HasPrefix(pdf, []byte("%PDF-")), path)
+	}
+
+	code, _, _ := suite.download("", "/equipments/"+stickID+"/labels.pdf?unitIds=HRM-999999")
+	suite.Equal(http.StatusNotFound, code)
+
+	emptyCategoryID := suite.createCategory(`{"name": "Unlabeled"}`)
+	code, _, _ = suite.download("", "/categories/"+emptyCategoryID+"/labels.pdf")
+	suite.Equal(http.StatusNotFound, code)
+
+	code, _, _ = suite.download(borrower, "/equipments/"+stickID+"/labels.pdf")
+	suite.Equal(http.StatusForbidden, code)
+}
+
+func (suite *TestSuite) TestExportBorrowHistory() {
+	borrower := suite.createPerson("export-borrower@test.local", user.Borrower)
+	other := suite.createPerson("export-other@test.local", user.Borrower)
+
+	ropeID := suite.createEquipmentType(createRequest{Name: "Skipping Rope"})
+	ladderID := suite.createEquipmentType(createRequest{Name: "Agility Ladder"})
+
+	data := borrowRequestFor(ropeID, 1)
+	data.Equipments = append(data.Equipments, borrowEquipmentItem{EquipmentTypeID: ladderID, Quantity: 1})
+	code, _ := suite.createBorrowRequestAs(borrower, data)
+	suite.Require().Equal(http.StatusOK, code)
+
+	// Someone else's history never ends up in a borrower's export
+	data = borrowRequestFor(ropeID, 1)
+	data.ExpectedClaimAt = data.ExpectedClaimAt.Add(24 * time.Hour)
+	data.ExpectedReturnAt = data.ExpectedReturnAt.Add(24 * time.Hour)
+	code, _ = suite.createBorrowRequestAs(other, data)
+	suite.Require().Equal(http.StatusOK, code)
+
+	code, contentType, body := suite.download(borrower, "/borrow-history/export?userId="+other)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Equal("text/csv", contentType)
+
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	suite.Require().NoError(err)
+	suite.Require().Len(records, 3)
+	suite.Equal(borrowHistoryExportHeader, records[0])
+	for _, record := range records[1:] {
+		suite.Equal("export-borrower@test.local", record[3])
+	}
+
+	code, contentType, body = suite.download(borrower, "/borrow-history/export?format=xlsx")
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Equal("application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", contentType)
+
+	file, err := excelize.OpenReader(bytes.NewReader(body))
+	suite.Require().NoError(err)
+	defer file.Close()
+
+	rows, err := file.GetRows("Borrow History")
+	suite.Require().NoError(err)
+	suite.Require().Len(rows, 3)
+	suite.Equal(borrowHistoryExportHeader, rows[0])
+
+	code, _, _ = suite.download(borrower, "/borrow-history/export?format=pdf")
+	suite.Equal(http.StatusBadRequest, code)
+}
+
+func (suite *TestSuite) TestCrossUserAccess() {
+	owner := suite.createPerson("owner@test.local", user.Borrower)
+	other := suite.createPerson("other@test.local", user.Borrower)
+
+	equipmentTypeID := suite.createEquipmentType(createRequest{Name: "Frisbee"})
+
+	borrow := borrowRequestFor(equipmentTypeID, 1)
+	borrow.RequestedBy = other
+	body, err := json.Marshal(borrow)
+	suite.Require().NoError(err)
+
+	code, created := suite.createBorrowRequestAs(owner, borrow)
+	suite.Require().Equal(http.StatusOK, code)
+
+	// The borrower comes from the session, not the body
+	suite.Equal(owner, created.Borrower.UserID)
+	borrowRequestID := created.BorrowRequestID
+
+	var borrowRequestItemID string
+	err = suite.pgContainer.Pool.QueryRow(
+		suite.ctx,
+		"SELECT borrow_request_item_id FROM borrow_request_item WHERE borrow_request_id = $1",
+		borrowRequestID,
+	).Scan(&borrowRequestItemID)
+	suite.Require().NoError(err)
+
+	suite.Equal(http.StatusOK, suite.requestAs(owner, http.MethodGet, "/borrow-requests/"+borrowRequestID, ""))
+
+	forbidden := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodGet, "/borrow-requests/" + borrowRequestID, ""},
+		{http.MethodPut, "/borrow-requests/" + borrowRequestID, string(body)},
+		{http.MethodPost, "/borrow-requests/" + borrowRequestID + "/cancel", "{}"},
+		{http.MethodPost, "/borrow-requests/" + borrowRequestID + "/extensions", `{"expectedReturnAt": "2030-01-01T00:00:00Z"}`},
+		{http.MethodGet, "/borrow-requests", ""},
+		{http.MethodPatch, "/review-borrow-requests", `{"id": "` + borrowRequestID + `", "status": "approved"}`},
+		{http.MethodPost, "/return-requests", `{"items": [{"borrowRequestItemId": "` + borrowRequestItemID + `", "quantity": 1}]}`},
+		{http.MethodGet, "/users/" + owner + "/borrowed-equipments", ""},
+		{http.MethodGet, "/users/" + owner + "/strikes", ""},
+	}
+	for _, tc := range forbidden {
+		suite.Equal(http.StatusForbidden, suite.requestAs(other, tc.method, tc.path, tc.body), tc.method+" "+tc.path)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, suite.httpServer.URL+"/borrow-history?userId="+owner, nil)
+	suite.Require().NoError(err)
+	req.Header.Set(testUserHeader, other)
+
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().NoError(err)
+
+	var history struct {
+		api.Response
+		Data []borrowRequest `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&history)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusOK, resp.StatusCode)
+	suite.Empty(history.Data)
+}
+
+func (suite *TestSuite) TestRolePermissions() {
+	auditor := suite.createPerson("auditor@test.local", user.Auditor)
+	assistant := suite.createPerson("assistant@test.local", user.StudentAssistant)
+
+	review := `{"id": "00000000-0000-0000-0000-000000000000", "status": "approved"}`
+
+	// Auditors can see everything but change nothing
+	suite.Equal(http.StatusOK, suite.requestAs(auditor, http.MethodGet, "/borrow-requests", ""))
+	suite.Equal(http.StatusOK, suite.requestAs(auditor, http.MethodGet, "/borrow-history", ""))
+	suite.Equal(http.StatusForbidden, suite.requestAs(auditor, http.MethodPatch, "/review-borrow-requests", review))
+	suite.Equal(http.StatusForbidden, suite.requestAs(auditor, http.MethodGet, "/borrow-requests/otp/000000", ""))
+	suite.Equal(http.StatusForbidden, suite.requestAs(auditor, http.MethodPost, "/categories", `{"name": "Audit"}`))
+
+	// Student assistants hand out equipment but can't edit the catalog
+	suite.NotEqual(http.StatusForbidden, suite.requestAs(assistant, http.MethodGet, "/borrow-requests/otp/000000", ""))
+	suite.Equal(http.StatusOK, suite.requestAs(assistant, http.MethodGet, "/borrow-requests", ""))
+	suite.Equal(http.StatusForbidden, suite.requestAs(assistant, http.MethodPatch, "/review-borrow-requests", review))
+	suite.Equal(http.StatusForbidden, suite.requestAs(assistant, http.MethodPost, "/categories", `{"name": "Assist"}`))
+	suite.Equal(http.StatusForbidden, suite.requestAs(assistant, http.MethodDelete, "/equipments/00000000-0000-0000-0000-000000000000", ""))
+}
+
+func (suite *TestSuite) TestSites() {
+	borrower := suite.createPerson("site-borrower@test.local", user.Borrower)
+	manager := suite.createPerson("site-manager@test.local", user.EquipmentManager)
+
+	body := `{"name": "Annex", "building": "Annex Building", "room": "101"}`
+	resp, err := http.Post(suite.httpServer.URL+"/sites", "application/json", strings.NewReader(body))
+	suite.Require().NoError(err)
+
+	var created struct {
+		api.Response
+		Data site `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+	suite.Require().Equal(http.StatusCreated, resp.StatusCode)
+	annexID := created.Data.SiteID
+
+	equipmentTypeID := suite.createEquipmentType(createRequest{Name: "Shot Put", SiteID: annexID})
+
+	// The only unit is kept at the annex, so the default site has none
+	borrow := borrowRequestFor(equipmentTypeID, 1)
+	code, _ := suite.createBorrowRequestAs(borrower, borrow)
+	suite.Equal(http.StatusBadRequest, code)
+
+	borrow.SiteID = annexID
+	code, result := suite.createBorrowRequestAs(borrower, borrow)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Equal(annexID, result.Site.SiteID)
+
+	if result.Status == pending {
+		review := `{"id": "` + result.BorrowRequestID + `", "status": "approved"}`
+		suite.Equal(http.StatusOK, suite.requestAs(manager, http.MethodPatch, "/review-borrow-requests", review))
+	}
+
+	resp, err = http.Get(suite.httpServer.URL + "/equipments?site=" + annexID)
+	suite.Require().NoError(err)
+
+	var catalog struct {
+		api.Response
+		Data []equipmentWithBorrower `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&catalog)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+	suite.Require().Len(catalog.Data, 1)
+	suite.Equal(equipmentTypeID, catalog.Data[0].Equipment.EquipmentTypeID)
+
+	// The annex still needs its unit for the request above
+	transfer := `{"fromSiteId": "` + annexID + `", "quantity": 1}`
+	suite.Equal(
+		http.StatusConflict,
+		suite.requestAs(manager, http.MethodPost, "/equipments/"+equipmentTypeID+"/transfer", transfer),
+	)
+
+	suite.Equal(http.StatusConflict, suite.requestAs(manager, http.MethodDelete, "/sites/"+annexID, ""))
+}
+
+func (suite *TestSuite) TestSiteTransfers() {
+	manager := suite.createPerson("transfer-manager@test.local", user.EquipmentManager)
+
+	body := `{"name": "Gym Storage"}`
+	resp, err := http.Post(suite.httpServer.URL+"/sites", "application/json", strings.NewReader(body))
+	suite.Require().NoError(err)
+
+	var created struct {
+		api.Response
+		Data site `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+	suite.Require().Equal(http.StatusCreated, resp.StatusCode)
+	gymID := created.Data.SiteID
+
+	equipmentTypeID := suite.createEquipmentType(createRequest{Name: "Hurdle"})
+
+	increase := `{"quantity": 1, "acquisitionDate": "` + time.Now().Format(time.RFC3339) + `"}`
+	suite.Require().Equal(
+		http.StatusOK,
+		suite.requestAs(manager, http.MethodPost, "/equipments/"+equipmentTypeID+"/increase", increase),
+	)
+
+	// transferAs sends a transfer step and decodes the transfer it returns
+	transferAs := func(method, path, body string) (int, siteTransfer) {
+		req, err := http.NewRequest(method, suite.httpServer.URL+path, strings.NewReader(body))
+		suite.Require().NoError(err)
+		req.Header.Set(testUserHeader, manager)
+
+		resp, err := http.DefaultClient.Do(req)
+		suite.Require().NoError(err)
+		defer resp.Body.Close()
+
+		var result struct {
+			api.Response
+			Data siteTransfer `json:"data"`
+		}
+		suite.Require().NoError(json.NewDecoder(resp.Body).Decode(&result))
+
+		return resp.StatusCode, result.Data
+	}
+
+	request := `{"equipmentTypeId": "` + equipmentTypeID + `", "toSiteId": "` + gymID + `", "quantity": 2}`
+	code, transfer := transferAs(http.MethodPost, "/site-transfers", request)
+	suite.Require().Equal(http.StatusCreated, code)
+	suite.Equal(siteTransferRequested, transfer.Status)
+	suite.Equal(gymID, transfer.ToSite.SiteID)
+
+	code, transfer = transferAs(http.MethodPost, "/site-transfers/"+transfer.SiteTransferID+"/dispatch", `{}`)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Equal(siteTransferDispatched, transfer.Status)
+	suite.Require().Len(transfer.Units, 2)
+
+	// Units on their way can't be booked at either site
+	var inTransitCount int
+	err = suite.pgContainer.Pool.QueryRow(
+		suite.ctx,
+		"SELECT COUNT(*) FROM equipment WHERE equipment_type_id = $1 AND equipment_status_id = $2",
+		equipmentTypeID,
+		inTransit,
+	).Scan(&inTransitCount)
+	suite.Require().NoError(err)
+	suite.Equal(2, inTransitCount)
+
+	code, _ = transferAs(http.MethodPost, "/site-transfers/"+transfer.SiteTransferID+"/cancel", `{}`)
+	suite.Equal(http.StatusConflict, code)
+
+	// Only one of the two units shows up
+	receive := `{"unitIds": ["` + transfer.Units[0].AssetTag + `"], "remarks": "One hurdle is missing."}`
+	code, transfer = transferAs(http.MethodPost, "/site-transfers/"+transfer.SiteTransferID+"/receive", receive)
+	suite.Require().Equal(http.StatusOK, code)
+	suite.Equal(siteTransferReceived, transfer.Status)
+	suite.True(transfer.HasDiscrepancy)
+
+	var gymCount int
+	err = suite.pgContainer.Pool.QueryRow(
+		suite.ctx,
+		"SELECT COUNT(*) FROM equipment WHERE equipment_type_id = $1 AND equipment_status_id = $2 AND site_id = $3",
+		equipmentTypeID,
+		available,
+		gymID,
+	).Scan(&gymCount)
+	suite.Require().NoError(err)
+	suite.Equal(1, gymCount)
+
+	resp, err = http.Get(suite.httpServer.URL + "/site-transfers?site=" + gymID)
+	suite.Require().NoError(err)
+
+	var trail struct {
+		api.Response
+		Data []siteTransfer `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&trail)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+	suite.Require().Len(trail.Data, 1)
+	suite.Equal(transfer.SiteTransferID, trail.Data[0].SiteTransferID)
+}
+
+func (suite *TestSuite) TestMaintenanceTickets() {
+	manager := suite.createPerson("maintenance-manager@test.local", user.EquipmentManager)
+
+	equipmentTypeID := suite.createEquipmentType(createRequest{Name: "Javelin"})
+
+	var assetTag string
+	err := suite.pgContainer.Pool.QueryRow(
+		suite.ctx,
+		"SELECT asset_tag FROM equipment WHERE equipment_type_id = $1",
+		equipmentTypeID,
+	).Scan(&assetTag)
+	suite.Require().NoError(err)
+
+	// openTicket sends the multipart form used to open a ticket
+	openTicket := func() (int, maintenanceTicket) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("unitId", assetTag)
+		writer.WriteField("description", "Bent tip")
+		writer.WriteField("cost", "150.50")
+		suite.Require().NoError(writer.Close())
+
+		req, err := http.NewRequest(http.MethodPost, suite.httpServer.URL+"/maintenance-tickets", body)
+		suite.Require().NoError(err)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set(testUserHeader, manager)
+
+		resp, err := http.DefaultClient.Do(req)
+		suite.Require().NoError(err)
+		defer resp.Body.Close()
+
+		var result struct {
+			api.Response
+			Data maintenanceTicket `json:"data"`
+		}
+		suite.Require().NoError(json.NewDecoder(resp.Body).Decode(&result))
+
+		return resp.StatusCode, result.Data
+	}
+
+	// The unit is still available, so there is nothing to fix yet
+	code, _ := openTicket()
+	suite.Equal(http.StatusConflict, code)
+
+	reallocate := `{"id": "` + equipmentTypeID + `", "quantity": 1, "oldStatus": "available", "newStatus": "damaged"}`
+	suite.Require().Equal(
+		http.StatusOK,
+		suite.requestAs(manager, http.MethodPost, "/equipments/"+equipmentTypeID+"/reallocate", reallocate),
+	)
+
+	code, ticket := openTicket()
+	suite.Require().Equal(http.StatusCreated, code)
+	suite.Equal(maintenanceTicketOpen, ticket.Status)
+	suite.Equal(assetTag, ticket.Unit.AssetTag)
+	suite.Require().NotNil(ticket.Cost)
+	suite.InDelta(150.50, *ticket.Cost, 0.001)
+
+	code, _ = openTicket()
+	suite.Equal(http.StatusConflict, code)
+
+	resp, err := http.Get(suite.httpServer.URL + "/maintenance-tickets?status=open&equipmentTypeId=" + equipmentTypeID)
+	suite.Require().NoError(err)
+
+	var open struct {
+		api.Response
+		Data []maintenanceTicket `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&open)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+	suite.Require().Len(open.Data, 1)
+	suite.Equal(ticket.MaintenanceTicketID, open.Data[0].MaintenanceTicketID)
+
+	closeTicket := `{"outcome": "repaired", "resolution": "Straightened the tip."}`
+	suite.Equal(
+		http.StatusOK,
+		suite.requestAs(manager, http.MethodPost, "/maintenance-tickets/"+ticket.MaintenanceTicketID+"/close", closeTicket),
+	)
+	suite.Equal(
+		http.StatusConflict,
+		suite.requestAs(manager, http.MethodPost, "/maintenance-tickets/"+ticket.MaintenanceTicketID+"/close", closeTicket),
+	)
+
+	var statusID int
+	err = suite.pgContainer.Pool.QueryRow(
+		suite.ctx,
+		"SELECT equipment_status_id FROM equipment WHERE asset_tag = $1",
+		assetTag,
+	).Scan(&statusID)
+	suite.Require().NoError(err)
+	suite.Equal(int(available), statusID)
+
+	resp, err = http.Get(suite.httpServer.URL + "/maintenance-tickets/metrics?equipmentTypeId=" + equipmentTypeID)
+	suite.Require().NoError(err)
+
+	var metrics struct {
+		api.Response
+		Data maintenanceMetrics `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&metrics)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+	suite.Equal(0, metrics.Data.OpenCount)
+	suite.Equal(1, metrics.Data.RepairedCount)
+	suite.NotNil(metrics.Data.AverageRepairHours)
+}
+
+func (suite *TestSuite) TestMaintenanceSchedules() {
+	equipmentTypeID := suite.createEquipmentType(createRequest{Name: "Badminton Net"})
+
+	var unitID string
+	err := suite.pgContainer.Pool.QueryRow(
+		suite.ctx,
+		"SELECT equipment_id FROM equipment WHERE equipment_type_id = $1",
+		equipmentTypeID,
+	).Scan(&unitID)
+	suite.Require().NoError(err)
+
+	body := `{"equipmentTypeId": "` + equipmentTypeID + `", "name": "Inspection", "intervalDays": 7, "pullFromCirculation": true, "isActive": true}`
+	resp, err := http.Post(suite.httpServer.URL+"/maintenance-schedules", "application/json", strings.NewReader(body))
+	suite.Require().NoError(err)
+
+	var created struct {
+		api.Response
+		Data maintenanceSchedule `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+	suite.Require().Equal(http.StatusCreated, resp.StatusCode)
+
+	repo := NewRepository(suite.pgContainer.Pool)
+
+	tickets, err := repo.processMaintenanceSchedules(suite.ctx)
+	suite.Require().NoError(err)
+	suite.Empty(tickets)
+
+	// Pretend a week went by since the net and its schedule were added
+	_, err = suite.pgContainer.Pool.Exec(
+		suite.ctx,
+		"UPDATE maintenance_schedule SET created_at = NOW() - INTERVAL '8 days' WHERE maintenance_schedule_id = $1",
+		created.Data.MaintenanceScheduleID,
+	)
+	suite.Require().NoError(err)
+	_, err = suite.pgContainer.Pool.Exec(
+		suite.ctx,
+		"UPDATE equipment SET created_at = NOW() - INTERVAL '8 days' WHERE equipment_id = $1",
+		unitID,
+	)
+	suite.Require().NoError(err)
+
+	tickets, err = repo.processMaintenanceSchedules(suite.ctx)
+	suite.Require().NoError(err)
+	suite.Require().Len(tickets, 1)
+	suite.Equal(unitID, tickets[0].Unit.UnitID)
+	suite.Require().NotNil(tickets[0].ScheduleID)
+	suite.Equal(created.Data.MaintenanceScheduleID, *tickets[0].ScheduleID)
+	suite.Equal("maintenance", tickets[0].Unit.Status.Code)
+
+	// The open ticket keeps the unit from coming up again
+	tickets, err = repo.processMaintenanceSchedules(suite.ctx)
+	suite.Require().NoError(err)
+	suite.Empty(tickets)
+}
+
+func (suite *TestSuite) TestAnomalyScorer() {
+	scorer := anomalyScorer{location: time.UTC, threshold: defaultAnomalyThreshold}
+
+	var history []borrowFeatures
+	for i := range 60 {
+		history = append(history, borrowFeatures{
+			quantity:       float64(2 + i%4),
+			itemTypes:      float64(1 + i%2),
+			loanHours:      float64(2 + i%3),
+			requestHour:    float64(9 + i%6),
+			recentRequests: float64(i % 3),
+		})
+	}
+
+	normal := borrowFeatures{quantity: 3, itemTypes: 1, loanHours: 3, requestHour: 11, recentRequests: 1}
+	_, isAnomaly := scorer.score(normal, history)
+	suite.False(isAnomaly)
+
+	bulk := normal
+	bulk.quantity = 80
+	score, isAnomaly := scorer.score(bulk, history)
+	suite.True(isAnomaly)
+	suite.LessOrEqual(score, float64(maxAnomalyScore))
+
+	lateNight := normal
+	lateNight.requestHour = 2
+	_, isAnomaly = scorer.score(lateNight, history)
+	suite.True(isAnomaly)
+
+	// Too little history falls back to the built-in baselines
+	_, isAnomaly = scorer.score(normal, history[:5])
+	suite.False(isAnomaly)
+}
+
+type stubDetector struct {
+	calls  int
+	result anomaly
+}
+
+func (d *stubDetector) Detect(ctx context.Context, arg createBorrowResponse) (anomaly, error) {
+	d.calls++
+	return d.result, nil
+}
+
+func (suite *TestSuite) TestRemoteDetector() {
+	manila, err := time.LoadLocation("Asia/Manila")
+	suite.Require().NoError(err)
+
+	arg := createBorrowResponse{
+		BorrowRequestID:  "7f1c2a9e-3b8d-4a51-9c7e-2d4f6b8a0e13",
+		CreatedAt:        time.Date(2026, 10, 16, 1, 30, 0, 0, time.UTC),
+		Borrower:         user.BasicInfo{UserID: testManagerID, FirstName: "Juan", LastName: "Dela Cruz"},
+		Equipments:       []equipment{{EquipmentTypeID: "b0a1d6f2-6c41-4f0e-8b7a-5e2c9d3f1a47", Name: "Basketball", Quantity: 2}},
+		Location:         "Gym",
+		Purpose:          "PE class",
+		ExpectedReturnAt: time.Date(2026, 10, 16, 5, 30, 0, 0, time.UTC),
+		Status:           pending,
+	}
+
+	// Mirrors the request and response models of the service in /ml
+	var failures int
+	standIn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.Equal("/anomalies", r.URL.Path)
+
+		var transactions []struct {
+			BorrowRequestID  string `json:"borrowRequestId"`
+			CreatedAt        string `json:"createdAt"`
+			ExpectedReturnAt string `json:"expectedReturnAt"`
+			Borrower         struct {
+				ID         string  `json:"id"`
+				FirstName  string  `json:"firstName"`
+				MiddleName *string `json:"middleName"`
+				LastName   string  `json:"lastName"`
+				AvatarURL  *string `json:"avatarUrl"`
+			} `json:"borrower"`
+			Equipments []struct {
+				ID       string `json:"id"`
+				Name     string `json:"name"`
+				Quantity int    `json:"quantity"`
+			} `json:"equipments"`
+			Location string `json:"location"`
+			Purpose  string `json:"purpose"`
+		}
+		suite.Require().NoError(json.NewDecoder(r.Body).Decode(&transactions))
+		suite.Require().Len(transactions, 1)
+
+		tx := transactions[0]
+		suite.Equal(arg.BorrowRequestID, tx.BorrowRequestID)
+		suite.Equal("2026-10-16T09:30:00+08:00", tx.CreatedAt)
+		suite.Equal("2026-10-16T13:30:00+08:00", tx.ExpectedReturnAt)
+		suite.Equal(testManagerID, tx.Borrower.ID)
+		suite.Require().Len(tx.Equipments, 1)
+		suite.Equal(2, tx.Equipments[0].Quantity)
+
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"borrowRequestId": "` + tx.BorrowRequestID + `", "score": -0.25, "isAnomaly": true, "isFalsePositive": null}]`))
+	}))
+	defer standIn.Close()
+
+	remote := newRemoteDetector(standIn.URL, time.Second, manila)
+
+	result, err := remote.Detect(suite.ctx, arg)
+	suite.Require().NoError(err)
+	suite.Equal(anomaly{
+		BorrowRequestID: arg.BorrowRequestID,
+		Score:           -0.25,
+		IsAnomaly:       true,
+		Detector:        anomalyDetectorRemote,
+	}, result)
+
+	// Server errors are retried
+	failures = 2
+	_, err = remote.Detect(suite.ctx, arg)
+	suite.Require().NoError(err)
+
+	// Once the retries run out, the built-in detector takes over
+	local := &stubDetector{result: anomaly{BorrowRequestID: arg.BorrowRequestID, Score: 1}}
+	detector := fallbackDetector{primary: remote, fallback: local}
+
+	failures = 100
+	result, err = detector.Detect(suite.ctx, arg)
+	suite.Require().NoError(err)
+	suite.Equal(local.result, result)
+	suite.Equal(1, local.calls)
+
+	// The breaker opens after enough failures and stops calling the service
+	for range anomalyBreakerThreshold - 1 {
+		_, err = remote.Detect(suite.ctx, arg)
+		suite.Error(err)
+	}
+	remaining := failures
+	_, err = remote.Detect(suite.ctx, arg)
+	suite.ErrorIs(err, errAnomalyServiceUnavailable)
+	suite.Equal(remaining, failures)
+}
+
+func (suite *TestSuite) TestAnomalyReview() {
+	borrower := suite.createPerson("flagged@test.local", user.Borrower)
+	manager := suite.createPerson("anomaly-manager@test.local", user.EquipmentManager)
+
+	equipmentTypeID := suite.createEquipmentType(createRequest{Name: "Discus"})
+
+	code, created := suite.createBorrowRequestAs(borrower, borrowRequestFor(equipmentTypeID, 1))
+	suite.Require().Equal(http.StatusOK, code)
+	borrowRequestID := created.BorrowRequestID
+
+	// Detection runs in the background
+	suite.Eventually(func() bool {
+		var count int
+		err := suite.pgContainer.Pool.QueryRow(
+			suite.ctx,
+			"SELECT COUNT(*) FROM anomaly_result WHERE borrow_request_id = $1",
+			borrowRequestID,
+		).Scan(&count)
+		return err == nil && count == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	path := "/borrow-requests/" + borrowRequestID + "/anomaly"
+	repo := NewRepository(suite.pgContainer.Pool)
+
+	err := repo.createAnomalyResult(suite.ctx, anomaly{BorrowRequestID: borrowRequestID, Score: 2, Detector: anomalyDetectorBuiltin})
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusConflict, suite.requestAs(manager, http.MethodPatch, path, `{"isFalsePositive": true}`))
+
+	err = repo.createAnomalyResult(suite.ctx, anomaly{
+		BorrowRequestID: borrowRequestID,
+		Score:           6,
+		IsAnomaly:       true,
+		Detector:        anomalyDetectorBuiltin,
+	})
+	suite.Require().NoError(err)
+
+	suite.Equal(http.StatusForbidden, suite.requestAs(borrower, http.MethodPatch, path, `{"isFalsePositive": true}`))
+	suite.Equal(http.StatusBadRequest, suite.requestAs(manager, http.MethodPatch, path, `{}`))
+	suite.Equal(http.StatusOK, suite.requestAs(manager, http.MethodPatch, path, `{"isFalsePositive": true}`))
+
+	resp, err := http.Get(suite.httpServer.URL + "/borrow-requests/anomalies?review=false-positive&userId=" + borrower)
+	suite.Require().NoError(err)
+
+	var flagged struct {
+		api.Response
+		Data []flaggedBorrowRequest `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&flagged)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+	suite.Require().Equal(http.StatusOK, resp.StatusCode)
+	suite.Require().Len(flagged.Data, 1)
+	suite.Equal(borrowRequestID, flagged.Data[0].BorrowRequestID)
+	suite.Require().NotNil(flagged.Data[0].Anomaly.IsFalsePositive)
+	suite.True(*flagged.Data[0].Anomaly.IsFalsePositive)
+	suite.Require().NotNil(flagged.Data[0].ReviewedBy)
+	suite.Equal(manager, flagged.Data[0].ReviewedBy.UserID)
+
+	// The same pattern isn't flagged again, but anything worse still is
+	calibration, err := repo.getAnomalyCalibration(suite.ctx, anomalyDetectorBuiltin, time.Now().Add(-time.Hour))
+	suite.Require().NoError(err)
+
+	scorer := anomalyScorer{location: time.UTC, threshold: defaultAnomalyThreshold}.calibrate(calibration)
+	suite.InDelta(6, scorer.threshold, 0.01)
+
+	features := borrowFeatures{quantity: 10 + 6*7.5, itemTypes: 1, loanHours: 2, requestHour: 13}
+	_, isAnomaly := scorer.score(features, nil)
+	suite.False(isAnomaly)
+
+	features.quantity = 10 + 6.5*7.5
+	_, isAnomaly = scorer.score(features, nil)
+	suite.True(isAnomaly)
+}
+
+func (suite *TestSuite) TestUtilization() {
+	borrower := suite.createPerson("utilization@test.local", user.Borrower)
+	equipmentTypeID := suite.createEquipmentType(createRequest{Name: "Starting Block"})
+
+	increase := `{"quantity": 1, "acquisitionDate": "2025-11-26T01:42:59.367Z"}`
+	suite.Require().Equal(
+		http.StatusOK,
+		suite.requestAs("", http.MethodPost, "/equipments/"+equipmentTypeID+"/increase", increase),
+	)
+
+	code, _ := suite.createBorrowRequestAs(borrower, borrowRequestFor(equipmentTypeID, 1))
+	suite.Require().Equal(http.StatusOK, code)
+
+	// Both blocks were around for the last 10 hours and one of them has been
+	// out for the last 2
+	now := time.Now()
+	_, err := suite.pgContainer.Pool.Exec(
+		suite.ctx,
+		"UPDATE equipment SET created_at = $1 WHERE equipment_type_id = $2",
+		now.Add(-10*time.Hour),
+		equipmentTypeID,
+	)
+	suite.Require().NoError(err)
+	_, err = suite.pgContainer.Pool.Exec(
+		suite.ctx,
+		`
+		INSERT INTO borrow_transaction (borrow_request_item_id, equipment_id, created_at)
+		SELECT borrow_request_item.borrow_request_item_id, equipment.equipment_id, $1
+		FROM borrow_request_item
+		JOIN equipment USING (equipment_type_id)
+		WHERE borrow_request_item.equipment_type_id = $2
+		LIMIT 1
+		`,
+		now.Add(-2*time.Hour),
+		equipmentTypeID,
+	)
+	suite.Require().NoError(err)
+
+	repo := NewRepository(suite.pgContainer.Pool)
+	report, err := repo.getUtilization(suite.ctx, getUtilizationParams{from: now.Add(-4 * time.Hour), to: now})
+	suite.Require().NoError(err)
+
+	var block *utilization
+	for i := range report.Equipments {
+		if report.Equipments[i].ID == equipmentTypeID {
+			block = &report.Equipments[i]
+		}
+	}
+	suite.Require().NotNil(block)
+	suite.Equal(2, block.Units)
+	suite.Equal(1, block.IdleUnits)
+	suite.Equal(1, block.LoanCount)
+	suite.InDelta(2, block.LoanHours, 0.01)
+	suite.InDelta(0.25, block.Utilization, 0.01)
+	suite.Equal(1, block.PeakConcurrentLoans)
+
+	from := url.QueryEscape(now.Add(-4 * time.Hour).Format(time.RFC3339))
+	suite.Equal(http.StatusOK, suite.requestAs("", http.MethodGet, "/analytics/utilization?from="+from, ""))
+	suite.Equal(http.StatusBadRequest, suite.requestAs("", http.MethodGet, "/analytics/utilization?from=yesterday", ""))
+
+	// The report is cached for the next request
+	keys, err := suite.valkeyContainer.Client.Do(
+		suite.ctx,
+		suite.valkeyContainer.Client.B().Keys().Pattern("analytics:utilization:*").Build(),
+	).AsStrSlice()
+	suite.Require().NoError(err)
+	suite.NotEmpty(keys)
+}
+
+func (suite *TestSuite) TestDemandForecast() {
+	location := loadManilaLocation()
+	monday := time.Date(2026, 10, 12, 0, 0, 0, 0, location)
+	suite.Equal(monday, startOfWeek(time.Date(2026, 10, 18, 23, 0, 0, 0, location), location))
+	suite.Equal(monday, startOfWeek(monday, location))
+
+	// Two overlapping requests, then one handed straight to the next borrower
+	weekStarts := []time.Time{monday}
+	intervals := []demandInterval{
+		{start: monday.Add(8 * time.Hour), end: monday.Add(12 * time.Hour), quantity: 2},
+		{start: monday.Add(10 * time.Hour), end: monday.Add(14 * time.Hour), quantity: 3},
+		{start: monday.Add(14 * time.Hour), end: monday.Add(16 * time.Hour), quantity: 3},
+	}
+	suite.Equal([]float64{5}, weeklyPeakDemand(intervals, weekStarts))
+
+	forecast, method := forecastDemand([]float64{2, 4}, 2)
+	suite.Equal(forecastExponentialSmoothing, method)
+	suite.InDeltaSlice([]float64{2.6, 2.6}, forecast, 0.001)
+
+	season := make([]float64, seasonWeeks+10)
+	season[10] = 7
+	forecast, method = forecastDemand(season, 2)
+	suite.Equal(forecastSeasonalNaive, method)
+	suite.Equal([]float64{7, 0}, forecast)
+
+	borrower := suite.createPerson("forecast@test.local", user.Borrower)
+	equipmentTypeID := suite.createEquipmentType(createRequest{Name: "Relay Baton"})
+
+	code, _ := suite.createBorrowRequestAs(borrower, borrowRequestFor(equipmentTypeID, 1))
+	suite.Require().Equal(http.StatusOK, code)
+
+	// Last week three batons were wanted at once while there's only one
+	lastWeek := startOfWeek(time.Now(), location).AddDate(0, 0, -4)
+	_, err := suite.pgContainer.Pool.Exec(
+		suite.ctx,
+		"UPDATE equipment_type SET created_at = $1 WHERE equipment_type_id = $2",
+		lastWeek,
+		equipmentTypeID,
+	)
+	suite.Require().NoError(err)
+	_, err = suite.pgContainer.Pool.Exec(
+		suite.ctx,
+		`
+		UPDATE borrow_request
+		SET expected_claim_at = $1, expected_return_at = $2
+		FROM borrow_request_item
+		WHERE borrow_request_item.borrow_request_id = borrow_request.borrow_request_id
+		AND borrow_request_item.equipment_type_id = $3
+		`,
+		lastWeek.Add(9*time.Hour),
+		lastWeek.Add(11*time.Hour),
+		equipmentTypeID,
+	)
+	suite.Require().NoError(err)
+	_, err = suite.pgContainer.Pool.Exec(
+		suite.ctx,
+		"UPDATE borrow_request_item SET quantity = 3 WHERE equipment_type_id = $1",
+		equipmentTypeID,
+	)
+	suite.Require().NoError(err)
+
+	resp, err := http.Get(suite.httpServer.URL + "/analytics/forecast?weeks=2&equipmentTypeId=" + equipmentTypeID)
+	suite.Require().NoError(err)
+
+	var result struct {
+		api.Response
+		Data forecastReport `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+	suite.Require().Equal(http.StatusOK, resp.StatusCode)
+	suite.Require().Len(result.Data.Equipments, 1)
+
+	baton := result.Data.Equipments[0]
+	suite.Equal(equipmentTypeID, baton.ID)
+	suite.Equal(1, baton.CirculatingUnits)
+	suite.Len(baton.Forecast, 2)
+	suite.Equal(3.0, baton.Forecast[0].Units)
+	suite.Equal(2, baton.RecommendedQuantity)
+
+	suite.Equal(http.StatusBadRequest, suite.requestAs("", http.MethodGet, "/analytics/forecast?weeks=100", ""))
+}
@@ -0,0 +1,503 @@
+package equipment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/user"
+)
+
+var (
+	errSiteNotFound      = fmt.Errorf("site not found")
+	errInvalidSite       = fmt.Errorf("invalid site")
+	errSiteExists        = fmt.Errorf("site already exists")
+	errSiteInUse         = fmt.Errorf("site still has equipment or borrow requests")
+	errDefaultSite       = fmt.Errorf("default site can't be deleted")
+	errSiteManagerTarget = fmt.Errorf("site or user not found")
+	errNotSiteManager    = fmt.Errorf("user doesn't manage this site")
+)
+
+// siteDetail is the storage room a unit is kept in and where borrowers claim
+// and return it.
+type siteDetail struct {
+	SiteID   string  `json:"id"`
+	Name     string  `json:"name"`
+	Building *string `json:"building"`
+	Room     *string `json:"room"`
+}
+
+type site struct {
+	siteDetail
+	IsDefault bool             `json:"isDefault"`
+	Managers  []user.BasicInfo `json:"managers"`
+}
+
+// siteJSON selects the site referenced by the column as JSON, so it can be
+// returned next to the borrow request or waitlist entry it belongs to.
+func siteJSON(siteIDColumn string) string {
+	return `(
+		SELECT jsonb_build_object(
+			'id', site.site_id,
+			'name', site.name,
+			'building', site.building,
+			'room', site.room
+		)
+		FROM site
+		WHERE site.site_id = ` + siteIDColumn + `
+	)`
+}
+
+// resolveSiteID checks that the site exists. Requests that don't pick a site
+// go to the default one, which is all there is until more sites are added.
+func resolveSiteID(ctx context.Context, q dbQuerier, siteID string) (string, error) {
+	query := `
+	SELECT site_id
+	FROM site
+	WHERE CASE WHEN $1 = '' THEN is_default ELSE site_id::text = $1 END
+	`
+
+	var id string
+	if err := q.QueryRow(ctx, query, strings.TrimSpace(siteID)).Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", errSiteNotFound
+		}
+		return "", err
+	}
+
+	return id, nil
+}
+
+func getBorrowRequestSiteID(ctx context.Context, q dbQuerier, borrowRequestID string) (string, error) {
+	var siteID string
+	query := "SELECT site_id FROM borrow_request WHERE borrow_request_id = $1"
+	err := q.QueryRow(ctx, query, borrowRequestID).Scan(&siteID)
+	return siteID, err
+}
+
+// checkSiteManager makes sure a manager can handle the site. Managers who
+// aren't assigned to any site handle all of them.
+func checkSiteManager(ctx context.Context, q dbQuerier, personID, siteID string) error {
+	query := `
+	SELECT
+		NOT EXISTS (SELECT 1 FROM site_manager WHERE person_id = $1)
+		OR EXISTS (SELECT 1 FROM site_manager WHERE person_id = $1 AND site_id = $2)
+	`
+
+	var ok bool
+	if err := q.QueryRow(ctx, query, personID, siteID).Scan(&ok); err != nil {
+		return err
+	}
+
+	if !ok {
+		return errNotSiteManager
+	}
+
+	return nil
+}
+
+// siteAccessErrorResponse maps the errors shared by the endpoints that take
+// a site or are limited to a site's managers.
+func siteAccessErrorResponse(op string, err error) (api.Response, bool) {
+	switch {
+	case errors.Is(err, errSiteNotFound):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusBadRequest,
+			Message: "Site not found.",
+		}, true
+	case errors.Is(err, errNotSiteManager):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusForbidden,
+			Message: "You don't manage this site.",
+		}, true
+	}
+
+	return api.Response{}, false
+}
+
+const siteSelect = `
+SELECT
+	site.site_id,
+	site.name,
+	site.building,
+	site.room,
+	site.is_default,
+	COALESCE(
+		(
+			SELECT jsonb_agg(
+				jsonb_build_object(
+					'id', person.person_id,
+					'firstName', person.first_name,
+					'middleName', person.middle_name,
+					'lastName', person.last_name,
+					'avatarUrl', person.avatar_url
+				)
+				ORDER BY person.last_name, person.first_name
+			)
+			FROM site_manager
+			JOIN person USING (person_id)
+			WHERE site_manager.site_id = site.site_id
+		),
+		'[]'::jsonb
+	) AS managers
+FROM site
+`
+
+func scanSite(row pgx.Row, s *site) error {
+	return row.Scan(&s.SiteID, &s.Name, &s.Building, &s.Room, &s.IsDefault, &s.Managers)
+}
+
+func (r *repository) getSites(ctx context.Context) ([]site, error) {
+	rows, err := r.querier.Query(ctx, siteSelect+" ORDER BY site.is_default DESC, site.name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sites := []site{}
+	for rows.Next() {
+		var s site
+		if err := scanSite(rows, &s); err != nil {
+			return nil, err
+		}
+		sites = append(sites, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sites, nil
+}
+
+type siteRequest struct {
+	SiteID   string  `json:"-"`
+	Name     string  `json:"name"`
+	Building *string `json:"building"`
+	Room     *string `json:"room"`
+
+	// IsDefault moves the default to this site. The default can only be
+	// moved, never removed.
+	IsDefault bool `json:"isDefault"`
+}
+
+func (arg *siteRequest) validate() error {
+	arg.Name = strings.TrimSpace(arg.Name)
+	if arg.Name == "" {
+		return fmt.Errorf("%w: name is required", errInvalidSite)
+	}
+
+	return nil
+}
+
+// siteError maps constraint violations to the errors the handlers know how
+// to report.
+func siteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			return errSiteExists
+		case "23503":
+			return errSiteInUse
+		}
+	}
+	return err
+}
+
+// saveSite creates the site if it has no ID yet, or updates it otherwise.
+func (r *repository) saveSite(ctx context.Context, arg siteRequest) (site, error) {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return site{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	if arg.IsDefault {
+		query := "UPDATE site SET is_default = FALSE, updated_at = NOW() WHERE is_default AND site_id::text <> $1"
+		if _, err := tx.Exec(ctx, query, arg.SiteID); err != nil {
+			return site{}, err
+		}
+	}
+
+	query := `
+	INSERT INTO site (name, building, room, is_default)
+	VALUES ($1, $2, $3, $4)
+	RETURNING site_id
+	`
+	args := []any{arg.Name, arg.Building, arg.Room, arg.IsDefault}
+
+	if arg.SiteID != "" {
+		query = `
+		UPDATE site
+		SET
+			updated_at = NOW(),
+			name = $1,
+			building = $2,
+			room = $3,
+			is_default = is_default OR $4
+		WHERE site_id::text = $5
+		RETURNING site_id
+		`
+		args = append(args, arg.SiteID)
+	}
+
+	var siteID string
+	if err := tx.QueryRow(ctx, query, args...).Scan(&siteID); err != nil {
+		return site{}, siteError(err)
+	}
+
+	var res site
+	if err := scanSite(tx.QueryRow(ctx, siteSelect+" WHERE site.site_id = $1", siteID), &res); err != nil {
+		return site{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return site{}, err
+	}
+
+	return res, nil
+}
+
+func (r *repository) deleteSite(ctx context.Context, siteID string) error {
+	var isDefault bool
+	query := "SELECT is_default FROM site WHERE site_id = $1"
+	if err := r.querier.QueryRow(ctx, query, siteID).Scan(&isDefault); err != nil {
+		return err
+	}
+
+	if isDefault {
+		return errDefaultSite
+	}
+
+	if _, err := r.querier.Exec(ctx, "DELETE FROM site WHERE site_id = $1", siteID); err != nil {
+		return siteError(err)
+	}
+
+	return nil
+}
+
+func (r *repository) assignSiteManager(ctx context.Context, siteID, personID string) error {
+	query := `
+	INSERT INTO site_manager (site_id, person_id)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING
+	`
+
+	if _, err := r.querier.Exec(ctx, query, siteID, personID); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return errSiteManagerTarget
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (r *repository) removeSiteManager(ctx context.Context, siteID, personID string) error {
+	query := "DELETE FROM site_manager WHERE site_id = $1 AND person_id = $2"
+
+	tag, err := r.querier.Exec(ctx, query, siteID, personID)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *Server) getSites(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	sites, err := s.repository.getSites(ctx)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get sites: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get sites.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched sites.",
+		Data:    sites,
+	}
+}
+
+func (s *Server) createSite(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data siteRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create site: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid create site request.",
+		}
+	}
+
+	if err := data.validate(); err != nil {
+		return siteErrorResponse("create site", err)
+	}
+
+	site, err := s.repository.saveSite(ctx, data)
+	if err != nil {
+		return siteErrorResponse("create site", err)
+	}
+
+	return api.Response{
+		Code:    http.StatusCreated,
+		Message: "Successfully created site.",
+		Data:    site,
+	}
+}
+
+func (s *Server) updateSite(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data siteRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("update site: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid update site request.",
+		}
+	}
+
+	data.SiteID = r.PathValue("id")
+	if err := data.validate(); err != nil {
+		return siteErrorResponse("update site", err)
+	}
+
+	site, err := s.repository.saveSite(ctx, data)
+	if err != nil {
+		return siteErrorResponse("update site", err)
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully updated site.",
+		Data:    site,
+	}
+}
+
+func (s *Server) deleteSite(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	if err := s.repository.deleteSite(ctx, r.PathValue("id")); err != nil {
+		return siteErrorResponse("delete site", err)
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully deleted site.",
+	}
+}
+
+func siteErrorResponse(op string, err error) api.Response {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusNotFound,
+			Message: "Site not found.",
+		}
+	case errors.Is(err, errInvalidSite):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusBadRequest,
+			Message: "Site name is required.",
+		}
+	case errors.Is(err, errSiteExists):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "A site with the same name already exists.",
+		}
+	case errors.Is(err, errSiteInUse):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "Site still has equipment or borrow requests.",
+		}
+	case errors.Is(err, errDefaultSite):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "Make another site the default before deleting this one.",
+		}
+	default:
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusInternalServerError,
+			Message: fmt.Sprintf("Failed to %s.", op),
+		}
+	}
+}
+
+func (s *Server) assignSiteManager(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	if err := s.repository.assignSiteManager(ctx, r.PathValue("id"), r.PathValue("userId")); err != nil {
+		if errors.Is(err, errSiteManagerTarget) {
+			return api.Response{
+				Error:   fmt.Errorf("assign site manager: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Site or user not found.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("assign site manager: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to assign site manager.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully assigned site manager.",
+	}
+}
+
+func (s *Server) removeSiteManager(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	if err := s.repository.removeSiteManager(ctx, r.PathValue("id"), r.PathValue("userId")); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("remove site manager: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "User doesn't manage this site.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("remove site manager: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to remove site manager.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully removed site manager.",
+	}
+}
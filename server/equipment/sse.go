@@ -3,17 +3,45 @@ package equipment
 type event = string
 
 const (
-	eventEquipmentCreate     event = "equipment:create"
-	eventEquipmentAnomaly    event = "equipment:anomaly"
-	eventEquipmentReallocate event = "equipment:reallocate"
+	eventEquipmentCreate        event = "equipment:create"
+	eventEquipmentAnomaly       event = "equipment:anomaly"
+	eventEquipmentAnomalyReview event = "equipment:anomaly-review"
+	eventEquipmentReallocate    event = "equipment:reallocate"
+	eventEquipmentTransfer      event = "equipment:transfer"
 )
 
 const (
-	eventBorrowRequestCreate event = "borrow-request:create"
-	eventBorrowRequestUpdate event = "borrow-request:update"
-	eventBorrowRequestReview event = "borrow-request:review"
+	eventBorrowRequestCreate  event = "borrow-request:create"
+	eventBorrowRequestUpdate  event = "borrow-request:update"
+	eventBorrowRequestReview  event = "borrow-request:review"
+	eventBorrowRequestOverdue event = "borrow-request:overdue"
+	eventBorrowRequestEdit    event = "borrow-request:edit"
+	eventBorrowRequestCancel  event = "borrow-request:cancel"
 )
 
 const (
 	eventReturnRequestConfirm event = "return-request:confirm"
 )
+
+const (
+	eventExtensionCreate event = "borrow-request-extension:create"
+	eventExtensionReview event = "borrow-request-extension:review"
+)
+
+const (
+	eventSiteTransferRequest  event = "site-transfer:request"
+	eventSiteTransferDispatch event = "site-transfer:dispatch"
+	eventSiteTransferReceive  event = "site-transfer:receive"
+	eventSiteTransferCancel   event = "site-transfer:cancel"
+)
+
+const (
+	eventMaintenanceTicketCreate event = "maintenance-ticket:create"
+	eventMaintenanceTicketUpdate event = "maintenance-ticket:update"
+	eventMaintenanceTicketClose  event = "maintenance-ticket:close"
+)
+
+const (
+	eventWaitlistJoin  event = "waitlist:join"
+	eventWaitlistOffer event = "waitlist:offer"
+)
@@ -15,6 +15,7 @@ const (
 	lost
 	maintenance
 	disposed
+	inTransit
 )
 
 type equipmentStatusDetail struct {
@@ -31,6 +32,7 @@ var stringToEquipmentStatus = map[string]equipmentStatus{
 	"lost":        lost,
 	"maintenance": maintenance,
 	"disposed":    disposed,
+	"in_transit":  inTransit,
 }
 
 func (r equipmentStatus) MarshalJSON() ([]byte, error) {
@@ -42,6 +44,7 @@ func (r equipmentStatus) MarshalJSON() ([]byte, error) {
 		lost:        "lost",
 		maintenance: "maintenance",
 		disposed:    "disposed",
+		inTransit:   "in_transit",
 	}
 	if s, ok := status[r]; ok {
 		return json.Marshal(s)
@@ -71,6 +74,7 @@ const (
 	returned
 	unclaimed
 	rejected
+	cancelled
 )
 
 type borrowRequestStatusDetail struct {
@@ -86,6 +90,7 @@ var stringToBorrowRequestStatus = map[string]borrowRequestStatus{
 	"returned":  returned,
 	"unclaimed": unclaimed,
 	"rejected":  rejected,
+	"cancelled": cancelled,
 }
 
 func (r borrowRequestStatus) MarshalJSON() ([]byte, error) {
@@ -96,6 +101,7 @@ func (r borrowRequestStatus) MarshalJSON() ([]byte, error) {
 		returned:  "returned",
 		unclaimed: "unclaimed",
 		rejected:  "rejected",
+		cancelled: "cancelled",
 	}
 	if s, ok := status[r]; ok {
 		return json.Marshal(s)
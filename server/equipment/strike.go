@@ -0,0 +1,482 @@
+package equipment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/user"
+)
+
+type strikeReason = string
+
+const (
+	strikeLateReturn strikeReason = "late_return"
+	strikeUnclaimed  strikeReason = "unclaimed"
+	strikeDamaged    strikeReason = "damaged"
+	strikeLost       strikeReason = "lost"
+)
+
+const (
+	defaultStrikeThreshold  = 3
+	defaultStrikeWindow     = 30 * 24 * time.Hour
+	defaultStrikeSuspension = 7 * 24 * time.Hour
+)
+
+var (
+	errBorrowerSuspended   = fmt.Errorf("borrower is suspended from borrowing")
+	errStrikeAlreadyWaived = fmt.Errorf("strike is already waived")
+	errUnitNotReturned     = fmt.Errorf("equipment unit is not part of this return")
+)
+
+// strikePolicy decides when strikes add up to a suspension: reaching the
+// threshold within the window suspends the borrower for the given period,
+// counted from the strike that crossed it.
+type strikePolicy struct {
+	threshold  int
+	window     time.Duration
+	suspension time.Duration
+}
+
+// loadStrikePolicy reads STRIKE_THRESHOLD, STRIKE_WINDOW and
+// STRIKE_SUSPENSION, falling back to 3 strikes within 30 days for a week.
+func loadStrikePolicy() strikePolicy {
+	policy := strikePolicy{
+		threshold:  defaultStrikeThreshold,
+		window:     defaultStrikeWindow,
+		suspension: defaultStrikeSuspension,
+	}
+
+	if v := os.Getenv("STRIKE_THRESHOLD"); v != "" {
+		threshold, err := strconv.Atoi(v)
+		if err != nil || threshold < 1 {
+			slog.Warn("Invalid STRIKE_THRESHOLD, using default: " + v)
+		} else {
+			policy.threshold = threshold
+		}
+	}
+
+	if v := os.Getenv("STRIKE_WINDOW"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			slog.Warn("Invalid STRIKE_WINDOW, using default: " + v)
+		} else {
+			policy.window = window
+		}
+	}
+
+	if v := os.Getenv("STRIKE_SUSPENSION"); v != "" {
+		suspension, err := time.ParseDuration(v)
+		if err != nil || suspension < 0 {
+			slog.Warn("Invalid STRIKE_SUSPENSION, using default: " + v)
+		} else {
+			policy.suspension = suspension
+		}
+	}
+
+	return policy
+}
+
+// suspendedUntil returns when the latest suspension ends given the times of
+// a borrower's unwaived strikes in ascending order, or nil if the strikes
+// never crossed the threshold.
+func (p strikePolicy) suspendedUntil(strikes []time.Time) *time.Time {
+	var until *time.Time
+
+	for i, strikeAt := range strikes {
+		count := 0
+		for _, t := range strikes[:i+1] {
+			if strikeAt.Sub(t) < p.window {
+				count++
+			}
+		}
+
+		if count >= p.threshold {
+			end := strikeAt.Add(p.suspension)
+			until = &end
+		}
+	}
+
+	return until
+}
+
+type borrowerStrike struct {
+	BorrowerStrikeID string          `json:"id"`
+	CreatedAt        time.Time       `json:"createdAt"`
+	Reason           strikeReason    `json:"reason"`
+	BorrowRequestID  *string         `json:"borrowRequestId"`
+	AssetTag         *string         `json:"assetTag"`
+	WaivedAt         *time.Time      `json:"waivedAt"`
+	WaivedBy         *user.BasicInfo `json:"waivedBy"`
+	WaiveReason      *string         `json:"waiveReason"`
+}
+
+type borrowerStrikeSummary struct {
+	Strikes        []borrowerStrike `json:"strikes"`
+	ActiveStrikes  int              `json:"activeStrikes"`
+	SuspendedUntil *time.Time       `json:"suspendedUntil"`
+}
+
+func (r *repository) getStrikes(ctx context.Context, personID string) ([]borrowerStrike, error) {
+	query := `
+	SELECT
+		borrower_strike.borrower_strike_id,
+		borrower_strike.created_at,
+		borrower_strike.reason,
+		borrower_strike.borrow_request_id,
+		equipment.asset_tag,
+		borrower_strike.waived_at,
+		CASE WHEN waiver.person_id IS NULL THEN NULL
+		ELSE
+			jsonb_build_object(
+				'id', waiver.person_id,
+				'firstName', waiver.first_name,
+				'middleName', waiver.middle_name,
+				'lastName', waiver.last_name,
+				'avatarUrl', waiver.avatar_url
+			)
+		END AS waived_by,
+		borrower_strike.waive_reason
+	FROM borrower_strike
+	LEFT JOIN equipment ON equipment.equipment_id = borrower_strike.equipment_id
+	LEFT JOIN person waiver ON waiver.person_id = borrower_strike.waived_by
+	WHERE borrower_strike.person_id = $1
+	ORDER BY borrower_strike.created_at DESC
+	`
+
+	rows, err := r.querier.Query(ctx, query, personID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	strikes := []borrowerStrike{}
+	for rows.Next() {
+		var strike borrowerStrike
+		if err := rows.Scan(
+			&strike.BorrowerStrikeID,
+			&strike.CreatedAt,
+			&strike.Reason,
+			&strike.BorrowRequestID,
+			&strike.AssetTag,
+			&strike.WaivedAt,
+			&strike.WaivedBy,
+			&strike.WaiveReason,
+		); err != nil {
+			return nil, err
+		}
+		strikes = append(strikes, strike)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return strikes, nil
+}
+
+// getActiveStrikeTimes returns when the borrower's unwaived strikes since the
+// given time were recorded, oldest first.
+func (r *repository) getActiveStrikeTimes(ctx context.Context, personID string, since time.Time) ([]time.Time, error) {
+	query := `
+	SELECT created_at
+	FROM borrower_strike
+	WHERE person_id = $1 AND waived_at IS NULL AND created_at >= $2
+	ORDER BY created_at
+	`
+
+	rows, err := r.querier.Query(ctx, query, personID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[time.Time])
+}
+
+type waiveStrikeRequest struct {
+	BorrowerStrikeID string  `json:"id"`
+	PersonID         string  `json:"personId"`
+	WaivedBy         string  `json:"waivedBy"`
+	Reason           *string `json:"reason"`
+}
+
+func (r *repository) waiveStrike(ctx context.Context, arg waiveStrikeRequest) error {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	checkQuery := `
+	SELECT waived_at
+	FROM borrower_strike
+	WHERE borrower_strike_id = $1 AND person_id = $2
+	FOR UPDATE
+	`
+
+	var waivedAt *time.Time
+	if err := tx.QueryRow(ctx, checkQuery, arg.BorrowerStrikeID, arg.PersonID).Scan(&waivedAt); err != nil {
+		return err
+	}
+
+	if waivedAt != nil {
+		return errStrikeAlreadyWaived
+	}
+
+	updateQuery := `
+	UPDATE borrower_strike
+	SET waived_at = NOW(), waived_by = $2, waive_reason = $3
+	WHERE borrower_strike_id = $1
+	`
+	if _, err := tx.Exec(ctx, updateQuery, arg.BorrowerStrikeID, arg.WaivedBy, arg.Reason); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// markReturnedUnits sets the status of units that were returned under the
+// given return request, e.g. to damaged or lost, and returns their IDs.
+func markReturnedUnits(
+	ctx context.Context,
+	tx pgx.Tx,
+	returnRequestID string,
+	codes []string,
+	status equipmentStatus,
+) ([]string, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+
+	query := `
+	UPDATE equipment
+	SET equipment_status_id = $1, updated_at = NOW()
+	WHERE equipment_id IN (
+		SELECT borrow_transaction.equipment_id
+		FROM borrow_transaction
+		JOIN return_transaction USING (borrow_transaction_id)
+		JOIN return_request_item ON return_request_item.return_request_item_id = return_transaction.return_request_item_id
+		WHERE return_request_item.return_request_id = $2
+	)
+	AND (equipment_id::text = ANY($3) OR asset_tag = ANY($3))
+	RETURNING equipment_id
+	`
+
+	rows, err := tx.Query(ctx, query, status, returnRequestID, codes)
+	if err != nil {
+		return nil, err
+	}
+
+	unitIDs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(unitIDs) != len(codes) {
+		return nil, errUnitNotReturned
+	}
+
+	return unitIDs, nil
+}
+
+// recordReturnStrikes adds strikes for a confirmed return that was handed in
+// after the expected return time or had damaged or lost units.
+func recordReturnStrikes(
+	ctx context.Context,
+	tx pgx.Tx,
+	returnRequestID string,
+	damagedUnitIDs []string,
+	lostUnitIDs []string,
+) error {
+	lateQuery := `
+	INSERT INTO borrower_strike (person_id, borrow_request_id, reason)
+	SELECT borrow_request.requested_by, borrow_request.borrow_request_id, $2
+	FROM return_request
+	JOIN borrow_request USING (borrow_request_id)
+	WHERE return_request.return_request_id = $1
+	AND return_request.created_at > borrow_request.expected_return_at
+	ON CONFLICT DO NOTHING
+	`
+	if _, err := tx.Exec(ctx, lateQuery, returnRequestID, strikeLateReturn); err != nil {
+		return err
+	}
+
+	unitQuery := `
+	INSERT INTO borrower_strike (person_id, borrow_request_id, reason, equipment_id)
+	SELECT borrow_request.requested_by, borrow_request.borrow_request_id, $2, unnest($3::uuid[])
+	FROM return_request
+	JOIN borrow_request USING (borrow_request_id)
+	WHERE return_request.return_request_id = $1
+	ON CONFLICT DO NOTHING
+	`
+
+	if len(damagedUnitIDs) > 0 {
+		if _, err := tx.Exec(ctx, unitQuery, returnRequestID, strikeDamaged, damagedUnitIDs); err != nil {
+			return err
+		}
+	}
+
+	if len(lostUnitIDs) > 0 {
+		if _, err := tx.Exec(ctx, unitQuery, returnRequestID, strikeLost, lostUnitIDs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getSuspendedUntil returns when the borrower may borrow again, or nil if
+// they aren't suspended.
+func (s *Server) getSuspendedUntil(ctx context.Context, personID string) (*time.Time, error) {
+	since := time.Now().Add(-s.strikePolicy.window - s.strikePolicy.suspension)
+	strikes, err := s.repository.getActiveStrikeTimes(ctx, personID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	until := s.strikePolicy.suspendedUntil(strikes)
+	if until == nil || !until.After(time.Now()) {
+		return nil, nil
+	}
+
+	return until, nil
+}
+
+// checkSuspension returns a 403 response if the borrower is currently
+// suspended from borrowing.
+func (s *Server) checkSuspension(ctx context.Context, op string, personID string) (api.Response, bool) {
+	until, err := s.getSuspendedUntil(ctx, personID)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to check borrowing privileges.",
+		}, true
+	}
+
+	if until != nil {
+		return api.Response{
+			Error: fmt.Errorf("%s: %w", op, errBorrowerSuspended),
+			Code:  http.StatusForbidden,
+			Message: fmt.Sprintf(
+				"Borrowing is suspended until %s due to repeated late returns, unclaimed requests or damaged equipment.",
+				until.Format("Jan 2, 2006 3:04 PM"),
+			),
+		}, true
+	}
+
+	return api.Response{}, false
+}
+
+func (s *Server) getStrikes(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("get strikes", err)
+	}
+
+	personID := r.PathValue("userId")
+	if !canAccessUser(claims, personID) {
+		return api.Response{
+			Error:   fmt.Errorf("get strikes: %w", errNotBorrowRequestOwner),
+			Code:    http.StatusForbidden,
+			Message: "You can only view your own strikes.",
+		}
+	}
+
+	strikes, err := s.repository.getStrikes(ctx, personID)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get strikes: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get strikes.",
+		}
+	}
+
+	suspendedUntil, err := s.getSuspendedUntil(ctx, personID)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get strikes: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get strikes.",
+		}
+	}
+
+	res := borrowerStrikeSummary{
+		Strikes:        strikes,
+		SuspendedUntil: suspendedUntil,
+	}
+	for _, strike := range strikes {
+		if strike.WaivedAt == nil && time.Since(strike.CreatedAt) < s.strikePolicy.window {
+			res.ActiveStrikes++
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched strikes.",
+		Data:    res,
+	}
+}
+
+func (s *Server) waiveStrike(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data waiveStrikeRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("waive strike: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid waive strike request.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("waive strike", err)
+	}
+	data.WaivedBy = claims.UserID
+
+	data.BorrowerStrikeID = r.PathValue("strikeId")
+	data.PersonID = r.PathValue("userId")
+
+	if err := s.repository.waiveStrike(ctx, data); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("waive strike: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Strike not found.",
+			}
+		}
+
+		if errors.Is(err, errStrikeAlreadyWaived) {
+			return api.Response{
+				Error:   fmt.Errorf("waive strike: %w", err),
+				Code:    http.StatusConflict,
+				Message: "Strike has already been waived.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("waive strike: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to waive strike.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully waived strike.",
+	}
+}
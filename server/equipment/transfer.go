@@ -0,0 +1,1166 @@
+package equipment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/sse"
+	"github.com/xGihyun/hirami/user"
+)
+
+type siteTransferStatus = string
+
+const (
+	siteTransferRequested  siteTransferStatus = "requested"
+	siteTransferDispatched siteTransferStatus = "dispatched"
+	siteTransferReceived   siteTransferStatus = "received"
+	siteTransferCancelled  siteTransferStatus = "cancelled"
+)
+
+var (
+	errSameSite                 = fmt.Errorf("units are already at the destination site")
+	errInvalidTransferQuantity  = fmt.Errorf("transfer quantity must be greater than zero")
+	errInsufficientToMove       = fmt.Errorf("not enough available units at the source site")
+	errTransferOverbooking      = fmt.Errorf("source site would not have enough units for its bookings")
+	errSiteTransferNotRequested = fmt.Errorf("site transfer was already dispatched or cancelled")
+	errSiteTransferNotInTransit = fmt.Errorf("site transfer is not in transit")
+	errUnitNotInTransfer        = fmt.Errorf("equipment unit is not part of this transfer")
+)
+
+// endOfTime bounds checks that have to cover every booking from now on.
+var endOfTime = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+type siteTransferUnit struct {
+	UnitID   string `json:"id"`
+	AssetTag string `json:"assetTag"`
+
+	// IsReceived is nil until the transfer is received and false for units
+	// that never arrived.
+	IsReceived *bool `json:"isReceived"`
+}
+
+type siteTransfer struct {
+	SiteTransferID string             `json:"id"`
+	CreatedAt      time.Time          `json:"createdAt"`
+	Equipment      equipment          `json:"equipment"`
+	FromSite       siteDetail         `json:"fromSite"`
+	ToSite         siteDetail         `json:"toSite"`
+	Reason         *string            `json:"reason"`
+	Status         siteTransferStatus `json:"status"`
+	RequestedBy    user.BasicInfo     `json:"requestedBy"`
+	DispatchedBy   *user.BasicInfo    `json:"dispatchedBy"`
+	DispatchedAt   *time.Time         `json:"dispatchedAt"`
+	ReceivedBy     *user.BasicInfo    `json:"receivedBy"`
+	ReceivedAt     *time.Time         `json:"receivedAt"`
+	Remarks        *string            `json:"remarks"`
+	Units          []siteTransferUnit `json:"units"`
+
+	// HasDiscrepancy is set once fewer units arrived than were dispatched.
+	HasDiscrepancy bool `json:"hasDiscrepancy"`
+}
+
+var siteTransferSelect = `
+	SELECT
+		site_transfer.site_transfer_id,
+		site_transfer.created_at,
+		jsonb_build_object(
+			'id', equipment_type.equipment_type_id,
+			'name', equipment_type.name,
+			'brand', equipment_type.brand,
+			'model', equipment_type.model,
+			'imageUrl', equipment_type.image_url,
+			'quantity', site_transfer.quantity
+		) AS equipment,
+		` + siteJSON("site_transfer.from_site_id") + ` AS from_site,
+		` + siteJSON("site_transfer.to_site_id") + ` AS to_site,
+		site_transfer.reason,
+		site_transfer.status,
+		jsonb_build_object(
+			'id', requester.person_id,
+			'firstName', requester.first_name,
+			'middleName', requester.middle_name,
+			'lastName', requester.last_name,
+			'avatarUrl', requester.avatar_url
+		) AS requested_by,
+		CASE
+			WHEN dispatcher.person_id IS NULL THEN NULL
+			ELSE jsonb_build_object(
+				'id', dispatcher.person_id,
+				'firstName', dispatcher.first_name,
+				'middleName', dispatcher.middle_name,
+				'lastName', dispatcher.last_name,
+				'avatarUrl', dispatcher.avatar_url
+			)
+		END AS dispatched_by,
+		site_transfer.dispatched_at,
+		CASE
+			WHEN receiver.person_id IS NULL THEN NULL
+			ELSE jsonb_build_object(
+				'id', receiver.person_id,
+				'firstName', receiver.first_name,
+				'middleName', receiver.middle_name,
+				'lastName', receiver.last_name,
+				'avatarUrl', receiver.avatar_url
+			)
+		END AS received_by,
+		site_transfer.received_at,
+		site_transfer.remarks,
+		COALESCE(transfer_unit_agg.units, '[]'::jsonb) AS units,
+		COALESCE(transfer_unit_agg.has_discrepancy, FALSE) AS has_discrepancy
+	FROM site_transfer
+	JOIN equipment_type ON equipment_type.equipment_type_id = site_transfer.equipment_type_id
+	JOIN person requester ON requester.person_id = site_transfer.requested_by
+	LEFT JOIN person dispatcher ON dispatcher.person_id = site_transfer.dispatched_by
+	LEFT JOIN person receiver ON receiver.person_id = site_transfer.received_by
+	LEFT JOIN LATERAL (
+		SELECT
+			jsonb_agg(
+				jsonb_build_object(
+					'id', equipment.equipment_id,
+					'assetTag', equipment.asset_tag,
+					'isReceived', site_transfer_unit.is_received
+				)
+				ORDER BY equipment.asset_tag
+			) AS units,
+			bool_or(site_transfer_unit.is_received IS FALSE) AS has_discrepancy
+		FROM site_transfer_unit
+		JOIN equipment ON equipment.equipment_id = site_transfer_unit.equipment_id
+		WHERE site_transfer_unit.site_transfer_id = site_transfer.site_transfer_id
+	) transfer_unit_agg ON TRUE
+`
+
+func getSiteTransfer(ctx context.Context, q dbQuerier, id string) (siteTransfer, error) {
+	query := siteTransferSelect + " WHERE site_transfer.site_transfer_id = $1"
+
+	rows, err := q.Query(ctx, query, id)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[siteTransfer])
+}
+
+// lockedSiteTransfer is the part of a transfer that the dispatch and receive
+// steps work with.
+type lockedSiteTransfer struct {
+	siteTransferID  string
+	equipmentTypeID string
+	fromSiteID      string
+	toSiteID        string
+	quantity        int
+	status          siteTransferStatus
+}
+
+func lockSiteTransfer(ctx context.Context, tx pgx.Tx, id string) (lockedSiteTransfer, error) {
+	query := `
+	SELECT site_transfer_id, equipment_type_id, from_site_id, to_site_id, quantity, status
+	FROM site_transfer
+	WHERE site_transfer_id = $1
+	FOR UPDATE
+	`
+
+	var t lockedSiteTransfer
+	err := tx.QueryRow(ctx, query, id).Scan(
+		&t.siteTransferID,
+		&t.equipmentTypeID,
+		&t.fromSiteID,
+		&t.toSiteID,
+		&t.quantity,
+		&t.status,
+	)
+	return t, err
+}
+
+// checkEitherSiteManager lets managers of either end of a transfer through.
+func checkEitherSiteManager(ctx context.Context, q dbQuerier, personID string, t lockedSiteTransfer) error {
+	err := checkSiteManager(ctx, q, personID, t.toSiteID)
+	if errors.Is(err, errNotSiteManager) {
+		return checkSiteManager(ctx, q, personID, t.fromSiteID)
+	}
+	return err
+}
+
+// dispatchUnits puts units of the source site in transit. Scanned units must
+// match the transfer exactly, otherwise the first available units are picked.
+// The source site has to keep enough units for the requests already booked
+// there.
+func dispatchUnits(ctx context.Context, tx pgx.Tx, t lockedSiteTransfer, scanned []string) error {
+	var unitIDs []string
+
+	if len(scanned) > 0 {
+		units, err := lockScannedUnits(ctx, tx, scanned)
+		if err != nil {
+			return err
+		}
+
+		for _, unit := range units {
+			switch {
+			case unit.equipmentTypeID != t.equipmentTypeID:
+				return fmt.Errorf("%w: %s", errUnitTypeMismatch, unit.assetTag)
+			case unit.siteID != t.fromSiteID:
+				return fmt.Errorf("%w: %s", errUnitAtOtherSite, unit.assetTag)
+			case unit.status != available:
+				return fmt.Errorf("%w: %s", errUnitUnavailable, unit.assetTag)
+			}
+			unitIDs = append(unitIDs, unit.unitID)
+		}
+
+		if len(unitIDs) != t.quantity {
+			return fmt.Errorf("%w: expected %d, got %d", errUnitQuantityMismatch, t.quantity, len(unitIDs))
+		}
+	} else {
+		selectQuery := `
+		SELECT equipment_id
+		FROM equipment
+		WHERE equipment_type_id = $1 AND equipment_status_id = $2 AND site_id = $3
+		ORDER BY asset_tag
+		LIMIT $4
+		FOR UPDATE
+		`
+
+		rows, err := tx.Query(ctx, selectQuery, t.equipmentTypeID, available, t.fromSiteID, t.quantity)
+		if err != nil {
+			return err
+		}
+
+		unitIDs, err = pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return err
+		}
+
+		if len(unitIDs) < t.quantity {
+			return fmt.Errorf("%w: requested %d, got %d", errInsufficientToMove, t.quantity, len(unitIDs))
+		}
+	}
+
+	updateQuery := `
+	UPDATE equipment
+	SET equipment_status_id = $1, updated_at = NOW()
+	WHERE equipment_id = ANY($2::uuid[])
+	`
+	if _, err := tx.Exec(ctx, updateQuery, inTransit, unitIDs); err != nil {
+		return err
+	}
+
+	unitQuery := `
+	INSERT INTO site_transfer_unit (site_transfer_id, equipment_id)
+	SELECT $1, unnest($2::uuid[])
+	`
+	if _, err := tx.Exec(ctx, unitQuery, t.siteTransferID, unitIDs); err != nil {
+		return err
+	}
+
+	items := []borrowEquipmentItem{{EquipmentTypeID: t.equipmentTypeID}}
+	if err := checkAvailability(ctx, tx, t.fromSiteID, items, time.Now(), endOfTime, ""); err != nil {
+		if errors.Is(err, errInsufficientEquipmentQuantity) {
+			return errTransferOverbooking
+		}
+		return err
+	}
+
+	return nil
+}
+
+// receiveUnits moves the units that arrived to the destination site. An
+// empty scan means every dispatched unit arrived. Units that weren't scanned
+// are flagged and stay in transit so they're left out of availability until
+// someone finds them and reallocates them.
+func receiveUnits(ctx context.Context, tx pgx.Tx, t lockedSiteTransfer, scanned []string) error {
+	transferQuery := `
+	SELECT equipment_id
+	FROM equipment
+	WHERE equipment_id IN (
+		SELECT equipment_id FROM site_transfer_unit WHERE site_transfer_id = $1
+	)
+	FOR UPDATE
+	`
+
+	rows, err := tx.Query(ctx, transferQuery, t.siteTransferID)
+	if err != nil {
+		return err
+	}
+
+	receivedIDs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	if len(scanned) > 0 {
+		inTransfer := make(map[string]bool, len(receivedIDs))
+		for _, id := range receivedIDs {
+			inTransfer[id] = true
+		}
+
+		units, err := lockScannedUnits(ctx, tx, scanned)
+		if err != nil {
+			return err
+		}
+
+		receivedIDs = receivedIDs[:0]
+		for _, unit := range units {
+			if !inTransfer[unit.unitID] {
+				return fmt.Errorf("%w: %s", errUnitNotInTransfer, unit.assetTag)
+			}
+			receivedIDs = append(receivedIDs, unit.unitID)
+		}
+	}
+
+	unitQuery := `
+	UPDATE site_transfer_unit
+	SET is_received = (equipment_id = ANY($2::uuid[]))
+	WHERE site_transfer_id = $1
+	`
+	if _, err := tx.Exec(ctx, unitQuery, t.siteTransferID, receivedIDs); err != nil {
+		return err
+	}
+
+	updateQuery := `
+	UPDATE equipment
+	SET site_id = $1, equipment_status_id = $2, updated_at = NOW()
+	WHERE equipment_id = ANY($3::uuid[])
+	`
+	if _, err := tx.Exec(ctx, updateQuery, t.toSiteID, available, receivedIDs); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type createSiteTransfer struct {
+	EquipmentTypeID string  `json:"equipmentTypeId"`
+	FromSiteID      string  `json:"fromSiteId"`
+	ToSiteID        string  `json:"toSiteId"`
+	Quantity        int     `json:"quantity"`
+	Reason          *string `json:"reason"`
+	RequestedBy     string  `json:"-"`
+}
+
+// insertSiteTransfer records a transfer request after checking its sites.
+// The requester has to manage one of them.
+func insertSiteTransfer(ctx context.Context, tx pgx.Tx, arg createSiteTransfer) (lockedSiteTransfer, error) {
+	if arg.Quantity <= 0 {
+		return lockedSiteTransfer{}, errInvalidTransferQuantity
+	}
+
+	fromSiteID, err := resolveSiteID(ctx, tx, arg.FromSiteID)
+	if err != nil {
+		return lockedSiteTransfer{}, err
+	}
+
+	toSiteID, err := resolveSiteID(ctx, tx, arg.ToSiteID)
+	if err != nil {
+		return lockedSiteTransfer{}, err
+	}
+
+	if fromSiteID == toSiteID {
+		return lockedSiteTransfer{}, errSameSite
+	}
+
+	t := lockedSiteTransfer{
+		equipmentTypeID: arg.EquipmentTypeID,
+		fromSiteID:      fromSiteID,
+		toSiteID:        toSiteID,
+		quantity:        arg.Quantity,
+		status:          siteTransferRequested,
+	}
+
+	if err := checkEitherSiteManager(ctx, tx, arg.RequestedBy, t); err != nil {
+		return lockedSiteTransfer{}, err
+	}
+
+	if err := lockEquipmentType(ctx, tx, arg.EquipmentTypeID); err != nil {
+		return lockedSiteTransfer{}, err
+	}
+
+	query := `
+	INSERT INTO site_transfer (equipment_type_id, from_site_id, to_site_id, quantity, reason, requested_by)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING site_transfer_id
+	`
+	if err := tx.QueryRow(
+		ctx,
+		query,
+		t.equipmentTypeID,
+		t.fromSiteID,
+		t.toSiteID,
+		t.quantity,
+		arg.Reason,
+		arg.RequestedBy,
+	).Scan(&t.siteTransferID); err != nil {
+		return lockedSiteTransfer{}, err
+	}
+
+	return t, nil
+}
+
+func (r *repository) createSiteTransfer(ctx context.Context, arg createSiteTransfer) (siteTransfer, error) {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	t, err := insertSiteTransfer(ctx, tx, arg)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+
+	transfer, err := getSiteTransfer(ctx, tx, t.siteTransferID)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return siteTransfer{}, err
+	}
+
+	return transfer, nil
+}
+
+type getSiteTransfersParams struct {
+	status          *string
+	siteID          *string
+	equipmentTypeID *string
+	page            api.Page
+}
+
+// getSiteTransfers is the audit trail of transfers, newest first. The site
+// filter matches either end of a transfer.
+func (r *repository) getSiteTransfers(ctx context.Context, params getSiteTransfersParams) ([]siteTransfer, *string, error) {
+	query := siteTransferSelect + " WHERE TRUE"
+
+	var args []any
+	if params.status != nil && *params.status != "" {
+		args = append(args, *params.status)
+		query += fmt.Sprintf(" AND site_transfer.status = $%d", len(args))
+	}
+
+	if params.siteID != nil && *params.siteID != "" {
+		args = append(args, *params.siteID)
+		query += fmt.Sprintf(
+			" AND (site_transfer.from_site_id::text = $%d OR site_transfer.to_site_id::text = $%d)",
+			len(args),
+			len(args),
+		)
+	}
+
+	if params.equipmentTypeID != nil && *params.equipmentTypeID != "" {
+		args = append(args, *params.equipmentTypeID)
+		query += fmt.Sprintf(" AND site_transfer.equipment_type_id::text = $%d", len(args))
+	}
+
+	if params.page.Cursor != "" {
+		var cursorCreatedAt time.Time
+		var cursorID string
+		if err := api.DecodeCursor(params.page.Cursor, &cursorCreatedAt, &cursorID); err != nil {
+			return nil, nil, err
+		}
+
+		args = append(args, cursorCreatedAt, cursorID)
+		query += fmt.Sprintf(
+			" AND (site_transfer.created_at, site_transfer.site_transfer_id) < ($%d, $%d)",
+			len(args)-1,
+			len(args),
+		)
+	}
+
+	query += " ORDER BY site_transfer.created_at DESC, site_transfer.site_transfer_id DESC"
+
+	if params.page.Limit > 0 {
+		args = append(args, params.page.Limit+1)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := r.querier.Query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	transfers, err := pgx.CollectRows(rows, pgx.RowToStructByName[siteTransfer])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if transfers == nil {
+		transfers = []siteTransfer{}
+	}
+
+	return api.NextPage(transfers, params.page, func(t siteTransfer) []any {
+		return []any{t.CreatedAt, t.SiteTransferID}
+	})
+}
+
+func (r *repository) getSiteTransfer(ctx context.Context, id string) (siteTransfer, error) {
+	return getSiteTransfer(ctx, r.querier, id)
+}
+
+type dispatchSiteTransfer struct {
+	SiteTransferID string `json:"-"`
+
+	// UnitIDs optionally pins the exact units (by ID or asset tag) to send.
+	UnitIDs []string `json:"unitIds"`
+
+	DispatchedBy string `json:"-"`
+}
+
+// dispatchSiteTransfer sends the units out of the source site, which only its
+// managers can do.
+func (r *repository) dispatchSiteTransfer(ctx context.Context, arg dispatchSiteTransfer) (siteTransfer, error) {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	t, err := lockSiteTransfer(ctx, tx, arg.SiteTransferID)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+
+	if t.status != siteTransferRequested {
+		return siteTransfer{}, errSiteTransferNotRequested
+	}
+
+	if err := checkSiteManager(ctx, tx, arg.DispatchedBy, t.fromSiteID); err != nil {
+		return siteTransfer{}, err
+	}
+
+	if err := lockEquipmentType(ctx, tx, t.equipmentTypeID); err != nil {
+		return siteTransfer{}, err
+	}
+
+	if err := dispatchUnits(ctx, tx, t, arg.UnitIDs); err != nil {
+		return siteTransfer{}, err
+	}
+
+	query := `
+	UPDATE site_transfer
+	SET status = $1, dispatched_by = $2, dispatched_at = NOW(), updated_at = NOW()
+	WHERE site_transfer_id = $3
+	`
+	if _, err := tx.Exec(ctx, query, siteTransferDispatched, arg.DispatchedBy, t.siteTransferID); err != nil {
+		return siteTransfer{}, err
+	}
+
+	transfer, err := getSiteTransfer(ctx, tx, t.siteTransferID)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return siteTransfer{}, err
+	}
+
+	return transfer, nil
+}
+
+type receiveSiteTransfer struct {
+	SiteTransferID string `json:"-"`
+
+	// UnitIDs are the units (by ID or asset tag) that arrived. Leave it empty
+	// if all of them did.
+	UnitIDs []string `json:"unitIds"`
+
+	Remarks    *string `json:"remarks"`
+	ReceivedBy string  `json:"-"`
+}
+
+// receiveSiteTransfer confirms which units arrived at the destination site,
+// which only its managers can do.
+func (r *repository) receiveSiteTransfer(ctx context.Context, arg receiveSiteTransfer) (siteTransfer, error) {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	t, err := lockSiteTransfer(ctx, tx, arg.SiteTransferID)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+
+	if t.status != siteTransferDispatched {
+		return siteTransfer{}, errSiteTransferNotInTransit
+	}
+
+	if err := checkSiteManager(ctx, tx, arg.ReceivedBy, t.toSiteID); err != nil {
+		return siteTransfer{}, err
+	}
+
+	if err := receiveUnits(ctx, tx, t, arg.UnitIDs); err != nil {
+		return siteTransfer{}, err
+	}
+
+	query := `
+	UPDATE site_transfer
+	SET status = $1, received_by = $2, received_at = NOW(), remarks = $3, updated_at = NOW()
+	WHERE site_transfer_id = $4
+	`
+	if _, err := tx.Exec(ctx, query, siteTransferReceived, arg.ReceivedBy, arg.Remarks, t.siteTransferID); err != nil {
+		return siteTransfer{}, err
+	}
+
+	transfer, err := getSiteTransfer(ctx, tx, t.siteTransferID)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return siteTransfer{}, err
+	}
+
+	return transfer, nil
+}
+
+type cancelSiteTransfer struct {
+	SiteTransferID string  `json:"-"`
+	Remarks        *string `json:"remarks"`
+	CancelledBy    string  `json:"-"`
+}
+
+// cancelSiteTransfer drops a transfer that hasn't been dispatched yet.
+func (r *repository) cancelSiteTransfer(ctx context.Context, arg cancelSiteTransfer) (siteTransfer, error) {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	t, err := lockSiteTransfer(ctx, tx, arg.SiteTransferID)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+
+	if t.status != siteTransferRequested {
+		return siteTransfer{}, errSiteTransferNotRequested
+	}
+
+	if err := checkEitherSiteManager(ctx, tx, arg.CancelledBy, t); err != nil {
+		return siteTransfer{}, err
+	}
+
+	query := `
+	UPDATE site_transfer
+	SET status = $1, remarks = $2, updated_at = NOW()
+	WHERE site_transfer_id = $3
+	`
+	if _, err := tx.Exec(ctx, query, siteTransferCancelled, arg.Remarks, t.siteTransferID); err != nil {
+		return siteTransfer{}, err
+	}
+
+	transfer, err := getSiteTransfer(ctx, tx, t.siteTransferID)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return siteTransfer{}, err
+	}
+
+	return transfer, nil
+}
+
+type transferRequest struct {
+	EquipmentTypeID string  `json:"id"`
+	FromSiteID      string  `json:"fromSiteId"`
+	ToSiteID        string  `json:"toSiteId"`
+	Quantity        int     `json:"quantity"`
+	Reason          *string `json:"reason"`
+
+	// UnitIDs optionally pins the exact units (by ID or asset tag) to move.
+	UnitIDs []string `json:"unitIds"`
+
+	TransferredBy string `json:"-"`
+}
+
+// transferUnits moves available units of an equipment type to another site
+// in one go, for when a manager of both sites carries them over personally.
+// It still goes on the audit trail as a transfer that was received at once.
+func (r *repository) transferUnits(ctx context.Context, arg transferRequest) (siteTransfer, error) {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	if len(arg.UnitIDs) > 0 {
+		arg.Quantity = len(arg.UnitIDs)
+	}
+
+	t, err := insertSiteTransfer(ctx, tx, createSiteTransfer{
+		EquipmentTypeID: arg.EquipmentTypeID,
+		FromSiteID:      arg.FromSiteID,
+		ToSiteID:        arg.ToSiteID,
+		Quantity:        arg.Quantity,
+		Reason:          arg.Reason,
+		RequestedBy:     arg.TransferredBy,
+	})
+	if err != nil {
+		return siteTransfer{}, err
+	}
+
+	for _, siteID := range []string{t.fromSiteID, t.toSiteID} {
+		if err := checkSiteManager(ctx, tx, arg.TransferredBy, siteID); err != nil {
+			return siteTransfer{}, err
+		}
+	}
+
+	if err := dispatchUnits(ctx, tx, t, arg.UnitIDs); err != nil {
+		return siteTransfer{}, err
+	}
+
+	if err := receiveUnits(ctx, tx, t, nil); err != nil {
+		return siteTransfer{}, err
+	}
+
+	query := `
+	UPDATE site_transfer
+	SET
+		status = $1,
+		dispatched_by = $2,
+		dispatched_at = NOW(),
+		received_by = $2,
+		received_at = NOW(),
+		updated_at = NOW()
+	WHERE site_transfer_id = $3
+	`
+	if _, err := tx.Exec(ctx, query, siteTransferReceived, arg.TransferredBy, t.siteTransferID); err != nil {
+		return siteTransfer{}, err
+	}
+
+	transfer, err := getSiteTransfer(ctx, tx, t.siteTransferID)
+	if err != nil {
+		return siteTransfer{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return siteTransfer{}, err
+	}
+
+	return transfer, nil
+}
+
+// siteTransferErrorResponse maps the errors shared by the transfer endpoints.
+func siteTransferErrorResponse(op string, err error) api.Response {
+	if res, ok := siteAccessErrorResponse(op, err); ok {
+		return res
+	}
+
+	if message, ok := scannedUnitErrorMessage(err); ok {
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusBadRequest,
+			Message: message,
+		}
+	}
+
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusNotFound,
+			Message: "Site transfer or equipment not found.",
+		}
+	case errors.Is(err, errSameSite), errors.Is(err, errInvalidTransferQuantity):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusBadRequest,
+			Message: "Pick a quantity and a different site to transfer to.",
+		}
+	case errors.Is(err, errInsufficientToMove):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "Not enough available units at the source site.",
+		}
+	case errors.Is(err, errTransferOverbooking):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "The source site needs these units for upcoming borrow requests.",
+		}
+	case errors.Is(err, errSiteTransferNotRequested):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "This transfer was already dispatched or cancelled.",
+		}
+	case errors.Is(err, errSiteTransferNotInTransit):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "This transfer is not in transit.",
+		}
+	}
+
+	return api.Response{
+		Error:   fmt.Errorf("%s: %w", op, err),
+		Code:    http.StatusInternalServerError,
+		Message: fmt.Sprintf("Failed to %s.", op),
+	}
+}
+
+func (s *Server) createSiteTransfer(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data createSiteTransfer
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create site transfer: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid site transfer.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("create site transfer", err)
+	}
+	data.RequestedBy = claims.UserID
+
+	transfer, err := s.repository.createSiteTransfer(ctx, data)
+	if err != nil {
+		return siteTransferErrorResponse("create site transfer", err)
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventSiteTransferRequest,
+		Data:  transfer,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create site transfer: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to create site transfer.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create site transfer: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to create site transfer.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusCreated,
+		Message: "Successfully created site transfer.",
+		Data:    transfer,
+	}
+}
+
+func (s *Server) getSiteTransfers(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	page, err := api.ParsePage(r)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get site transfers: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid limit.",
+		}
+	}
+
+	query := r.URL.Query()
+	status := query.Get("status")
+	siteID := query.Get("site")
+	equipmentTypeID := query.Get("equipmentTypeId")
+
+	params := getSiteTransfersParams{
+		status:          &status,
+		siteID:          &siteID,
+		equipmentTypeID: &equipmentTypeID,
+		page:            page,
+	}
+
+	transfers, nextCursor, err := s.repository.getSiteTransfers(ctx, params)
+	if err != nil {
+		if errors.Is(err, api.ErrInvalidCursor) {
+			return api.Response{
+				Error:   fmt.Errorf("get site transfers: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid cursor.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("get site transfers: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get site transfers.",
+		}
+	}
+
+	return api.Response{
+		Code:       http.StatusOK,
+		Message:    "Successfully fetched site transfers.",
+		Data:       transfers,
+		NextCursor: nextCursor,
+	}
+}
+
+func (s *Server) getSiteTransfer(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	transfer, err := s.repository.getSiteTransfer(ctx, r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("get site transfer: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Site transfer not found.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("get site transfer: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get site transfer.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched site transfer.",
+		Data:    transfer,
+	}
+}
+
+func (s *Server) dispatchSiteTransfer(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data dispatchSiteTransfer
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("dispatch site transfer: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid site transfer dispatch.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("dispatch site transfer", err)
+	}
+	data.SiteTransferID = r.PathValue("id")
+	data.DispatchedBy = claims.UserID
+
+	transfer, err := s.repository.dispatchSiteTransfer(ctx, data)
+	if err != nil {
+		return siteTransferErrorResponse("dispatch site transfer", err)
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventSiteTransferDispatch,
+		Data:  transfer,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("dispatch site transfer: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to dispatch site transfer.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("dispatch site transfer: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to dispatch site transfer.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully dispatched site transfer.",
+		Data:    transfer,
+	}
+}
+
+func (s *Server) receiveSiteTransfer(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data receiveSiteTransfer
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("receive site transfer: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid site transfer receipt.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("receive site transfer", err)
+	}
+	data.SiteTransferID = r.PathValue("id")
+	data.ReceivedBy = claims.UserID
+
+	transfer, err := s.repository.receiveSiteTransfer(ctx, data)
+	if err != nil {
+		return siteTransferErrorResponse("receive site transfer", err)
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventSiteTransferReceive,
+		Data:  transfer,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("receive site transfer: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to receive site transfer.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("receive site transfer: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to receive site transfer.",
+		}
+	}
+
+	go s.offerWaitlistedEquipment(context.WithoutCancel(ctx))
+
+	message := "Successfully received site transfer."
+	if transfer.HasDiscrepancy {
+		message = "Received site transfer with missing units."
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: message,
+		Data:    transfer,
+	}
+}
+
+func (s *Server) cancelSiteTransfer(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data cancelSiteTransfer
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("cancel site transfer: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid site transfer cancellation.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("cancel site transfer", err)
+	}
+	data.SiteTransferID = r.PathValue("id")
+	data.CancelledBy = claims.UserID
+
+	transfer, err := s.repository.cancelSiteTransfer(ctx, data)
+	if err != nil {
+		return siteTransferErrorResponse("cancel site transfer", err)
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventSiteTransferCancel,
+		Data:  transfer,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("cancel site transfer: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to cancel site transfer.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("cancel site transfer: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to cancel site transfer.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully cancelled site transfer.",
+		Data:    transfer,
+	}
+}
+
+func (s *Server) transferUnits(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data transferRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("transfer units: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid transfer request.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("transfer units", err)
+	}
+	data.EquipmentTypeID = r.PathValue("equipmentTypeId")
+	data.TransferredBy = claims.UserID
+
+	transfer, err := s.repository.transferUnits(ctx, data)
+	if err != nil {
+		return siteTransferErrorResponse("transfer units", err)
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventEquipmentTransfer,
+		Data:  transfer,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("transfer units: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to transfer units.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("transfer units: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to transfer units.",
+		}
+	}
+
+	go s.offerWaitlistedEquipment(context.WithoutCancel(ctx))
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully transferred units.",
+		Data:    transfer,
+	}
+}
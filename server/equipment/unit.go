@@ -0,0 +1,384 @@
+package equipment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/user"
+)
+
+type unitBorrowTransaction struct {
+	BorrowTransactionID string         `json:"id"`
+	BorrowRequestID     string         `json:"borrowRequestId"`
+	Borrower            user.BasicInfo `json:"borrower"`
+	BorrowedAt          time.Time      `json:"borrowedAt"`
+	ReturnedAt          *time.Time     `json:"returnedAt"`
+}
+
+type equipmentUnit struct {
+	UnitID          string                  `json:"id"`
+	EquipmentTypeID string                  `json:"equipmentTypeId"`
+	AssetTag        string                  `json:"assetTag"`
+	SerialNumber    *string                 `json:"serialNumber"`
+	AcquiredAt      time.Time               `json:"acquiredAt"`
+	Status          equipmentStatusDetail   `json:"status"`
+	Site            siteDetail              `json:"site"`
+	CurrentBorrower *user.BasicInfo         `json:"currentBorrower"`
+	Transactions    []unitBorrowTransaction `json:"transactions,omitempty"`
+}
+
+type getUnitParams struct {
+	equipmentTypeID string
+	status          *string
+	siteID          *string
+}
+
+const unitQuery = `
+	SELECT
+		equipment.equipment_id,
+		equipment.equipment_type_id,
+		equipment.asset_tag,
+		equipment.serial_number,
+		equipment.acquired_at,
+		jsonb_build_object(
+			'id', equipment_status.equipment_status_id,
+			'code', equipment_status.code,
+			'label', equipment_status.label
+		) AS status,
+		jsonb_build_object(
+			'id', site.site_id,
+			'name', site.name,
+			'building', site.building,
+			'room', site.room
+		) AS site,
+		current_borrower.borrower
+	FROM equipment
+	JOIN equipment_status USING (equipment_status_id)
+	JOIN site USING (site_id)
+	LEFT JOIN LATERAL (
+		SELECT jsonb_build_object(
+			'id', person.person_id,
+			'firstName', person.first_name,
+			'middleName', person.middle_name,
+			'lastName', person.last_name,
+			'avatarUrl', person.avatar_url
+		) AS borrower
+		FROM borrow_transaction
+		JOIN borrow_request_item USING (borrow_request_item_id)
+		JOIN borrow_request USING (borrow_request_id)
+		JOIN person ON person.person_id = borrow_request.requested_by
+		WHERE borrow_transaction.equipment_id = equipment.equipment_id
+		AND NOT EXISTS (
+			SELECT 1
+			FROM return_transaction
+			WHERE return_transaction.borrow_transaction_id = borrow_transaction.borrow_transaction_id
+		)
+		ORDER BY borrow_transaction.created_at DESC
+		LIMIT 1
+	) current_borrower ON TRUE
+	`
+
+func (r *repository) getUnits(ctx context.Context, params getUnitParams) ([]equipmentUnit, error) {
+	query := unitQuery + " WHERE equipment.equipment_type_id = $1"
+
+	args := []any{params.equipmentTypeID}
+	argIdx := len(args) + 1
+
+	if params.status != nil && *params.status != "" {
+		status, ok := stringToEquipmentStatus[*params.status]
+		if !ok {
+			return nil, fmt.Errorf("invalid status: %s", *params.status)
+		}
+		query += fmt.Sprintf(" AND equipment.equipment_status_id = $%d", argIdx)
+		args = append(args, status)
+		argIdx++
+	}
+
+	if params.siteID != nil && *params.siteID != "" {
+		query += fmt.Sprintf(" AND equipment.site_id::text = $%d", argIdx)
+		args = append(args, *params.siteID)
+		argIdx++
+	}
+
+	query += " ORDER BY equipment.asset_tag"
+
+	rows, err := r.querier.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	units := []equipmentUnit{}
+	for rows.Next() {
+		var unit equipmentUnit
+		if err := rows.Scan(
+			&unit.UnitID,
+			&unit.EquipmentTypeID,
+			&unit.AssetTag,
+			&unit.SerialNumber,
+			&unit.AcquiredAt,
+			&unit.Status,
+			&unit.Site,
+			&unit.CurrentBorrower,
+		); err != nil {
+			return nil, err
+		}
+		units = append(units, unit)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return units, nil
+}
+
+// Units can be looked up by either their ID or their asset tag, since the
+// latter is what gets scanned off the label.
+func (r *repository) getUnit(ctx context.Context, equipmentTypeID, unitID string) (equipmentUnit, error) {
+	query := unitQuery + `
+	WHERE equipment.equipment_type_id = $1
+	AND (equipment.equipment_id::text = $2 OR equipment.asset_tag = $2)
+	`
+
+	var unit equipmentUnit
+	if err := r.querier.QueryRow(ctx, query, equipmentTypeID, unitID).Scan(
+		&unit.UnitID,
+		&unit.EquipmentTypeID,
+		&unit.AssetTag,
+		&unit.SerialNumber,
+		&unit.AcquiredAt,
+		&unit.Status,
+		&unit.Site,
+		&unit.CurrentBorrower,
+	); err != nil {
+		return equipmentUnit{}, err
+	}
+
+	transactionsQuery := `
+	SELECT
+		borrow_transaction.borrow_transaction_id,
+		borrow_request.borrow_request_id,
+		jsonb_build_object(
+			'id', person.person_id,
+			'firstName', person.first_name,
+			'middleName', person.middle_name,
+			'lastName', person.last_name,
+			'avatarUrl', person.avatar_url
+		) AS borrower,
+		borrow_transaction.created_at AS borrowed_at,
+		return_transaction.created_at AS returned_at
+	FROM borrow_transaction
+	JOIN borrow_request_item USING (borrow_request_item_id)
+	JOIN borrow_request USING (borrow_request_id)
+	JOIN person ON person.person_id = borrow_request.requested_by
+	LEFT JOIN return_transaction USING (borrow_transaction_id)
+	WHERE borrow_transaction.equipment_id = $1
+	ORDER BY borrow_transaction.created_at DESC
+	`
+
+	rows, err := r.querier.Query(ctx, transactionsQuery, unit.UnitID)
+	if err != nil {
+		return equipmentUnit{}, err
+	}
+	defer rows.Close()
+
+	unit.Transactions = []unitBorrowTransaction{}
+	for rows.Next() {
+		var transaction unitBorrowTransaction
+		if err := rows.Scan(
+			&transaction.BorrowTransactionID,
+			&transaction.BorrowRequestID,
+			&transaction.Borrower,
+			&transaction.BorrowedAt,
+			&transaction.ReturnedAt,
+		); err != nil {
+			return equipmentUnit{}, err
+		}
+		unit.Transactions = append(unit.Transactions, transaction)
+	}
+
+	if err := rows.Err(); err != nil {
+		return equipmentUnit{}, err
+	}
+
+	return unit, nil
+}
+
+type updateUnitRequest struct {
+	EquipmentTypeID string  `json:"equipmentTypeId"`
+	UnitID          string  `json:"id"`
+	AssetTag        *string `json:"assetTag"`
+	SerialNumber    *string `json:"serialNumber"`
+}
+
+var errDuplicateUnitIdentifier = fmt.Errorf("asset tag or serial number is already in use")
+
+func (r *repository) updateUnit(ctx context.Context, arg updateUnitRequest) (string, error) {
+	query := `
+	UPDATE equipment
+	SET asset_tag = COALESCE(NULLIF($1, ''), asset_tag),
+		serial_number = CASE WHEN $2::text IS NULL THEN serial_number ELSE NULLIF($2, '') END,
+		updated_at = NOW()
+	WHERE equipment_type_id = $3
+	AND (equipment_id::text = $4 OR asset_tag = $4)
+	RETURNING equipment_id
+	`
+
+	var unitID string
+	row := r.querier.QueryRow(ctx, query, arg.AssetTag, arg.SerialNumber, arg.EquipmentTypeID, arg.UnitID)
+	if err := row.Scan(&unitID); err != nil {
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			return "", errDuplicateUnitIdentifier
+		}
+		return "", err
+	}
+
+	return unitID, nil
+}
+
+func (s *Server) getUnits(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	status := r.URL.Query().Get("status")
+	if _, ok := stringToEquipmentStatus[status]; status != "" && !ok {
+		return api.Response{
+			Error:   fmt.Errorf("get units: invalid status %q", status),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid equipment status.",
+		}
+	}
+
+	siteID := r.URL.Query().Get("site")
+	params := getUnitParams{
+		equipmentTypeID: r.PathValue("equipmentTypeId"),
+		status:          &status,
+		siteID:          &siteID,
+	}
+	units, err := s.repository.getUnits(ctx, params)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get units: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get equipment units.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched equipment units.",
+		Data:    units,
+	}
+}
+
+func (s *Server) getUnit(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	equipmentTypeID := r.PathValue("equipmentTypeId")
+	unitID := r.PathValue("unitId")
+	unit, err := s.repository.getUnit(ctx, equipmentTypeID, unitID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("get unit: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Equipment unit not found.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("get unit: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get equipment unit.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched equipment unit.",
+		Data:    unit,
+	}
+}
+
+func (s *Server) updateUnit(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data updateUnitRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("update unit: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid update unit request.",
+		}
+	}
+
+	data.EquipmentTypeID = r.PathValue("equipmentTypeId")
+	data.UnitID = r.PathValue("unitId")
+
+	if data.AssetTag != nil {
+		assetTag := strings.TrimSpace(*data.AssetTag)
+		if assetTag == "" {
+			return api.Response{
+				Error:   fmt.Errorf("update unit: asset tag cannot be empty"),
+				Code:    http.StatusBadRequest,
+				Message: "Asset tag cannot be empty.",
+			}
+		}
+		data.AssetTag = &assetTag
+	}
+
+	if data.SerialNumber != nil {
+		serialNumber := strings.TrimSpace(*data.SerialNumber)
+		data.SerialNumber = &serialNumber
+	}
+
+	unitID, err := s.repository.updateUnit(ctx, data)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("update unit: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Equipment unit not found.",
+			}
+		}
+
+		if errors.Is(err, errDuplicateUnitIdentifier) {
+			return api.Response{
+				Error:   fmt.Errorf("update unit: %w", err),
+				Code:    http.StatusConflict,
+				Message: "Asset tag or serial number is already in use.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("update unit: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to update equipment unit.",
+		}
+	}
+
+	unit, err := s.repository.getUnit(ctx, data.EquipmentTypeID, unitID)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("update unit: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to update equipment unit.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully updated equipment unit.",
+		Data:    unit,
+	}
+}
@@ -0,0 +1,803 @@
+package equipment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/xGihyun/hirami/api"
+	"github.com/xGihyun/hirami/sse"
+	"github.com/xGihyun/hirami/user"
+)
+
+var (
+	errEquipmentAvailable      = fmt.Errorf("equipment is available for the requested window")
+	errWaitlistExceedsCapacity = fmt.Errorf("waitlist quantity exceeds total equipment units")
+	errInvalidWaitlistPosition = fmt.Errorf("waitlist position must be greater than zero")
+)
+
+type waitlistEntry struct {
+	WaitlistEntryID  string         `json:"id"`
+	CreatedAt        time.Time      `json:"createdAt"`
+	Position         int            `json:"position"`
+	Borrower         user.BasicInfo `json:"borrower"`
+	Equipment        equipment      `json:"equipment"`
+	Location         string         `json:"location"`
+	Purpose          string         `json:"purpose"`
+	ExpectedClaimAt  time.Time      `json:"expectedClaimAt"`
+	ExpectedReturnAt time.Time      `json:"expectedReturnAt"`
+	Site             siteDetail     `json:"site"`
+}
+
+// waitlistEntrySelect is shared by every query that returns waitlist
+// entries so they are all scanned the same way by scanWaitlistEntry.
+var waitlistEntrySelect = `
+SELECT
+	waitlist_entry.waitlist_entry_id,
+	waitlist_entry.created_at,
+	waitlist_entry.position,
+	jsonb_build_object(
+		'id', person.person_id,
+		'firstName', person.first_name,
+		'middleName', person.middle_name,
+		'lastName', person.last_name,
+		'avatarUrl', person.avatar_url
+	) AS borrower,
+	jsonb_build_object(
+		'id', equipment_type.equipment_type_id,
+		'name', equipment_type.name,
+		'brand', equipment_type.brand,
+		'model', equipment_type.model,
+		'imageUrl', equipment_type.image_url,
+		'quantity', waitlist_entry.quantity
+	) AS equipment,
+	waitlist_entry.location,
+	waitlist_entry.purpose,
+	waitlist_entry.expected_claim_at,
+	waitlist_entry.expected_return_at,
+	` + siteJSON("waitlist_entry.site_id") + ` AS site,
+	person.email
+FROM waitlist_entry
+JOIN person ON person.person_id = waitlist_entry.requested_by
+JOIN equipment_type ON equipment_type.equipment_type_id = waitlist_entry.equipment_type_id
+`
+
+func scanWaitlistEntry(row pgx.Row, entry *waitlistEntry, email *string) error {
+	return row.Scan(
+		&entry.WaitlistEntryID,
+		&entry.CreatedAt,
+		&entry.Position,
+		&entry.Borrower,
+		&entry.Equipment,
+		&entry.Location,
+		&entry.Purpose,
+		&entry.ExpectedClaimAt,
+		&entry.ExpectedReturnAt,
+		&entry.Site,
+		email,
+	)
+}
+
+// lockEquipmentType serializes changes to an equipment type's waitlist with
+// approvals and offers for the same type.
+func lockEquipmentType(ctx context.Context, tx pgx.Tx, equipmentTypeID string) error {
+	query := `
+	SELECT equipment_type_id
+	FROM equipment_type
+	WHERE equipment_type_id = $1
+	FOR UPDATE
+	`
+
+	var id string
+	return tx.QueryRow(ctx, query, equipmentTypeID).Scan(&id)
+}
+
+// renumberWaitlist closes the gaps left in an equipment type's queue once
+// entries are removed or offered.
+func renumberWaitlist(ctx context.Context, tx pgx.Tx, equipmentTypeID string) error {
+	query := `
+	UPDATE waitlist_entry
+	SET position = ranked.position, updated_at = NOW()
+	FROM (
+		SELECT
+			waitlist_entry_id,
+			ROW_NUMBER() OVER (ORDER BY position, created_at) AS position
+		FROM waitlist_entry
+		WHERE equipment_type_id = $1 AND offered_at IS NULL
+	) ranked
+	WHERE waitlist_entry.waitlist_entry_id = ranked.waitlist_entry_id
+	AND waitlist_entry.position <> ranked.position
+	`
+
+	_, err := tx.Exec(ctx, query, equipmentTypeID)
+	return err
+}
+
+type joinWaitlistRequest struct {
+	EquipmentTypeID  string    `json:"equipmentTypeId"`
+	Quantity         uint      `json:"quantity"`
+	Location         string    `json:"location"`
+	Purpose          string    `json:"purpose"`
+	ExpectedClaimAt  time.Time `json:"expectedClaimAt"`
+	ExpectedReturnAt time.Time `json:"expectedReturnAt"`
+	RequestedBy      string    `json:"requestedBy"`
+	SiteID           string    `json:"siteId"`
+}
+
+func (r *repository) joinWaitlist(ctx context.Context, arg joinWaitlistRequest) (waitlistEntry, error) {
+	if arg.Quantity <= 0 {
+		return waitlistEntry{}, errInvalidBorrowQuantity
+	}
+
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return waitlistEntry{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockEquipmentType(ctx, tx, arg.EquipmentTypeID); err != nil {
+		return waitlistEntry{}, err
+	}
+
+	siteID, err := resolveSiteID(ctx, tx, arg.SiteID)
+	if err != nil {
+		return waitlistEntry{}, err
+	}
+
+	capacity, err := getCapacity(ctx, tx, siteID, arg.EquipmentTypeID)
+	if err != nil {
+		return waitlistEntry{}, err
+	}
+
+	if int(arg.Quantity) > capacity {
+		return waitlistEntry{}, errWaitlistExceedsCapacity
+	}
+
+	// The offer becomes a borrow request, so it has to follow the same
+	// policies as one.
+	items := []borrowEquipmentItem{{EquipmentTypeID: arg.EquipmentTypeID, Quantity: arg.Quantity}}
+	if _, err := checkBorrowPolicies(ctx, tx, arg.RequestedBy, items, arg.ExpectedClaimAt, arg.ExpectedReturnAt, ""); err != nil {
+		return waitlistEntry{}, err
+	}
+
+	// Only fully booked windows can be waitlisted, otherwise the borrower
+	// should just create a borrow request.
+	err = checkAvailability(ctx, tx, siteID, items, arg.ExpectedClaimAt, arg.ExpectedReturnAt, "")
+	if err == nil {
+		return waitlistEntry{}, errEquipmentAvailable
+	}
+	if !errors.Is(err, errInsufficientEquipmentQuantity) {
+		return waitlistEntry{}, err
+	}
+
+	insertQuery := `
+	INSERT INTO waitlist_entry (
+		equipment_type_id,
+		quantity,
+		location,
+		purpose,
+		expected_claim_at,
+		expected_return_at,
+		requested_by,
+		site_id,
+		position
+	)
+	SELECT $1, $2, $3, $4, $5, $6, $7, $8, COALESCE(MAX(position), 0) + 1
+	FROM waitlist_entry
+	WHERE equipment_type_id = $1 AND offered_at IS NULL
+	RETURNING waitlist_entry_id
+	`
+
+	var waitlistEntryID string
+	if err := tx.QueryRow(
+		ctx,
+		insertQuery,
+		arg.EquipmentTypeID,
+		int16(arg.Quantity),
+		arg.Location,
+		arg.Purpose,
+		arg.ExpectedClaimAt,
+		arg.ExpectedReturnAt,
+		arg.RequestedBy,
+		siteID,
+	).Scan(&waitlistEntryID); err != nil {
+		return waitlistEntry{}, err
+	}
+
+	var (
+		entry waitlistEntry
+		email string
+	)
+	row := tx.QueryRow(ctx, waitlistEntrySelect+" WHERE waitlist_entry.waitlist_entry_id = $1", waitlistEntryID)
+	if err := scanWaitlistEntry(row, &entry, &email); err != nil {
+		return waitlistEntry{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return waitlistEntry{}, err
+	}
+
+	return entry, nil
+}
+
+type getWaitlistParams struct {
+	equipmentTypeID *string
+}
+
+func (r *repository) getWaitlist(ctx context.Context, params getWaitlistParams) ([]waitlistEntry, error) {
+	query := waitlistEntrySelect + " WHERE waitlist_entry.offered_at IS NULL"
+
+	var args []any
+	if params.equipmentTypeID != nil && *params.equipmentTypeID != "" {
+		query += " AND waitlist_entry.equipment_type_id = $1"
+		args = append(args, *params.equipmentTypeID)
+	}
+
+	query += " ORDER BY equipment_type.name, waitlist_entry.equipment_type_id, waitlist_entry.position"
+
+	rows, err := r.querier.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []waitlistEntry{}
+	for rows.Next() {
+		var (
+			entry waitlistEntry
+			email string
+		)
+		if err := scanWaitlistEntry(rows, &entry, &email); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+type moveWaitlistEntryRequest struct {
+	WaitlistEntryID string `json:"id"`
+	Position        int    `json:"position"`
+}
+
+// moveWaitlistEntry puts an entry at the given position in its equipment
+// type's queue and shifts the others around it. Positions past the end of
+// the queue move the entry to the back.
+func (r *repository) moveWaitlistEntry(ctx context.Context, arg moveWaitlistEntryRequest) error {
+	if arg.Position < 1 {
+		return errInvalidWaitlistPosition
+	}
+
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var equipmentTypeID string
+	typeQuery := `
+	SELECT equipment_type_id
+	FROM waitlist_entry
+	WHERE waitlist_entry_id = $1 AND offered_at IS NULL
+	`
+	if err := tx.QueryRow(ctx, typeQuery, arg.WaitlistEntryID).Scan(&equipmentTypeID); err != nil {
+		return err
+	}
+
+	if err := lockEquipmentType(ctx, tx, equipmentTypeID); err != nil {
+		return err
+	}
+
+	queueQuery := `
+	SELECT waitlist_entry_id
+	FROM waitlist_entry
+	WHERE equipment_type_id = $1 AND offered_at IS NULL
+	ORDER BY position, created_at
+	`
+	rows, err := tx.Query(ctx, queueQuery, equipmentTypeID)
+	if err != nil {
+		return err
+	}
+
+	queue, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	// The entry may have been offered while we were waiting for the lock.
+	idx := slices.Index(queue, arg.WaitlistEntryID)
+	if idx == -1 {
+		return pgx.ErrNoRows
+	}
+
+	queue = slices.Delete(queue, idx, idx+1)
+	position := min(arg.Position, len(queue)+1)
+	queue = slices.Insert(queue, position-1, arg.WaitlistEntryID)
+
+	updateQuery := `
+	UPDATE waitlist_entry
+	SET position = ordered.position, updated_at = NOW()
+	FROM unnest($1::uuid[]) WITH ORDINALITY AS ordered(waitlist_entry_id, position)
+	WHERE waitlist_entry.waitlist_entry_id = ordered.waitlist_entry_id
+	`
+	if _, err := tx.Exec(ctx, updateQuery, queue); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *repository) deleteWaitlistEntry(ctx context.Context, waitlistEntryID string) error {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+	DELETE FROM waitlist_entry
+	WHERE waitlist_entry_id = $1 AND offered_at IS NULL
+	RETURNING equipment_type_id
+	`
+
+	var equipmentTypeID string
+	if err := tx.QueryRow(ctx, query, waitlistEntryID).Scan(&equipmentTypeID); err != nil {
+		return err
+	}
+
+	if err := renumberWaitlist(ctx, tx, equipmentTypeID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *repository) clearWaitlist(ctx context.Context, equipmentTypeID string) (int64, error) {
+	query := `
+	DELETE FROM waitlist_entry
+	WHERE equipment_type_id = $1 AND offered_at IS NULL
+	`
+
+	tag, err := r.querier.Exec(ctx, query, equipmentTypeID)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+type waitlistOffer struct {
+	WaitlistEntryID  string         `json:"waitlistEntryId"`
+	BorrowRequestID  string         `json:"borrowRequestId"`
+	Borrower         user.BasicInfo `json:"borrower"`
+	Equipment        equipment      `json:"equipment"`
+	ExpectedClaimAt  time.Time      `json:"expectedClaimAt"`
+	ExpectedReturnAt time.Time      `json:"expectedReturnAt"`
+	Site             siteDetail     `json:"site"`
+
+	email string
+}
+
+// processWaitlist walks every queue in order and turns each entry whose
+// window has enough free units into a pending borrow request. Entries whose
+// claim time has already passed are dropped since they can't be offered
+// anymore.
+func (r *repository) processWaitlist(ctx context.Context) ([]waitlistOffer, error) {
+	tx, err := r.querier.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	lockQuery := `
+	SELECT equipment_type_id
+	FROM equipment_type
+	WHERE equipment_type_id IN (
+		SELECT equipment_type_id
+		FROM waitlist_entry
+		WHERE offered_at IS NULL
+	)
+	ORDER BY equipment_type_id
+	FOR UPDATE
+	`
+	rows, err := tx.Query(ctx, lockQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	equipmentTypeIDs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(equipmentTypeIDs) == 0 {
+		return nil, nil
+	}
+
+	expiredQuery := `
+	DELETE FROM waitlist_entry
+	WHERE offered_at IS NULL AND expected_claim_at <= NOW()
+	`
+	if _, err := tx.Exec(ctx, expiredQuery); err != nil {
+		return nil, err
+	}
+
+	entriesQuery := waitlistEntrySelect + `
+	WHERE waitlist_entry.offered_at IS NULL
+	ORDER BY waitlist_entry.equipment_type_id, waitlist_entry.position
+	`
+	rows, err = tx.Query(ctx, entriesQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []waitlistOffer
+	for rows.Next() {
+		var (
+			entry waitlistEntry
+			email string
+		)
+		if err := scanWaitlistEntry(rows, &entry, &email); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		entries = append(entries, waitlistOffer{
+			WaitlistEntryID:  entry.WaitlistEntryID,
+			Borrower:         entry.Borrower,
+			Equipment:        entry.Equipment,
+			ExpectedClaimAt:  entry.ExpectedClaimAt,
+			ExpectedReturnAt: entry.ExpectedReturnAt,
+			Site:             entry.Site,
+			email:            email,
+		})
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The pending request is linked back to its entry in the same statement,
+	// so getBookings already counts it when the next entry is checked.
+	offerQuery := `
+	WITH inserted_request AS (
+		INSERT INTO borrow_request (location, purpose, expected_claim_at, expected_return_at, requested_by, site_id)
+		SELECT location, purpose, expected_claim_at, expected_return_at, requested_by, site_id
+		FROM waitlist_entry
+		WHERE waitlist_entry_id = $1
+		RETURNING borrow_request_id
+	),
+	inserted_item AS (
+		INSERT INTO borrow_request_item (borrow_request_id, equipment_type_id, quantity)
+		SELECT inserted_request.borrow_request_id, waitlist_entry.equipment_type_id, waitlist_entry.quantity
+		FROM inserted_request, waitlist_entry
+		WHERE waitlist_entry.waitlist_entry_id = $1
+	)
+	UPDATE waitlist_entry
+	SET offered_at = NOW(), updated_at = NOW(), borrow_request_id = inserted_request.borrow_request_id
+	FROM inserted_request
+	WHERE waitlist_entry.waitlist_entry_id = $1
+	RETURNING inserted_request.borrow_request_id
+	`
+
+	var offers []waitlistOffer
+	for _, entry := range entries {
+		items := []borrowEquipmentItem{{EquipmentTypeID: entry.Equipment.EquipmentTypeID, Quantity: entry.Equipment.Quantity}}
+		err := checkAvailability(ctx, tx, entry.Site.SiteID, items, entry.ExpectedClaimAt, entry.ExpectedReturnAt, "")
+		if errors.Is(err, errInsufficientEquipmentQuantity) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := tx.QueryRow(ctx, offerQuery, entry.WaitlistEntryID).Scan(&entry.BorrowRequestID); err != nil {
+			return nil, err
+		}
+
+		offers = append(offers, entry)
+	}
+
+	for _, equipmentTypeID := range equipmentTypeIDs {
+		if err := renumberWaitlist(ctx, tx, equipmentTypeID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return offers, nil
+}
+
+// offerWaitlistedEquipment hands freed up units to the next borrowers in
+// line and lets them know through SSE and email. It runs after anything that
+// can free units, so failures are only logged.
+func (s *Server) offerWaitlistedEquipment(ctx context.Context) {
+	offers, err := s.repository.processWaitlist(ctx)
+	if err != nil {
+		slog.Error("Error processing waitlist: " + err.Error())
+		return
+	}
+
+	for _, offer := range offers {
+		eventRes := sse.EventResponse{
+			Event: eventWaitlistOffer,
+			Data:  offer,
+		}
+		jsonData, err := json.Marshal(eventRes)
+		if err != nil {
+			slog.Error("Error encoding waitlist offer: " + err.Error())
+			continue
+		}
+
+		pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+		if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+			slog.Error("Error publishing waitlist offer: " + res.Error().Error())
+		}
+
+		fullName := fmt.Sprintf("%s %s", offer.Borrower.FirstName, offer.Borrower.LastName)
+		message := fmt.Sprintf(
+			"%d unit(s) of %s are now free from %s to %s. We have created a borrow request for you and it is waiting for approval.",
+			offer.Equipment.Quantity,
+			offer.Equipment.Name,
+			offer.ExpectedClaimAt.Format("Jan 2, 2006 3:04 PM"),
+			offer.ExpectedReturnAt.Format("Jan 2, 2006 3:04 PM"),
+		)
+		if err := s.sendNotificationEmail(offer.email, "Your Waitlisted Equipment Is Available", fullName, "Equipment Available", message); err != nil {
+			slog.Error("Error emailing waitlist offer: " + err.Error())
+		}
+	}
+}
+
+func (s *Server) joinWaitlist(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data joinWaitlistRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("join waitlist: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid join waitlist request.",
+		}
+	}
+
+	claims, err := userClaims(r)
+	if err != nil {
+		return unauthorizedResponse("join waitlist", err)
+	}
+	data.RequestedBy = claims.UserID
+
+	if strings.TrimSpace(data.Location) == "" {
+		return api.Response{
+			Error:   fmt.Errorf("join waitlist: location is required"),
+			Code:    http.StatusBadRequest,
+			Message: "Location is required.",
+		}
+	}
+
+	if strings.TrimSpace(data.Purpose) == "" {
+		return api.Response{
+			Error:   fmt.Errorf("join waitlist: purpose is required"),
+			Code:    http.StatusBadRequest,
+			Message: "Purpose is required.",
+		}
+	}
+
+	if !data.ExpectedReturnAt.After(data.ExpectedClaimAt) {
+		return api.Response{
+			Error:   fmt.Errorf("join waitlist: expected return time must be after claim time"),
+			Code:    http.StatusBadRequest,
+			Message: "Expected return time must be after claim time.",
+		}
+	}
+
+	if res, suspended := s.checkSuspension(ctx, "join waitlist", data.RequestedBy); suspended {
+		return res
+	}
+
+	entry, err := s.repository.joinWaitlist(ctx, data)
+	if err != nil {
+		if res, ok := siteAccessErrorResponse("join waitlist", err); ok {
+			return res
+		}
+
+		if errors.Is(err, errInvalidBorrowQuantity) {
+			return api.Response{
+				Error:   fmt.Errorf("join waitlist: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Borrow quantity must be greater than zero.",
+			}
+		}
+
+		if res, ok := policyViolationResponse("join waitlist", err); ok {
+			return res
+		}
+
+		if errors.Is(err, errWaitlistExceedsCapacity) {
+			return api.Response{
+				Error:   fmt.Errorf("join waitlist: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Requested quantity exceeds the total number of units.",
+			}
+		}
+
+		if errors.Is(err, errEquipmentAvailable) {
+			return api.Response{
+				Error:   fmt.Errorf("join waitlist: %w", err),
+				Code:    http.StatusConflict,
+				Message: "Equipment is available for this time window. Create a borrow request instead.",
+			}
+		}
+
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("join waitlist: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Equipment not found.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("join waitlist: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to join waitlist.",
+		}
+	}
+
+	eventRes := sse.EventResponse{
+		Event: eventWaitlistJoin,
+		Data:  entry,
+	}
+	jsonData, err := json.Marshal(eventRes)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("join waitlist: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to join waitlist.",
+		}
+	}
+
+	pubCmd := s.valkeyClient.B().Publish().Channel("equipment").Message(string(jsonData)).Build()
+	if res := s.valkeyClient.Do(ctx, pubCmd); res.Error() != nil {
+		return api.Response{
+			Error:   fmt.Errorf("join waitlist: %w", res.Error()),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to join waitlist.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusCreated,
+		Message: "Successfully joined waitlist.",
+		Data:    entry,
+	}
+}
+
+func (s *Server) getWaitlist(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var params getWaitlistParams
+	if equipmentTypeID := r.URL.Query().Get("equipmentTypeId"); equipmentTypeID != "" {
+		params.equipmentTypeID = &equipmentTypeID
+	}
+
+	entries, err := s.repository.getWaitlist(ctx, params)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get waitlist: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get waitlist.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched waitlist.",
+		Data:    entries,
+	}
+}
+
+func (s *Server) moveWaitlistEntry(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data moveWaitlistEntryRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("move waitlist entry: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid move waitlist entry request.",
+		}
+	}
+
+	data.WaitlistEntryID = r.PathValue("id")
+
+	if err := s.repository.moveWaitlistEntry(ctx, data); err != nil {
+		if errors.Is(err, errInvalidWaitlistPosition) {
+			return api.Response{
+				Error:   fmt.Errorf("move waitlist entry: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Position must be greater than zero.",
+			}
+		}
+
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("move waitlist entry: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Waitlist entry not found.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("move waitlist entry: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to move waitlist entry.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully moved waitlist entry.",
+	}
+}
+
+func (s *Server) deleteWaitlistEntry(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	if err := s.repository.deleteWaitlistEntry(ctx, r.PathValue("id")); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return api.Response{
+				Error:   fmt.Errorf("delete waitlist entry: %w", err),
+				Code:    http.StatusNotFound,
+				Message: "Waitlist entry not found.",
+			}
+		}
+
+		return api.Response{
+			Error:   fmt.Errorf("delete waitlist entry: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to remove waitlist entry.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully removed waitlist entry.",
+	}
+}
+
+func (s *Server) clearWaitlist(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	count, err := s.repository.clearWaitlist(ctx, r.PathValue("equipmentTypeId"))
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("clear waitlist: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to clear waitlist.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: fmt.Sprintf("Successfully removed %d waitlist entries.", count),
+	}
+}
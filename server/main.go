@@ -128,7 +128,7 @@ func main() {
 
 	app := app{
 		user:      *user.NewServer(userRepo, gmailService, testMode),
-		equipment: *equipment.NewServer(equipment.NewRepository(pool), valkeyClient),
+		equipment: *equipment.NewServer(equipment.NewRepository(pool), valkeyClient, gmailService),
 		sse:       *sse.NewServer(valkeyClient),
 		mw:        mw,
 	}
@@ -137,8 +137,8 @@ func main() {
 	router.Handle("GET /uploads/", http.StripPrefix("/uploads", fs))
 
 	router.HandleFunc("GET /events", app.sse.EventsHandler)
-	app.user.SetupRoutes(router, app.mw.AuthMiddleware, rateLimitFn)
-	app.equipment.SetupRoutes(router, app.mw.AuthMiddleware, app.mw.RequireRole)
+	app.user.SetupRoutes(router, app.mw.AuthMiddleware, rateLimitFn, app.mw.RequirePermission)
+	app.equipment.SetupRoutes(router, app.mw.AuthMiddleware, app.mw.RequirePermission)
 
 	app.equipment.StartExpirationWorker(ctx)
 
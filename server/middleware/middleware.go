@@ -14,12 +14,24 @@ import (
 )
 
 type UserClaims struct {
-	UserID string
-	Role   user.Role
+	UserID      string
+	Role        user.Role
+	Permissions []user.Permission
+}
+
+// Can reports whether the user's role grants the permission.
+func (c *UserClaims) Can(permission user.Permission) bool {
+	return slices.Contains(c.Permissions, permission)
 }
 
 const UserContextKey = "user"
 
+// GetClaims returns the claims that AuthMiddleware added to the context.
+func GetClaims(ctx context.Context) (*UserClaims, bool) {
+	claims, ok := ctx.Value(UserContextKey).(*UserClaims)
+	return claims, ok && claims != nil
+}
+
 type Middleware struct {
 	userRepo     user.Repository
 	valkeyClient valkey.Client
@@ -32,8 +44,9 @@ func NewMiddleware(repo user.Repository, valkeyClient valkey.Client) *Middleware
 	}
 }
 
-// RequireRole returns a middleware that checks if the user has the required role
-func (m *Middleware) RequireRole(allowedRoles ...user.Role) func(http.Handler) http.Handler {
+// RequirePermission returns a middleware that checks if the user's role
+// grants any of the permissions
+func (m *Middleware) RequirePermission(permissions ...user.Permission) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			claims, ok := r.Context().Value(UserContextKey).(*UserClaims)
@@ -42,9 +55,7 @@ func (m *Middleware) RequireRole(allowedRoles ...user.Role) func(http.Handler) h
 				return
 			}
 
-			hasRole := slices.Contains(allowedRoles, claims.Role)
-
-			if !hasRole {
+			if !slices.ContainsFunc(permissions, claims.Can) {
 				http.Error(w, "Forbidden", http.StatusForbidden)
 				return
 			}
@@ -78,21 +89,10 @@ func (m *Middleware) AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Convert user.RoleDetail to user.Role
-		var role user.Role
-		switch result.User.Role.Code {
-		case "borrower":
-			role = user.Borrower
-		case "equipment_manager":
-			role = user.EquipmentManager
-		default:
-			http.Error(w, "Unknown user role", http.StatusInternalServerError)
-			return
-		}
-
 		claims := &UserClaims{
-			UserID: result.User.UserID,
-			Role:   role,
+			UserID:      result.User.UserID,
+			Role:        user.Role(result.User.Role.Code),
+			Permissions: result.User.Role.Permissions,
 		}
 
 		// Add claims to request context
@@ -145,6 +145,12 @@ func (rw *responseWriter) WriteHeader(status int) {
 	rw.ResponseWriter.WriteHeader(status)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush streamed responses.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -0,0 +1,41 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE SEQUENCE IF NOT EXISTS equipment_asset_tag_seq;
+
+ALTER TABLE equipment
+ADD COLUMN asset_tag TEXT,
+ADD COLUMN serial_number TEXT;
+
+-- Backfill existing units in the order they were acquired
+WITH numbered_equipment AS (
+    SELECT
+        equipment_id,
+        ROW_NUMBER() OVER (ORDER BY acquired_at, created_at, equipment_id) AS n
+    FROM equipment
+)
+UPDATE equipment
+SET asset_tag = 'HRM-' || LPAD(numbered_equipment.n::TEXT, 6, '0')
+FROM numbered_equipment
+WHERE numbered_equipment.equipment_id = equipment.equipment_id;
+
+SELECT setval(
+    'equipment_asset_tag_seq',
+    GREATEST((SELECT COUNT(*) FROM equipment), 1),
+    (SELECT COUNT(*) FROM equipment) > 0
+);
+
+ALTER TABLE equipment
+ALTER COLUMN asset_tag SET DEFAULT 'HRM-' || LPAD(nextval('equipment_asset_tag_seq')::TEXT, 6, '0'),
+ALTER COLUMN asset_tag SET NOT NULL,
+ADD CONSTRAINT equipment_asset_tag_key UNIQUE (asset_tag),
+ADD CONSTRAINT equipment_serial_number_key UNIQUE (serial_number);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE equipment
+DROP COLUMN asset_tag,
+DROP COLUMN serial_number;
+
+DROP SEQUENCE IF EXISTS equipment_asset_tag_seq;
+-- +goose StatementEnd
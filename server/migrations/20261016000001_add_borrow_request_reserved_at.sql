@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE borrow_request
+ADD COLUMN reserved_at TIMESTAMPTZ;
+
+-- Approved requests used to reserve their units as soon as they were reviewed
+UPDATE borrow_request
+SET reserved_at = COALESCE(reviewed_at, NOW())
+WHERE borrow_request_status_id = (
+    SELECT borrow_request_status_id
+    FROM borrow_request_status
+    WHERE code = 'approved'
+);
+
+CREATE INDEX borrow_request_window_idx
+ON borrow_request (expected_claim_at, expected_return_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS borrow_request_window_idx;
+
+ALTER TABLE borrow_request
+DROP COLUMN reserved_at;
+-- +goose StatementEnd
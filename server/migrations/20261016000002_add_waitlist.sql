@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS waitlist_entry (
+    waitlist_entry_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    equipment_type_id UUID NOT NULL REFERENCES equipment_type(equipment_type_id) ON DELETE CASCADE,
+    quantity SMALLINT NOT NULL CHECK (quantity > 0),
+    location TEXT NOT NULL,
+    purpose TEXT NOT NULL,
+    expected_claim_at TIMESTAMPTZ NOT NULL,
+    expected_return_at TIMESTAMPTZ NOT NULL,
+    requested_by UUID NOT NULL REFERENCES person(person_id) ON DELETE CASCADE,
+    position INT NOT NULL,
+
+    -- Set once the entry has been turned into a pending borrow request
+    offered_at TIMESTAMPTZ,
+    borrow_request_id UUID REFERENCES borrow_request(borrow_request_id) ON DELETE SET NULL
+);
+
+CREATE INDEX waitlist_entry_queue_idx
+ON waitlist_entry (equipment_type_id, position)
+WHERE offered_at IS NULL;
+
+CREATE INDEX waitlist_entry_borrow_request_idx
+ON waitlist_entry (borrow_request_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS waitlist_entry;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE borrow_request
+ADD COLUMN overdue_at TIMESTAMPTZ;
+
+-- One row per reminder stage ('before', 'due', 'overdue-1', ...) so the
+-- worker never emails the same borrower twice for the same stage
+CREATE TABLE IF NOT EXISTS borrow_request_reminder (
+    borrow_request_reminder_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    borrow_request_id UUID NOT NULL REFERENCES borrow_request(borrow_request_id) ON DELETE CASCADE,
+    stage TEXT NOT NULL,
+
+    UNIQUE (borrow_request_id, stage)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS borrow_request_reminder;
+
+ALTER TABLE borrow_request
+DROP COLUMN overdue_at;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS borrower_strike (
+    borrower_strike_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    person_id UUID NOT NULL REFERENCES person(person_id) ON DELETE CASCADE,
+    reason TEXT NOT NULL CHECK (reason IN ('late_return', 'unclaimed', 'damaged', 'lost')),
+    borrow_request_id UUID REFERENCES borrow_request(borrow_request_id) ON DELETE SET NULL,
+
+    -- Only set for damaged and lost units
+    equipment_id UUID REFERENCES equipment(equipment_id) ON DELETE SET NULL,
+
+    waived_at TIMESTAMPTZ,
+    waived_by UUID REFERENCES person(person_id),
+    waive_reason TEXT,
+
+    UNIQUE NULLS NOT DISTINCT (borrow_request_id, reason, equipment_id)
+);
+
+CREATE INDEX borrower_strike_person_idx
+ON borrower_strike (person_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS borrower_strike;
+-- +goose StatementEnd
@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS borrow_policy (
+    borrow_policy_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    name TEXT NOT NULL,
+
+    -- A policy applies to an equipment type, a category or, when both are
+    -- NULL, to everything that has no more specific policy
+    equipment_type_id UUID REFERENCES equipment_type(equipment_type_id) ON DELETE CASCADE,
+    category_id UUID REFERENCES category(category_id) ON DELETE CASCADE,
+
+    max_quantity INT CHECK (max_quantity > 0),
+    lead_time_minutes INT NOT NULL DEFAULT 60 CHECK (lead_time_minutes >= 0),
+    min_loan_minutes INT NOT NULL DEFAULT 60 CHECK (min_loan_minutes >= 0),
+    max_loan_minutes INT CHECK (max_loan_minutes > 0),
+    max_concurrent_loans INT CHECK (max_concurrent_loans > 0),
+    requires_approval BOOLEAN NOT NULL DEFAULT TRUE,
+
+    CHECK (equipment_type_id IS NULL OR category_id IS NULL),
+    CHECK (max_loan_minutes IS NULL OR max_loan_minutes >= min_loan_minutes),
+    UNIQUE NULLS NOT DISTINCT (equipment_type_id, category_id)
+);
+
+-- Same rules that used to be hard-coded when creating a borrow request
+INSERT INTO borrow_policy (name, lead_time_minutes, min_loan_minutes)
+VALUES ('Default', 60, 60);
+
+-- Reviewer recorded on requests that are approved without a manager. It
+-- can't log in since it is inactive and has no valid password hash.
+INSERT INTO person (email, password_hash, first_name, last_name, is_active, person_role_id)
+SELECT 'system@hirami.local', '!', 'Hirami', 'System', FALSE, person_role_id
+FROM person_role
+WHERE code = 'equipment_manager'
+ON CONFLICT (email) DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- The system reviewer is kept since approved requests may still reference it
+DROP TABLE IF EXISTS borrow_policy;
+-- +goose StatementEnd
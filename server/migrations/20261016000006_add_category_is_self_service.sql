@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Requests for equipment in self-service categories can be approved
+-- automatically for borrowers in good standing
+ALTER TABLE category
+ADD COLUMN is_self_service BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE category
+DROP COLUMN is_self_service;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+INSERT INTO borrow_request_status (code, label)
+VALUES ('cancelled', 'Cancelled');
+
+ALTER TABLE borrow_request
+ADD COLUMN cancelled_at TIMESTAMPTZ;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE borrow_request
+DROP COLUMN cancelled_at;
+
+UPDATE borrow_request
+SET borrow_request_status_id = (
+    SELECT borrow_request_status_id FROM borrow_request_status WHERE code = 'rejected'
+)
+WHERE borrow_request_status_id = (
+    SELECT borrow_request_status_id FROM borrow_request_status WHERE code = 'cancelled'
+);
+
+DELETE FROM borrow_request_status
+WHERE code = 'cancelled';
+-- +goose StatementEnd
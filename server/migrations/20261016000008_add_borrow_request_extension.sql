@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS borrow_request_extension (
+    borrow_request_extension_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    borrow_request_id UUID NOT NULL REFERENCES borrow_request(borrow_request_id) ON DELETE CASCADE,
+    requested_by UUID NOT NULL REFERENCES person(person_id) ON DELETE CASCADE,
+
+    -- The due date when the extension was filed, so the original due date
+    -- is still known after it is approved
+    previous_return_at TIMESTAMPTZ NOT NULL,
+    requested_return_at TIMESTAMPTZ NOT NULL CHECK (requested_return_at > previous_return_at),
+    reason TEXT,
+
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    reviewed_by UUID REFERENCES person(person_id),
+    reviewed_at TIMESTAMPTZ,
+    remarks TEXT
+);
+
+-- A borrower can only wait on one extension per request at a time
+CREATE UNIQUE INDEX borrow_request_extension_pending_idx
+ON borrow_request_extension (borrow_request_id)
+WHERE status = 'pending';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS borrow_request_extension;
+-- +goose StatementEnd
@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE person_role
+ADD COLUMN permissions TEXT[] NOT NULL DEFAULT '{}',
+-- Built-in roles can't be deleted or renamed
+ADD COLUMN is_system BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE person_role
+SET permissions = '{equipment.write,borrow.view,borrow.review,borrow.fulfill,reports.view,users.manage}',
+    is_system = TRUE
+WHERE code = 'equipment_manager';
+
+UPDATE person_role
+SET is_system = TRUE
+WHERE code = 'borrower';
+
+INSERT INTO person_role (code, label, permissions)
+VALUES ('auditor', 'Auditor', '{borrow.view,reports.view}'),
+    ('student_assistant', 'Student Assistant', '{borrow.view,borrow.fulfill}');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+UPDATE person
+SET person_role_id = (SELECT person_role_id FROM person_role WHERE code = 'borrower')
+WHERE person_role_id IN (SELECT person_role_id FROM person_role WHERE NOT is_system);
+
+DELETE FROM person_role
+WHERE NOT is_system;
+
+ALTER TABLE person_role
+DROP COLUMN permissions,
+DROP COLUMN is_system;
+-- +goose StatementEnd
@@ -0,0 +1,68 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS site (
+    site_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    name TEXT NOT NULL UNIQUE,
+    building TEXT,
+    room TEXT,
+
+    -- Used when a request doesn't say which site it is for
+    is_default BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE UNIQUE INDEX site_default_idx
+ON site (is_default)
+WHERE is_default;
+
+INSERT INTO site (name, is_default)
+VALUES ('Main Storage', TRUE);
+
+-- Managers assigned to specific sites can only handle those sites. Managers
+-- without assignments handle every site.
+CREATE TABLE IF NOT EXISTS site_manager (
+    site_id UUID NOT NULL REFERENCES site(site_id) ON DELETE CASCADE,
+    person_id UUID NOT NULL REFERENCES person(person_id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (site_id, person_id)
+);
+
+CREATE INDEX site_manager_person_idx
+ON site_manager (person_id);
+
+ALTER TABLE equipment
+ADD COLUMN site_id UUID REFERENCES site(site_id);
+
+ALTER TABLE borrow_request
+ADD COLUMN site_id UUID REFERENCES site(site_id);
+
+ALTER TABLE waitlist_entry
+ADD COLUMN site_id UUID REFERENCES site(site_id) ON DELETE CASCADE;
+
+UPDATE equipment SET site_id = (SELECT site_id FROM site WHERE is_default);
+UPDATE borrow_request SET site_id = (SELECT site_id FROM site WHERE is_default);
+UPDATE waitlist_entry SET site_id = (SELECT site_id FROM site WHERE is_default);
+
+ALTER TABLE equipment ALTER COLUMN site_id SET NOT NULL;
+ALTER TABLE borrow_request ALTER COLUMN site_id SET NOT NULL;
+ALTER TABLE waitlist_entry ALTER COLUMN site_id SET NOT NULL;
+
+CREATE INDEX equipment_site_idx
+ON equipment (site_id, equipment_type_id);
+
+CREATE INDEX borrow_request_site_idx
+ON borrow_request (site_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE waitlist_entry DROP COLUMN site_id;
+ALTER TABLE borrow_request DROP COLUMN site_id;
+ALTER TABLE equipment DROP COLUMN site_id;
+
+DROP TABLE IF EXISTS site_manager;
+DROP TABLE IF EXISTS site;
+-- +goose StatementEnd
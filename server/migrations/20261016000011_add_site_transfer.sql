@@ -0,0 +1,58 @@
+-- +goose Up
+-- +goose StatementBegin
+INSERT INTO equipment_status (code, label)
+VALUES ('in_transit', 'In Transit');
+
+CREATE TABLE IF NOT EXISTS site_transfer (
+    site_transfer_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    equipment_type_id UUID NOT NULL REFERENCES equipment_type(equipment_type_id) ON DELETE CASCADE,
+    from_site_id UUID NOT NULL REFERENCES site(site_id),
+    to_site_id UUID NOT NULL REFERENCES site(site_id) CHECK (to_site_id <> from_site_id),
+    quantity SMALLINT NOT NULL CHECK (quantity > 0),
+    reason TEXT,
+
+    status TEXT NOT NULL DEFAULT 'requested' CHECK (status IN ('requested', 'dispatched', 'received', 'cancelled')),
+    requested_by UUID NOT NULL REFERENCES person(person_id),
+    dispatched_by UUID REFERENCES person(person_id),
+    dispatched_at TIMESTAMPTZ,
+    received_by UUID REFERENCES person(person_id),
+    received_at TIMESTAMPTZ,
+    remarks TEXT
+);
+
+CREATE INDEX site_transfer_from_site_idx
+ON site_transfer (from_site_id, created_at);
+
+CREATE INDEX site_transfer_to_site_idx
+ON site_transfer (to_site_id, created_at);
+
+CREATE TABLE IF NOT EXISTS site_transfer_unit (
+    site_transfer_id UUID NOT NULL REFERENCES site_transfer(site_transfer_id) ON DELETE CASCADE,
+    equipment_id UUID NOT NULL REFERENCES equipment(equipment_id) ON DELETE CASCADE,
+
+    -- NULL until the transfer is received, FALSE if the unit never arrived
+    is_received BOOLEAN,
+
+    PRIMARY KEY (site_transfer_id, equipment_id)
+);
+
+CREATE INDEX site_transfer_unit_equipment_idx
+ON site_transfer_unit (equipment_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS site_transfer_unit;
+DROP TABLE IF EXISTS site_transfer;
+
+-- Units still on their way are put back where they were dispatched from
+UPDATE equipment
+SET equipment_status_id = (SELECT equipment_status_id FROM equipment_status WHERE code = 'available')
+WHERE equipment_status_id = (SELECT equipment_status_id FROM equipment_status WHERE code = 'in_transit');
+
+DELETE FROM equipment_status
+WHERE code = 'in_transit';
+-- +goose StatementEnd
@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS maintenance_ticket (
+    maintenance_ticket_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    equipment_id UUID NOT NULL REFERENCES equipment(equipment_id) ON DELETE CASCADE,
+    description TEXT NOT NULL,
+    opened_by UUID NOT NULL REFERENCES person(person_id),
+    assigned_to UUID REFERENCES person(person_id) ON DELETE SET NULL,
+    cost NUMERIC(10, 2) CHECK (cost >= 0),
+    expected_completion_at TIMESTAMPTZ,
+
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'repaired', 'disposed')),
+    resolution TEXT,
+    closed_by UUID REFERENCES person(person_id),
+    closed_at TIMESTAMPTZ
+);
+
+-- A unit can only be in one repair at a time
+CREATE UNIQUE INDEX maintenance_ticket_open_idx
+ON maintenance_ticket (equipment_id)
+WHERE status = 'open';
+
+CREATE INDEX maintenance_ticket_assigned_to_idx
+ON maintenance_ticket (assigned_to)
+WHERE status = 'open';
+
+CREATE TABLE IF NOT EXISTS maintenance_ticket_photo (
+    maintenance_ticket_photo_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    maintenance_ticket_id UUID NOT NULL REFERENCES maintenance_ticket(maintenance_ticket_id) ON DELETE CASCADE,
+    url TEXT NOT NULL
+);
+
+CREATE INDEX maintenance_ticket_photo_ticket_idx
+ON maintenance_ticket_photo (maintenance_ticket_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS maintenance_ticket_photo;
+DROP TABLE IF EXISTS maintenance_ticket;
+-- +goose StatementEnd
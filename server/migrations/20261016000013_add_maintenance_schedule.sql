@@ -0,0 +1,51 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS maintenance_schedule (
+    maintenance_schedule_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    equipment_type_id UUID NOT NULL REFERENCES equipment_type(equipment_type_id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    description TEXT,
+
+    -- A unit is due once either interval has passed since it was last serviced
+    interval_days INT CHECK (interval_days > 0),
+    interval_loans INT CHECK (interval_loans > 0),
+
+    pull_from_circulation BOOLEAN NOT NULL DEFAULT FALSE,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+
+    CHECK (interval_days IS NOT NULL OR interval_loans IS NOT NULL),
+    UNIQUE (equipment_type_id, name)
+);
+
+-- Tickets opened by the worker have no one behind them
+ALTER TABLE maintenance_ticket
+ALTER COLUMN opened_by DROP NOT NULL;
+
+ALTER TABLE maintenance_ticket
+ADD COLUMN maintenance_schedule_id UUID REFERENCES maintenance_schedule(maintenance_schedule_id) ON DELETE SET NULL;
+
+CREATE INDEX maintenance_ticket_schedule_idx
+ON maintenance_ticket (maintenance_schedule_id, equipment_id, closed_at);
+
+CREATE INDEX borrow_transaction_equipment_idx
+ON borrow_transaction (equipment_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS borrow_transaction_equipment_idx;
+
+DELETE FROM maintenance_ticket
+WHERE opened_by IS NULL;
+
+ALTER TABLE maintenance_ticket
+DROP COLUMN IF EXISTS maintenance_schedule_id;
+
+ALTER TABLE maintenance_ticket
+ALTER COLUMN opened_by SET NOT NULL;
+
+DROP TABLE IF EXISTS maintenance_schedule;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Keep the latest result of each borrow request
+DELETE FROM anomaly_result
+WHERE anomaly_result_id IN (
+    SELECT anomaly_result_id
+    FROM (
+        SELECT
+            anomaly_result_id,
+            ROW_NUMBER() OVER (PARTITION BY borrow_request_id ORDER BY updated_at DESC, created_at DESC) AS rank
+        FROM anomaly_result
+    ) ranked
+    WHERE rank > 1
+);
+
+CREATE UNIQUE INDEX anomaly_result_borrow_request_idx
+ON anomaly_result (borrow_request_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS anomaly_result_borrow_request_idx;
+-- +goose StatementEnd
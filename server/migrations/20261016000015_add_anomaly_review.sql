@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Scores from different detectors aren't comparable, so labels are only used
+-- to calibrate the detector that produced them. Results recorded before this
+-- was tracked have no detector.
+ALTER TABLE anomaly_result
+ADD COLUMN detector TEXT CHECK (detector IN ('builtin', 'remote'));
+
+ALTER TABLE anomaly_result
+ADD COLUMN reviewed_by UUID REFERENCES person(person_id) ON DELETE SET NULL;
+
+ALTER TABLE anomaly_result
+ADD COLUMN reviewed_at TIMESTAMPTZ;
+
+CREATE INDEX anomaly_result_flagged_idx
+ON anomaly_result (created_at, borrow_request_id)
+WHERE is_anomaly;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS anomaly_result_flagged_idx;
+
+ALTER TABLE anomaly_result
+DROP COLUMN IF EXISTS reviewed_at;
+
+ALTER TABLE anomaly_result
+DROP COLUMN IF EXISTS reviewed_by;
+
+ALTER TABLE anomaly_result
+DROP COLUMN IF EXISTS detector;
+-- +goose StatementEnd
@@ -0,0 +1,52 @@
+package user
+
+import "slices"
+
+// Permission grants access to a group of actions. Roles are named sets of
+// permissions, so anything that isn't granted is denied.
+type Permission string
+
+const (
+	// PermissionEquipmentWrite manages the catalog, i.e. equipment, units
+	// and categories.
+	PermissionEquipmentWrite Permission = "equipment.write"
+	// PermissionBorrowView sees every borrower's requests, returns, strikes
+	// and waitlist entries.
+	PermissionBorrowView Permission = "borrow.view"
+	// PermissionBorrowReview approves or rejects borrow requests and
+	// extensions, and manages borrow policies, strikes and the waitlist.
+	PermissionBorrowReview Permission = "borrow.review"
+	// PermissionBorrowFulfill hands out equipment and receives returns.
+	PermissionBorrowFulfill Permission = "borrow.fulfill"
+	// PermissionReportsView sees and exports every borrower's history.
+	PermissionReportsView Permission = "reports.view"
+	// PermissionUsersManage manages users and roles.
+	PermissionUsersManage Permission = "users.manage"
+)
+
+type permissionDetail struct {
+	Code        Permission `json:"code"`
+	Description string     `json:"description"`
+}
+
+var permissions = []permissionDetail{
+	{PermissionEquipmentWrite, "Add, edit and delete equipment and categories."},
+	{PermissionBorrowView, "View every borrower's requests, returns and strikes."},
+	{PermissionBorrowReview, "Review borrow requests and extensions, and manage borrow policies."},
+	{PermissionBorrowFulfill, "Hand out equipment and receive returns."},
+	{PermissionReportsView, "View and export every borrower's history."},
+	{PermissionUsersManage, "Manage users and roles."},
+}
+
+// AllPermissions returns every known permission.
+func AllPermissions() []Permission {
+	res := make([]Permission, len(permissions))
+	for i, p := range permissions {
+		res[i] = p.Code
+	}
+	return res
+}
+
+func (p Permission) valid() bool {
+	return slices.Contains(AllPermissions(), p)
+}
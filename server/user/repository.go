@@ -9,13 +9,14 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/xGihyun/hirami/api"
 )
 
 type Repository interface {
 	Register(ctx context.Context, arg RegisterRequest) (string, error)
 	login(ctx context.Context, arg loginRequest) (signInResponse, error)
 	get(ctx context.Context, userID string) (user, error)
-	getAll(ctx context.Context, params getParams) ([]user, error)
+	getAll(ctx context.Context, params getParams) ([]user, *string, error)
 	Update(ctx context.Context, arg UpdateRequest) (user, error)
 
 	createPasswordResetToken(ctx context.Context, email, tokenHash string, expiresAt time.Time) error
@@ -29,6 +30,11 @@ type Repository interface {
 	invalidateSession(ctx context.Context, token string) error
 	createSession(ctx context.Context, token, userID string) (session, error)
 	ValidateSessionToken(ctx context.Context, token string) (sessionValidationResponse, error)
+
+	getRoles(ctx context.Context) ([]role, error)
+	createRole(ctx context.Context, arg roleRequest) (role, error)
+	updateRole(ctx context.Context, arg roleRequest) (role, error)
+	deleteRole(ctx context.Context, id int) error
 }
 
 type repository struct {
@@ -62,9 +68,14 @@ func (r *repository) Register(ctx context.Context, arg RegisterRequest) (string,
 		role = *arg.Role
 	}
 
+	roleID, err := r.roleID(ctx, role)
+	if err != nil {
+		return "", err
+	}
+
 	query := `
 	INSERT INTO person (email, password_hash, first_name, middle_name, last_name, person_role_id, avatar_url)
-	VALUES ($1, $2, $3, $4, $5, (SELECT person_role_id FROM person_role WHERE code = $6), $7)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
 	RETURNING person_id
 	`
 
@@ -78,7 +89,7 @@ func (r *repository) Register(ctx context.Context, arg RegisterRequest) (string,
 		arg.FirstName,
 		arg.MiddleName,
 		arg.LastName,
-		role.Code(),
+		roleID,
 		arg.AvatarURL,
 	)
 	if err := row.Scan(&userID); err != nil {
@@ -177,7 +188,8 @@ func (r *repository) get(ctx context.Context, userID string) (user, error) {
 		jsonb_build_object(
 			'id', person_role.person_role_id,
 			'code', person_role.code,
-			'label', person_role.label
+			'label', person_role.label,
+			'permissions', person_role.permissions
 		) AS role,
 		person.is_active
 	FROM person
@@ -219,7 +231,8 @@ func (r *repository) GetByEmail(ctx context.Context, email string) (user, error)
 		jsonb_build_object(
 			'id', person_role.person_role_id,
 			'code', person_role.code,
-			'label', person_role.label
+			'label', person_role.label,
+			'permissions', person_role.permissions
 		) AS role,
 		person.is_active
 	FROM person
@@ -249,9 +262,11 @@ func (r *repository) GetByEmail(ctx context.Context, email string) (user, error)
 
 type getParams struct {
 	search *string
+
+	page api.Page
 }
 
-func (r *repository) getAll(ctx context.Context, params getParams) ([]user, error) {
+func (r *repository) getAll(ctx context.Context, params getParams) ([]user, *string, error) {
 	query := `
 	SELECT 
 		person.person_id, 
@@ -265,7 +280,8 @@ func (r *repository) getAll(ctx context.Context, params getParams) ([]user, erro
 		jsonb_build_object(
 			'id', person_role.person_role_id,
 			'code', person_role.code,
-			'label', person_role.label
+			'label', person_role.label,
+			'permissions', person_role.permissions
 		) AS role,
 		person.is_active
 	FROM person
@@ -295,19 +311,39 @@ func (r *repository) getAll(ctx context.Context, params getParams) ([]user, erro
 		argIdx++
 	}
 
-	query += " ORDER BY person_role.person_role_id"
+	if params.page.Cursor != "" {
+		var cursorRoleID int
+		var cursorID string
+		if err := api.DecodeCursor(params.page.Cursor, &cursorRoleID, &cursorID); err != nil {
+			return nil, nil, err
+		}
+
+		query += fmt.Sprintf(" AND (person_role.person_role_id, person.person_id) > ($%d, $%d)", argIdx, argIdx+1)
+		args = append(args, cursorRoleID, cursorID)
+		argIdx += 2
+	}
+
+	query += " ORDER BY person_role.person_role_id, person.person_id"
+
+	if params.page.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", argIdx)
+		args = append(args, params.page.Limit+1)
+		argIdx++
+	}
 
 	rows, err := r.querier.Query(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	users, err := pgx.CollectRows(rows, pgx.RowToStructByName[user])
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return users, nil
+	return api.NextPage(users, params.page, func(u user) []any {
+		return []any{u.Role.ID, u.UserID}
+	})
 }
 
 //
@@ -326,7 +362,15 @@ type UpdateRequest struct {
 }
 
 func (r *repository) Update(ctx context.Context, arg UpdateRequest) (user, error) {
-	fmt.Println(arg.IsActive)
+	var roleID *int
+	if arg.Role != nil {
+		id, err := r.roleID(ctx, *arg.Role)
+		if err != nil {
+			return user{}, err
+		}
+		roleID = &id
+	}
+
 	query := `
 	WITH updated_user AS (
 		UPDATE person
@@ -362,7 +406,8 @@ func (r *repository) Update(ctx context.Context, arg UpdateRequest) (user, error
 		jsonb_build_object(
 			'id', person_role.person_role_id,
 			'code', person_role.code,
-			'label', person_role.label
+			'label', person_role.label,
+			'permissions', person_role.permissions
 		) AS role,
 		updated_user.is_active
 	FROM updated_user
@@ -377,7 +422,7 @@ func (r *repository) Update(ctx context.Context, arg UpdateRequest) (user, error
 		arg.FirstName,
 		arg.MiddleName,
 		arg.LastName,
-		arg.Role,
+		roleID,
 		arg.AvatarURL,
 		arg.IsActive,
 		arg.PersonID,
@@ -565,7 +610,8 @@ func (r *repository) CreateUser(ctx context.Context, arg createUserRequest) (use
 		jsonb_build_object(
 			'id', person_role.person_role_id,
 			'code', person_role.code,
-			'label', person_role.label
+			'label', person_role.label,
+			'permissions', person_role.permissions
 		) AS role,
 		inserted_user.is_active
 	`
@@ -1,56 +1,361 @@
 package user
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/xGihyun/hirami/api"
 )
 
-type Role int
+// Role is the code of a person_role. Besides the built-in roles below,
+// roles can be added through the admin API.
+type Role string
 
 const (
-	Borrower Role = iota + 1
-	EquipmentManager
+	Borrower         Role = "borrower"
+	EquipmentManager Role = "equipment_manager"
+	Auditor          Role = "auditor"
+	StudentAssistant Role = "student_assistant"
 )
 
+func (r Role) Code() string {
+	return string(r)
+}
+
 type RoleDetail struct {
-	ID    int    `json:"id"`
-	Code  string `json:"code"`
-	Label string `json:"label"`
+	ID          int          `json:"id"`
+	Code        string       `json:"code"`
+	Label       string       `json:"label"`
+	Permissions []Permission `json:"permissions"`
+}
+
+var (
+	errUnknownRole       = fmt.Errorf("unknown role")
+	errInvalidRole       = fmt.Errorf("invalid role")
+	errUnknownPermission = fmt.Errorf("unknown permission")
+	errRoleExists        = fmt.Errorf("role already exists")
+	errRoleInUse         = fmt.Errorf("role is still assigned to users")
+	errSystemRole        = fmt.Errorf("built-in roles can't be deleted or renamed")
+)
+
+var roleCodePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+const roleColumns = "person_role_id, code, label, permissions, is_system"
+
+type role struct {
+	RoleDetail
+	IsSystem bool `json:"isSystem"`
 }
 
-var stringToRole = map[string]Role{
-	"borrower":          Borrower,
-	"equipment_manager": EquipmentManager,
+func roleFields(r *role) []any {
+	return []any{&r.ID, &r.Code, &r.Label, &r.Permissions, &r.IsSystem}
 }
 
-func (r Role) Code() string {
-	roles := map[Role]string{
-		Borrower:         "borrower",
-		EquipmentManager: "equipment_manager",
+type roleRequest struct {
+	RoleID      int          `json:"-"`
+	Code        string       `json:"code"`
+	Label       string       `json:"label"`
+	Permissions []Permission `json:"permissions"`
+}
+
+func (arg *roleRequest) validate() error {
+	arg.Code = strings.TrimSpace(arg.Code)
+	arg.Label = strings.TrimSpace(arg.Label)
+
+	switch {
+	case !roleCodePattern.MatchString(arg.Code):
+		return fmt.Errorf("%w: code must be in snake_case", errInvalidRole)
+	case arg.Label == "":
+		return fmt.Errorf("%w: label is required", errInvalidRole)
+	}
+
+	for _, p := range arg.Permissions {
+		if !p.valid() {
+			return fmt.Errorf("%w: %s", errUnknownPermission, p)
+		}
+	}
+
+	if arg.Permissions == nil {
+		arg.Permissions = []Permission{}
+	}
+
+	return nil
+}
+
+// roleID looks up a role by its code.
+func (r *repository) roleID(ctx context.Context, code Role) (int, error) {
+	var id int
+	query := "SELECT person_role_id FROM person_role WHERE code = $1"
+	if err := r.querier.QueryRow(ctx, query, code).Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, errUnknownRole
+		}
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *repository) getRoles(ctx context.Context) ([]role, error) {
+	query := "SELECT " + roleColumns + " FROM person_role ORDER BY person_role_id"
+
+	rows, err := r.querier.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []role{}
+	for rows.Next() {
+		var role role
+		if err := rows.Scan(roleFields(&role)...); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
 	}
-	return roles[r]
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
 }
 
-func (r Role) MarshalJSON() ([]byte, error) {
-	roles := map[Role]string{
-		Borrower:         "borrower",
-		EquipmentManager: "equipment_manager",
+func (r *repository) createRole(ctx context.Context, arg roleRequest) (role, error) {
+	query := `
+	INSERT INTO person_role (code, label, permissions)
+	VALUES ($1, $2, $3)
+	RETURNING ` + roleColumns
+
+	var res role
+	if err := r.querier.QueryRow(ctx, query, arg.Code, arg.Label, arg.Permissions).Scan(roleFields(&res)...); err != nil {
+		return role{}, roleError(err)
 	}
-	if s, ok := roles[r]; ok {
-		return json.Marshal(s)
+
+	return res, nil
+}
+
+func (r *repository) updateRole(ctx context.Context, arg roleRequest) (role, error) {
+	var (
+		code     string
+		isSystem bool
+	)
+	query := "SELECT code, is_system FROM person_role WHERE person_role_id = $1"
+	if err := r.querier.QueryRow(ctx, query, arg.RoleID).Scan(&code, &isSystem); err != nil {
+		return role{}, err
+	}
+
+	if isSystem && code != arg.Code {
+		return role{}, errSystemRole
+	}
+
+	query = `
+	UPDATE person_role
+	SET
+		updated_at = NOW(),
+		code = $2,
+		label = $3,
+		permissions = $4
+	WHERE person_role_id = $1
+	RETURNING ` + roleColumns
+
+	var res role
+	if err := r.querier.QueryRow(ctx, query, arg.RoleID, arg.Code, arg.Label, arg.Permissions).Scan(roleFields(&res)...); err != nil {
+		return role{}, roleError(err)
 	}
-	return nil, fmt.Errorf("invalid role: %d", r)
+
+	return res, nil
 }
 
-func (r *Role) UnmarshalJSON(data []byte) error {
-	var s string
-	if err := json.Unmarshal(data, &s); err != nil {
+func (r *repository) deleteRole(ctx context.Context, id int) error {
+	var isSystem bool
+	query := "SELECT is_system FROM person_role WHERE person_role_id = $1"
+	if err := r.querier.QueryRow(ctx, query, id).Scan(&isSystem); err != nil {
 		return err
 	}
 
-	if role, ok := stringToRole[s]; ok {
-		*r = role
-		return nil
+	if isSystem {
+		return errSystemRole
+	}
+
+	if _, err := r.querier.Exec(ctx, "DELETE FROM person_role WHERE person_role_id = $1", id); err != nil {
+		return roleError(err)
+	}
+
+	return nil
+}
+
+// roleError maps constraint violations to the errors the handlers know how
+// to report.
+func roleError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505":
+			return errRoleExists
+		case "23503":
+			return errRoleInUse
+		}
+	}
+	return err
+}
+
+func (s *Server) getPermissions(w http.ResponseWriter, r *http.Request) api.Response {
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched permissions.",
+		Data:    permissions,
+	}
+}
+
+func (s *Server) getRoles(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	roles, err := s.repository.getRoles(ctx)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get roles: %w", err),
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to get roles.",
+		}
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully fetched roles.",
+		Data:    roles,
+	}
+}
+
+func (s *Server) createRole(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data roleRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("create role: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid create role request.",
+		}
+	}
+
+	if err := data.validate(); err != nil {
+		return roleErrorResponse("create role", err)
+	}
+
+	role, err := s.repository.createRole(ctx, data)
+	if err != nil {
+		return roleErrorResponse("create role", err)
+	}
+
+	return api.Response{
+		Code:    http.StatusCreated,
+		Message: "Successfully created role.",
+		Data:    role,
+	}
+}
+
+func (s *Server) updateRole(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	var data roleRequest
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&data); err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("update role: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid update role request.",
+		}
+	}
+
+	roleID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return roleErrorResponse("update role", pgx.ErrNoRows)
+	}
+	data.RoleID = roleID
+
+	if err := data.validate(); err != nil {
+		return roleErrorResponse("update role", err)
+	}
+
+	role, err := s.repository.updateRole(ctx, data)
+	if err != nil {
+		return roleErrorResponse("update role", err)
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully updated role.",
+		Data:    role,
+	}
+}
+
+func (s *Server) deleteRole(w http.ResponseWriter, r *http.Request) api.Response {
+	ctx := r.Context()
+
+	roleID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return roleErrorResponse("delete role", pgx.ErrNoRows)
+	}
+
+	if err := s.repository.deleteRole(ctx, roleID); err != nil {
+		return roleErrorResponse("delete role", err)
+	}
+
+	return api.Response{
+		Code:    http.StatusOK,
+		Message: "Successfully deleted role.",
+	}
+}
+
+func roleErrorResponse(op string, err error) api.Response {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusNotFound,
+			Message: "Role not found.",
+		}
+	case errors.Is(err, errInvalidRole), errors.Is(err, errUnknownPermission):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid role.",
+		}
+	case errors.Is(err, errRoleExists):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "A role with the same code already exists.",
+		}
+	case errors.Is(err, errRoleInUse):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "Role is still assigned to users.",
+		}
+	case errors.Is(err, errSystemRole):
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusConflict,
+			Message: "Built-in roles can't be deleted or renamed.",
+		}
+	default:
+		return api.Response{
+			Error:   fmt.Errorf("%s: %w", op, err),
+			Code:    http.StatusInternalServerError,
+			Message: fmt.Sprintf("Failed to %s.", op),
+		}
 	}
-	return fmt.Errorf("invalid role: %s", s)
 }
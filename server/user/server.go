@@ -32,7 +32,12 @@ func NewServer(repo Repository, svc *gmail.Service, testMode bool) *Server {
 	}
 }
 
-func (s *Server) SetupRoutes(mux *http.ServeMux, auth func(http.Handler) http.Handler, rateLimit func(int, time.Duration) func(http.Handler) http.Handler) {
+func (s *Server) SetupRoutes(
+	mux *http.ServeMux,
+	auth func(http.Handler) http.Handler,
+	rateLimit func(int, time.Duration) func(http.Handler) http.Handler,
+	requirePermission func(...Permission) func(http.Handler) http.Handler,
+) {
 	// Public routes with rate limiting
 	mux.Handle("POST /register", rateLimit(5, time.Hour)(api.Handler(s.Register)))
 	mux.Handle("POST /verify-email", rateLimit(10, time.Minute)(api.Handler(s.VerifyEmail)))
@@ -48,9 +53,16 @@ func (s *Server) SetupRoutes(mux *http.ServeMux, auth func(http.Handler) http.Ha
 	mux.Handle("GET /users", auth(api.Handler(s.getAll)))
 	mux.Handle("GET /users/exists", api.Handler(s.Exists)) // This could be public for UI checks
 	mux.Handle("GET /users/{id}", auth(api.Handler(s.Get)))
-	mux.Handle("PATCH /users/{id}", auth(api.Handler(s.Update)))
+	mux.Handle("PATCH /users/{id}", auth(s.updateHandler(requirePermission)))
 
 	mux.Handle("GET /sessions", api.Handler(s.GetSession))
+
+	// Roles and permissions
+	mux.Handle("GET /permissions", auth(api.Handler(s.getPermissions)))
+	mux.Handle("GET /roles", auth(api.Handler(s.getRoles)))
+	mux.Handle("POST /roles", auth(requirePermission(PermissionUsersManage)(api.Handler(s.createRole))))
+	mux.Handle("PATCH /roles/{id}", auth(requirePermission(PermissionUsersManage)(api.Handler(s.updateRole))))
+	mux.Handle("DELETE /roles/{id}", auth(requirePermission(PermissionUsersManage)(api.Handler(s.deleteRole))))
 }
 
 func (s *Server) Exists(w http.ResponseWriter, r *http.Request) api.Response {
@@ -148,20 +160,9 @@ func (s *Server) Register(w http.ResponseWriter, r *http.Request) api.Response {
 		middleName = &middleNameValue
 	}
 
+	// Anyone can sign up, so everyone starts as a borrower. Other roles are
+	// given by someone who can manage users.
 	roleVal := Borrower
-	if roleStr := r.FormValue("role"); roleStr != "" {
-		roleStr = strings.TrimSpace(strings.ToLower(roleStr))
-
-		var ok bool
-		roleVal, ok = stringToRole[roleStr]
-		if !ok {
-			return api.Response{
-				Error:   fmt.Errorf("sign up: invalid role %s", roleStr),
-				Code:    http.StatusBadRequest,
-				Message: "Invalid role. Must be 'borrower' or 'equipment_manager'.",
-			}
-		}
-	}
 
 	data := RegisterRequest{
 		Email:      strings.TrimSpace(r.FormValue("email")),
@@ -227,6 +228,14 @@ func (s *Server) Register(w http.ResponseWriter, r *http.Request) api.Response {
 
 	userID, err := s.repository.Register(ctx, data)
 	if err != nil {
+		if errors.Is(err, errUnknownRole) {
+			return api.Response{
+				Error:   fmt.Errorf("sign up: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid role.",
+			}
+		}
+
 		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
 			return api.Response{
 				Error:   fmt.Errorf("sign up: %w", err),
@@ -599,12 +608,30 @@ func (s *Server) Get(w http.ResponseWriter, r *http.Request) api.Response {
 func (s *Server) getAll(w http.ResponseWriter, r *http.Request) api.Response {
 	ctx := r.Context()
 
+	page, err := api.ParsePage(r)
+	if err != nil {
+		return api.Response{
+			Error:   fmt.Errorf("get users: %w", err),
+			Code:    http.StatusBadRequest,
+			Message: "Invalid limit.",
+		}
+	}
+
 	search := r.URL.Query().Get("search")
 	params := getParams{
 		search: &search,
+		page:   page,
 	}
-	users, err := s.repository.getAll(ctx, params)
+	users, nextCursor, err := s.repository.getAll(ctx, params)
 	if err != nil {
+		if errors.Is(err, api.ErrInvalidCursor) {
+			return api.Response{
+				Error:   fmt.Errorf("get users: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid cursor.",
+			}
+		}
+
 		return api.Response{
 			Error:   fmt.Errorf("get users: %s", err),
 			Code:    http.StatusInternalServerError,
@@ -613,9 +640,10 @@ func (s *Server) getAll(w http.ResponseWriter, r *http.Request) api.Response {
 	}
 
 	return api.Response{
-		Code:    http.StatusOK,
-		Message: "Successfully fetched users.",
-		Data:    users,
+		Code:       http.StatusOK,
+		Message:    "Successfully fetched users.",
+		Data:       users,
+		NextCursor: nextCursor,
 	}
 }
 
@@ -696,17 +724,7 @@ func (s *Server) Update(w http.ResponseWriter, r *http.Request) api.Response {
 
 	var role *Role
 	if roleStr := r.FormValue("role"); roleStr != "" {
-		roleStr = strings.TrimSpace(strings.ToLower(roleStr))
-
-		r, ok := stringToRole[roleStr]
-		if !ok {
-			return api.Response{
-				Error:   fmt.Errorf("update user: invalid role %s", roleStr),
-				Code:    http.StatusBadRequest,
-				Message: "Invalid role. Must be 'borrower' or 'equipment_manager'.",
-			}
-		}
-
+		r := Role(strings.TrimSpace(strings.ToLower(roleStr)))
 		role = &r
 	}
 
@@ -732,6 +750,14 @@ func (s *Server) Update(w http.ResponseWriter, r *http.Request) api.Response {
 
 	user, err := s.repository.Update(ctx, data)
 	if err != nil {
+		if errors.Is(err, errUnknownRole) {
+			return api.Response{
+				Error:   fmt.Errorf("update user: %w", err),
+				Code:    http.StatusBadRequest,
+				Message: "Invalid role.",
+			}
+		}
+
 		return api.Response{
 			Error:   fmt.Errorf("update user: %w", err),
 			Code:    http.StatusInternalServerError,
@@ -746,6 +772,43 @@ func (s *Server) Update(w http.ResponseWriter, r *http.Request) api.Response {
 	}
 }
 
+// updateHandler lets signed-in users update a profile, but changing someone's
+// role requires PermissionUsersManage. Sending the user's current role back
+// isn't a change.
+func (s *Server) updateHandler(
+	requirePermission func(...Permission) func(http.Handler) http.Handler,
+) http.Handler {
+	update := api.Handler(s.Update)
+	updateRole := requirePermission(PermissionUsersManage)(update)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.changesRole(r) {
+			updateRole.ServeHTTP(w, r)
+			return
+		}
+		update.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) changesRole(r *http.Request) bool {
+	// Update rejects a form it can't parse anyway
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		return false
+	}
+
+	code := strings.TrimSpace(strings.ToLower(r.FormValue("role")))
+	if code == "" {
+		return false
+	}
+
+	current, err := s.repository.get(r.Context(), r.FormValue("id"))
+	if err != nil {
+		return true
+	}
+
+	return current.Role.Code != code
+}
+
 func (s *Server) RequestPasswordReset(w http.ResponseWriter, r *http.Request) api.Response {
 	ctx := r.Context()
 
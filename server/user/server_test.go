@@ -8,6 +8,9 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -15,6 +18,8 @@ import (
 	"github.com/xGihyun/hirami/testhelpers"
 )
 
+const testUserHeader = "X-User-Id"
+
 type UserRepoTestSuite struct {
 	suite.Suite
 
@@ -35,12 +40,46 @@ func (suite *UserRepoTestSuite) SetupSuite() {
 	}
 	suite.pgContainer = pgContainer
 
-	server := *NewServer(NewRepository(pgContainer.Pool), nil)
+	server := *NewServer(NewRepository(pgContainer.Pool), nil, true)
 
 	mux := http.NewServeMux()
 	mux.Handle("/register", api.Handler(server.Register))
 	mux.Handle("/login", api.Handler(server.Login))
 	mux.Handle("/sessions", api.Handler(server.GetSession))
+	mux.Handle("GET /roles", api.Handler(server.getRoles))
+	mux.Handle("POST /roles", api.Handler(server.createRole))
+	mux.Handle("PATCH /roles/{id}", api.Handler(server.updateRole))
+	mux.Handle("DELETE /roles/{id}", api.Handler(server.deleteRole))
+
+	// The middleware package imports this one, so requests here carry the
+	// permissions of the user in the X-User-Id header instead of a session.
+	requirePermission := func(permissions ...Permission) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var granted []Permission
+				query := `
+				SELECT person_role.permissions
+				FROM person
+				JOIN person_role USING (person_role_id)
+				WHERE person.person_id = $1
+				`
+				err := pgContainer.Pool.QueryRow(r.Context(), query, r.Header.Get(testUserHeader)).Scan(&granted)
+				if err != nil {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+
+				for _, p := range permissions {
+					if !slices.Contains(granted, p) {
+						http.Error(w, "Forbidden", http.StatusForbidden)
+						return
+					}
+				}
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	mux.Handle("PATCH /users/{id}", server.updateHandler(requirePermission))
 
 	suite.httpServer = httptest.NewServer(mux)
 }
@@ -234,6 +273,158 @@ func (suite *UserRepoTestSuite) TestSessionValidationDeactivatedUser() {
 	suite.Equal("Failed to get user session.", result.Message)
 }
 
+func (suite *UserRepoTestSuite) TestRoles() {
+	resp, err := http.Get(suite.httpServer.URL + "/roles")
+	suite.Require().NoError(err)
+
+	var roles struct {
+		api.Response
+		Data []role `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&roles)
+	resp.Body.Close()
+	suite.Require().NoError(err)
+
+	byCode := make(map[string]role)
+	for _, r := range roles.Data {
+		byCode[r.Code] = r
+	}
+	suite.ElementsMatch(AllPermissions(), byCode[EquipmentManager.Code()].Permissions)
+	suite.Empty(byCode[Borrower.Code()].Permissions)
+	suite.ElementsMatch([]Permission{PermissionBorrowView, PermissionReportsView}, byCode[Auditor.Code()].Permissions)
+	suite.ElementsMatch([]Permission{PermissionBorrowView, PermissionBorrowFulfill}, byCode[StudentAssistant.Code()].Permissions)
+
+	send := func(method, path, body string) (*http.Response, role) {
+		req, err := http.NewRequest(method, suite.httpServer.URL+path, strings.NewReader(body))
+		suite.Require().NoError(err)
+
+		resp, err := http.DefaultClient.Do(req)
+		suite.Require().NoError(err)
+		defer resp.Body.Close()
+
+		var result struct {
+			api.Response
+			Data role `json:"data"`
+		}
+		suite.Require().NoError(json.NewDecoder(resp.Body).Decode(&result))
+
+		return resp, result.Data
+	}
+
+	resp, _ = send(http.MethodPost, "/roles", `{"code": "lab_tech", "label": "Lab Technician", "permissions": ["equipment.delete"]}`)
+	suite.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	resp, created := send(http.MethodPost, "/roles", `{"code": "lab_tech", "label": "Lab Technician", "permissions": ["equipment.write"]}`)
+	suite.Require().Equal(http.StatusCreated, resp.StatusCode)
+	suite.Equal([]Permission{PermissionEquipmentWrite}, created.Permissions)
+
+	resp, _ = send(http.MethodPost, "/roles", `{"code": "lab_tech", "label": "Duplicate"}`)
+	suite.Equal(http.StatusConflict, resp.StatusCode)
+
+	id := strconv.Itoa(created.ID)
+	resp, updated := send(http.MethodPatch, "/roles/"+id, `{"code": "lab_tech", "label": "Lab Technician", "permissions": ["equipment.write", "borrow.fulfill"]}`)
+	suite.Require().Equal(http.StatusOK, resp.StatusCode)
+	suite.Equal([]Permission{PermissionEquipmentWrite, PermissionBorrowFulfill}, updated.Permissions)
+
+	// Built-in roles keep their code and can't be deleted
+	borrowerID := strconv.Itoa(byCode[Borrower.Code()].ID)
+	resp, _ = send(http.MethodPatch, "/roles/"+borrowerID, `{"code": "student", "label": "Student"}`)
+	suite.Equal(http.StatusConflict, resp.StatusCode)
+
+	resp, _ = send(http.MethodDelete, "/roles/"+borrowerID, "")
+	suite.Equal(http.StatusConflict, resp.StatusCode)
+
+	resp, _ = send(http.MethodDelete, "/roles/"+id, "")
+	suite.Equal(http.StatusOK, resp.StatusCode)
+
+	resp, _ = send(http.MethodDelete, "/roles/"+id, "")
+	suite.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (suite *UserRepoTestSuite) TestRoleChanges() {
+	register := func(email, role string) string {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("email", email)
+		writer.WriteField("password", "SecurePass123!")
+		writer.WriteField("firstName", "Test")
+		writer.WriteField("lastName", "User")
+		writer.WriteField("role", role)
+		writer.Close()
+
+		resp, err := http.Post(suite.httpServer.URL+"/register", writer.FormDataContentType(), body)
+		suite.Require().NoError(err)
+		defer resp.Body.Close()
+
+		var result struct {
+			api.Response
+			Data string `json:"data"`
+		}
+		suite.Require().NoError(json.NewDecoder(resp.Body).Decode(&result))
+		suite.Require().Equal(http.StatusCreated, resp.StatusCode)
+
+		return result.Data
+	}
+
+	roleOf := func(userID string) string {
+		var code string
+		query := `
+		SELECT person_role.code
+		FROM person
+		JOIN person_role USING (person_role_id)
+		WHERE person.person_id = $1
+		`
+		err := suite.pgContainer.Pool.QueryRow(suite.ctx, query, userID).Scan(&code)
+		suite.Require().NoError(err)
+
+		return code
+	}
+
+	update := func(actorID, userID, role string) int {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("id", userID)
+		writer.WriteField("isActive", "true")
+		writer.WriteField("role", role)
+		writer.Close()
+
+		req, err := http.NewRequest(http.MethodPatch, suite.httpServer.URL+"/users/"+userID, body)
+		suite.Require().NoError(err)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set(testUserHeader, actorID)
+
+		resp, err := http.DefaultClient.Do(req)
+		suite.Require().NoError(err)
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	// Signing up never grants more than a borrower
+	borrowerID := register("rolechange.borrower@test.com", EquipmentManager.Code())
+	suite.Equal(Borrower.Code(), roleOf(borrowerID))
+
+	managerID := register("rolechange.manager@test.com", "")
+	_, err := suite.pgContainer.Pool.Exec(suite.ctx, `
+	UPDATE person
+	SET person_role_id = (SELECT person_role_id FROM person_role WHERE code = $1)
+	WHERE person_id = $2
+	`, EquipmentManager.Code(), managerID)
+	suite.Require().NoError(err)
+
+	suite.Equal(http.StatusForbidden, update(borrowerID, borrowerID, EquipmentManager.Code()))
+	suite.Equal(Borrower.Code(), roleOf(borrowerID))
+
+	// Sending back the current role isn't a role change
+	suite.Equal(http.StatusOK, update(borrowerID, borrowerID, Borrower.Code()))
+
+	suite.Equal(http.StatusBadRequest, update(managerID, borrowerID, "overlord"))
+	suite.Equal(Borrower.Code(), roleOf(borrowerID))
+
+	suite.Equal(http.StatusOK, update(managerID, borrowerID, StudentAssistant.Code()))
+	suite.Equal(StudentAssistant.Code(), roleOf(borrowerID))
+}
+
 func RegisterTestUser(serverURL string, data RegisterRequest) error {
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)